@@ -4,25 +4,124 @@ import (
 	"flag"
 	"github.com/fngoc/url-shortener/internal/logger"
 	"os"
+	"strconv"
+	"time"
 )
 
 type flags struct {
 	ServerAddress     string
 	BaseResultAddress string
+	Domains           string
 	FilePath          string
 	DBConf            string
+	DumpFormat        string
+	DumpPath          string
+	CSVMaxRows        int
+	AllowedSchemes    string
+	MaxURLLength      int
+	StripFragments    bool
+	PolicyFile        string
+	ReputationFile    string
+	BlockPrivateIPs   bool
+	PolicyReload      time.Duration
+	GeoIPFile         string
+	RateLimitCreate   string
+	RateLimitRedirect string
+	RateLimitDelete   string
+	TrustForwardedFor bool
+	MaxBodySize       int
+	CompressMinSize   int
+	CompressTypes     string
+	QRCacheSize       int
+	TitleFetchWorkers int
+	TitleFetchTimeout time.Duration
+	PasswordAttempts  string
+	RedirectType      int
+	RedirectMaxAge    time.Duration
+	QueryPassthrough  string
+	RestoreWindow     time.Duration
+	PurgeAfter        time.Duration
+	PurgeInterval     time.Duration
+	WebhookAttempts   int
+	WebhookBackoff    time.Duration
+	WebhookTimeout    time.Duration
+	OutboxSink        string
+	OutboxInterval    time.Duration
 }
 
-var Flags flags
-
 const defaultPostgresParams string = "host=localhost user=postgres password=postgres dbname=test_db sslmode=disable"
 const defaultFileParams string = "data.json"
+const defaultCSVMaxRows = 10000
+const defaultAllowedSchemes = "http,https"
+const defaultMaxURLLength = 2048
+const defaultPolicyReload = 10 * time.Second
+const defaultMaxBodySize = 4 << 20
+const defaultCompressMinSize = 1024
+const defaultQRCacheSize = 1024
+const defaultTitleFetchTimeout = 5 * time.Second
+const defaultRedirectType = 307
+const defaultRedirectMaxAge = 24 * time.Hour
+const defaultRestoreWindow = 7 * 24 * time.Hour
+const defaultPurgeAfter = 30 * 24 * time.Hour
+const defaultWebhookAttempts = 8
+const defaultWebhookBackoff = 30 * time.Second
+const defaultWebhookTimeout = 5 * time.Second
+const defaultOutboxInterval = time.Second
+const defaultCompressTypes = "application/json,application/x-ndjson,application/problem+json,text/plain,text/csv,text/html,image/svg+xml"
+
+// Flags заполняется в ParseArgs. Значения по умолчанию нужны пакетам, которые используются без ParseArgs, например в тестах
+var Flags = flags{
+	CSVMaxRows:      defaultCSVMaxRows,
+	AllowedSchemes:  defaultAllowedSchemes,
+	MaxURLLength:    defaultMaxURLLength,
+	PolicyReload:    defaultPolicyReload,
+	MaxBodySize:     defaultMaxBodySize,
+	RedirectType:    defaultRedirectType,
+	RedirectMaxAge:  defaultRedirectMaxAge,
+	RestoreWindow:   defaultRestoreWindow,
+	CompressMinSize: defaultCompressMinSize,
+	CompressTypes:   defaultCompressTypes,
+}
 
 func ParseArgs() {
 	flag.StringVar(&Flags.ServerAddress, "a", "localhost:8080", "server address")
 	flag.StringVar(&Flags.BaseResultAddress, "b", "http://localhost:8080", "base result server address")
+	flag.StringVar(&Flags.Domains, "domains", "", "comma separated base URLs of additional short link domains, e.g. https://go.example.com")
 	flag.StringVar(&Flags.FilePath, "f", defaultFileParams, "file path")
-	flag.StringVar(&Flags.DBConf, "d", defaultPostgresParams, "db params")
+	flag.StringVar(&Flags.DBConf, "d", defaultPostgresParams, "db params (postgres DSN or sqlite:///path/to.db)")
+	flag.StringVar(&Flags.DumpFormat, "format", "jsonl", "export/import format: jsonl or csv")
+	flag.StringVar(&Flags.DumpPath, "dump", "", "export/import file path, stdout/stdin if empty")
+	flag.IntVar(&Flags.CSVMaxRows, "csv-max-rows", defaultCSVMaxRows, "max rows in bulk CSV upload")
+	flag.StringVar(&Flags.AllowedSchemes, "allowed-schemes", defaultAllowedSchemes, "comma separated URL schemes allowed to shorten")
+	flag.IntVar(&Flags.MaxURLLength, "max-url-length", defaultMaxURLLength, "max length of URL to shorten")
+	flag.BoolVar(&Flags.StripFragments, "strip-fragments", false, "strip #fragment from URLs to shorten")
+	flag.StringVar(&Flags.PolicyFile, "policy-file", "", "file with allow/deny domain lists, reloaded on change")
+	flag.StringVar(&Flags.ReputationFile, "reputation-file", "", "file with known malicious hosts and URL prefixes")
+	flag.BoolVar(&Flags.BlockPrivateIPs, "block-private-ips", true, "reject URLs resolving to private, loopback and link-local addresses")
+	flag.DurationVar(&Flags.PolicyReload, "policy-reload", defaultPolicyReload, "policy files reload check interval")
+	flag.StringVar(&Flags.GeoIPFile, "geoip-file", "", "CSV file with network,country lines for link targeting by country, reloaded on change")
+	flag.StringVar(&Flags.RateLimitCreate, "rate-limit-create", "10:50", "create requests limit per user and per IP as rate:burst, 0 disables")
+	flag.StringVar(&Flags.RateLimitRedirect, "rate-limit-redirect", "100:200", "redirect requests limit per user and per IP as rate:burst, 0 disables")
+	flag.StringVar(&Flags.RateLimitDelete, "rate-limit-delete", "5:20", "delete requests limit per user and per IP as rate:burst, 0 disables")
+	flag.BoolVar(&Flags.TrustForwardedFor, "trust-forwarded-for", false, "take client IP from the last X-Forwarded-For entry appended by a trusted proxy")
+	flag.IntVar(&Flags.MaxBodySize, "max-body-size", defaultMaxBodySize, "max request body size in bytes, checked before and after decompression")
+	flag.IntVar(&Flags.CompressMinSize, "compress-min-size", defaultCompressMinSize, "min response size in bytes to compress")
+	flag.StringVar(&Flags.CompressTypes, "compress-types", defaultCompressTypes, "comma separated response content types to compress")
+	flag.IntVar(&Flags.QRCacheSize, "qr-cache-size", defaultQRCacheSize, "number of rendered QR codes kept in memory, 0 disables cache")
+	flag.IntVar(&Flags.TitleFetchWorkers, "title-fetch-workers", 2, "workers fetching destination page titles for previews, 0 disables")
+	flag.DurationVar(&Flags.TitleFetchTimeout, "title-fetch-timeout", defaultTitleFetchTimeout, "destination page title fetch timeout")
+	flag.StringVar(&Flags.PasswordAttempts, "password-attempts", "0.1:5", "failed password attempts limit per protected link and client IP as rate:burst, 0 disables")
+	flag.IntVar(&Flags.RedirectType, "redirect-type", defaultRedirectType, "default redirect status: 301, 302, 307 or 308")
+	flag.DurationVar(&Flags.RedirectMaxAge, "redirect-max-age", defaultRedirectMaxAge, "Cache-Control max-age of permanent (301, 308) redirects")
+	flag.StringVar(&Flags.QueryPassthrough, "query-passthrough", "none", "query parameters of a short link request added to the destination: none, utm or all")
+	flag.DurationVar(&Flags.RestoreWindow, "restore-window", defaultRestoreWindow, "time after deletion during which the owner can restore a link")
+	flag.DurationVar(&Flags.PurgeAfter, "purge-after", defaultPurgeAfter, "time after deletion when a link is removed for good and its URL can be shortened again, 0 disables")
+	flag.DurationVar(&Flags.PurgeInterval, "purge-interval", time.Hour, "deleted links purge check interval")
+	flag.IntVar(&Flags.WebhookAttempts, "webhook-attempts", defaultWebhookAttempts, "delivery attempts of a webhook event, 0 disables webhooks")
+	flag.DurationVar(&Flags.WebhookBackoff, "webhook-backoff", defaultWebhookBackoff, "delay before the second webhook delivery attempt, doubled for each next one")
+	flag.DurationVar(&Flags.WebhookTimeout, "webhook-timeout", defaultWebhookTimeout, "webhook delivery request timeout")
+	flag.StringVar(&Flags.OutboxSink, "outbox-sink", "", "sink for link events from the outbox: memory or file:path, empty disables the outbox")
+	flag.DurationVar(&Flags.OutboxInterval, "outbox-interval", defaultOutboxInterval, "outbox relay check interval")
 	flag.Parse()
 
 	serverAddressEnv, findAddress := os.LookupEnv("SERVER_ADDRESS")
@@ -42,6 +141,38 @@ func ParseArgs() {
 	if findDBConf {
 		Flags.DBConf = DBEnv
 	}
+	lookupEnvString("DOMAINS", &Flags.Domains)
+	lookupEnvString("ALLOWED_SCHEMES", &Flags.AllowedSchemes)
+	lookupEnvInt("CSV_MAX_ROWS", &Flags.CSVMaxRows)
+	lookupEnvInt("MAX_URL_LENGTH", &Flags.MaxURLLength)
+	lookupEnvBool("STRIP_FRAGMENTS", &Flags.StripFragments)
+	lookupEnvString("POLICY_FILE", &Flags.PolicyFile)
+	lookupEnvString("REPUTATION_FILE", &Flags.ReputationFile)
+	lookupEnvBool("BLOCK_PRIVATE_IPS", &Flags.BlockPrivateIPs)
+	lookupEnvDuration("POLICY_RELOAD", &Flags.PolicyReload)
+	lookupEnvString("GEOIP_FILE", &Flags.GeoIPFile)
+	lookupEnvString("RATE_LIMIT_CREATE", &Flags.RateLimitCreate)
+	lookupEnvString("RATE_LIMIT_REDIRECT", &Flags.RateLimitRedirect)
+	lookupEnvString("RATE_LIMIT_DELETE", &Flags.RateLimitDelete)
+	lookupEnvBool("TRUST_FORWARDED_FOR", &Flags.TrustForwardedFor)
+	lookupEnvInt("MAX_BODY_SIZE", &Flags.MaxBodySize)
+	lookupEnvInt("COMPRESS_MIN_SIZE", &Flags.CompressMinSize)
+	lookupEnvString("COMPRESS_TYPES", &Flags.CompressTypes)
+	lookupEnvInt("QR_CACHE_SIZE", &Flags.QRCacheSize)
+	lookupEnvInt("TITLE_FETCH_WORKERS", &Flags.TitleFetchWorkers)
+	lookupEnvDuration("TITLE_FETCH_TIMEOUT", &Flags.TitleFetchTimeout)
+	lookupEnvString("PASSWORD_ATTEMPTS", &Flags.PasswordAttempts)
+	lookupEnvInt("REDIRECT_TYPE", &Flags.RedirectType)
+	lookupEnvDuration("REDIRECT_MAX_AGE", &Flags.RedirectMaxAge)
+	lookupEnvString("QUERY_PASSTHROUGH", &Flags.QueryPassthrough)
+	lookupEnvDuration("RESTORE_WINDOW", &Flags.RestoreWindow)
+	lookupEnvDuration("PURGE_AFTER", &Flags.PurgeAfter)
+	lookupEnvDuration("PURGE_INTERVAL", &Flags.PurgeInterval)
+	lookupEnvInt("WEBHOOK_ATTEMPTS", &Flags.WebhookAttempts)
+	lookupEnvDuration("WEBHOOK_BACKOFF", &Flags.WebhookBackoff)
+	lookupEnvDuration("WEBHOOK_TIMEOUT", &Flags.WebhookTimeout)
+	lookupEnvString("OUTBOX_SINK", &Flags.OutboxSink)
+	lookupEnvDuration("OUTBOX_INTERVAL", &Flags.OutboxInterval)
 	logger.Log.Info("Parse argument's is done")
 }
 
@@ -60,3 +191,52 @@ func HasFlagOrEnvFileVariable() bool {
 	}
 	return false
 }
+
+// lookupEnvString перезаписывает target значением переменной окружения name, если она задана
+func lookupEnvString(name string, target *string) {
+	if env, find := os.LookupEnv(name); find {
+		*target = env
+	}
+}
+
+// lookupEnvInt перезаписывает target значением переменной окружения name, если она задана и является числом
+func lookupEnvInt(name string, target *int) {
+	env, find := os.LookupEnv(name)
+	if !find {
+		return
+	}
+	value, err := strconv.Atoi(env)
+	if err != nil {
+		logger.Log.Warn(name + " is not a number")
+		return
+	}
+	*target = value
+}
+
+// lookupEnvBool перезаписывает target значением переменной окружения name, если она задана и является bool
+func lookupEnvBool(name string, target *bool) {
+	env, find := os.LookupEnv(name)
+	if !find {
+		return
+	}
+	value, err := strconv.ParseBool(env)
+	if err != nil {
+		logger.Log.Warn(name + " is not a bool")
+		return
+	}
+	*target = value
+}
+
+// lookupEnvDuration перезаписывает target значением переменной окружения name, если она задана и является длительностью
+func lookupEnvDuration(name string, target *time.Duration) {
+	env, find := os.LookupEnv(name)
+	if !find {
+		return
+	}
+	value, err := time.ParseDuration(env)
+	if err != nil {
+		logger.Log.Warn(name + " is not a duration")
+		return
+	}
+	*target = value
+}
@@ -0,0 +1,132 @@
+// Package domains описывает домены, на которых сервис отдаёт короткие ссылки. Домен по умолчанию
+// задаётся флагом -b, дополнительные брендовые домены — флагом -domains
+package domains
+
+import (
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Domain домен коротких ссылок. Name хост домена, под которым хранятся его ссылки,
+// пустой у домена по умолчанию. BaseURL префикс коротких ссылок домена
+type Domain struct {
+	Name    string
+	BaseURL string
+}
+
+var (
+	mu    sync.RWMutex
+	extra = map[string]Domain{}
+)
+
+// Initialize задаёт дополнительные домены списком базовых адресов через запятую, например
+// "https://go.brand.com,https://brand.link". Пустой список оставляет только домен по умолчанию
+func Initialize(baseURLs string) error {
+	parsed := make(map[string]Domain)
+	for _, raw := range strings.Split(baseURLs, ",") {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+		u, err := url.Parse(raw)
+		if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" ||
+			strings.Trim(u.Path, "/") != "" || u.RawQuery != "" || u.Fragment != "" {
+			return fmt.Errorf("domain %q must be a base URL like https://go.example.com", raw)
+		}
+		name := strings.ToLower(u.Host)
+		if name == defaultHost() {
+			return fmt.Errorf("domain %q is already the default domain", raw)
+		}
+		if _, ok := parsed[name]; ok {
+			return fmt.Errorf("domain %q is listed twice", raw)
+		}
+		parsed[name] = Domain{Name: name, BaseURL: u.Scheme + "://" + name}
+	}
+
+	mu.Lock()
+	extra = parsed
+	mu.Unlock()
+	return nil
+}
+
+// Default возвращает домен по умолчанию
+func Default() Domain {
+	return Domain{BaseURL: config.Flags.BaseResultAddress}
+}
+
+// Lookup находит домен по хосту. Пустое имя и хост домена по умолчанию дают домен по умолчанию
+func Lookup(name string) (Domain, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || name == defaultHost() {
+		return Default(), true
+	}
+	mu.RLock()
+	defer mu.RUnlock()
+	domain, ok := extra[name]
+	return domain, ok
+}
+
+// FromHost возвращает домен запроса по заголовку Host. Незнакомые хосты, например адрес
+// внутренней сети, обслуживаются как домен по умолчанию
+func FromHost(host string) Domain {
+	if domain, ok := Lookup(host); ok {
+		return domain
+	}
+	return Default()
+}
+
+// Name приводит имя домена к виду, под которым хранятся его ссылки. Домен, которого нет
+// в настройках, остаётся как есть: его ссылки доступны владельцам, пока домен не вернут
+func Name(name string) string {
+	if domain, ok := Lookup(name); ok {
+		return domain.Name
+	}
+	return strings.ToLower(strings.TrimSpace(name))
+}
+
+// ShortURL возвращает короткую ссылку id на домене name
+func ShortURL(name string, id string) string {
+	domain, ok := Lookup(name)
+	if !ok {
+		// домен убран из настроек: адрес строится по схеме домена по умолчанию
+		scheme := "https"
+		if u, err := url.Parse(config.Flags.BaseResultAddress); err == nil && u.Scheme != "" {
+			scheme = u.Scheme
+		}
+		domain.BaseURL = scheme + "://" + name
+	}
+	return domain.BaseURL + "/" + id
+}
+
+// All возвращает домен по умолчанию и дополнительные домены в порядке имён
+func All() []Domain {
+	mu.RLock()
+	result := make([]Domain, 0, len(extra)+1)
+	for _, domain := range extra {
+		result = append(result, domain)
+	}
+	mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return append([]Domain{Default()}, result...)
+}
+
+// Host возвращает хост BaseURL домена в нижнем регистре
+func (d Domain) Host() string {
+	u, err := url.Parse(d.BaseURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Host)
+}
+
+// defaultHost хост домена по умолчанию
+func defaultHost() string {
+	return Default().Host()
+}
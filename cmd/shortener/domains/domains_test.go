@@ -0,0 +1,71 @@
+package domains
+
+import (
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestInitialize(t *testing.T) {
+	config.Flags.BaseResultAddress = "http://localhost:8080"
+	t.Cleanup(func() {
+		config.Flags.BaseResultAddress = ""
+		require.NoError(t, Initialize(""))
+	})
+
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{"empty", "", false},
+		{"two domains", "https://go.brand.com, http://Brand.link/", false},
+		{"not a base url", "https://go.brand.com/path", true},
+		{"no scheme", "go.brand.com", true},
+		{"default domain", "http://localhost:8080", true},
+		{"duplicate", "https://go.brand.com,http://go.brand.com", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Initialize(tt.value)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	config.Flags.BaseResultAddress = "http://localhost:8080"
+	require.NoError(t, Initialize("https://go.brand.com,http://brand.link"))
+	t.Cleanup(func() {
+		config.Flags.BaseResultAddress = ""
+		require.NoError(t, Initialize(""))
+	})
+
+	domain, ok := Lookup("GO.brand.com")
+	require.True(t, ok)
+	assert.Equal(t, Domain{Name: "go.brand.com", BaseURL: "https://go.brand.com"}, domain)
+
+	domain, ok = Lookup("localhost:8080")
+	require.True(t, ok)
+	assert.Equal(t, Default(), domain)
+
+	_, ok = Lookup("unknown.com")
+	assert.False(t, ok)
+	assert.Equal(t, Default(), FromHost("10.0.0.1:8080"))
+	assert.Equal(t, "brand.link", FromHost("brand.link").Name)
+
+	assert.Equal(t, "", Name("localhost:8080"))
+	assert.Equal(t, "old.brand.com", Name("Old.brand.com"))
+	assert.Equal(t, "https://go.brand.com/abc", ShortURL("go.brand.com", "abc"))
+	assert.Equal(t, "http://localhost:8080/abc", ShortURL("", "abc"))
+	assert.Equal(t, "http://old.brand.com/abc", ShortURL("old.brand.com", "abc"))
+
+	all := All()
+	require.Len(t, all, 3)
+	assert.Equal(t, []string{"", "brand.link", "go.brand.com"}, []string{all[0].Name, all[1].Name, all[2].Name})
+}
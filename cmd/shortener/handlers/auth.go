@@ -75,7 +75,7 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 			tokenString, err = BuildJWTString()
 
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
+				writeError(w, http.StatusInternalServerError, codeInternalError, "token is not issued")
 				return
 			}
 
@@ -90,8 +90,8 @@ func AuthMiddleware(next http.HandlerFunc) http.HandlerFunc {
 		userID, err := GetUserID(tokenString)
 
 		if err != nil {
-			w.WriteHeader(http.StatusUnauthorized)
 			logger.Log.Warn(err.Error())
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "token is not valid")
 			return
 		}
 
@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"context"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"github.com/fngoc/url-shortener/internal/models"
+	"go.uber.org/zap"
+	"sync"
+)
+
+const (
+	// clickQueueSize число переходов, которые ждут записи в хранилище
+	clickQueueSize = 10000
+	// clickWorkers число обработчиков, которые записывают переходы
+	clickWorkers = 4
+)
+
+// click переход по ссылке record на вариант variant или storage.NoVariant
+type click struct {
+	record  models.URLData
+	variant int
+}
+
+// ClickRecorder записывает переходы по ссылкам в фоне, чтобы запись статистики не задерживала редирект
+type ClickRecorder struct {
+	clicks chan click
+}
+
+// Clicks очередь записи переходов, nil — переход записывается сразу в обработчике редиректа
+var Clicks *ClickRecorder
+
+// NewClickRecorder создаёт очередь на queueSize переходов. Запись начинается после Run
+func NewClickRecorder(queueSize int) *ClickRecorder {
+	return &ClickRecorder{clicks: make(chan click, queueSize)}
+}
+
+// Run записывает переходы workers обработчиками, пока не отменён ctx, затем записывает оставшиеся
+// в очереди и возвращается
+func (c *ClickRecorder) Run(ctx context.Context, workers int) {
+	// принятый переход записывается и во время остановки
+	saveCtx := context.WithoutCancel(ctx)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case next := <-c.clicks:
+					saveClick(saveCtx, next)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for {
+		select {
+		case next := <-c.clicks:
+			saveClick(saveCtx, next)
+		default:
+			return
+		}
+	}
+}
+
+// record ставит переход в очередь. Если очередь заполнена, переход записывается сразу, чтобы не потеряться
+func (c *ClickRecorder) record(ctx context.Context, next click) {
+	select {
+	case c.clicks <- next:
+	default:
+		saveClick(ctx, next)
+	}
+}
+
+// InitializeClicks включает запись переходов в фоне, обработчики запускает RunClicks
+func InitializeClicks() {
+	Clicks = NewClickRecorder(clickQueueSize)
+}
+
+// RunClicks записывает переходы из очереди Clicks, пока не отменён ctx. Если очередь не настроена, сразу возвращается
+func RunClicks(ctx context.Context) {
+	if Clicks != nil {
+		Clicks.Run(ctx, clickWorkers)
+	}
+}
+
+// recordClick учитывает переход по ссылке в фоне через Clicks или сразу, если очередь не настроена
+func recordClick(ctx context.Context, record models.URLData, variant int) {
+	if Clicks != nil {
+		Clicks.record(ctx, click{record: record, variant: variant})
+		return
+	}
+	saveClick(ctx, click{record: record, variant: variant})
+}
+
+// saveClick записывает переход и сообщает подписчикам, если число переходов перешло их порог.
+// Ошибка хранилища только логируется
+func saveClick(ctx context.Context, next click) {
+	clicks, err := storage.Store.RecordClick(ctx, next.record.Domain, next.record.ShortURL, next.variant)
+	if err != nil {
+		logger.Log.Error("Click is not recorded", zap.String("short_url", next.record.ShortURL), zap.Error(err))
+		return
+	}
+	notifyClicks(ctx, next.record, clicks)
+}
@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// totalClicks возвращает число записанных переходов по ссылке
+func totalClicks(t *testing.T, shortURL string) int {
+	clicks, err := storage.Store.Clicks(context.Background(), "", shortURL)
+	require.NoError(t, err)
+	total := 0
+	for _, n := range clicks {
+		total += n
+	}
+	return total
+}
+
+func TestClickRecorder(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	ctx := context.WithValue(context.Background(), constants.UserIDKey, 1)
+	require.NoError(t, storage.Store.SaveRecord(ctx, models.URLData{ShortURL: "clicked", OriginalURL: "https://ya.ru/clicked", UserID: 1}))
+	redirect := func() {
+		w := httptest.NewRecorder()
+		GetRedirectWebhook(w, httptest.NewRequest(http.MethodGet, "/clicked", nil))
+		require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+	}
+
+	Clicks = NewClickRecorder(10)
+	defer func() { Clicks = nil }()
+	for i := 0; i < 3; i++ {
+		redirect()
+	}
+	require.Zero(t, totalClicks(t, "clicked"), "clicks wait in the queue until Run")
+
+	runCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		Clicks.Run(runCtx, 2)
+		close(done)
+	}()
+	require.Eventually(t, func() bool {
+		return totalClicks(t, "clicked") == 3
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+	// после остановки очередь принимает переходы, пока не заполнится, а затем они записываются сразу
+	for i := 0; i < 10; i++ {
+		redirect()
+	}
+	require.Equal(t, 3, totalClicks(t, "clicked"))
+	redirect()
+	require.Equal(t, 4, totalClicks(t, "clicked"), "click is recorded at once when the queue is full")
+
+	Clicks.Run(runCtx, 2)
+	require.Equal(t, 14, totalClicks(t, "clicked"), "queued clicks are recorded when Run stops")
+}
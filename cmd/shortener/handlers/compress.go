@@ -0,0 +1,340 @@
+package handlers
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/klauspost/compress/zstd"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Поддерживаемые значения Content-Encoding, в порядке предпочтения при равных q
+const (
+	encodingBrotli = "br"
+	encodingZstd   = "zstd"
+	encodingGzip   = "gzip"
+)
+
+var preferredEncodings = []string{encodingBrotli, encodingZstd, encodingGzip}
+
+// encoder общий интерфейс gzip.Writer, brotli.Writer и zstd.Encoder
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoderPools пулы сжимающих writer'ов: создание каждого стоит десятков-сотен килобайт
+var encoderPools = map[string]*sync.Pool{
+	encodingGzip: {New: func() any {
+		return gzip.NewWriter(io.Discard)
+	}},
+	encodingBrotli: {New: func() any {
+		return brotli.NewWriterLevel(io.Discard, brotli.DefaultCompression)
+	}},
+	encodingZstd: {New: func() any {
+		zw, _ := zstd.NewWriter(io.Discard, zstd.WithEncoderConcurrency(1))
+		return zw
+	}},
+}
+
+// negotiateEncoding выбирает кодировку ответа по Accept-Encoding с учётом q-значений.
+// Пустая строка означает ответ без сжатия
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, found := strings.Cut(strings.TrimSpace(param), "=")
+			if found && strings.EqualFold(key, "q") {
+				parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+				if err != nil || parsed < 0 || parsed > 1 {
+					parsed = 0
+				}
+				q = parsed
+			}
+		}
+		weights[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, encoding := range preferredEncodings {
+		q, found := weights[encoding]
+		if !found {
+			q, found = weights["*"]
+		}
+		if found && q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// compressibleType сообщает, входит ли Content-Type ответа в config.Flags.CompressTypes
+func compressibleType(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, allowed := range strings.Split(config.Flags.CompressTypes, ",") {
+		if strings.EqualFold(strings.TrimSpace(allowed), mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// compressWriter реализует интерфейс http.ResponseWriter и позволяет прозрачно для сервера
+// сжимать передаваемые данные и выставлять правильные HTTP-заголовки. Решение о сжатии
+// откладывается, пока ответ не наберёт config.Flags.CompressMinSize байт, не будет сброшен
+// через Flush или не завершится
+type compressWriter struct {
+	w        http.ResponseWriter
+	encoding string
+	enc      encoder
+	buf      []byte
+	status   int
+	decided  bool
+}
+
+func newCompressWriter(w http.ResponseWriter, encoding string) *compressWriter {
+	return &compressWriter{w: w, encoding: encoding}
+}
+
+func (c *compressWriter) Header() http.Header {
+	return c.w.Header()
+}
+
+func (c *compressWriter) WriteHeader(statusCode int) {
+	if c.status != 0 || c.decided {
+		return
+	}
+	c.status = statusCode
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if !c.decided {
+		c.buf = append(c.buf, p...)
+		if len(c.buf) < config.Flags.CompressMinSize {
+			return len(p), nil
+		}
+		if err := c.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if c.enc != nil {
+		return c.enc.Write(p)
+	}
+	return c.w.Write(p)
+}
+
+// decide отправляет заголовки и накопленный буфер. Ответ сжимается, если allowSize и
+// статус, Content-Type и уже выставленные заголовки это допускают
+func (c *compressWriter) decide(allowSize bool) error {
+	c.decided = true
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+
+	header := c.w.Header()
+	if allowSize && c.compressible() {
+		c.enc = encoderPools[c.encoding].Get().(encoder)
+		c.enc.Reset(c.w)
+		header.Set("Content-Encoding", c.encoding)
+		header.Del("Content-Length")
+	}
+	c.w.WriteHeader(c.status)
+
+	buf := c.buf
+	c.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if c.enc != nil {
+		_, err = c.enc.Write(buf)
+	} else {
+		_, err = c.w.Write(buf)
+	}
+	return err
+}
+
+// compressible сообщает, можно ли сжимать ответ: редиректы, ответы без тела, частичные и
+// уже сжатые ответы отдаются как есть
+func (c *compressWriter) compressible() bool {
+	header := c.w.Header()
+	switch {
+	case c.status < http.StatusOK, c.status == http.StatusNoContent,
+		c.status >= http.StatusMultipleChoices && c.status < http.StatusBadRequest:
+		return false
+	case header.Get("Content-Encoding") != "", header.Get("Content-Range") != "":
+		return false
+	}
+	return compressibleType(header.Get("Content-Type"))
+}
+
+// Flush досылает сжатые данные клиенту, не закрывая поток. Потоковый ответ сжимается
+// независимо от размера первой порции
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		_ = c.decide(true)
+	}
+	if c.enc != nil {
+		_ = c.enc.Flush()
+	}
+	if f, ok := c.w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close дописывает ответ и возвращает writer в пул. Ответ меньше config.Flags.CompressMinSize
+// отдаётся без сжатия
+func (c *compressWriter) Close() error {
+	if !c.decided {
+		if c.status == 0 {
+			return nil
+		}
+		return c.decide(false)
+	}
+	if c.enc == nil {
+		return nil
+	}
+	err := c.enc.Close()
+	c.enc.Reset(io.Discard)
+	encoderPools[c.encoding].Put(c.enc)
+	c.enc = nil
+	return err
+}
+
+// gzipReaderPool пул gzip.Reader'ов для распаковки запросов
+var gzipReaderPool sync.Pool
+
+// compressReader реализует интерфейс io.ReadCloser и позволяет прозрачно для сервера
+// декомпрессировать получаемые от клиента данные
+type compressReader struct {
+	r     io.ReadCloser
+	dr    io.Reader
+	close func()
+}
+
+func newCompressReader(r io.ReadCloser, encoding string) (*compressReader, error) {
+	switch encoding {
+	case encodingGzip:
+		zr, ok := gzipReaderPool.Get().(*gzip.Reader)
+		var err error
+		if ok {
+			err = zr.Reset(r)
+		} else {
+			zr, err = gzip.NewReader(r)
+		}
+		if err != nil {
+			if zr != nil {
+				gzipReaderPool.Put(zr)
+			}
+			return nil, err
+		}
+		return &compressReader{r: r, dr: zr, close: func() {
+			_ = zr.Close()
+			gzipReaderPool.Put(zr)
+		}}, nil
+	case encodingBrotli:
+		return &compressReader{r: r, dr: brotli.NewReader(r), close: func() {}}, nil
+	case encodingZstd:
+		zr, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, err
+		}
+		return &compressReader{r: r, dr: zr, close: zr.Close}, nil
+	}
+	return nil, fmt.Errorf("unsupported content encoding %q", encoding)
+}
+
+func (c *compressReader) Read(p []byte) (n int, err error) {
+	return c.dr.Read(p)
+}
+
+func (c *compressReader) Close() error {
+	c.close()
+	return c.r.Close()
+}
+
+// requestEncoding возвращает кодировку тела запроса из Content-Encoding, пустую для identity
+func requestEncoding(r *http.Request) (string, bool) {
+	encoding := strings.ToLower(strings.TrimSpace(r.Header.Get("Content-Encoding")))
+	switch encoding {
+	case "", "identity":
+		return "", true
+	case encodingGzip, "x-gzip":
+		return encodingGzip, true
+	case encodingBrotli, encodingZstd:
+		return encoding, true
+	}
+	return "", false
+}
+
+// CompressMiddleware — middleware для сжатия HTTP-запросов и ответов. Кодировка ответа
+// выбирается по Accept-Encoding, тело запроса распаковывается по Content-Encoding.
+// Размер тела ограничивается config.Flags.MaxBodySize и до, и после распаковки,
+// чтобы маленький архив не разворачивался в гигабайты
+func CompressMiddleware(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ow := w
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		maxBodySize := int64(config.Flags.MaxBodySize)
+		if maxBodySize > 0 {
+			r.Body = http.MaxBytesReader(w, r.Body, maxBodySize)
+		}
+
+		encoding, ok := requestEncoding(r)
+		if !ok {
+			w.Header().Set("Accept-Encoding", strings.Join(preferredEncodings, ", "))
+			writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, "content encoding is not supported")
+			return
+		}
+		if encoding != "" {
+			cr, err := newCompressReader(r.Body, encoding)
+			if err != nil {
+				var maxBytesErr *http.MaxBytesError
+				if errors.As(err, &maxBytesErr) {
+					writeBodyError(w, err)
+					return
+				}
+				writeError(w, http.StatusBadRequest, codeInvalidBody, "body is not valid "+encoding)
+				return
+			}
+			defer cr.Close()
+			r.Body = cr
+			if maxBodySize > 0 {
+				r.Body = http.MaxBytesReader(w, cr, maxBodySize)
+			}
+		}
+
+		if encoding := negotiateEncoding(r.Header.Get("Accept-Encoding")); encoding != "" {
+			cw := newCompressWriter(w, encoding)
+			ow = cw
+			defer cw.Close()
+		}
+
+		h.ServeHTTP(ow, r)
+	}
+}
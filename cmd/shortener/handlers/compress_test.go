@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"gzip", encodingGzip},
+		{"gzip, deflate, br", encodingBrotli},
+		{"gzip;q=1.0, br;q=0.5", encodingGzip},
+		{"br;q=0, zstd", encodingZstd},
+		{"*", encodingBrotli},
+		{"*;q=0.5, br;q=0", encodingZstd},
+		{"deflate, identity", ""},
+		{"GZIP ; Q=0.8", encodingGzip},
+		{"gzip;q=0", ""},
+		{"gzip;q=bad", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding, func(t *testing.T) {
+			assert.Equal(t, tt.want, negotiateEncoding(tt.acceptEncoding))
+		})
+	}
+}
+
+func TestCompressMiddleware_Response(t *testing.T) {
+	large := strings.Repeat(`{"short_url":"http://localhost:8080/abc"}`, 100)
+	decoders := map[string]func(io.Reader) (io.Reader, error){
+		encodingGzip: func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		encodingBrotli: func(r io.Reader) (io.Reader, error) {
+			return brotli.NewReader(r), nil
+		},
+		encodingZstd: func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) },
+	}
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		status         int
+		contentType    string
+		body           string
+		wantEncoding   string
+	}{
+		{"gzip", "gzip", http.StatusOK, "application/json", large, encodingGzip},
+		{"brotli", "gzip, br", http.StatusOK, "application/json", large, encodingBrotli},
+		{"zstd", "zstd, gzip;q=0.5", http.StatusCreated, "application/json; charset=utf-8", large, encodingZstd},
+		{"small body", "gzip", http.StatusOK, "application/json", `{"result":"ok"}`, ""},
+		{"not allowed type", "gzip", http.StatusOK, "image/png", large, ""},
+		{"redirect", "gzip", http.StatusTemporaryRedirect, "text/html", large, ""},
+		{"no accept encoding", "", http.StatusOK, "application/json", large, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := CompressMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", tt.contentType)
+				w.WriteHeader(tt.status)
+				// тело пишется частями, чтобы решение о сжатии принималось по накопленному размеру
+				for i := 0; i < len(tt.body); i += 100 {
+					_, _ = io.WriteString(w, tt.body[i:min(i+100, len(tt.body))])
+				}
+			})
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			w := httptest.NewRecorder()
+			handler(w, request)
+
+			require.Equal(t, tt.status, w.Code)
+			require.Equal(t, tt.wantEncoding, w.Header().Get("Content-Encoding"))
+			require.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+
+			var body io.Reader = w.Body
+			if tt.wantEncoding != "" {
+				require.Less(t, w.Body.Len(), len(tt.body))
+				var err error
+				body, err = decoders[tt.wantEncoding](w.Body)
+				require.NoError(t, err)
+			}
+			data, err := io.ReadAll(body)
+			require.NoError(t, err)
+			require.Equal(t, tt.body, string(data))
+		})
+	}
+}
+
+func TestCompressMiddleware_Request(t *testing.T) {
+	const payload = "https://practicum.yandex.ru/"
+	compress := map[string]func(*bytes.Buffer) io.WriteCloser{
+		encodingGzip: func(b *bytes.Buffer) io.WriteCloser { return gzip.NewWriter(b) },
+		encodingBrotli: func(b *bytes.Buffer) io.WriteCloser {
+			return brotli.NewWriter(b)
+		},
+		encodingZstd: func(b *bytes.Buffer) io.WriteCloser {
+			zw, _ := zstd.NewWriter(b)
+			return zw
+		},
+	}
+
+	for encoding, newWriter := range compress {
+		t.Run(encoding, func(t *testing.T) {
+			buf := bytes.Buffer{}
+			zw := newWriter(&buf)
+			_, err := zw.Write([]byte(payload))
+			require.NoError(t, err)
+			require.NoError(t, zw.Close())
+
+			var got string
+			handler := CompressMiddleware(func(w http.ResponseWriter, r *http.Request) {
+				data, err := io.ReadAll(r.Body)
+				require.NoError(t, err)
+				got = string(data)
+			})
+			request := httptest.NewRequest(http.MethodPost, "/", &buf)
+			request.Header.Set("Content-Encoding", encoding)
+			handler(httptest.NewRecorder(), request)
+			require.Equal(t, payload, got)
+		})
+	}
+
+	t.Run("unsupported encoding", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(payload))
+		request.Header.Set("Content-Encoding", "compress")
+		w := httptest.NewRecorder()
+		CompressMiddleware(PostSaveWebhook)(w, request)
+		require.Equal(t, http.StatusUnsupportedMediaType, w.Code)
+	})
+}
@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/domains"
+	"github.com/fngoc/url-shortener/cmd/shortener/policy"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/normalizer"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// csvChunkSize сколько строк CSV сохраняется и отдаётся клиенту за один раз
+const csvChunkSize = 100
+
+// Статусы строк в ответе PostShortenCSVWebhook
+const (
+	csvStatusCreated    = "created"
+	csvStatusConflict   = "conflict"
+	csvStatusAliasTaken = "alias_taken"
+	csvStatusInvalid    = "invalid"
+	csvStatusBlocked    = "blocked"
+	csvStatusError      = "error"
+)
+
+var csvResponseHeader = []string{"url", "alias", "short_url", "status", "error"}
+
+var aliasPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// csvRow строка входного CSV, err заполняется, если строку не удалось разобрать
+type csvRow struct {
+	item batchItem
+	err  error
+}
+
+// PostShortenCSVWebhook функция обработчик POST HTTP-запроса для сокращения ссылок из CSV
+// с колонками url, alias и expiry. Файл читается и ответ пишется потоково, по csvChunkSize строк.
+// Параметр domain выбирает домен для всех ссылок файла
+func PostShortenCSVWebhook(w http.ResponseWriter, r *http.Request) {
+	contentType := r.Header.Get("Content-Type")
+	allowedTextCSV := strings.Contains(contentType, "text/csv")
+	gzipTextCSV := strings.Contains(contentType, "gzip")
+
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if !allowedTextCSV && !gzipTextCSV {
+		writeUnsupportedMedia(w, "text/csv")
+		return
+	}
+	domain, err := requestDomain(r.URL.Query().Get("domain"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	reader := csv.NewReader(r.Body)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.WriteHeader(http.StatusOK)
+
+	writer := csv.NewWriter(w)
+	_ = writer.Write(csvResponseHeader)
+
+	rows := make([]csvRow, 0, csvChunkSize)
+	count := 0
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			rows = append(rows, csvRow{err: err})
+			break
+		}
+		if count == 0 && strings.EqualFold(strings.TrimSpace(record[0]), "url") {
+			continue
+		}
+
+		count++
+		if count > config.Flags.CSVMaxRows {
+			rows = append(rows, csvRow{err: fmt.Errorf("max rows limit %d exceeded", config.Flags.CSVMaxRows)})
+			break
+		}
+		row := parseCSVRow(record)
+		row.item.domain = domain
+		rows = append(rows, row)
+
+		if len(rows) == csvChunkSize {
+			writeCSVChunk(r, writer, w, rows)
+			rows = rows[:0]
+		}
+	}
+	writeCSVChunk(r, writer, w, rows)
+}
+
+// writeCSVChunk сохраняет разобранные строки и пишет результаты клиенту в порядке строк
+func writeCSVChunk(r *http.Request, writer *csv.Writer, w http.ResponseWriter, rows []csvRow) {
+	items := make([]batchItem, 0, len(rows))
+	for _, row := range rows {
+		if row.err == nil {
+			items = append(items, row.item)
+		}
+	}
+	results := saveBatch(r.Context(), items)
+
+	for _, row := range rows {
+		if row.err != nil {
+			_ = writer.Write([]string{row.item.originalURL, row.item.alias, "", csvStatusInvalid, row.err.Error()})
+			continue
+		}
+		result := results[0]
+		results = results[1:]
+		_ = writer.Write(csvResultRow(row.item, result))
+	}
+
+	writer.Flush()
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func csvResultRow(item batchItem, result batchResult) []string {
+	if result.err == nil {
+		return []string{item.originalURL, item.alias, domains.ShortURL(item.domain, result.id), csvStatusCreated, ""}
+	}
+
+	var dbErr *storage.DBError
+	var violation *policy.Violation
+	switch {
+	case errors.As(result.err, &violation):
+		return []string{item.originalURL, item.alias, "", csvStatusBlocked, violation.Error()}
+	case errors.As(result.err, &dbErr):
+		return []string{item.originalURL, item.alias, domains.ShortURL(item.domain, dbErr.ShortURL), csvStatusConflict, ""}
+	case errors.Is(result.err, storage.ErrAlreadyExists):
+		return []string{item.originalURL, item.alias, "", csvStatusAliasTaken, result.err.Error()}
+	case errors.Is(result.err, normalizer.ErrInvalidURL):
+		return []string{item.originalURL, item.alias, "", csvStatusInvalid, result.err.Error()}
+	}
+	return []string{item.originalURL, item.alias, "", csvStatusError, result.err.Error()}
+}
+
+// parseCSVRow разбирает строку url[,alias[,expiry]]
+func parseCSVRow(record []string) csvRow {
+	row := csvRow{}
+	fields := make([]string, 3)
+	for i := 0; i < len(record) && i < len(fields); i++ {
+		fields[i] = strings.TrimSpace(record[i])
+	}
+	row.item.originalURL = fields[0]
+	row.item.alias = fields[1]
+
+	if row.item.originalURL == "" {
+		row.err = fmt.Errorf("url is empty")
+		return row
+	}
+	if row.item.alias != "" && !aliasPattern.MatchString(row.item.alias) {
+		row.err = fmt.Errorf("alias must match %s", aliasPattern.String())
+		return row
+	}
+	expiresAt, err := parseExpiry(fields[2])
+	if err != nil {
+		row.err = err
+		return row
+	}
+	row.item.expiresAt = expiresAt
+	return row
+}
+
+// parseExpiry разбирает срок жизни ссылки: время в RFC 3339 или длительность от текущего момента, например 72h
+func parseExpiry(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return nil, fmt.Errorf("expiry must be RFC 3339 time or positive duration")
+	}
+	t := time.Now().Add(d)
+	return &t, nil
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/csv"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPostShortenCSVWebhook(t *testing.T) {
+	config.Flags.BaseResultAddress = "http://localhost:8080"
+	config.Flags.CSVMaxRows = 5
+
+	tests := []struct {
+		name     string
+		body     string
+		statuses []string
+	}{
+		{
+			"header and rows",
+			"url,alias,expiry\nhttps://ya.ru,,\nhttps://google.com,my-alias,24h\n",
+			[]string{csvStatusCreated, csvStatusCreated},
+		},
+		{
+			"conflict and taken alias",
+			"https://exists.ru\nhttps://new.ru,taken\n",
+			[]string{csvStatusConflict, csvStatusAliasTaken},
+		},
+		{
+			"invalid rows",
+			",\nhttps://ya.ru,bad alias\nhttps://ya.ru,,tomorrow\n",
+			[]string{csvStatusInvalid, csvStatusInvalid, csvStatusInvalid},
+		},
+		{
+			"max rows",
+			strings.Repeat("https://ya.ru/page\n", 6),
+			[]string{csvStatusCreated, csvStatusConflict, csvStatusConflict, csvStatusConflict, csvStatusConflict, csvStatusInvalid},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.NoError(t, storage.InitializeInMemoryLocalStore())
+			require.NoError(t, storage.Store.SaveData(context.TODO(), "taken", "https://exists.ru/"))
+
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten/csv", strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", "text/csv")
+			request = request.WithContext(context.WithValue(request.Context(), constants.UserIDKey, 1))
+			w := httptest.NewRecorder()
+
+			PostShortenCSVWebhook(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+
+			require.Equal(t, http.StatusOK, res.StatusCode)
+			require.Equal(t, "text/csv", res.Header.Get("Content-Type"))
+
+			records, err := csv.NewReader(res.Body).ReadAll()
+			require.NoError(t, err)
+			require.Equal(t, csvResponseHeader, records[0])
+
+			statuses := make([]string, 0, len(records)-1)
+			for _, record := range records[1:] {
+				statuses = append(statuses, record[3])
+			}
+			require.Equal(t, tt.statuses, statuses)
+		})
+	}
+}
+
+func TestPostShortenCSVWebhook_Gzip(t *testing.T) {
+	config.Flags.CSVMaxRows = 10
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	_, err := zw.Write([]byte("https://ya.ru\n"))
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten/csv", &body)
+	request.Header.Set("Content-Type", "text/csv")
+	request.Header.Set("Content-Encoding", "gzip")
+	w := httptest.NewRecorder()
+
+	CompressMiddleware(PostShortenCSVWebhook)(w, request)
+	res := w.Result()
+	defer res.Body.Close()
+
+	records, err := csv.NewReader(res.Body).ReadAll()
+	require.NoError(t, err)
+	require.Len(t, records, 2)
+	require.Equal(t, csvStatusCreated, records[1][3])
+}
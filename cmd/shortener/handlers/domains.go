@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/domains"
+	"github.com/fngoc/url-shortener/internal/models"
+	"net/http"
+	"strings"
+)
+
+// errUnknownDomain возвращается, если запрошенного домена нет в настройках сервиса
+var errUnknownDomain = errors.New("unknown domain")
+
+// requestDomain возвращает имя домена новой ссылки: домен из запроса или домен по умолчанию
+func requestDomain(name string) (string, error) {
+	domain, ok := domains.Lookup(name)
+	if !ok {
+		return "", fmt.Errorf("%w: %s", errUnknownDomain, name)
+	}
+	return domain.Name, nil
+}
+
+// splitLinkID разбирает идентификатор ссылки вида "id" на домене по умолчанию или "domain/id"
+func splitLinkID(value string) (domain string, id string) {
+	if i := strings.LastIndex(value, "/"); i >= 0 {
+		return domains.Name(value[:i]), value[i+1:]
+	}
+	return "", value
+}
+
+// GetDomainsWebhook функция обработчик GET HTTP-запроса для получения доменов, на которых
+// можно создавать короткие ссылки
+func GetDomainsWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	all := domains.All()
+	resp := make([]models.Domain, 0, len(all))
+	for _, domain := range all {
+		resp = append(resp, models.Domain{Name: domain.Host(), BaseURL: domain.BaseURL, Default: domain.Name == ""})
+	}
+
+	buf := bytes.Buffer{}
+	if err := json.NewEncoder(&buf).Encode(resp); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/domains"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDomains(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	defer func(base string) { config.Flags.BaseResultAddress = base }(config.Flags.BaseResultAddress)
+	config.Flags.BaseResultAddress = "http://localhost:8080"
+	require.NoError(t, domains.Initialize("https://go.brand.com"))
+	t.Cleanup(func() { require.NoError(t, domains.Initialize("")) })
+
+	ctx := context.WithValue(context.Background(), constants.UserIDKey, 1)
+	require.NoError(t, storage.Store.SaveRecord(ctx, models.URLData{ShortURL: "sale", OriginalURL: "https://ya.ru/sale", UserID: 1}))
+	require.NoError(t, storage.Store.SaveRecord(ctx, models.URLData{
+		ShortURL: "sale", OriginalURL: "https://ya.ru/brand-sale", UserID: 1, Domain: "go.brand.com",
+	}))
+
+	t.Run("redirect by host", func(t *testing.T) {
+		for host, location := range map[string]string{
+			"go.brand.com":   "https://ya.ru/brand-sale",
+			"localhost:8080": "https://ya.ru/sale",
+			"10.0.0.1":       "https://ya.ru/sale",
+		} {
+			request := httptest.NewRequest(http.MethodGet, "/sale", nil)
+			request.Host = host
+			w := httptest.NewRecorder()
+			GetRedirectWebhook(w, request)
+			require.Equal(t, http.StatusTemporaryRedirect, w.Code, host)
+			require.Equal(t, location, w.Header().Get("Location"), host)
+		}
+	})
+
+	t.Run("shorten on domain", func(t *testing.T) {
+		tests := []struct {
+			name       string
+			body       string
+			statusCode int
+			prefix     string
+		}{
+			{"brand domain", `{"url":"https://ya.ru/new","domain":"go.brand.com"}`, http.StatusCreated, "https://go.brand.com/"},
+			{"same url on default domain", `{"url":"https://ya.ru/new"}`, http.StatusCreated, "http://localhost:8080/"},
+			{"conflict within domain", `{"url":"https://ya.ru/brand-sale","domain":"GO.brand.com"}`, http.StatusConflict, "https://go.brand.com/sale"},
+			{"unknown domain", `{"url":"https://ya.ru/new","domain":"evil.com"}`, http.StatusBadRequest, ""},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(tt.body))
+				request.Header.Set("Content-Type", "application/json")
+				w := httptest.NewRecorder()
+				PostShortenWebhook(w, request.WithContext(ctx))
+				require.Equal(t, tt.statusCode, w.Code)
+				if tt.prefix != "" {
+					var resp models.Response
+					require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+					require.True(t, strings.HasPrefix(resp.Result, tt.prefix), resp.Result)
+				}
+			})
+		}
+	})
+
+	t.Run("edit link on domain", func(t *testing.T) {
+		request := httptest.NewRequest(http.MethodPatch, "/api/user/urls/sale?domain=go.brand.com", strings.NewReader(`{"folder":"brand"}`))
+		request.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		PatchUrlWebhook(w, request.WithContext(ctx))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp models.ResponseDto
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		require.Equal(t, "https://go.brand.com/sale", resp.ShortURL)
+		require.Equal(t, "go.brand.com", resp.Domain)
+
+		record, err := storage.Store.GetRecord(ctx, "", "sale")
+		require.NoError(t, err)
+		require.Empty(t, record.Folder)
+	})
+
+	t.Run("list domains", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		GetDomainsWebhook(w, httptest.NewRequest(http.MethodGet, "/api/domains", nil))
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var resp []models.Domain
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+		require.Equal(t, []models.Domain{
+			{Name: "localhost:8080", BaseURL: "http://localhost:8080", Default: true},
+			{Name: "go.brand.com", BaseURL: "https://go.brand.com"},
+		}, resp)
+	})
+}
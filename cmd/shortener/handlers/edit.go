@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/domains"
+	"github.com/fngoc/url-shortener/cmd/shortener/preview"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/cmd/shortener/utm"
+	"github.com/fngoc/url-shortener/internal/models"
+	"net/http"
+	"strings"
+)
+
+const (
+	userUrlsPathPrefix = "/api/user/urls/"
+	historyPathSuffix  = "/history"
+)
+
+// PatchUrlWebhook функция обработчик PATCH HTTP-запроса для изменения адреса назначения, срока жизни,
+// кода редиректа, меток, папки, правил перенаправления, вариантов A/B-теста, UTM-меток
+// и режима дописывания пути ссылки пользователя. Ссылка другого домена выбирается параметром domain
+func PatchUrlWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPatch {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if !strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		writeUnsupportedMedia(w, "application/json")
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, userUrlsPathPrefix)
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "short url id is empty")
+		return
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	var req models.LinkUpdate
+	if err := dec.Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	var changes storage.LinkChanges
+	if req.URL != nil {
+		originalURL, err := prepareURL(r.Context(), *req.URL)
+		if err != nil {
+			writeSaveError(w, err)
+			return
+		}
+		changes.OriginalURL = &originalURL
+	}
+	if req.ExpiresAt != nil {
+		expiresAt, err := parseExpiry(*req.ExpiresAt)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+			return
+		}
+		changes.ExpiresAt = expiresAt
+		changes.ClearExpiresAt = expiresAt == nil
+	}
+	if req.RedirectType != nil {
+		if *req.RedirectType != 0 && !IsRedirectType(*req.RedirectType) {
+			writeError(w, http.StatusBadRequest, codeInvalidBody, "redirect_type must be 301, 302, 307 or 308")
+			return
+		}
+		changes.RedirectType = req.RedirectType
+	}
+	if req.Tags != nil {
+		tags, err := prepareTags(*req.Tags)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+			return
+		}
+		changes.Tags = &tags
+	}
+	if req.Folder != nil {
+		folder, err := prepareFolder(*req.Folder)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+			return
+		}
+		changes.Folder = &folder
+	}
+	if req.Rules != nil {
+		rules, err := prepareRules(r.Context(), *req.Rules)
+		if err != nil {
+			writeSaveError(w, err)
+			return
+		}
+		changes.Rules = &rules
+	}
+	if req.Variants != nil {
+		variants, err := prepareVariants(r.Context(), *req.Variants)
+		if err != nil {
+			writeSaveError(w, err)
+			return
+		}
+		changes.Variants = &variants
+	}
+	if req.UTM != nil {
+		utmTemplate, err := utm.Validate(*req.UTM)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+			return
+		}
+		changes.UTM = &utmTemplate
+	}
+	changes.PrefixMode = req.PrefixMode
+
+	userID := r.Context().Value(constants.UserIDKey).(int)
+	domain := domains.Name(r.URL.Query().Get("domain"))
+	if _, err := findLink(r.Context(), domain, id, userID, storage.RoleEditor); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	record, err := storage.Store.UpdateRecord(r.Context(), domain, id, changes)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if changes.OriginalURL != nil && record.Title == "" {
+		preview.Enqueue(domain, id, record.OriginalURL)
+	}
+
+	buf := bytes.Buffer{}
+	if err := json.NewEncoder(&buf).Encode(toResponseDtos([]models.URLData{record})[0]); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// GetUrlHistoryWebhook функция обработчик GET HTTP-запроса для получения прежних адресов
+// назначения ссылки пользователя, последние первыми. Домен ссылки задаётся параметром domain
+func GetUrlHistoryWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, userUrlsPathPrefix), historyPathSuffix)
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "short url id is empty")
+		return
+	}
+
+	userID := r.Context().Value(constants.UserIDKey).(int)
+	domain := domains.Name(r.URL.Query().Get("domain"))
+	if _, err := findLink(r.Context(), domain, id, userID, storage.RoleViewer); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	history, err := storage.Store.History(r.Context(), domain, id)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if len(history) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	buf := bytes.Buffer{}
+	if err := json.NewEncoder(&buf).Encode(history); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPatchUrlWebhook(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	ctx := context.WithValue(context.Background(), constants.UserIDKey, 1)
+	require.NoError(t, storage.Store.SaveData(ctx, "key", "https://ya.ru/typo"))
+	require.NoError(t, storage.Store.SaveData(ctx, "other", "https://ya.ru/other"))
+
+	tests := []struct {
+		name       string
+		target     string
+		body       string
+		userID     int
+		statusCode int
+		code       string
+	}{
+		{"new destination", "/api/user/urls/key", `{"url":"https://ya.ru/fixed","redirect_type":308,"expires_at":"24h"}`, 1, http.StatusOK, ""},
+		{"clear expiry", "/api/user/urls/key", `{"expires_at":""}`, 1, http.StatusOK, ""},
+		{"destination of other link", "/api/user/urls/key", `{"url":"https://ya.ru/other"}`, 1, http.StatusConflict, codeConflict},
+		{"invalid url", "/api/user/urls/key", `{"url":"ya.ru"}`, 1, http.StatusBadRequest, codeInvalidURL},
+		{"invalid redirect type", "/api/user/urls/key", `{"redirect_type":200}`, 1, http.StatusBadRequest, codeInvalidBody},
+		{"invalid expiry", "/api/user/urls/key", `{"expires_at":"tomorrow"}`, 1, http.StatusBadRequest, codeInvalidBody},
+		{"unknown field", "/api/user/urls/key", `{"title":"x"}`, 1, http.StatusBadRequest, codeInvalidBody},
+		{"link of other user", "/api/user/urls/key", `{"url":"https://ya.ru/stolen"}`, 2, http.StatusNotFound, codeNotFound},
+		{"missing link", "/api/user/urls/missing", `{"url":"https://ya.ru/missing"}`, 1, http.StatusNotFound, codeNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPatch, tt.target, strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", "application/json")
+			request = request.WithContext(context.WithValue(request.Context(), constants.UserIDKey, tt.userID))
+			w := httptest.NewRecorder()
+
+			PatchUrlWebhook(w, request)
+			require.Equal(t, tt.statusCode, w.Code)
+			if tt.code != "" {
+				var resp problem
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+				require.Equal(t, tt.code, resp.Code)
+			}
+		})
+	}
+
+	record, err := storage.Store.GetRecord(ctx, "", "key")
+	require.NoError(t, err)
+	require.Equal(t, "https://ya.ru/fixed", record.OriginalURL)
+	require.Equal(t, http.StatusPermanentRedirect, record.RedirectType)
+	require.Nil(t, record.ExpiresAt)
+
+	request := httptest.NewRequest(http.MethodGet, "/api/user/urls/key/history", nil)
+	request = request.WithContext(ctx)
+	w := httptest.NewRecorder()
+	GetUrlHistoryWebhook(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var history []models.HistoryEntry
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&history))
+	require.Len(t, history, 1)
+	require.Equal(t, "https://ya.ru/typo", history[0].OriginalURL)
+
+	request = httptest.NewRequest(http.MethodGet, "/api/user/urls/other/history", nil)
+	w = httptest.NewRecorder()
+	GetUrlHistoryWebhook(w, request.WithContext(ctx))
+	require.Equal(t, http.StatusNoContent, w.Code)
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/fngoc/url-shortener/cmd/shortener/policy"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"github.com/fngoc/url-shortener/internal/normalizer"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+const problemContentType = "application/problem+json"
+
+// Коды ошибок в поле code ответа application/problem+json
+const (
+	codeBadRequest         = "bad_request"
+	codeMethodNotAllowed   = "method_not_allowed"
+	codeUnsupportedMedia   = "unsupported_media_type"
+	codeInvalidBody        = "invalid_body"
+	codeBodyTooLarge       = "body_too_large"
+	codeInvalidURL         = "invalid_url"
+	codeDestinationBlocked = "destination_blocked"
+	codeUnauthorized       = "unauthorized"
+	codeForbidden          = "forbidden"
+	codeNotFound           = "not_found"
+	codeGone               = "gone"
+	codeConflict           = "conflict"
+	codeAlreadyExists      = "already_exists"
+	codeRateLimited        = "rate_limited"
+	codeStorageError       = "storage_error"
+	codeInternalError      = "internal_error"
+)
+
+// problem тело ошибки по RFC 9457 с кодом ошибки в поле code
+type problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Code   string `json:"code"`
+	Detail string `json:"detail,omitempty"`
+	// ShortURL существующая сокращённая ссылка при конфликте
+	ShortURL string `json:"short_url,omitempty"`
+	// Violation нарушение политики при code=destination_blocked
+	Violation *policy.Violation `json:"violation,omitempty"`
+}
+
+// writeProblem отвечает ошибкой в формате application/problem+json
+func writeProblem(w http.ResponseWriter, p problem) {
+	if p.Type == "" {
+		p.Type = "about:blank"
+	}
+	p.Title = http.StatusText(p.Status)
+	w.Header().Set("Content-Type", problemContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+// writeError отвечает ошибкой status с кодом code и описанием detail
+func writeError(w http.ResponseWriter, status int, code, detail string) {
+	writeProblem(w, problem{Status: status, Code: code, Detail: detail})
+}
+
+// writeBodyError отвечает на ошибку чтения или разбора тела запроса: 413, если тело больше
+// config.Flags.MaxBodySize, иначе 400
+func writeBodyError(w http.ResponseWriter, err error) {
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeBodyTooLarge, err.Error())
+		return
+	}
+	writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+}
+
+// writeStorageError отвечает на ошибку хранилища. Ошибки, не связанные с данными запроса,
+// считаются отказом хранилища и возвращаются как 500 без подробностей
+func writeStorageError(w http.ResponseWriter, err error) {
+	var dbErr *storage.DBError
+	var deleteErr *storage.DBDeleteError
+	switch {
+	case errors.As(err, &dbErr):
+		writeProblem(w, problem{Status: http.StatusConflict, Code: codeConflict,
+			Detail: "url is already shortened", ShortURL: dbErr.ShortURL})
+	case errors.Is(err, storage.ErrAlreadyExists):
+		writeError(w, http.StatusConflict, codeAlreadyExists, "short url is already taken")
+	case errors.Is(err, storage.ErrNotFound):
+		writeError(w, http.StatusNotFound, codeNotFound, "short url is not found")
+	case errors.As(err, &deleteErr):
+		writeError(w, http.StatusGone, codeGone, "short url is deleted or expired")
+	case errors.Is(err, errForbidden):
+		writeError(w, http.StatusForbidden, codeForbidden, "workspace role does not allow this action")
+	case errors.Is(err, errLastOwner):
+		writeError(w, http.StatusConflict, codeConflict, err.Error())
+	case errors.Is(err, storage.ErrInvalidListOptions):
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+	default:
+		logger.Log.Error("Storage request failed", zap.Error(err))
+		writeError(w, http.StatusInternalServerError, codeStorageError, "storage is unavailable")
+	}
+}
+
+// writeSaveError отвечает на ошибку сохранения ссылки: ошибки prepareURL, prepareRules, prepareVariants, тела запроса или хранилища
+func writeSaveError(w http.ResponseWriter, err error) {
+	var violation *policy.Violation
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.As(err, &violation):
+		writeProblem(w, problem{Status: http.StatusUnprocessableEntity, Code: codeDestinationBlocked,
+			Detail: violation.Reason, Violation: violation})
+	case errors.Is(err, normalizer.ErrInvalidURL):
+		writeError(w, http.StatusBadRequest, codeInvalidURL, err.Error())
+	case errors.Is(err, errInvalidRule), errors.Is(err, errInvalidVariant):
+		writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+	case errors.As(err, &maxBytesErr):
+		writeBodyError(w, err)
+	default:
+		writeStorageError(w, err)
+	}
+}
+
+// writeMethodNotAllowed отвечает на запрос с неподдерживаемым методом
+func writeMethodNotAllowed(w http.ResponseWriter) {
+	writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "method is not allowed")
+}
+
+// writeUnsupportedMedia отвечает на запрос с неподходящим Content-Type
+func writeUnsupportedMedia(w http.ResponseWriter, expected string) {
+	writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedMedia, "content type must be "+expected)
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// failingStore хранилище, недоступное для любых запросов
+type failingStore struct {
+	storage.Repository
+}
+
+func (failingStore) GetRecord(context.Context, string, string) (models.URLData, error) {
+	return models.URLData{}, errors.New("connection refused")
+}
+
+func (failingStore) SaveData(context.Context, string, string) error {
+	return errors.New("connection refused")
+}
+
+func TestStorageFailure(t *testing.T) {
+	storage.Store = failingStore{}
+	t.Cleanup(func() { require.NoError(t, storage.InitializeInMemoryLocalStore()) })
+
+	tests := []struct {
+		name    string
+		request *http.Request
+		handler http.HandlerFunc
+	}{
+		{"redirect", httptest.NewRequest(http.MethodGet, "/abc", nil), GetRedirectWebhook},
+		{"save", newTextRequest("https://ya.ru"), PostSaveWebhook},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, tt.request)
+
+			require.Equal(t, http.StatusInternalServerError, w.Code)
+			require.Equal(t, problemContentType, w.Header().Get("Content-Type"))
+			var resp problem
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			assert.Equal(t, codeStorageError, resp.Code)
+			assert.NotContains(t, resp.Detail, "connection refused")
+		})
+	}
+}
+
+func TestRedirectNotFound(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	w := httptest.NewRecorder()
+	GetRedirectWebhook(w, httptest.NewRequest(http.MethodGet, "/missing", nil))
+	require.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestCompressMiddleware_BodyLimit(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	defer func(size int) { config.Flags.MaxBodySize = size }(config.Flags.MaxBodySize)
+	config.Flags.MaxBodySize = 4096
+
+	gzipped := func(data []byte) []byte {
+		buf := bytes.Buffer{}
+		zw := gzip.NewWriter(&buf)
+		_, err := zw.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+	bomb := gzipped(bytes.Repeat([]byte("a"), 1<<20))
+	require.Less(t, len(bomb), config.Flags.MaxBodySize)
+
+	tests := []struct {
+		name       string
+		body       []byte
+		gzip       bool
+		statusCode int
+		code       string
+	}{
+		{"small body", []byte("https://ya.ru"), false, http.StatusCreated, ""},
+		{"large body", bytes.Repeat([]byte("a"), 8192), false, http.StatusRequestEntityTooLarge, codeBodyTooLarge},
+		{"small gzip", gzipped([]byte("https://google.com")), true, http.StatusCreated, ""},
+		{"gzip bomb", bomb, true, http.StatusRequestEntityTooLarge, codeBodyTooLarge},
+		{"not gzip", []byte("https://ya.ru"), true, http.StatusBadRequest, codeInvalidBody},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(tt.body))
+			request.Header.Set("Content-Type", "text/plain")
+			if tt.gzip {
+				request.Header.Set("Content-Encoding", "gzip")
+			}
+			w := httptest.NewRecorder()
+			CompressMiddleware(PostSaveWebhook)(w, request)
+
+			require.Equal(t, tt.statusCode, w.Code)
+			if tt.code != "" {
+				var resp problem
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+				assert.Equal(t, tt.code, resp.Code)
+			}
+		})
+	}
+}
+
+func newTextRequest(body string) *http.Request {
+	request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+	request.Header.Set("Content-Type", "text/plain")
+	return request
+}
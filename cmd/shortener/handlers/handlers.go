@@ -7,7 +7,11 @@ import (
 	"errors"
 	"github.com/fngoc/url-shortener/cmd/shortener/config"
 	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/domains"
+	"github.com/fngoc/url-shortener/cmd/shortener/preview"
 	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/cmd/shortener/utm"
+	"github.com/fngoc/url-shortener/cmd/shortener/webhooks"
 	"github.com/fngoc/url-shortener/internal/logger"
 	"github.com/fngoc/url-shortener/internal/models"
 	"github.com/fngoc/url-shortener/internal/utils"
@@ -15,42 +19,58 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"time"
 )
 
 type deleteJob struct {
 	userID int
+	domain string
 	url    string
 }
 
-// GetRedirectWebhook функция обработчик GET HTTP-запроса
+// GetRedirectWebhook функция обработчик GET HTTP-запроса. Для идентификатора с суффиксом "+"
+// и для ссылок с Interstitial вместо редиректа отдаётся страница предпросмотра.
+// Защищённая ссылка требует пароль в заголовке X-Link-Password, браузеру отдаётся форма пароля.
+// Код редиректа и Cache-Control зависят от RedirectType ссылки. Ссылка ищется на домене из заголовка Host,
+// путь после идентификатора дописывается к адресу назначения ссылок с PrefixMode,
+// адрес назначения выбирается правилами перенаправления и вариантами A/B-теста ссылки и дополняется
+// UTM-метками и параметрами запроса. Редирект учитывается в статистике переходов в фоне, см. Clicks
 func GetRedirectWebhook(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusBadRequest)
+		writeMethodNotAllowed(w)
 		return
 	}
 
-	id := strings.TrimPrefix(r.URL.Path, "/")
-	if id == "" {
-		w.WriteHeader(http.StatusBadRequest)
+	id, preview, suffix, err := parseRedirectPath(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
 		return
 	}
-	url, err := storage.Store.GetData(r.Context(), id)
+	record, err := findRedirectLink(r, id, suffix)
 	if err != nil {
-		var deleteErr *storage.DBDeleteError
-		if errors.As(err, &deleteErr) {
-			w.WriteHeader(http.StatusGone)
-			return
-		}
-		w.WriteHeader(http.StatusBadRequest)
+		writeStorageError(w, err)
+		return
+	}
+	if record.PasswordHash != "" && !verifyLinkPassword(w, r, record, r.Header.Get(passwordHeader)) {
+		return
+	}
+	target, variant := destination(w, r, record, suffix)
+	if preview || record.Interstitial {
+		// страница предпросмотра показывает тот же адрес, на который ведёт редирект
+		record.OriginalURL = target
+		writePreviewPage(w, record)
 		return
 	}
-	http.Redirect(w, r, url, http.StatusTemporaryRedirect)
+	status := redirectStatus(record)
+	setRedirectCacheHeaders(w, record, status)
+	recordClick(r.Context(), record, variant)
+	http.Redirect(w, r, target, status)
 }
 
 // GetUrlsWebhook функция обработчик GET HTTP-запроса для получения всех urls
 func GetUrlsWebhook(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusBadRequest)
+		writeMethodNotAllowed(w)
 		return
 	}
 
@@ -59,17 +79,37 @@ func GetUrlsWebhook(w http.ResponseWriter, r *http.Request) {
 		authHeader := r.Header.Get("Authorization")
 		if _, err := GetUserID(authHeader); err != nil {
 			logger.Log.Warn("Token in 'Authorization' header is not valid")
-			w.WriteHeader(http.StatusUnauthorized)
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "token is not valid")
 			return
 		}
 	}
 
 	userID := r.Context().Value(constants.UserIDKey).(int)
-	authCtx := context.WithValue(r.Context(), constants.UserIDKey, userID)
 
-	urls, err := storage.Store.GetAllData(authCtx)
+	opts, err := parseListOptions(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	if opts.WorkspaceID != 0 {
+		if err := authorizeWorkspace(r.Context(), opts.WorkspaceID, userID, storage.RoleViewer); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+	}
+
+	if r.URL.Query().Get("format") == "ndjson" || strings.Contains(r.Header.Get("Accept"), ndjsonContentType) {
+		streamUrls(w, r, userID, opts, writeNDJSON)
+		return
+	}
+	if opts.Limit == 0 {
+		streamUrls(w, r, userID, opts, writeJSONArray)
+		return
+	}
+
+	urls, next, err := storage.Store.ListData(r.Context(), userID, opts)
 	if err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeStorageError(w, err)
 		return
 	}
 	if len(urls) == 0 {
@@ -79,11 +119,14 @@ func GetUrlsWebhook(w http.ResponseWriter, r *http.Request) {
 
 	buf := bytes.Buffer{}
 	encode := json.NewEncoder(&buf)
-	if err := encode.Encode(urls); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if err := encode.Encode(toResponseDtos(urls)); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
 		return
 	}
 
+	if next != "" {
+		setNextCursor(w, r, next)
+	}
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write(buf.Bytes())
@@ -98,7 +141,7 @@ func DeleteUrlsWebhook(w http.ResponseWriter, r *http.Request) {
 	dec.DisallowUnknownFields()
 
 	if err := dec.Decode(&IDs); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
@@ -113,9 +156,11 @@ func DeleteUrlsWebhook(w http.ResponseWriter, r *http.Request) {
 	}
 
 	for j := 0; j < numJobs; j++ {
+		domain, id := splitLinkID(IDs[j])
 		item := deleteJob{
 			userID: userID,
-			url:    IDs[j],
+			domain: domain,
+			url:    id,
 		}
 		jobs <- item
 	}
@@ -123,9 +168,71 @@ func DeleteUrlsWebhook(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusAccepted)
 }
 
+// RestoreUrlsWebhook функция обработчик POST HTTP-запроса для отмены удаления urls в пределах
+// config.Flags.RestoreWindow. Ссылки, которые нельзя восстановить, пропускаются, как и при удалении
+func RestoreUrlsWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	userID := r.Context().Value(constants.UserIDKey).(int)
+	var IDs []string
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&IDs); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	deletedAfter := time.Now().Add(-config.Flags.RestoreWindow)
+	restored := make([]models.URLData, 0, len(IDs))
+	for _, value := range IDs {
+		domain, id := splitLinkID(value)
+		if _, err := findLink(r.Context(), domain, id, userID, storage.RoleEditor); err != nil {
+			if errors.Is(err, storage.ErrNotFound) || errors.Is(err, errForbidden) {
+				continue
+			}
+			writeStorageError(w, err)
+			return
+		}
+		record, err := storage.Store.RestoreData(r.Context(), domain, id, deletedAfter)
+		if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrNotRestorable) {
+			continue
+		}
+		if err != nil {
+			writeStorageError(w, err)
+			return
+		}
+		restored = append(restored, record)
+	}
+	if len(restored) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	buf := bytes.Buffer{}
+	if err := json.NewEncoder(&buf).Encode(toResponseDtos(restored)); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
+
+// deleteWorker удаляет ссылки, на которые у пользователя есть права редактора, и сообщает об удалении
+// подписчикам владельца ссылки. Остальные ссылки пропускаются
 func deleteWorker(jobs <-chan deleteJob) {
 	for j := range jobs {
-		storage.Store.DeleteData(j.userID, j.url)
+		if _, err := findLink(context.Background(), j.domain, j.url, j.userID, storage.RoleEditor); err != nil {
+			continue
+		}
+		if err := storage.Store.DeleteData(j.domain, j.url); err != nil {
+			continue
+		}
+		notifyLink(context.Background(), webhooks.EventLinkDeleted, j.domain, j.url)
 	}
 }
 
@@ -135,29 +242,43 @@ func PostSaveWebhook(w http.ResponseWriter, r *http.Request) {
 	allowedTextPlan := strings.Contains(contentType, "text/plain")
 	gzipTextPlan := strings.Contains(contentType, "gzip")
 
-	if r.Method != http.MethodPost || (!allowedTextPlan && !gzipTextPlan) {
-		w.WriteHeader(http.StatusBadRequest)
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if !allowedTextPlan && !gzipTextPlan {
+		writeUnsupportedMedia(w, "text/plain")
 		return
 	}
 
-	b, _ := io.ReadAll(r.Body)
-
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
 	if len(b) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "body is empty")
 		return
 	}
+	originalURL, err := prepareURL(r.Context(), plainTextURL(string(b)))
+	if err != nil {
+		writeSaveError(w, err)
+		return
+	}
+
 	id := utils.GenerateString(8)
-	err := storage.Store.SaveData(r.Context(), id, string(b))
+	err = storage.Store.SaveData(r.Context(), id, originalURL)
 	if err != nil {
 		var dbErr *storage.DBError
 		if errors.As(err, &dbErr) && pgerrcode.IsIntegrityConstraintViolation(dbErr.Err.Code) {
 			setResponsePostSaveWebhook(w, http.StatusConflict, dbErr.ShortURL)
 			return
-		} else {
-			w.WriteHeader(http.StatusBadRequest)
-			return
 		}
+		writeStorageError(w, err)
+		return
 	}
+	preview.Enqueue("", id, originalURL)
+	notifyLink(r.Context(), webhooks.EventLinkCreated, "", id)
 	setResponsePostSaveWebhook(w, http.StatusCreated, id)
 }
 
@@ -174,20 +295,98 @@ func PostShortenWebhook(w http.ResponseWriter, r *http.Request) {
 	allowedApplicationJSON := strings.Contains(contentType, "application/json")
 	gzipTextPlan := strings.Contains(contentType, "gzip")
 
-	if r.Method != http.MethodPost || (!allowedApplicationJSON && !gzipTextPlan) {
-		w.WriteHeader(http.StatusBadRequest)
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if !allowedApplicationJSON && !gzipTextPlan {
+		writeUnsupportedMedia(w, "application/json")
 		return
 	}
 
 	dec := json.NewDecoder(r.Body)
 	var req models.Request
 	if err := dec.Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBodyError(w, err)
+		return
+	}
+
+	originalURL, err := prepareURL(r.Context(), req.URL)
+	if err != nil {
+		writeSaveError(w, err)
+		return
+	}
+
+	if req.RedirectType != 0 && !IsRedirectType(req.RedirectType) {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "redirect_type must be 301, 302, 307 or 308")
+		return
+	}
+	domain, err := requestDomain(req.Domain)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+		return
+	}
+	tags, err := prepareTags(req.Tags)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+		return
+	}
+	folder, err := prepareFolder(req.Folder)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+		return
+	}
+	rules, err := prepareRules(r.Context(), req.Rules)
+	if err != nil {
+		writeSaveError(w, err)
+		return
+	}
+	variants, err := prepareVariants(r.Context(), req.Variants)
+	if err != nil {
+		writeSaveError(w, err)
+		return
+	}
+	utmTemplate, err := utm.Validate(req.UTM)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
 		return
 	}
+	var passwordHash string
+	if req.Password != "" {
+		if passwordHash, err = hashPassword(req.Password); err != nil {
+			if errors.Is(err, errInvalidPassword) {
+				writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+				return
+			}
+			writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+			return
+		}
+	}
 
+	userID, _ := r.Context().Value(constants.UserIDKey).(int)
+	if req.Workspace != 0 {
+		if err := authorizeWorkspace(r.Context(), req.Workspace, userID, storage.RoleEditor); err != nil {
+			writeStorageError(w, err)
+			return
+		}
+	}
 	id := utils.GenerateString(8)
-	err := storage.Store.SaveData(r.Context(), id, req.URL)
+	err = storage.Store.SaveRecord(r.Context(), models.URLData{
+		ShortURL:     id,
+		OriginalURL:  originalURL,
+		UserID:       userID,
+		Interstitial: req.Interstitial,
+		PasswordHash: passwordHash,
+		RedirectType: req.RedirectType,
+		Tags:         tags,
+		Folder:       folder,
+		WorkspaceID:  req.Workspace,
+		Domain:       domain,
+		Rules:        rules,
+		Variants:     variants,
+		UTM:          utmTemplate,
+		PrefixMode:   req.PrefixMode,
+	})
 	if err != nil {
 		var dbErr *storage.DBError
 		if errors.As(err, &dbErr) && pgerrcode.IsIntegrityConstraintViolation(dbErr.Err.Code) {
@@ -195,27 +394,37 @@ func PostShortenWebhook(w http.ResponseWriter, r *http.Request) {
 
 			buf := bytes.Buffer{}
 			encode := json.NewEncoder(&buf)
-			if err := encode.Encode(models.Response{Result: config.Flags.BaseResultAddress + "/" + id}); err != nil {
-				w.WriteHeader(http.StatusBadRequest)
+			if err := encode.Encode(shortenResponse(domain, id, req.QR)); err != nil {
+				writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
 				return
 			}
 			setResponsePostShortenWebhook(w, http.StatusConflict, buf)
 			return
-		} else {
-			w.WriteHeader(http.StatusBadRequest)
-			return
 		}
+		writeStorageError(w, err)
+		return
 	}
+	preview.Enqueue(domain, id, originalURL)
+	notifyLink(r.Context(), webhooks.EventLinkCreated, domain, id)
 
 	buf := bytes.Buffer{}
 	encode := json.NewEncoder(&buf)
-	if err := encode.Encode(models.Response{Result: config.Flags.BaseResultAddress + "/" + id}); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if err := encode.Encode(shortenResponse(domain, id, req.QR)); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
 		return
 	}
 	setResponsePostShortenWebhook(w, http.StatusCreated, buf)
 }
 
+// shortenResponse формирует ответ PostShortenWebhook, withQR добавляет адрес QR-кода
+func shortenResponse(domain string, id string, withQR bool) models.Response {
+	resp := models.Response{Result: domains.ShortURL(domain, id)}
+	if withQR {
+		resp.QR = qrURL(domain, id)
+	}
+	return resp
+}
+
 // setResponsePostShortenWebhook устанавливает ответ для PostShortenWebhook
 func setResponsePostShortenWebhook(w http.ResponseWriter, statusCode int, buf bytes.Buffer) {
 	w.Header().Set("Content-Type", "application/json")
@@ -229,36 +438,48 @@ func PostShortenBatchWebhook(w http.ResponseWriter, r *http.Request) {
 	allowedApplicationJSON := strings.Contains(contentType, "application/json")
 	gzipTextPlan := strings.Contains(contentType, "gzip")
 
-	if r.Method != http.MethodPost || (!allowedApplicationJSON && !gzipTextPlan) {
-		w.WriteHeader(http.StatusBadRequest)
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if !allowedApplicationJSON && !gzipTextPlan {
+		writeUnsupportedMedia(w, "application/json")
 		return
 	}
 
 	dec := json.NewDecoder(r.Body)
 	var req []models.RequestBatch
 	if err := dec.Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeBodyError(w, err)
 		return
 	}
 
-	var resp = make([]models.ResponseBatch, 0, len(req))
+	items := make([]batchItem, 0, len(req))
 	for _, v := range req {
-		id := utils.GenerateString(8)
-		err := storage.Store.SaveData(r.Context(), id, v.OriginalURL)
+		domain, err := requestDomain(v.Domain)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+			return
+		}
+		items = append(items, batchItem{originalURL: v.OriginalURL, domain: domain})
+	}
+
+	var resp = make([]models.ResponseBatch, 0, len(req))
+	for i, result := range saveBatch(r.Context(), items) {
+		if result.err != nil {
+			writeSaveError(w, result.err)
 			return
 		}
 		resp = append(resp, models.ResponseBatch{
-			CorrelationID: v.CorrelationID,
-			ShortURL:      config.Flags.BaseResultAddress + "/" + id,
+			CorrelationID: req[i].CorrelationID,
+			ShortURL:      domains.ShortURL(items[i].domain, result.id),
 		})
 	}
 
 	buf := bytes.Buffer{}
 	encode := json.NewEncoder(&buf)
 	if err := encode.Encode(resp); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
 		return
 	}
 
@@ -268,15 +489,63 @@ func PostShortenBatchWebhook(w http.ResponseWriter, r *http.Request) {
 	_, _ = w.Write(buf.Bytes())
 }
 
+// batchItem ссылка для пакетного сохранения, alias и expiresAt необязательны,
+// пустой domain означает домен по умолчанию
+type batchItem struct {
+	domain      string
+	originalURL string
+	alias       string
+	expiresAt   *time.Time
+}
+
+// batchResult результат сохранения batchItem
+type batchResult struct {
+	id  string
+	err error
+}
+
+// saveBatch сохраняет ссылки пакета. Ошибка одной ссылки не прерывает сохранение остальных,
+// результаты возвращаются в порядке items
+func saveBatch(ctx context.Context, items []batchItem) []batchResult {
+	userID, _ := ctx.Value(constants.UserIDKey).(int)
+
+	results := make([]batchResult, 0, len(items))
+	for _, item := range items {
+		originalURL, err := prepareURL(ctx, item.originalURL)
+		if err != nil {
+			results = append(results, batchResult{err: err})
+			continue
+		}
+
+		id := item.alias
+		if id == "" {
+			id = utils.GenerateString(8)
+		}
+		err = storage.Store.SaveRecord(ctx, models.URLData{
+			ShortURL:    id,
+			OriginalURL: originalURL,
+			UserID:      userID,
+			ExpiresAt:   item.expiresAt,
+			Domain:      item.domain,
+		})
+		if err == nil {
+			preview.Enqueue(item.domain, id, originalURL)
+			notifyLink(ctx, webhooks.EventLinkCreated, item.domain, id)
+		}
+		results = append(results, batchResult{id: id, err: err})
+	}
+	return results
+}
+
 // CheckConnection функция обработчик GET HTTP-запроса для проверки соединения с БД
 func CheckConnection(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		w.WriteHeader(http.StatusBadRequest)
+		writeMethodNotAllowed(w)
 		return
 	}
 
 	if !storage.CustomPing() {
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, http.StatusInternalServerError, codeStorageError, "storage is unavailable")
 		return
 	}
 
@@ -1,18 +1,25 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/policy"
 	"github.com/fngoc/url-shortener/cmd/shortener/storage"
 	"github.com/fngoc/url-shortener/internal/models"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 )
 
-type MockLocalStore storage.LocalStore
+type MockLocalStore map[string]string
 
 func TestGetRedirectWebhook(t *testing.T) {
 	type want struct {
@@ -46,7 +53,7 @@ func TestGetRedirectWebhook(t *testing.T) {
 			"/testKeys",
 			nil,
 			want{
-				statusCode: http.StatusBadRequest,
+				statusCode: http.StatusMethodNotAllowed,
 				expectBody: false,
 				body:       "",
 			},
@@ -75,12 +82,12 @@ func TestGetRedirectWebhook(t *testing.T) {
 		},
 	}
 
-	if err := storage.InitializeFileLocalStore("data.json"); err != nil {
-		t.Fatal(err)
-	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			storage.Store = storage.LocalStore(tt.store)
+			require.NoError(t, storage.InitializeInMemoryLocalStore())
+			for key, value := range tt.store {
+				require.NoError(t, storage.Store.SaveData(context.TODO(), key, value))
+			}
 
 			request := httptest.NewRequest(tt.method, tt.requestURL, nil)
 			w := httptest.NewRecorder()
@@ -128,8 +135,8 @@ func TestPostSaveWebhook(t *testing.T) {
 			"asdasd",
 			"text/plain",
 			want{
-				contentType: "",
-				statusCode:  http.StatusBadRequest,
+				contentType: problemContentType,
+				statusCode:  http.StatusMethodNotAllowed,
 				expectBody:  false,
 			},
 		},
@@ -139,8 +146,8 @@ func TestPostSaveWebhook(t *testing.T) {
 			"https://google.com",
 			"application/json",
 			want{
-				contentType: "",
-				statusCode:  http.StatusBadRequest,
+				contentType: problemContentType,
+				statusCode:  http.StatusUnsupportedMediaType,
 				expectBody:  false,
 			},
 		},
@@ -150,14 +157,14 @@ func TestPostSaveWebhook(t *testing.T) {
 			"",
 			"text/plain",
 			want{
-				contentType: "",
+				contentType: problemContentType,
 				statusCode:  http.StatusBadRequest,
 				expectBody:  false,
 			},
 		},
 	}
 
-	if err := storage.InitializeFileLocalStore("data.json"); err != nil {
+	if err := storage.InitializeFileLocalStore(filepath.Join(t.TempDir(), "data.json")); err != nil {
 		t.Fatal(err)
 	}
 	for _, tt := range tests {
@@ -210,8 +217,8 @@ func TestPostShortenWebhook(t *testing.T) {
 			"asdasd",
 			"application/json",
 			want{
-				contentType: "",
-				statusCode:  http.StatusBadRequest,
+				contentType: problemContentType,
+				statusCode:  http.StatusMethodNotAllowed,
 				expectBody:  false,
 			},
 		},
@@ -221,8 +228,8 @@ func TestPostShortenWebhook(t *testing.T) {
 			"https://google.com",
 			"text/plan",
 			want{
-				contentType: "",
-				statusCode:  http.StatusBadRequest,
+				contentType: problemContentType,
+				statusCode:  http.StatusUnsupportedMediaType,
 				expectBody:  false,
 			},
 		},
@@ -230,16 +237,16 @@ func TestPostShortenWebhook(t *testing.T) {
 			"empty body test",
 			"POST",
 			"",
-			"text/plain",
+			"application/json",
 			want{
-				contentType: "",
+				contentType: problemContentType,
 				statusCode:  http.StatusBadRequest,
 				expectBody:  false,
 			},
 		},
 	}
 
-	if err := storage.InitializeFileLocalStore("data.json"); err != nil {
+	if err := storage.InitializeFileLocalStore(filepath.Join(t.TempDir(), "data.json")); err != nil {
 		t.Fatal(err)
 	}
 	for _, tt := range tests {
@@ -268,3 +275,113 @@ func TestPostShortenWebhook(t *testing.T) {
 		})
 	}
 }
+
+func TestPostSaveWebhook_Normalization(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{"first save", "HTTP://Example.com:80/", http.StatusCreated},
+		{"same link in normal form", "http://example.com/", http.StatusConflict},
+		{"form encoded body", "url=http%3A%2F%2Fexample.com", http.StatusConflict},
+		{"not absolute", "example.com", http.StatusBadRequest},
+		{"scheme is not allowed", "ftp://example.com/", http.StatusBadRequest},
+	}
+
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			request.Header.Add("Content-Type", "text/plain")
+			w := httptest.NewRecorder()
+
+			PostSaveWebhook(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+
+			require.Equal(t, tt.statusCode, res.StatusCode)
+		})
+	}
+}
+
+func TestPostShortenWebhook_Policy(t *testing.T) {
+	policy.Current = &policy.Engine{Resolver: net.DefaultResolver}
+	policy.Current.SetLists(policy.DomainLists{Deny: []string{"evil.com"}})
+	defer func() { policy.Current = nil }()
+
+	tests := []struct {
+		name string
+		url  string
+		code string
+	}{
+		{"metadata address", "http://169.254.169.254/", policy.CodePrivateAddress},
+		{"decimal metadata address", "http://2852039166/", policy.CodePrivateAddress},
+		{"hex metadata address", "http://0xA9FEA9FE/", policy.CodePrivateAddress},
+		{"octal metadata address", "http://0251.0376.0251.0376/", policy.CodePrivateAddress},
+		{"denied domain", "https://phishing.evil.com/login", policy.CodeDomainDenied},
+	}
+
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, err := json.Marshal(models.Request{URL: tt.url})
+			require.NoError(t, err)
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(string(body)))
+			request.Header.Add("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			PostShortenWebhook(w, request)
+			res := w.Result()
+			defer res.Body.Close()
+
+			require.Equal(t, http.StatusUnprocessableEntity, res.StatusCode)
+			require.Equal(t, problemContentType, res.Header.Get("Content-Type"))
+
+			var resp problem
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&resp))
+			require.Equal(t, codeDestinationBlocked, resp.Code)
+			require.Equal(t, http.StatusUnprocessableEntity, resp.Status)
+			require.NotNil(t, resp.Violation)
+			require.Equal(t, tt.code, resp.Violation.Code)
+		})
+	}
+}
+
+func TestRestoreUrlsWebhook(t *testing.T) {
+	window := config.Flags.RestoreWindow
+	config.Flags.RestoreWindow = time.Hour
+	defer func() { config.Flags.RestoreWindow = window }()
+
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	ctx := context.WithValue(context.Background(), constants.UserIDKey, 1)
+	require.NoError(t, storage.Store.SaveData(ctx, "deleted", "https://ya.ru/deleted"))
+	require.NoError(t, storage.Store.SaveData(ctx, "active", "https://ya.ru/active"))
+	require.NoError(t, storage.Store.DeleteData("", "deleted"))
+
+	restore := func(userID int, body string) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(http.MethodPost, "/api/user/urls/restore", strings.NewReader(body))
+		request = request.WithContext(context.WithValue(request.Context(), constants.UserIDKey, userID))
+		w := httptest.NewRecorder()
+		RestoreUrlsWebhook(w, request)
+		return w
+	}
+
+	require.Equal(t, http.StatusNoContent, restore(2, `["deleted"]`).Code, "link of other user")
+	require.Equal(t, http.StatusBadRequest, restore(1, `{"id":"deleted"}`).Code)
+
+	w := restore(1, `["deleted","active","missing"]`)
+	require.Equal(t, http.StatusOK, w.Code)
+	var restored []models.ResponseDto
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&restored))
+	require.Len(t, restored, 1)
+	require.Equal(t, "https://ya.ru/deleted", restored[0].OriginalURL)
+	require.False(t, restored[0].IsDeleted)
+
+	_, err := storage.Store.GetRecord(ctx, "", "deleted")
+	require.NoError(t, err)
+
+	config.Flags.RestoreWindow = 0
+	require.NoError(t, storage.Store.DeleteData("", "deleted"))
+	require.Equal(t, http.StatusNoContent, restore(1, `["deleted"]`).Code, "grace window is over")
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/domains"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"github.com/fngoc/url-shortener/internal/models"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// listPageSize размер страницы, которой читается хранилище при потоковой выдаче
+	listPageSize = 500
+	// maxListLimit максимальный размер страницы в параметре limit
+	maxListLimit = 1000
+
+	ndjsonContentType = "application/x-ndjson"
+)
+
+// listWriter пишет ответ потоковой выдачи: begin вызывается перед первой записью,
+// item для каждой записи, end после последней
+type listWriter struct {
+	contentType string
+	begin       []byte
+	separator   []byte
+	end         []byte
+}
+
+var (
+	writeJSONArray = listWriter{contentType: "application/json", begin: []byte("["), separator: []byte(","), end: []byte("]\n")}
+	writeNDJSON    = listWriter{contentType: ndjsonContentType, separator: []byte("\n"), end: []byte("\n")}
+)
+
+// parseListOptions разбирает параметры limit, cursor, created_from, created_to, deleted, q, tag, folder, workspace и sort
+func parseListOptions(query url.Values) (storage.ListOptions, error) {
+	opts := storage.ListOptions{
+		Cursor: query.Get("cursor"),
+		Query:  query.Get("q"),
+		Tag:    strings.ToLower(strings.TrimSpace(query.Get("tag"))),
+		Folder: strings.TrimSpace(query.Get("folder")),
+		Sort:   query.Get("sort"),
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxListLimit {
+			return opts, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		opts.Limit = limit
+	}
+	if value := query.Get("workspace"); value != "" {
+		workspaceID, err := strconv.Atoi(value)
+		if err != nil || workspaceID < 1 {
+			return opts, fmt.Errorf("workspace must be a positive number")
+		}
+		opts.WorkspaceID = workspaceID
+	}
+	for name, target := range map[string]**time.Time{"created_from": &opts.CreatedFrom, "created_to": &opts.CreatedTo} {
+		if value := query.Get(name); value != "" {
+			t, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				return opts, fmt.Errorf("%s must be RFC 3339 time", name)
+			}
+			*target = &t
+		}
+	}
+	switch query.Get("deleted") {
+	case "", "all":
+	case "true", "false":
+		deleted := query.Get("deleted") == "true"
+		opts.Deleted = &deleted
+	default:
+		return opts, fmt.Errorf("deleted must be true, false or all")
+	}
+	switch opts.Sort {
+	case "", storage.SortCreatedAsc, storage.SortCreatedDesc, storage.SortOriginalAsc, storage.SortOriginalDesc:
+	default:
+		return opts, fmt.Errorf("unknown sort: %s", opts.Sort)
+	}
+	return opts, nil
+}
+
+// streamUrls отдаёт все ссылки пользователя, читая хранилище страницами и не накапливая их в памяти
+func streamUrls(w http.ResponseWriter, r *http.Request, userID int, opts storage.ListOptions, lw listWriter) {
+	opts.Limit = listPageSize
+
+	urls, next, err := storage.Store.ListData(r.Context(), userID, opts)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if len(urls) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	w.Header().Set("Content-Type", lw.contentType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(lw.begin)
+
+	first := true
+	for {
+		for _, dto := range toResponseDtos(urls) {
+			data, err := json.Marshal(dto)
+			if err != nil {
+				logger.Log.Error("Urls stream is interrupted", zap.Error(err))
+				return
+			}
+			if !first {
+				_, _ = w.Write(lw.separator)
+			}
+			first = false
+			if _, err := w.Write(data); err != nil {
+				return
+			}
+		}
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+
+		if next == "" {
+			break
+		}
+		opts.Cursor = next
+		if urls, next, err = storage.Store.ListData(r.Context(), userID, opts); err != nil {
+			logger.Log.Error("Urls stream is interrupted", zap.Error(err))
+			return
+		}
+	}
+	_, _ = w.Write(lw.end)
+}
+
+// setNextCursor сообщает курсор следующей страницы в заголовках X-Next-Cursor и Link
+func setNextCursor(w http.ResponseWriter, r *http.Request, next string) {
+	query := r.URL.Query()
+	query.Set("cursor", next)
+	w.Header().Set("X-Next-Cursor", next)
+	w.Header().Set("Link", fmt.Sprintf(`<%s%s?%s>; rel="next"`, config.Flags.BaseResultAddress, r.URL.Path, query.Encode()))
+}
+
+func toResponseDtos(urls []models.URLData) []models.ResponseDto {
+	result := make([]models.ResponseDto, 0, len(urls))
+	for _, u := range urls {
+		result = append(result, models.ResponseDto{
+			ShortURL:     domains.ShortURL(u.Domain, u.ShortURL),
+			OriginalURL:  u.OriginalURL,
+			CreatedAt:    u.CreatedAt,
+			ExpiresAt:    u.ExpiresAt,
+			IsDeleted:    u.IsDeleted,
+			DeletedAt:    u.DeletedAt,
+			Title:        u.Title,
+			Interstitial: u.Interstitial,
+			Protected:    u.PasswordHash != "",
+			RedirectType: u.RedirectType,
+			Tags:         u.Tags,
+			Folder:       u.Folder,
+			Workspace:    u.WorkspaceID,
+			Domain:       u.Domain,
+			Rules:        u.Rules,
+			Variants:     u.Variants,
+			UTM:          u.UTM,
+			PrefixMode:   u.PrefixMode,
+		})
+	}
+	return result
+}
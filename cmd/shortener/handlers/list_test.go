@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func getUrls(t *testing.T, target string, accept string) *http.Response {
+	token, err := BuildJWTString()
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	request.AddCookie(&http.Cookie{Name: CookieName, Value: token})
+	request.Header.Set("Accept", accept)
+	request = request.WithContext(context.WithValue(request.Context(), constants.UserIDKey, 1))
+	w := httptest.NewRecorder()
+
+	GetUrlsWebhook(w, request)
+	return w.Result()
+}
+
+func TestGetUrlsWebhook(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	ctx := context.WithValue(context.Background(), constants.UserIDKey, 1)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, storage.Store.SaveData(ctx, fmt.Sprintf("key%d", i), fmt.Sprintf("https://ya.ru/%d", i)))
+	}
+
+	t.Run("pages", func(t *testing.T) {
+		var urls []models.ResponseDto
+		target := "/api/user/urls?limit=2&sort=original_url"
+		for pages := 0; ; pages++ {
+			require.Less(t, pages, 3)
+			res := getUrls(t, target, "")
+			require.Equal(t, http.StatusOK, res.StatusCode)
+
+			var page []models.ResponseDto
+			require.NoError(t, json.NewDecoder(res.Body).Decode(&page))
+			res.Body.Close()
+			urls = append(urls, page...)
+
+			next := res.Header.Get("X-Next-Cursor")
+			if next == "" {
+				break
+			}
+			require.Contains(t, res.Header.Get("Link"), `rel="next"`)
+			target = "/api/user/urls?limit=2&sort=original_url&cursor=" + next
+		}
+		require.Len(t, urls, 5)
+		require.Equal(t, "https://ya.ru/4", urls[4].OriginalURL)
+	})
+
+	t.Run("json array", func(t *testing.T) {
+		res := getUrls(t, "/api/user/urls?q=ya.ru/3", "")
+		defer res.Body.Close()
+
+		require.Equal(t, "application/json", res.Header.Get("Content-Type"))
+		var urls []models.ResponseDto
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&urls))
+		require.Len(t, urls, 1)
+		require.Equal(t, "https://ya.ru/3", urls[0].OriginalURL)
+	})
+
+	t.Run("ndjson", func(t *testing.T) {
+		res := getUrls(t, "/api/user/urls", ndjsonContentType)
+		defer res.Body.Close()
+
+		require.Equal(t, ndjsonContentType, res.Header.Get("Content-Type"))
+		scanner := bufio.NewScanner(res.Body)
+		lines := 0
+		for scanner.Scan() {
+			var dto models.ResponseDto
+			require.NoError(t, json.Unmarshal(scanner.Bytes(), &dto))
+			lines++
+		}
+		require.Equal(t, 5, lines)
+	})
+
+	t.Run("no content", func(t *testing.T) {
+		res := getUrls(t, "/api/user/urls?deleted=true", "")
+		defer res.Body.Close()
+		require.Equal(t, http.StatusNoContent, res.StatusCode)
+	})
+
+	t.Run("bad request", func(t *testing.T) {
+		for _, target := range []string{"/api/user/urls?limit=0", "/api/user/urls?sort=id", "/api/user/urls?created_from=yesterday",
+			"/api/user/urls?deleted=maybe", "/api/user/urls?limit=1&cursor=broken"} {
+			res := getUrls(t, target, "")
+			res.Body.Close()
+			require.Equal(t, http.StatusBadRequest, res.StatusCode, target)
+		}
+	})
+}
+
+func TestGetUrlsWebhook_Tags(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+
+	shorten := func(body string) int {
+		request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		request = request.WithContext(context.WithValue(request.Context(), constants.UserIDKey, 1))
+		w := httptest.NewRecorder()
+		PostShortenWebhook(w, request)
+		return w.Code
+	}
+	require.Equal(t, http.StatusCreated, shorten(`{"url":"https://ya.ru/a","tags":["Team"," docs","team"],"folder":"Work"}`))
+	require.Equal(t, http.StatusCreated, shorten(`{"url":"https://ya.ru/b","tags":["team"]}`))
+	require.Equal(t, http.StatusCreated, shorten(`{"url":"https://ya.ru/c"}`))
+	require.Equal(t, http.StatusBadRequest, shorten(`{"url":"https://ya.ru/d","tags":["a,b"]}`))
+	require.Equal(t, http.StatusBadRequest, shorten(`{"url":"https://ya.ru/e","tags":[""]}`))
+
+	list := func(target string) []models.ResponseDto {
+		res := getUrls(t, target, "")
+		defer res.Body.Close()
+		if res.StatusCode == http.StatusNoContent {
+			return nil
+		}
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		var urls []models.ResponseDto
+		require.NoError(t, json.NewDecoder(res.Body).Decode(&urls))
+		return urls
+	}
+
+	urls := list("/api/user/urls?tag=TEAM&sort=original_url")
+	require.Len(t, urls, 2)
+	require.Equal(t, []string{"docs", "team"}, urls[0].Tags)
+	require.Equal(t, "Work", urls[0].Folder)
+	require.Len(t, list("/api/user/urls?folder=Work"), 1)
+	require.Empty(t, list("/api/user/urls?tag=missing"))
+
+	records, _, err := storage.Store.ListData(context.Background(), 1, storage.ListOptions{Tag: "team", Folder: "Work"})
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+
+	request := httptest.NewRequest(http.MethodPatch, "/api/user/urls/"+records[0].ShortURL, strings.NewReader(`{"tags":[],"folder":""}`))
+	request.Header.Set("Content-Type", "application/json")
+	request = request.WithContext(context.WithValue(request.Context(), constants.UserIDKey, 1))
+	w := httptest.NewRecorder()
+	PatchUrlWebhook(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.Len(t, list("/api/user/urls?tag=team"), 1)
+	require.Empty(t, list("/api/user/urls?folder=Work"))
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/ratelimit"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"github.com/fngoc/url-shortener/internal/models"
+	"go.uber.org/zap"
+	"golang.org/x/crypto/bcrypt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// passwordHeader заголовок, в котором API-клиенты передают пароль ссылки
+	passwordHeader = "X-Link-Password"
+	// passwordField поле формы с паролем
+	passwordField = "password"
+
+	minPasswordLength = 4
+	// maxPasswordLength ограничение bcrypt на длину пароля в байтах
+	maxPasswordLength = 72
+
+	codePasswordRequired = "password_required"
+	codeInvalidPassword  = "invalid_password"
+)
+
+var passwordTemplate = template.Must(template.New("password").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Ссылка защищена паролем</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:24rem;margin:4rem auto;padding:0 1rem;color:#222}
+input,button{font-size:1rem;padding:.5rem;margin-top:.5rem;width:100%;box-sizing:border-box}
+.error{color:#c5221f}
+</style>
+</head>
+<body>
+<h1>Ссылка защищена паролем</h1>
+{{if .Failed}}<p class="error">Неверный пароль</p>{{end}}
+<form method="post" action="{{.Action}}">
+<input type="password" name="password" autocomplete="current-password" required autofocus>
+<button type="submit">Перейти</button>
+</form>
+</body>
+</html>
+`))
+
+// errInvalidPassword возвращается hashPassword для пароля недопустимой длины
+var errInvalidPassword = fmt.Errorf("password must be from %d to %d bytes", minPasswordLength, maxPasswordLength)
+
+// hashPassword проверяет длину пароля и возвращает его bcrypt-хеш
+func hashPassword(password string) (string, error) {
+	if len(password) < minPasswordLength || len(password) > maxPasswordLength {
+		return "", errInvalidPassword
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// PostRedirectWebhook функция обработчик POST HTTP-запроса с формы пароля защищённой ссылки,
+// ссылка ищется на домене из заголовка Host
+func PostRedirectWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	id, preview, suffix, err := parseRedirectPath(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	record, err := findRedirectLink(r, id, suffix)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if record.PasswordHash != "" && !verifyLinkPassword(w, r, record, r.PostForm.Get(passwordField)) {
+		return
+	}
+	target, variant := destination(w, r, record, suffix)
+	if preview || record.Interstitial {
+		record.OriginalURL = target
+		writePreviewPage(w, record)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	recordClick(r.Context(), record, variant)
+	http.Redirect(w, r, target, http.StatusSeeOther)
+}
+
+// verifyLinkPassword проверяет пароль защищённой ссылки. Неудачные попытки ограничиваются лимитом
+// ratelimit.GroupPassword на ссылку и IP клиента, чтобы пароль нельзя было подобрать перебором, а чужие
+// неверные пароли не закрывали ссылку остальным. При отказе ответ уже записан в w
+func verifyLinkPassword(w http.ResponseWriter, r *http.Request, record models.URLData, password string) bool {
+	if password == "" {
+		writePasswordRequired(w, r, false)
+		return false
+	}
+
+	limit := ratelimit.Limits[ratelimit.GroupPassword]
+	key := ratelimit.GroupPassword + ":" + record.Domain + "/" + record.ShortURL + ":" + clientIP(r)
+	if !limit.Disabled() {
+		result, err := ratelimit.Current.Peek(r.Context(), key, limit)
+		if err != nil {
+			logger.Log.Warn("Rate limit store is unavailable", zap.Error(err))
+		} else if !result.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			writeError(w, http.StatusTooManyRequests, codeRateLimited, "too many password attempts")
+			return false
+		}
+	}
+
+	if bcrypt.CompareHashAndPassword([]byte(record.PasswordHash), []byte(password)) != nil {
+		if !limit.Disabled() {
+			if _, err := ratelimit.Current.Take(r.Context(), key, limit); err != nil {
+				logger.Log.Warn("Rate limit store is unavailable", zap.Error(err))
+			}
+		}
+		writePasswordRequired(w, r, true)
+		return false
+	}
+	return true
+}
+
+// writePasswordRequired отвечает 401: браузеру формой ввода пароля, API-клиенту ошибкой problem+json
+func writePasswordRequired(w http.ResponseWriter, r *http.Request, failed bool) {
+	w.Header().Set("Cache-Control", "no-store")
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		if failed {
+			writeError(w, http.StatusUnauthorized, codeInvalidPassword, "password is not valid")
+			return
+		}
+		writeError(w, http.StatusUnauthorized, codePasswordRequired, "password is required in "+passwordHeader+" header")
+		return
+	}
+
+	buf := bytes.Buffer{}
+	err := passwordTemplate.Execute(&buf, struct {
+		Action string
+		Failed bool
+	}{r.URL.RequestURI(), failed})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(http.StatusUnauthorized)
+	_, _ = w.Write(buf.Bytes())
+}
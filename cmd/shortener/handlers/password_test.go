@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/fngoc/url-shortener/cmd/shortener/ratelimit"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestPasswordProtectedLink(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	require.NoError(t, err)
+	require.NoError(t, storage.Store.SaveRecord(context.TODO(), models.URLData{
+		ShortURL: "docs", OriginalURL: "https://wiki.example.com/", PasswordHash: string(hash),
+	}))
+
+	ratelimit.Current = ratelimit.NewMemoryStore()
+	ratelimit.Limits = map[string]ratelimit.Limit{}
+	t.Cleanup(func() { ratelimit.Limits = map[string]ratelimit.Limit{} })
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		header     string
+		accept     string
+		form       string
+		statusCode int
+		code       string
+		location   string
+	}{
+		{"no password", http.MethodGet, "/docs", "", "", "", http.StatusUnauthorized, codePasswordRequired, ""},
+		{"wrong password", http.MethodGet, "/docs", "guess", "", "", http.StatusUnauthorized, codeInvalidPassword, ""},
+		{"password in header", http.MethodGet, "/docs", "secret", "", "", http.StatusTemporaryRedirect, "", "https://wiki.example.com/"},
+		{"browser gets form", http.MethodGet, "/docs", "", "text/html", "", http.StatusUnauthorized, "", ""},
+		{"preview needs password", http.MethodGet, "/docs+", "", "", "", http.StatusUnauthorized, codePasswordRequired, ""},
+		{"wrong password in form", http.MethodPost, "/docs", "", "text/html", "password=guess", http.StatusUnauthorized, "", ""},
+		{"password in form", http.MethodPost, "/docs", "", "text/html", "password=secret", http.StatusSeeOther, "", "https://wiki.example.com/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.form))
+			if tt.form != "" {
+				request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if tt.header != "" {
+				request.Header.Set(passwordHeader, tt.header)
+			}
+			request.Header.Set("Accept", tt.accept)
+			w := httptest.NewRecorder()
+			if tt.method == http.MethodPost {
+				PostRedirectWebhook(w, request)
+			} else {
+				GetRedirectWebhook(w, request)
+			}
+
+			require.Equal(t, tt.statusCode, w.Code)
+			assert.Contains(t, w.Header().Get("Cache-Control"), "no-store")
+			if tt.location == "" {
+				assert.NotContains(t, w.Body.String(), "wiki.example.com", "destination is hidden before the password")
+			}
+			if tt.code != "" {
+				var resp problem
+				require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+				assert.Equal(t, tt.code, resp.Code)
+			}
+			if tt.accept == "text/html" && tt.statusCode == http.StatusUnauthorized {
+				assert.Contains(t, w.Body.String(), `<form method="post" action="/docs">`)
+			}
+			if tt.location != "" {
+				assert.Equal(t, tt.location, w.Header().Get("Location"))
+			}
+		})
+	}
+
+	t.Run("failed attempts are throttled per link and client", func(t *testing.T) {
+		ratelimit.Current = ratelimit.NewMemoryStore()
+		ratelimit.Limits = map[string]ratelimit.Limit{ratelimit.GroupPassword: {Rate: 0.01, Burst: 2}}
+		attempt := func(remoteAddr, password string) int {
+			request := httptest.NewRequest(http.MethodGet, "/docs", nil)
+			request.RemoteAddr = remoteAddr
+			request.Header.Set(passwordHeader, password)
+			w := httptest.NewRecorder()
+			GetRedirectWebhook(w, request)
+			return w.Code
+		}
+		for i := 0; i < 5; i++ {
+			require.Equal(t, http.StatusTemporaryRedirect, attempt("10.0.0.1:1000", "secret"), "correct passwords are not charged")
+		}
+		require.Equal(t, http.StatusUnauthorized, attempt("10.0.0.2:1000", "guess1"))
+		require.Equal(t, http.StatusUnauthorized, attempt("10.0.0.2:1000", "guess2"))
+		require.Equal(t, http.StatusTooManyRequests, attempt("10.0.0.2:1000", "guess3"))
+		require.Equal(t, http.StatusTooManyRequests, attempt("10.0.0.2:1000", "secret"))
+		require.Equal(t, http.StatusTemporaryRedirect, attempt("10.0.0.1:1000", "secret"), "other clients keep access after the bucket has drained")
+	})
+}
+
+func TestPostShortenWebhook_Password(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{"with password", `{"url":"https://wiki.example.com/","password":"secret"}`, http.StatusCreated},
+		{"short password", `{"url":"https://wiki.example.com/other","password":"abc"}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			PostShortenWebhook(w, request)
+			require.Equal(t, tt.statusCode, w.Code)
+			if tt.statusCode != http.StatusCreated {
+				return
+			}
+
+			var resp models.Response
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+			shortURL, err := url.Parse(resp.Result)
+			require.NoError(t, err)
+			record, err := storage.Store.GetRecord(context.TODO(), "", strings.TrimPrefix(shortURL.Path, "/"))
+			require.NoError(t, err)
+			require.NotEqual(t, "secret", record.PasswordHash)
+			require.NoError(t, bcrypt.CompareHashAndPassword([]byte(record.PasswordHash), []byte("secret")))
+		})
+	}
+}
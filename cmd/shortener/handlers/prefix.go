@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/domains"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// maxPathSuffixSegments ограничение на число сегментов пути после идентификатора ссылки
+const maxPathSuffixSegments = 32
+
+// parseRedirectPath разбирает путь /{id}[+][/suffix] перехода по ссылке. Суффикс возвращается
+// сегментами в раскодированном виде. Сегменты "." и "..", пустые сегменты в середине пути и сегменты
+// с закодированными разделителями отклоняются, чтобы суффикс не мог выйти за путь адреса назначения
+func parseRedirectPath(r *http.Request) (id string, preview bool, suffix []string, err error) {
+	id, rest, _ := strings.Cut(strings.TrimPrefix(r.URL.EscapedPath(), "/"), "/")
+	if id, err = url.PathUnescape(id); err != nil {
+		return "", false, nil, fmt.Errorf("short url id is not valid")
+	}
+	preview = strings.HasSuffix(id, previewSuffix)
+	id = strings.TrimSuffix(id, previewSuffix)
+	if id == "" {
+		return "", false, nil, fmt.Errorf("short url id is empty")
+	}
+	if rest == "" {
+		return id, preview, nil, nil
+	}
+
+	segments := strings.Split(rest, "/")
+	if len(segments) > maxPathSuffixSegments {
+		return "", false, nil, fmt.Errorf("path suffix must have no more than %d segments", maxPathSuffixSegments)
+	}
+	suffix = make([]string, 0, len(segments))
+	for i, segment := range segments {
+		decoded, err := url.PathUnescape(segment)
+		if err != nil || decoded == "." || decoded == ".." || strings.ContainsAny(decoded, "/\\") ||
+			decoded == "" && i != len(segments)-1 {
+			return "", false, nil, fmt.Errorf("path suffix is not valid")
+		}
+		suffix = append(suffix, decoded)
+	}
+	return id, preview, suffix, nil
+}
+
+// findRedirectLink находит действующую ссылку на домене из заголовка Host. Путь после идентификатора
+// допустим только у ссылок с PrefixMode, для остальных ссылка считается ненайденной
+func findRedirectLink(r *http.Request, id string, suffix []string) (models.URLData, error) {
+	record, err := storage.Store.GetRecord(r.Context(), domains.FromHost(r.Host).Name, id)
+	if err != nil {
+		return models.URLData{}, err
+	}
+	if len(suffix) > 0 && !record.PrefixMode {
+		return models.URLData{}, fmt.Errorf("data by key: %s, %w", id, storage.ErrNotFound)
+	}
+	return record, nil
+}
+
+// joinPath дописывает сегменты suffix к пути адреса назначения target. Запрос и фрагмент адреса
+// сохраняются, хост и схема не меняются
+func joinPath(target string, suffix []string) string {
+	if len(suffix) == 0 {
+		return target
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return target
+	}
+	escaped := make([]string, 0, len(suffix))
+	for _, segment := range suffix {
+		escaped = append(escaped, url.PathEscape(segment))
+	}
+	if escaped[len(escaped)-1] == "" {
+		// JoinPath сохраняет завершающий слеш, только если им заканчивается последний сегмент
+		escaped[len(escaped)-1] = "/"
+	}
+	joined := u.JoinPath(escaped...)
+	if joined.Scheme != u.Scheme || joined.Host != u.Host || !strings.HasPrefix(joined.Path, strings.TrimSuffix(u.Path, "/")) {
+		return target
+	}
+	return joined.String()
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"context"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetRedirectWebhook_PrefixMode(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	ctx := context.WithValue(context.Background(), constants.UserIDKey, 1)
+	for _, record := range []models.URLData{
+		{ShortURL: "docs", OriginalURL: "https://go.dev/doc?lang=ru#top", PrefixMode: true},
+		{ShortURL: "root", OriginalURL: "https://go.dev", PrefixMode: true},
+		{ShortURL: "plain", OriginalURL: "https://ya.ru/plain"},
+	} {
+		require.NoError(t, storage.Store.SaveRecord(ctx, record))
+	}
+
+	tests := []struct {
+		name       string
+		target     string
+		statusCode int
+		location   string
+	}{
+		{"without suffix", "/docs", http.StatusTemporaryRedirect, "https://go.dev/doc?lang=ru#top"},
+		{"suffix", "/docs/install/linux", http.StatusTemporaryRedirect, "https://go.dev/doc/install/linux?lang=ru#top"},
+		{"trailing slash", "/docs/install/", http.StatusTemporaryRedirect, "https://go.dev/doc/install/?lang=ru#top"},
+		{"escaped segment", "/docs/a%20b", http.StatusTemporaryRedirect, "https://go.dev/doc/a%20b?lang=ru#top"},
+		{"destination without path", "/root/blog", http.StatusTemporaryRedirect, "https://go.dev/blog"},
+		{"dot segments", "/docs/../admin", http.StatusBadRequest, ""},
+		{"encoded dot segments", "/docs/%2e%2e/admin", http.StatusBadRequest, ""},
+		{"encoded slash", "/docs/a%2Fb", http.StatusBadRequest, ""},
+		{"encoded backslash", "/docs/a%5Cb", http.StatusBadRequest, ""},
+		{"empty segment", "/docs//admin", http.StatusBadRequest, ""},
+		{"link without prefix mode", "/plain/extra", http.StatusNotFound, ""},
+		{"link without prefix mode without suffix", "/plain", http.StatusTemporaryRedirect, "https://ya.ru/plain"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			GetRedirectWebhook(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			require.Equal(t, tt.statusCode, w.Code, w.Body.String())
+			require.Equal(t, tt.location, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestJoinPath(t *testing.T) {
+	tests := []struct {
+		name   string
+		target string
+		suffix []string
+		want   string
+	}{
+		{"no suffix", "https://go.dev/doc", nil, "https://go.dev/doc"},
+		{"segments", "https://go.dev/doc/", []string{"a", "b"}, "https://go.dev/doc/a/b"},
+		{"trailing slash", "https://go.dev/doc", []string{"a", ""}, "https://go.dev/doc/a/"},
+		{"escaped", "https://go.dev/doc", []string{"a?b#c"}, "https://go.dev/doc/a%3Fb%23c"},
+		{"query kept", "https://go.dev/doc?x=1", []string{"a"}, "https://go.dev/doc/a?x=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			require.Equal(t, tt.want, joinPath(tt.target, tt.suffix))
+		})
+	}
+}
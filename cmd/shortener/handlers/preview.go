@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"bytes"
+	"github.com/fngoc/url-shortener/cmd/shortener/domains"
+	"github.com/fngoc/url-shortener/internal/models"
+	"html/template"
+	"net/http"
+	"net/url"
+)
+
+// previewSuffix суффикс идентификатора, открывающий страницу предпросмотра вместо редиректа
+const previewSuffix = "+"
+
+var previewTemplate = template.Must(template.New("preview").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta name="robots" content="noindex">
+<title>Переход по ссылке {{.ShortURL}}</title>
+<style>
+body{font-family:system-ui,sans-serif;max-width:40rem;margin:4rem auto;padding:0 1rem;color:#222}
+.url{word-break:break-all;color:#555}
+a.button{display:inline-block;margin-top:1.5rem;padding:.75rem 1.5rem;background:#1a73e8;color:#fff;border-radius:.25rem;text-decoration:none}
+</style>
+</head>
+<body>
+<p>Ссылка {{.ShortURL}} ведёт на</p>
+<h1>{{if .Title}}{{.Title}}{{else}}{{.Host}}{{end}}</h1>
+<p class="url">{{.OriginalURL}}</p>
+<a class="button" href="{{.OriginalURL}}" rel="noopener noreferrer nofollow">Перейти</a>
+</body>
+</html>
+`))
+
+// writePreviewPage отвечает HTML-страницей с адресом назначения, его заголовком и кнопкой перехода
+func writePreviewPage(w http.ResponseWriter, record models.URLData) {
+	host := record.OriginalURL
+	if u, err := url.Parse(record.OriginalURL); err == nil {
+		host = u.Hostname()
+	}
+
+	buf := bytes.Buffer{}
+	err := previewTemplate.Execute(&buf, struct {
+		ShortURL    string
+		OriginalURL string
+		Title       string
+		Host        string
+	}{domains.ShortURL(record.Domain, record.ShortURL), record.OriginalURL, record.Title, host})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
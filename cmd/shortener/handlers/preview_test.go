@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"context"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetRedirectWebhook_Preview(t *testing.T) {
+	config.Flags.BaseResultAddress = "http://localhost:8080"
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	ctx := context.TODO()
+	require.NoError(t, storage.Store.SaveData(ctx, "plain", "https://ya.ru/"))
+	require.NoError(t, storage.Store.SaveRecord(ctx, models.URLData{
+		ShortURL: "warn", OriginalURL: "https://example.com/?q=<script>", Interstitial: true,
+	}))
+	require.NoError(t, storage.Store.UpdateTitle(ctx, "", "warn", "Example <b>Domain</b>"))
+
+	tests := []struct {
+		name       string
+		target     string
+		statusCode int
+		contains   []string
+	}{
+		{"redirect", "/plain", http.StatusTemporaryRedirect, nil},
+		{"preview by suffix", "/plain+", http.StatusOK, []string{"https://ya.ru/", "<h1>ya.ru</h1>", "http://localhost:8080/plain"}},
+		{"interstitial link", "/warn", http.StatusOK, []string{"Example &lt;b&gt;Domain&lt;/b&gt;", "https://example.com/?q=%3cscript%3e"}},
+		{"missing preview", "/missing+", http.StatusNotFound, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			GetRedirectWebhook(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			require.Equal(t, tt.statusCode, w.Code)
+			if tt.statusCode == http.StatusOK {
+				require.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+			}
+			for _, s := range tt.contains {
+				assert.Contains(t, w.Body.String(), s)
+			}
+			assert.NotContains(t, w.Body.String(), "<script>")
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/domains"
+	"github.com/fngoc/url-shortener/cmd/shortener/qr"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const qrPathPrefix = "/api/qr/"
+
+// GetQRWebhook функция обработчик GET HTTP-запроса для получения QR-кода сокращённой ссылки.
+// Параметры format (png или svg), size, level и margin необязательны, ссылка другого домена
+// выбирается параметром domain
+func GetQRWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	id := strings.TrimPrefix(r.URL.Path, qrPathPrefix)
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "short url id is empty")
+		return
+	}
+	opts, err := parseQROptions(r.URL.Query())
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	domain := domains.Name(r.URL.Query().Get("domain"))
+	if _, err := storage.Store.GetData(r.Context(), domain, id); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	data, err := qr.Current.Render(domains.ShortURL(domain, id), opts)
+	if errors.Is(err, qr.ErrSizeTooSmall) {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+		return
+	}
+
+	hash := fnv.New64a()
+	_, _ = hash.Write(data)
+	etag := fmt.Sprintf(`"%x"`, hash.Sum64())
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=86400")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", opts.ContentType())
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(data)
+}
+
+// parseQROptions разбирает параметры format, size, level и margin, отсутствующие берутся по умолчанию
+func parseQROptions(query url.Values) (qr.Options, error) {
+	opts := qr.DefaultOptions()
+	if value := query.Get("format"); value != "" {
+		opts.Format = strings.ToLower(value)
+	}
+	if value := query.Get("level"); value != "" {
+		opts.Level = strings.ToUpper(value)
+	}
+	for name, target := range map[string]*int{"size": &opts.Size, "margin": &opts.Margin} {
+		if value := query.Get(name); value != "" {
+			parsed, err := strconv.Atoi(value)
+			if err != nil {
+				return opts, fmt.Errorf("%s must be integer", name)
+			}
+			*target = parsed
+		}
+	}
+	return opts, opts.Validate()
+}
+
+// qrURL возвращает адрес QR-кода сокращённой ссылки id домена domain
+func qrURL(domain string, id string) string {
+	if domain != "" {
+		return config.Flags.BaseResultAddress + qrPathPrefix + id + "?domain=" + url.QueryEscape(domain)
+	}
+	return config.Flags.BaseResultAddress + qrPathPrefix + id
+}
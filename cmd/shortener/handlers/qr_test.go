@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetQRWebhook(t *testing.T) {
+	config.Flags.BaseResultAddress = "http://localhost:8080"
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	require.NoError(t, storage.Store.SaveData(context.TODO(), "abc", "https://ya.ru/"))
+
+	tests := []struct {
+		name        string
+		target      string
+		statusCode  int
+		contentType string
+	}{
+		{"png by default", "/api/qr/abc", http.StatusOK, "image/png"},
+		{"svg", "/api/qr/abc?format=svg&size=512&level=h&margin=0", http.StatusOK, "image/svg+xml"},
+		{"not found", "/api/qr/missing", http.StatusNotFound, problemContentType},
+		{"bad size", "/api/qr/abc?size=10", http.StatusBadRequest, problemContentType},
+		{"size too small for modules", "/api/qr/abc?size=64&level=h&margin=16", http.StatusBadRequest, problemContentType},
+		{"bad margin", "/api/qr/abc?margin=wide", http.StatusBadRequest, problemContentType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			GetQRWebhook(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			require.Equal(t, tt.statusCode, w.Code)
+			require.Equal(t, tt.contentType, w.Header().Get("Content-Type"))
+			if tt.statusCode == http.StatusOK {
+				assert.NotEmpty(t, w.Body.Bytes())
+				assert.NotEmpty(t, w.Header().Get("ETag"))
+			}
+		})
+	}
+
+	t.Run("not modified", func(t *testing.T) {
+		w := httptest.NewRecorder()
+		GetQRWebhook(w, httptest.NewRequest(http.MethodGet, "/api/qr/abc", nil))
+		request := httptest.NewRequest(http.MethodGet, "/api/qr/abc", nil)
+		request.Header.Set("If-None-Match", w.Header().Get("ETag"))
+
+		w = httptest.NewRecorder()
+		GetQRWebhook(w, request)
+		require.Equal(t, http.StatusNotModified, w.Code)
+		require.Empty(t, w.Body.Bytes())
+	})
+}
+
+func TestPostShortenWebhook_QR(t *testing.T) {
+	config.Flags.BaseResultAddress = "http://localhost:8080"
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://ya.ru","qr":true}`))
+	request.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	PostShortenWebhook(w, request)
+	require.Equal(t, http.StatusCreated, w.Code)
+
+	var resp models.Response
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&resp))
+	id := strings.TrimPrefix(resp.Result, "http://localhost:8080/")
+	require.Equal(t, "http://localhost:8080/api/qr/"+id, resp.QR)
+}
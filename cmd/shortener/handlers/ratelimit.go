@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/ratelimit"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"go.uber.org/zap"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RateLimitMiddleware — middleware, ограничивающий частоту запросов группы group отдельно
+// по пользователю из AuthMiddleware и по IP клиента
+func RateLimitMiddleware(group string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := ratelimit.Limits[group]
+		if limit.Disabled() {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		keys := []string{group + ":ip:" + clientIP(r)}
+		if userID, ok := r.Context().Value(constants.UserIDKey).(int); ok {
+			keys = append(keys, group+":user:"+strconv.Itoa(userID))
+		}
+
+		var strictest ratelimit.Result
+		for i, key := range keys {
+			result, err := ratelimit.Current.Take(r.Context(), key, limit)
+			if err != nil {
+				// недоступность общего хранилища лимитов не должна останавливать сервис
+				logger.Log.Warn("Rate limit store is unavailable", zap.Error(err))
+				next.ServeHTTP(w, r)
+				return
+			}
+			if i == 0 || !result.Allowed || strictest.Allowed && result.Remaining < strictest.Remaining {
+				strictest = result
+			}
+			if !result.Allowed {
+				break
+			}
+		}
+
+		w.Header().Set("RateLimit-Limit", strconv.Itoa(strictest.Limit))
+		w.Header().Set("RateLimit-Remaining", strconv.Itoa(strictest.Remaining))
+		w.Header().Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(strictest.Reset)))
+		if !strictest.Allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(strictest.RetryAfter)))
+			writeError(w, http.StatusTooManyRequests, codeRateLimited, "rate limit exceeded")
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// clientIP возвращает IP клиента. X-Forwarded-For учитывается, только если сервис стоит за доверенным прокси,
+// и берётся последний адрес: его дописал прокси, а предыдущие передал сам клиент
+func clientIP(r *http.Request) string {
+	if config.Flags.TrustForwardedFor {
+		if values := r.Header.Values("X-Forwarded-For"); len(values) > 0 {
+			forwarded := values[len(values)-1]
+			if last := strings.TrimSpace(forwarded[strings.LastIndex(forwarded, ",")+1:]); last != "" {
+				return last
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/ratelimit"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	ratelimit.Current = ratelimit.NewMemoryStore()
+	ratelimit.Limits = map[string]ratelimit.Limit{ratelimit.GroupCreate: {Rate: 1, Burst: 2}}
+	t.Cleanup(func() { ratelimit.Limits = map[string]ratelimit.Limit{} })
+
+	handler := RateLimitMiddleware(ratelimit.GroupCreate, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	send := func(userID int, remoteAddr string) *http.Response {
+		request := httptest.NewRequest(http.MethodPost, "/", nil)
+		request.RemoteAddr = remoteAddr
+		request = request.WithContext(context.WithValue(request.Context(), constants.UserIDKey, userID))
+		w := httptest.NewRecorder()
+		handler(w, request)
+		return w.Result()
+	}
+
+	tests := []struct {
+		name       string
+		userID     int
+		remoteAddr string
+		wantStatus int
+		remaining  string
+	}{
+		{"first request", 1, "10.0.0.1:1000", http.StatusCreated, "1"},
+		{"second request", 1, "10.0.0.1:1000", http.StatusCreated, "0"},
+		{"user limit exceeded from other ip", 1, "10.0.0.2:1000", http.StatusTooManyRequests, "0"},
+		{"ip limit exceeded for other user", 2, "10.0.0.1:1000", http.StatusTooManyRequests, "0"},
+		{"other user and ip", 3, "10.0.0.3:1000", http.StatusCreated, "1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := send(tt.userID, tt.remoteAddr)
+			defer res.Body.Close()
+
+			require.Equal(t, tt.wantStatus, res.StatusCode)
+			assert.Equal(t, "2", res.Header.Get("RateLimit-Limit"))
+			assert.Equal(t, tt.remaining, res.Header.Get("RateLimit-Remaining"))
+			if tt.wantStatus == http.StatusTooManyRequests {
+				assert.Equal(t, "1", res.Header.Get("Retry-After"))
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddleware_Disabled(t *testing.T) {
+	ratelimit.Limits = map[string]ratelimit.Limit{}
+	handler := RateLimitMiddleware(ratelimit.GroupDelete, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	})
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodDelete, "/api/user/urls", nil))
+	assert.Equal(t, http.StatusAccepted, w.Code)
+	assert.Empty(t, w.Header().Get("RateLimit-Limit"))
+}
+
+func TestClientIP(t *testing.T) {
+	trust := config.Flags.TrustForwardedFor
+	t.Cleanup(func() { config.Flags.TrustForwardedFor = trust })
+
+	tests := []struct {
+		name      string
+		trust     bool
+		forwarded []string
+		want      string
+	}{
+		{"remote address", false, nil, "10.0.0.1"},
+		{"untrusted header", false, []string{"203.0.113.7"}, "10.0.0.1"},
+		{"proxy appended address", true, []string{"203.0.113.7"}, "203.0.113.7"},
+		{"spoofed leading entry", true, []string{"198.51.100.1, 203.0.113.7"}, "203.0.113.7"},
+		{"spoofed header", true, []string{"198.51.100.1", "203.0.113.7"}, "203.0.113.7"},
+		{"empty last entry", true, []string{"198.51.100.1,"}, "10.0.0.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Flags.TrustForwardedFor = tt.trust
+			request := httptest.NewRequest(http.MethodGet, "/", nil)
+			request.RemoteAddr = "10.0.0.1:1000"
+			for _, forwarded := range tt.forwarded {
+				request.Header.Add("X-Forwarded-For", forwarded)
+			}
+			assert.Equal(t, tt.want, clientIP(request))
+		})
+	}
+}
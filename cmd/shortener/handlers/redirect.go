@@ -0,0 +1,46 @@
+package handlers
+
+import (
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/internal/models"
+	"net/http"
+	"time"
+)
+
+// IsRedirectType сообщает, что code — поддерживаемый код редиректа ссылки
+func IsRedirectType(code int) bool {
+	switch code {
+	case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+// redirectStatus возвращает код редиректа ссылки, для ссылки без собственного кода — config.Flags.RedirectType
+func redirectStatus(record models.URLData) int {
+	if IsRedirectType(record.RedirectType) {
+		return record.RedirectType
+	}
+	if IsRedirectType(config.Flags.RedirectType) {
+		return config.Flags.RedirectType
+	}
+	return http.StatusTemporaryRedirect
+}
+
+// setRedirectCacheHeaders выставляет Cache-Control редиректа. Постоянные редиректы кэшируются
+// на config.Flags.RedirectMaxAge, но не дольше срока жизни ссылки. Временные редиректы,
+// защищённые паролем ссылки и ссылки с правилами перенаправления или A/B-тестом не кэшируются,
+// чтобы каждый переход доходил до сервиса
+func setRedirectCacheHeaders(w http.ResponseWriter, record models.URLData, status int) {
+	permanent := status == http.StatusMovedPermanently || status == http.StatusPermanentRedirect
+	maxAge := config.Flags.RedirectMaxAge
+	if record.ExpiresAt != nil {
+		maxAge = min(maxAge, time.Until(*record.ExpiresAt))
+	}
+	if !permanent || record.PasswordHash != "" || len(record.Rules) > 0 || len(record.Variants) > 0 || maxAge < time.Second {
+		w.Header().Set("Cache-Control", "private, no-store, max-age=0")
+		return
+	}
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"context"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGetRedirectWebhook_RedirectType(t *testing.T) {
+	defaultType, maxAge := config.Flags.RedirectType, config.Flags.RedirectMaxAge
+	config.Flags.RedirectType, config.Flags.RedirectMaxAge = http.StatusFound, time.Hour
+	defer func() { config.Flags.RedirectType, config.Flags.RedirectMaxAge = defaultType, maxAge }()
+
+	soon := time.Now().Add(10 * time.Minute)
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	ctx := context.TODO()
+	for _, record := range []models.URLData{
+		{ShortURL: "default", OriginalURL: "https://ya.ru/default"},
+		{ShortURL: "moved", OriginalURL: "https://ya.ru/moved", RedirectType: http.StatusMovedPermanently},
+		{ShortURL: "permanent", OriginalURL: "https://ya.ru/permanent", RedirectType: http.StatusPermanentRedirect},
+		{ShortURL: "temporary", OriginalURL: "https://ya.ru/temporary", RedirectType: http.StatusTemporaryRedirect},
+		{ShortURL: "expiring", OriginalURL: "https://ya.ru/expiring", RedirectType: http.StatusMovedPermanently, ExpiresAt: &soon},
+	} {
+		require.NoError(t, storage.Store.SaveRecord(ctx, record))
+	}
+
+	tests := []struct {
+		name         string
+		target       string
+		statusCode   int
+		cacheControl string
+	}{
+		{"service default", "/default", http.StatusFound, "private, no-store, max-age=0"},
+		{"301", "/moved", http.StatusMovedPermanently, "public, max-age=3600"},
+		{"308", "/permanent", http.StatusPermanentRedirect, "public, max-age=3600"},
+		{"307", "/temporary", http.StatusTemporaryRedirect, "private, no-store, max-age=0"},
+		{"capped by expiration", "/expiring", http.StatusMovedPermanently, "public, max-age=599"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			GetRedirectWebhook(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			require.Equal(t, tt.statusCode, w.Code)
+			require.Equal(t, "https://ya.ru"+tt.target, w.Header().Get("Location"))
+			require.Equal(t, tt.cacheControl, w.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestPostShortenWebhook_RedirectType(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{"permanent", `{"url":"https://ya.ru/a","redirect_type":308}`, http.StatusCreated},
+		{"default", `{"url":"https://ya.ru/b"}`, http.StatusCreated},
+		{"not a redirect", `{"url":"https://ya.ru/c","redirect_type":200}`, http.StatusBadRequest},
+	}
+
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(tt.body))
+			request.Header.Add("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			PostShortenWebhook(w, request)
+			require.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+
+	types := map[string]int{}
+	require.NoError(t, storage.Store.ExportData(context.TODO(), func(record models.URLData) error {
+		types[record.OriginalURL] = record.RedirectType
+		return nil
+	}))
+	require.Equal(t, map[string]int{"https://ya.ru/a": http.StatusPermanentRedirect, "https://ya.ru/b": 0}, types)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/targeting"
+	"github.com/fngoc/url-shortener/internal/models"
+	"net/http"
+	"time"
+)
+
+// maxRules ограничение на число правил перенаправления одной ссылки
+const maxRules = 20
+
+// errInvalidRule возвращается prepareRules для правила с недопустимыми условиями
+var errInvalidRule = errors.New("invalid rule")
+
+// prepareRules проверяет условия правил перенаправления и адреса назначения правил так же,
+// как основной адрес ссылки
+func prepareRules(ctx context.Context, rules []models.TargetingRule) ([]models.TargetingRule, error) {
+	if len(rules) > maxRules {
+		return nil, fmt.Errorf("%w: no more than %d rules are allowed", errInvalidRule, maxRules)
+	}
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	result := make([]models.TargetingRule, 0, len(rules))
+	for i, rule := range rules {
+		rule, err := targeting.Normalize(rule)
+		if err != nil {
+			return nil, fmt.Errorf("%w %d: %s", errInvalidRule, i, err)
+		}
+		if rule.URL, err = prepareURL(ctx, rule.URL); err != nil {
+			return nil, fmt.Errorf("rule %d: %w", i, err)
+		}
+		result = append(result, rule)
+	}
+	return result, nil
+}
+
+// matchRule возвращает адрес первого правила ссылки, подходящего клиенту r
+func matchRule(r *http.Request, record models.URLData) (string, bool) {
+	if len(record.Rules) == 0 {
+		return "", false
+	}
+	client := targeting.Client{
+		Language: targeting.PreferredLanguage(r.Header.Get("Accept-Language")),
+		Time:     time.Now(),
+	}
+	client.Device, client.OS = targeting.ParseUserAgent(r.UserAgent())
+	if targeting.Geo != nil {
+		client.Country = targeting.Geo.Country(clientIP(r))
+	}
+	return targeting.Match(record.Rules, client)
+}
@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/cmd/shortener/targeting"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGetRedirectWebhook_Targeting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geoip.csv")
+	require.NoError(t, os.WriteFile(path, []byte("5.8.0.0/16,DE\n"), 0o600))
+	geo, err := targeting.NewGeoIP(path)
+	require.NoError(t, err)
+	targeting.Geo = geo
+	t.Cleanup(func() { targeting.Geo = nil })
+
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	require.NoError(t, storage.Store.SaveRecord(context.TODO(), models.URLData{
+		ShortURL:     "app",
+		OriginalURL:  "https://ya.ru/app",
+		RedirectType: http.StatusMovedPermanently,
+		Rules: []models.TargetingRule{
+			{URL: "https://ya.ru/ios", OS: []string{targeting.OSIOS}},
+			{URL: "https://ya.ru/de", Countries: []string{"DE"}},
+			{URL: "https://ya.ru/fr", Languages: []string{"fr"}},
+		},
+	}))
+
+	const iphone = "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Mobile/15E148"
+	tests := []struct {
+		name       string
+		userAgent  string
+		language   string
+		remoteAddr string
+		location   string
+	}{
+		{"os rule", iphone, "", "192.0.2.1:1234", "https://ya.ru/ios"},
+		{"country rule", "", "fr", "5.8.0.1:1234", "https://ya.ru/de"},
+		{"language rule", "", "fr-CA, en;q=0.8", "192.0.2.1:1234", "https://ya.ru/fr"},
+		{"no rule matches", "", "en", "192.0.2.1:1234", "https://ya.ru/app"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/app", nil)
+			request.RemoteAddr = tt.remoteAddr
+			request.Header.Set("User-Agent", tt.userAgent)
+			request.Header.Set("Accept-Language", tt.language)
+			w := httptest.NewRecorder()
+
+			GetRedirectWebhook(w, request)
+			require.Equal(t, http.StatusMovedPermanently, w.Code)
+			require.Equal(t, tt.location, w.Header().Get("Location"))
+			require.Equal(t, "private, no-store, max-age=0", w.Header().Get("Cache-Control"))
+		})
+	}
+}
+
+func TestPostShortenWebhook_Rules(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{"valid", `{"url":"https://ya.ru/a","rules":[{"url":"https://ya.ru/m","devices":["Mobile"]}]}`, http.StatusCreated},
+		{"no conditions", `{"url":"https://ya.ru/b","rules":[{"url":"https://ya.ru/m"}]}`, http.StatusBadRequest},
+		{"unknown os", `{"url":"https://ya.ru/c","rules":[{"url":"https://ya.ru/m","os":["palm"]}]}`, http.StatusBadRequest},
+		{"invalid rule url", `{"url":"https://ya.ru/d","rules":[{"url":"ftp://ya.ru/m","os":["ios"]}]}`, http.StatusBadRequest},
+	}
+
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(tt.body))
+			request.Header.Add("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			PostShortenWebhook(w, request)
+			require.Equal(t, tt.statusCode, w.Code, w.Body.String())
+		})
+	}
+
+	var rules []models.TargetingRule
+	require.NoError(t, storage.Store.ExportData(context.TODO(), func(record models.URLData) error {
+		rules = record.Rules
+		return nil
+	}))
+	require.Equal(t, []models.TargetingRule{{URL: "https://ya.ru/m", Devices: []string{targeting.DeviceMobile}}}, rules)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/domains"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/cmd/shortener/utm"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"github.com/fngoc/url-shortener/internal/models"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+)
+
+// withQuery дополняет адрес перехода UTM-метками из шаблонов ссылки и её рабочего пространства
+// и параметрами запроса перехода в режиме config.Flags.QueryPassthrough
+func withQuery(r *http.Request, record models.URLData, target string) string {
+	utmTemplate := record.UTM
+	if record.WorkspaceID != 0 {
+		workspace, err := storage.Store.Workspace(r.Context(), record.WorkspaceID)
+		if err != nil {
+			// без шаблона рабочего пространства переход всё равно должен состояться
+			logger.Log.Error("Workspace utm template is not loaded", zap.Int("workspace", record.WorkspaceID), zap.Error(err))
+		} else {
+			utmTemplate = utm.Merge(workspace.UTM, record.UTM)
+		}
+	}
+	domain := record.Domain
+	if domain == "" {
+		domain = domains.Default().Host()
+	}
+	return utm.Apply(target, utm.Expand(utmTemplate, record.ShortURL, domain), utm.Passthrough(r.URL.Query(), config.Flags.QueryPassthrough))
+}
+
+// PutWorkspaceUTMWebhook функция обработчик PUT HTTP-запроса для замены шаблона UTM-меток
+// ссылок рабочего пространства, доступен владельцам. Пустой шаблон снимает метки
+func PutWorkspaceUTMWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if !strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		writeUnsupportedMedia(w, "application/json")
+		return
+	}
+	workspaceID, _, err := parseWorkspacePath(r.URL.Path, "utm", false)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	var req map[string]string
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	utmTemplate, err := utm.Validate(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+		return
+	}
+
+	userID := r.Context().Value(constants.UserIDKey).(int)
+	if err := authorizeWorkspace(r.Context(), workspaceID, userID, storage.RoleOwner); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if err := storage.Store.SetWorkspaceUTM(r.Context(), workspaceID, utmTemplate); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	workspace, err := storage.Store.Workspace(r.Context(), workspaceID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	workspace.Role = storage.RoleOwner
+	writeJSON(w, http.StatusOK, workspace)
+}
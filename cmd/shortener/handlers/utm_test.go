@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"context"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/cmd/shortener/utm"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGetRedirectWebhook_UTM(t *testing.T) {
+	passthrough, baseURL := config.Flags.QueryPassthrough, config.Flags.BaseResultAddress
+	config.Flags.QueryPassthrough, config.Flags.BaseResultAddress = utm.PassthroughUTM, "http://localhost:8080"
+	defer func() { config.Flags.QueryPassthrough, config.Flags.BaseResultAddress = passthrough, baseURL }()
+
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	owner := context.WithValue(context.Background(), constants.UserIDKey, 1)
+	workspace, err := storage.Store.CreateWorkspace(owner, "team", 1)
+	require.NoError(t, err)
+	for _, record := range []models.URLData{
+		{ShortURL: "plain", OriginalURL: "https://ya.ru/plain?a=1#top"},
+		{ShortURL: "tagged", OriginalURL: "https://ya.ru/tagged?utm_medium=site", UTM: map[string]string{"utm_source": "{domain}", "utm_medium": "email", "utm_content": "{id}"}},
+		{ShortURL: "team", OriginalURL: "https://ya.ru/team", UserID: 1, WorkspaceID: workspace.ID, UTM: map[string]string{"utm_campaign": "spring"}},
+	} {
+		require.NoError(t, storage.Store.SaveRecord(owner, record))
+	}
+
+	request := httptest.NewRequest(http.MethodPut, "/api/user/workspaces/"+strconv.Itoa(workspace.ID)+"/utm",
+		strings.NewReader(`{"utm_source":"team","utm_campaign":"winter"}`))
+	request.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	PutWorkspaceUTMWebhook(w, request.WithContext(owner))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+
+	tests := []struct {
+		name     string
+		target   string
+		location string
+	}{
+		{"no templates and query", "/plain", "https://ya.ru/plain?a=1#top"},
+		{"utm passthrough", "/plain?utm_source=ads&ref=x", "https://ya.ru/plain?a=1&utm_source=ads#top"},
+		{"link template", "/tagged", "https://ya.ru/tagged?utm_medium=site&utm_content=tagged&utm_source=localhost%3A8080"},
+		{"incoming overrides template", "/tagged?utm_source=ads", "https://ya.ru/tagged?utm_medium=site&utm_content=tagged&utm_source=ads"},
+		{"workspace template", "/team", "https://ya.ru/team?utm_campaign=spring&utm_source=team"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			GetRedirectWebhook(w, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+			require.Equal(t, tt.location, w.Header().Get("Location"))
+		})
+	}
+}
+
+func TestPutWorkspaceUTMWebhook(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	owner := context.WithValue(context.Background(), constants.UserIDKey, 1)
+	workspace, err := storage.Store.CreateWorkspace(owner, "team", 1)
+	require.NoError(t, err)
+	require.NoError(t, storage.Store.SetMemberRole(owner, workspace.ID, 2, storage.RoleEditor))
+	path := "/api/user/workspaces/" + strconv.Itoa(workspace.ID) + "/utm"
+
+	tests := []struct {
+		name       string
+		body       string
+		userID     int
+		statusCode int
+	}{
+		{"owner", `{"utm_source":"team"}`, 1, http.StatusOK},
+		{"unknown parameter", `{"ref":"team"}`, 1, http.StatusBadRequest},
+		{"editor", `{"utm_source":"editor"}`, 2, http.StatusForbidden},
+		{"stranger", `{"utm_source":"stranger"}`, 3, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPut, path, strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", "application/json")
+			request = request.WithContext(context.WithValue(request.Context(), constants.UserIDKey, tt.userID))
+			w := httptest.NewRecorder()
+
+			PutWorkspaceUTMWebhook(w, request)
+			require.Equal(t, tt.statusCode, w.Code, w.Body.String())
+		})
+	}
+
+	workspace, err = storage.Store.Workspace(owner, workspace.ID)
+	require.NoError(t, err)
+	require.Equal(t, map[string]string{"utm_source": "team"}, workspace.UTM)
+}
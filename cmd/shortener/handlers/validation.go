@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/policy"
+	"github.com/fngoc/url-shortener/internal/normalizer"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	maxTags         = 20
+	maxTagLength    = 50
+	maxFolderLength = 100
+)
+
+// prepareURL проверяет и нормализует URL по настройкам из config.Flags и проверяет адрес назначения
+// политикой policy.Current. Нарушение политики возвращается как *policy.Violation
+func prepareURL(ctx context.Context, raw string) (string, error) {
+	normalized, err := normalizer.Normalize(raw, normalizer.Options{
+		AllowedSchemes: strings.Split(config.Flags.AllowedSchemes, ","),
+		MaxLength:      config.Flags.MaxURLLength,
+		StripFragment:  config.Flags.StripFragments,
+	})
+	if err != nil {
+		return "", err
+	}
+	if policy.Current != nil {
+		if err := policy.Current.Check(ctx, normalized); err != nil {
+			return "", err
+		}
+	}
+	return normalized, nil
+}
+
+// plainTextURL достаёт URL из тела text/plain запроса. Тело вида url=... (так отправляет cmd/client)
+// разбирается как форма
+func plainTextURL(body string) string {
+	body = strings.TrimSpace(body)
+	if strings.HasPrefix(body, "url=") {
+		if values, err := url.ParseQuery(body); err == nil {
+			return values.Get("url")
+		}
+	}
+	return body
+}
+
+// prepareTags приводит метки к нижнему регистру, убирает повторы и сортирует. Запятая в метке
+// недопустима: по ней метки разделяются при выгрузке в CSV
+func prepareTags(tags []string) ([]string, error) {
+	if len(tags) > maxTags {
+		return nil, fmt.Errorf("no more than %d tags are allowed", maxTags)
+	}
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || utf8.RuneCountInString(tag) > maxTagLength || strings.Contains(tag, ",") {
+			return nil, fmt.Errorf("tag must be 1 to %d characters without commas", maxTagLength)
+		}
+		result = append(result, tag)
+	}
+	slices.Sort(result)
+	return slices.Compact(result), nil
+}
+
+// prepareFolder проверяет название папки, пустое название означает ссылку вне папок
+func prepareFolder(folder string) (string, error) {
+	folder = strings.TrimSpace(folder)
+	if utf8.RuneCountInString(folder) > maxFolderLength {
+		return "", fmt.Errorf("folder must be no longer than %d characters", maxFolderLength)
+	}
+	return folder, nil
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/domains"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"hash/fnv"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	maxVariants      = 10
+	maxVariantWeight = 1000
+	statsPathSuffix  = "/stats"
+	// variantCookie запоминает вариант посетителя по хешу его адреса, поэтому правка списка вариантов
+	// не переводит посетителя на другой адрес. Cookie ставится на путь ссылки, поэтому у каждой ссылки
+	// и каждого домена она своя
+	variantCookie       = "variant"
+	variantCookieMaxAge = 30 * 24 * time.Hour
+)
+
+// errInvalidVariant возвращается prepareVariants для недопустимых вариантов A/B-теста
+var errInvalidVariant = errors.New("invalid variants")
+
+// prepareVariants проверяет веса вариантов A/B-теста и их адреса назначения так же,
+// как основной адрес ссылки
+func prepareVariants(ctx context.Context, variants []models.Variant) ([]models.Variant, error) {
+	if len(variants) == 0 {
+		return nil, nil
+	}
+	if len(variants) < 2 || len(variants) > maxVariants {
+		return nil, fmt.Errorf("%w: from 2 to %d variants are allowed", errInvalidVariant, maxVariants)
+	}
+	result := make([]models.Variant, 0, len(variants))
+	total := 0
+	for i, variant := range variants {
+		if variant.Weight < 0 || variant.Weight > maxVariantWeight {
+			return nil, fmt.Errorf("%w: weight of variant %d must be from 0 to %d", errInvalidVariant, i, maxVariantWeight)
+		}
+		target, err := prepareURL(ctx, variant.URL)
+		if err != nil {
+			return nil, fmt.Errorf("variant %d: %w", i, err)
+		}
+		total += variant.Weight
+		result = append(result, models.Variant{URL: target, Weight: variant.Weight})
+	}
+	if total == 0 {
+		return nil, fmt.Errorf("%w: at least one variant must have a positive weight", errInvalidVariant)
+	}
+	return result, nil
+}
+
+// destination выбирает адрес перехода по ссылке: адрес первого подходящего правила, вариант A/B-теста
+// или OriginalURL, дописывает к нему путь suffix и дополняет параметрами withQuery. Второе значение —
+// индекс выбранного варианта или storage.NoVariant
+func destination(w http.ResponseWriter, r *http.Request, record models.URLData, suffix []string) (string, int) {
+	target, variant := record.OriginalURL, storage.NoVariant
+	if rule, ok := matchRule(r, record); ok {
+		target = rule
+	} else if len(record.Variants) > 0 {
+		variant = pickVariant(w, r, record)
+		target = record.Variants[variant].URL
+	}
+	return withQuery(r, record, joinPath(target, suffix)), variant
+}
+
+// pickVariant возвращает вариант посетителя из cookie, пока вариант с тем же адресом есть в ссылке и у него
+// есть вес, иначе выбирает случайный вариант пропорционально весам и запоминает его в cookie
+func pickVariant(w http.ResponseWriter, r *http.Request, record models.URLData) int {
+	if cookie, err := r.Cookie(variantCookie); err == nil {
+		for i, variant := range record.Variants {
+			if variant.Weight > 0 && variantKey(variant) == cookie.Value {
+				return i
+			}
+		}
+	}
+
+	variant := weightedVariant(record.Variants)
+	http.SetCookie(w, &http.Cookie{
+		Name:     variantCookie,
+		Value:    variantKey(record.Variants[variant]),
+		Path:     "/" + record.ShortURL,
+		MaxAge:   int(variantCookieMaxAge.Seconds()),
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return variant
+}
+
+// variantKey значение cookie варианта: хеш его адреса, который не меняется при перестановке вариантов
+func variantKey(variant models.Variant) string {
+	hash := fnv.New64a()
+	_, _ = hash.Write([]byte(variant.URL))
+	return strconv.FormatUint(hash.Sum64(), 36)
+}
+
+// weightedVariant выбирает случайный вариант пропорционально весам, сумма весов положительна
+func weightedVariant(variants []models.Variant) int {
+	total := 0
+	for _, variant := range variants {
+		total += variant.Weight
+	}
+	n := rand.IntN(total)
+	for i, variant := range variants {
+		if n < variant.Weight {
+			return i
+		}
+		n -= variant.Weight
+	}
+	return len(variants) - 1
+}
+
+// GetUrlStatsWebhook функция обработчик GET HTTP-запроса статистики переходов по ссылке
+// с разбивкой по текущим вариантам A/B-теста. Ссылка другого домена выбирается параметром domain
+func GetUrlStatsWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, userUrlsPathPrefix), statsPathSuffix)
+	if id == "" || strings.Contains(id, "/") {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "short url id is empty")
+		return
+	}
+
+	userID := r.Context().Value(constants.UserIDKey).(int)
+	domain := domains.Name(r.URL.Query().Get("domain"))
+	record, err := findLink(r.Context(), domain, id, userID, storage.RoleViewer)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	clicks, err := storage.Store.Clicks(r.Context(), domain, id)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+
+	var stats models.LinkStats
+	for _, n := range clicks {
+		stats.Clicks += n
+	}
+	for i, variant := range record.Variants {
+		stats.Variants = append(stats.Variants, models.VariantStats{Variant: variant, Clicks: clicks[i]})
+	}
+
+	buf := bytes.Buffer{}
+	if err := json.NewEncoder(&buf).Encode(stats); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(buf.Bytes())
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGetRedirectWebhook_Variants(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	ctx := context.WithValue(context.Background(), constants.UserIDKey, 1)
+	variants := []models.Variant{
+		{URL: "https://ya.ru/a", Weight: 0},
+		{URL: "https://ya.ru/b", Weight: 1},
+		{URL: "https://ya.ru/c", Weight: 1},
+	}
+	require.NoError(t, storage.Store.SaveRecord(ctx, models.URLData{ShortURL: "split", OriginalURL: "https://ya.ru/split", UserID: 1, Variants: variants}))
+
+	tests := []struct {
+		name      string
+		cookie    string
+		locations []string
+		newCookie bool
+	}{
+		{"new visitor", "", []string{"https://ya.ru/b", "https://ya.ru/c"}, true},
+		{"sticky variant", variantKey(variants[2]), []string{"https://ya.ru/c"}, false},
+		{"variant without weight", variantKey(variants[0]), []string{"https://ya.ru/b", "https://ya.ru/c"}, true},
+		{"positional cookie", "2", []string{"https://ya.ru/b", "https://ya.ru/c"}, true},
+		{"removed variant", variantKey(models.Variant{URL: "https://ya.ru/d"}), []string{"https://ya.ru/b", "https://ya.ru/c"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodGet, "/split", nil)
+			if tt.cookie != "" {
+				request.AddCookie(&http.Cookie{Name: variantCookie, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+
+			GetRedirectWebhook(w, request)
+			require.Equal(t, http.StatusTemporaryRedirect, w.Code)
+			require.Contains(t, tt.locations, w.Header().Get("Location"))
+			require.Equal(t, "private, no-store, max-age=0", w.Header().Get("Cache-Control"))
+
+			cookies := w.Result().Cookies()
+			if !tt.newCookie {
+				require.Empty(t, cookies)
+				return
+			}
+			require.Len(t, cookies, 1)
+			require.Equal(t, "/split", cookies[0].Path)
+			require.Equal(t, variantKey(models.Variant{URL: w.Header().Get("Location")}), cookies[0].Value)
+		})
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/user/urls/split/stats", nil)
+	request = request.WithContext(ctx)
+	w := httptest.NewRecorder()
+	GetUrlStatsWebhook(w, request)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var stats models.LinkStats
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&stats))
+	require.Equal(t, len(tests), stats.Clicks)
+	require.Len(t, stats.Variants, 3)
+	require.Zero(t, stats.Variants[0].Clicks)
+	require.GreaterOrEqual(t, stats.Variants[2].Clicks, 1)
+	require.Equal(t, len(tests), stats.Variants[1].Clicks+stats.Variants[2].Clicks)
+}
+
+func TestGetRedirectWebhook_VariantsReordered(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	ctx := context.WithValue(context.Background(), constants.UserIDKey, 1)
+	variants := []models.Variant{{URL: "https://ya.ru/a", Weight: 1}, {URL: "https://ya.ru/b", Weight: 1}}
+	require.NoError(t, storage.Store.SaveRecord(ctx, models.URLData{ShortURL: "split", OriginalURL: "https://ya.ru/split", UserID: 1, Variants: variants}))
+
+	w := httptest.NewRecorder()
+	GetRedirectWebhook(w, httptest.NewRequest(http.MethodGet, "/split", nil))
+	location := w.Header().Get("Location")
+	cookies := w.Result().Cookies()
+	require.Len(t, cookies, 1)
+
+	// вариант посетителя переезжает в конец списка, перед ним появляется новый
+	reordered := []models.Variant{{URL: "https://ya.ru/c", Weight: 1}}
+	for _, variant := range variants {
+		if variant.URL != location {
+			reordered = append(reordered, variant)
+		}
+	}
+	reordered = append(reordered, models.Variant{URL: location, Weight: 1})
+	_, err := storage.Store.UpdateRecord(ctx, "", "split", storage.LinkChanges{Variants: &reordered})
+	require.NoError(t, err)
+
+	request := httptest.NewRequest(http.MethodGet, "/split", nil)
+	request.AddCookie(cookies[0])
+	w = httptest.NewRecorder()
+	GetRedirectWebhook(w, request)
+	require.Equal(t, location, w.Header().Get("Location"), "returning visitor keeps the destination")
+	require.Empty(t, w.Result().Cookies())
+}
+
+func TestPostShortenWebhook_Variants(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{"valid", `{"url":"https://ya.ru/1","variants":[{"url":"https://ya.ru/a","weight":50},{"url":"https://ya.ru/b","weight":50}]}`, http.StatusCreated},
+		{"single variant", `{"url":"https://ya.ru/2","variants":[{"url":"https://ya.ru/a","weight":50}]}`, http.StatusBadRequest},
+		{"zero weights", `{"url":"https://ya.ru/3","variants":[{"url":"https://ya.ru/a","weight":0},{"url":"https://ya.ru/b","weight":0}]}`, http.StatusBadRequest},
+		{"negative weight", `{"url":"https://ya.ru/4","variants":[{"url":"https://ya.ru/a","weight":-1},{"url":"https://ya.ru/b","weight":2}]}`, http.StatusBadRequest},
+		{"invalid url", `{"url":"https://ya.ru/5","variants":[{"url":"ya.ru","weight":1},{"url":"https://ya.ru/b","weight":1}]}`, http.StatusBadRequest},
+	}
+
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(tt.body))
+			request.Header.Add("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			PostShortenWebhook(w, request)
+			require.Equal(t, tt.statusCode, w.Code, w.Body.String())
+		})
+	}
+}
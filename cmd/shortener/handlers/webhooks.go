@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/cmd/shortener/webhooks"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"github.com/fngoc/url-shortener/internal/models"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	webhooksPathPrefix = "/api/user/webhooks/"
+	// maxWebhooks ограничение на число подписок пользователя
+	maxWebhooks = 20
+	// minSecretLength и maxSecretLength ограничения длины секрета, заданного пользователем
+	minSecretLength = 16
+	maxSecretLength = 256
+)
+
+// PostWebhookWebhook функция обработчик POST HTTP-запроса для создания подписки на события ссылок
+// пользователя. Секрет подписи отдаётся только в ответе на этот запрос
+func PostWebhookWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if !strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		writeUnsupportedMedia(w, "application/json")
+		return
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	var req models.WebhookRequest
+	if err := dec.Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	webhook, err := prepareWebhook(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, err.Error())
+		return
+	}
+
+	userID := r.Context().Value(constants.UserIDKey).(int)
+	existing, err := storage.Store.Webhooks(r.Context(), userID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if len(existing) >= maxWebhooks {
+		writeError(w, http.StatusConflict, codeConflict, fmt.Sprintf("no more than %d webhooks are allowed", maxWebhooks))
+		return
+	}
+	webhook.UserID = userID
+	webhook, err = storage.Store.CreateWebhook(r.Context(), webhook)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if webhooks.Current != nil && webhook.ClickThreshold > 0 {
+		if err := webhooks.Current.RefreshThresholds(r.Context()); err != nil {
+			logger.Log.Warn("Webhook click thresholds are not loaded", zap.Error(err))
+		}
+	}
+	writeJSON(w, http.StatusCreated, webhook)
+}
+
+// GetWebhooksWebhook функция обработчик GET HTTP-запроса для получения подписок пользователя без секретов
+func GetWebhooksWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	userID := r.Context().Value(constants.UserIDKey).(int)
+	result, err := storage.Store.Webhooks(r.Context(), userID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if len(result) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	for i := range result {
+		result[i].Secret = ""
+	}
+	writeJSON(w, http.StatusOK, result)
+}
+
+// DeleteWebhookWebhook функция обработчик DELETE HTTP-запроса для удаления подписки вместе с журналом доставок
+func DeleteWebhookWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeMethodNotAllowed(w)
+		return
+	}
+	webhook, ok := userWebhook(w, r, "")
+	if !ok {
+		return
+	}
+	if err := storage.Store.DeleteWebhook(r.Context(), webhook.ID); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetDeliveriesWebhook функция обработчик GET HTTP-запроса для получения журнала доставок подписки,
+// последние доставки первыми
+func GetDeliveriesWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	webhook, ok := userWebhook(w, r, "deliveries")
+	if !ok {
+		return
+	}
+	deliveries, err := storage.Store.Deliveries(r.Context(), webhook.ID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if len(deliveries) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, deliveries)
+}
+
+// PostWebhookTestWebhook функция обработчик POST HTTP-запроса для тестовой отправки события ping.
+// Событие отправляется сразу одной попыткой, в ответе доставка с кодом ответа подписчика или ошибкой
+func PostWebhookTestWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	webhook, ok := userWebhook(w, r, "test")
+	if !ok {
+		return
+	}
+	if webhooks.Current == nil {
+		writeError(w, http.StatusServiceUnavailable, codeInternalError, "webhooks are disabled")
+		return
+	}
+	delivery, err := webhooks.Current.Test(r.Context(), webhook)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, delivery)
+}
+
+// prepareWebhook проверяет адрес, события, порог переходов и секрет подписки. Пустой секрет генерируется
+func prepareWebhook(req models.WebhookRequest) (models.Webhook, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return models.Webhook{}, fmt.Errorf("url must be an absolute http or https URL")
+	}
+	if len(req.URL) > config.Flags.MaxURLLength {
+		return models.Webhook{}, fmt.Errorf("url must be no longer than %d bytes", config.Flags.MaxURLLength)
+	}
+
+	if len(req.Events) == 0 {
+		return models.Webhook{}, fmt.Errorf("events must list at least one of %s", strings.Join(webhooks.Events, ", "))
+	}
+	events := make([]string, 0, len(req.Events))
+	for _, event := range req.Events {
+		if !webhooks.IsEvent(event) {
+			return models.Webhook{}, fmt.Errorf("unknown event %q, use %s", event, strings.Join(webhooks.Events, ", "))
+		}
+		if !slices.Contains(events, event) {
+			events = append(events, event)
+		}
+	}
+	switch {
+	case req.ClickThreshold < 0:
+		return models.Webhook{}, fmt.Errorf("click_threshold must not be negative")
+	case slices.Contains(events, webhooks.EventClickThreshold) && req.ClickThreshold == 0:
+		return models.Webhook{}, fmt.Errorf("click_threshold is required for %s", webhooks.EventClickThreshold)
+	case !slices.Contains(events, webhooks.EventClickThreshold) && req.ClickThreshold != 0:
+		return models.Webhook{}, fmt.Errorf("click_threshold is used only with %s", webhooks.EventClickThreshold)
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		buf := make([]byte, 32)
+		if _, err := rand.Read(buf); err != nil {
+			return models.Webhook{}, err
+		}
+		secret = hex.EncodeToString(buf)
+	} else if len(secret) < minSecretLength || len(secret) > maxSecretLength {
+		return models.Webhook{}, fmt.Errorf("secret must be from %d to %d bytes", minSecretLength, maxSecretLength)
+	}
+	return models.Webhook{URL: req.URL, Secret: secret, Events: events, ClickThreshold: req.ClickThreshold}, nil
+}
+
+// userWebhook находит подписку из пути /api/user/webhooks/{id}[/{section}]. Чужая подписка не раскрывается:
+// отвечает 404. При отказе ответ уже записан в w
+func userWebhook(w http.ResponseWriter, r *http.Request, section string) (models.Webhook, bool) {
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, webhooksPathPrefix), "/"), "/")
+	if section == "" && len(parts) != 1 || section != "" && (len(parts) != 2 || parts[1] != section) {
+		writeError(w, http.StatusBadRequest, codeBadRequest, fmt.Sprintf("unexpected path: %s", r.URL.Path))
+		return models.Webhook{}, false
+	}
+	webhookID, err := strconv.Atoi(parts[0])
+	if err != nil || webhookID < 1 {
+		writeError(w, http.StatusBadRequest, codeBadRequest, "webhook id must be a positive number")
+		return models.Webhook{}, false
+	}
+
+	userID := r.Context().Value(constants.UserIDKey).(int)
+	webhook, err := storage.Store.Webhook(r.Context(), webhookID)
+	if err == nil && webhook.UserID != userID {
+		err = fmt.Errorf("webhook: %d, %w", webhookID, storage.ErrNotFound)
+	}
+	if err != nil {
+		writeStorageError(w, err)
+		return models.Webhook{}, false
+	}
+	return webhook, true
+}
+
+// notifyLink сообщает подписчикам владельца ссылки о событии. Ссылка читается из хранилища,
+// чтобы событие содержало её сохранённое состояние
+func notifyLink(ctx context.Context, event string, domain string, shortURL string) {
+	if webhooks.Current == nil {
+		return
+	}
+	record, err := storage.Store.FindRecord(ctx, domain, shortURL)
+	if err != nil {
+		logger.Log.Warn("Webhook event link is not loaded", zap.String("short_url", shortURL), zap.Error(err))
+		return
+	}
+	// доставка сохраняется и после того, как клиент закрыл соединение
+	ctx = context.WithoutCancel(ctx)
+	if err := webhooks.Current.Notify(ctx, record.UserID, webhookEvent(event, record, 0)); err != nil {
+		logger.Log.Warn("Webhook deliveries are not saved", zap.String("event", event), zap.Error(err))
+	}
+}
+
+// notifyClicks сообщает подписчикам владельца ссылки record, что число переходов по ней достигло clicks,
+// если оно перешло порог переходов хотя бы одной подписки
+func notifyClicks(ctx context.Context, record models.URLData, clicks int) {
+	if webhooks.Current == nil || !webhooks.Current.Crossed(clicks-1, clicks) {
+		return
+	}
+	event := webhookEvent(webhooks.EventClickThreshold, record, clicks)
+	if err := webhooks.Current.NotifyClicks(ctx, record.UserID, clicks-1, event); err != nil {
+		logger.Log.Warn("Webhook deliveries are not saved", zap.String("event", event.Event), zap.Error(err))
+	}
+}
+
+// webhookEvent событие о ссылке record, clicks — число переходов по ссылке
+func webhookEvent(event string, record models.URLData, clicks int) models.WebhookEvent {
+	link := toResponseDtos([]models.URLData{record})[0]
+	return models.WebhookEvent{Event: event, CreatedAt: time.Now().UTC(), Link: &link, Clicks: clicks}
+}
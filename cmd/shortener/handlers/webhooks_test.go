@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/cmd/shortener/webhooks"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func postWebhook(t *testing.T, userID int, body string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(http.MethodPost, "/api/user/webhooks", strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	PostWebhookWebhook(w, request.WithContext(context.WithValue(context.Background(), constants.UserIDKey, userID)))
+	return w
+}
+
+func TestPostWebhookWebhook(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+
+	tests := []struct {
+		name       string
+		body       string
+		statusCode int
+	}{
+		{"created events", `{"url":"https://crm.example.com/hook","events":["link.created","link.deleted"]}`, http.StatusCreated},
+		{"click threshold", `{"url":"https://crm.example.com/hook","events":["link.click_threshold"],"click_threshold":100}`, http.StatusCreated},
+		{"own secret", `{"url":"https://crm.example.com/hook","secret":"0123456789abcdef","events":["link.created"]}`, http.StatusCreated},
+		{"short secret", `{"url":"https://crm.example.com/hook","secret":"short","events":["link.created"]}`, http.StatusBadRequest},
+		{"threshold without value", `{"url":"https://crm.example.com/hook","events":["link.click_threshold"]}`, http.StatusBadRequest},
+		{"threshold without event", `{"url":"https://crm.example.com/hook","events":["link.created"],"click_threshold":10}`, http.StatusBadRequest},
+		{"unknown event", `{"url":"https://crm.example.com/hook","events":["link.updated"]}`, http.StatusBadRequest},
+		{"no events", `{"url":"https://crm.example.com/hook","events":[]}`, http.StatusBadRequest},
+		{"relative url", `{"url":"/hook","events":["link.created"]}`, http.StatusBadRequest},
+		{"ftp url", `{"url":"ftp://crm.example.com/hook","events":["link.created"]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postWebhook(t, 1, tt.body)
+			require.Equal(t, tt.statusCode, w.Code, w.Body.String())
+			if tt.statusCode != http.StatusCreated {
+				return
+			}
+			var webhook models.Webhook
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&webhook))
+			require.NotZero(t, webhook.ID)
+			require.GreaterOrEqual(t, len(webhook.Secret), minSecretLength, "secret is returned on creation")
+		})
+	}
+
+	w := httptest.NewRecorder()
+	GetWebhooksWebhook(w, httptest.NewRequest(http.MethodGet, "/api/user/webhooks", nil).
+		WithContext(context.WithValue(context.Background(), constants.UserIDKey, 1)))
+	require.Equal(t, http.StatusOK, w.Code)
+	var listed []models.Webhook
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&listed))
+	require.Len(t, listed, 3)
+	for _, webhook := range listed {
+		require.Empty(t, webhook.Secret, "secret is not listed")
+	}
+
+	w = httptest.NewRecorder()
+	GetWebhooksWebhook(w, httptest.NewRequest(http.MethodGet, "/api/user/webhooks", nil).
+		WithContext(context.WithValue(context.Background(), constants.UserIDKey, 2)))
+	require.Equal(t, http.StatusNoContent, w.Code)
+}
+
+func TestWebhookAccess(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	w := postWebhook(t, 1, `{"url":"https://crm.example.com/hook","events":["link.created"]}`)
+	require.Equal(t, http.StatusCreated, w.Code)
+	var webhook models.Webhook
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&webhook))
+	path := "/api/user/webhooks/" + strconv.Itoa(webhook.ID)
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		userID     int
+		handler    http.HandlerFunc
+		statusCode int
+	}{
+		{"stranger reads deliveries", http.MethodGet, path + "/deliveries", 2, GetDeliveriesWebhook, http.StatusNotFound},
+		{"owner reads empty deliveries", http.MethodGet, path + "/deliveries", 1, GetDeliveriesWebhook, http.StatusNoContent},
+		{"bad id", http.MethodGet, "/api/user/webhooks/abc/deliveries", 1, GetDeliveriesWebhook, http.StatusBadRequest},
+		{"stranger deletes", http.MethodDelete, path, 2, DeleteWebhookWebhook, http.StatusNotFound},
+		{"owner deletes", http.MethodDelete, path, 1, DeleteWebhookWebhook, http.StatusNoContent},
+		{"deleted", http.MethodDelete, path, 1, DeleteWebhookWebhook, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			tt.handler(w, httptest.NewRequest(tt.method, tt.target, nil).
+				WithContext(context.WithValue(context.Background(), constants.UserIDKey, tt.userID)))
+			require.Equal(t, tt.statusCode, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestWebhookNotifications(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	var mu sync.Mutex
+	var received []models.WebhookEvent
+	var signatures []bool
+	secret := "0123456789abcdef"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get(webhooks.TimestampHeader), 10, 64)
+		var event models.WebhookEvent
+		_ = json.Unmarshal(body, &event)
+
+		mu.Lock()
+		defer mu.Unlock()
+		received = append(received, event)
+		signatures = append(signatures, r.Header.Get(webhooks.SignatureHeader) == webhooks.Sign(secret, timestamp, body))
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	current := webhooks.Current
+	webhooks.Current = webhooks.NewDispatcher(storage.Store, server.Client(), 3, time.Minute)
+	done := make(chan struct{})
+	go func() {
+		webhooks.Current.Run(ctx, time.Hour)
+		close(done)
+	}()
+	// обработчики читают хранилище, которое следующие тесты заменяют, поэтому они останавливаются до выхода из теста
+	defer func() {
+		cancel()
+		<-done
+		webhooks.Current = current
+	}()
+
+	w := postWebhook(t, 1, `{"url":"`+server.URL+`","secret":"`+secret+`","events":["link.created","link.click_threshold"],"click_threshold":2}`)
+	require.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var webhook models.Webhook
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&webhook))
+	path := "/api/user/webhooks/" + strconv.Itoa(webhook.ID)
+
+	w = httptest.NewRecorder()
+	PostWebhookTestWebhook(w, httptest.NewRequest(http.MethodPost, path+"/test", nil).
+		WithContext(context.WithValue(context.Background(), constants.UserIDKey, 1)))
+	require.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var delivery models.WebhookDelivery
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&delivery))
+	require.Equal(t, storage.DeliveryDelivered, delivery.Status)
+	require.True(t, delivery.Test)
+
+	request := httptest.NewRequest(http.MethodPost, "/api/shorten", strings.NewReader(`{"url":"https://ya.ru/hooked"}`))
+	request.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	PostShortenWebhook(w, request.WithContext(context.WithValue(context.Background(), constants.UserIDKey, 1)))
+	require.Equal(t, http.StatusCreated, w.Code)
+	var response models.Response
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	id := response.Result[strings.LastIndex(response.Result, "/")+1:]
+
+	for i := 0; i < 3; i++ {
+		GetRedirectWebhook(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/"+id, nil))
+	}
+
+	require.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(received) == 3
+	}, time.Second, 10*time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	require.Equal(t, webhooks.EventPing, received[0].Event)
+	require.Equal(t, webhooks.EventLinkCreated, received[1].Event)
+	require.Equal(t, "https://ya.ru/hooked", received[1].Link.OriginalURL)
+	require.Equal(t, webhooks.EventClickThreshold, received[2].Event)
+	require.Equal(t, 2, received[2].Clicks)
+	require.Equal(t, []bool{true, true, true}, signatures)
+
+	w = httptest.NewRecorder()
+	GetDeliveriesWebhook(w, httptest.NewRequest(http.MethodGet, path+"/deliveries", nil).
+		WithContext(context.WithValue(context.Background(), constants.UserIDKey, 1)))
+	require.Equal(t, http.StatusOK, w.Code)
+	var deliveries []models.WebhookDelivery
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&deliveries))
+	require.Len(t, deliveries, 3)
+}
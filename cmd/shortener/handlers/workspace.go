@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	workspacesPathPrefix   = "/api/user/workspaces/"
+	maxWorkspaceNameLength = 100
+)
+
+// errForbidden возвращается, если роли пользователя недостаточно для действия
+var errForbidden = errors.New("forbidden")
+
+// errLastOwner возвращается при попытке оставить рабочее пространство без владельца
+var errLastOwner = errors.New("workspace must keep at least one owner")
+
+// roleRank порядок ролей: каждая следующая роль включает права предыдущей
+var roleRank = map[string]int{storage.RoleViewer: 1, storage.RoleEditor: 2, storage.RoleOwner: 3}
+
+// authorizeWorkspace проверяет, что роль пользователя в рабочем пространстве не ниже need.
+// Не участнику рабочее пространство не раскрывается: возвращается storage.ErrNotFound
+func authorizeWorkspace(ctx context.Context, workspaceID int, userID int, need string) error {
+	role, err := storage.Store.MemberRole(ctx, workspaceID, userID)
+	if err != nil {
+		return err
+	}
+	if role == "" {
+		return fmt.Errorf("workspace: %d, %w", workspaceID, storage.ErrNotFound)
+	}
+	if roleRank[role] < roleRank[need] {
+		return errForbidden
+	}
+	return nil
+}
+
+// findLink находит ссылку в любом состоянии и проверяет права пользователя на неё: личной ссылкой
+// распоряжается только её автор, ссылкой рабочего пространства — участники с ролью не ниже need
+func findLink(ctx context.Context, domain string, shortURL string, userID int, need string) (models.URLData, error) {
+	record, err := storage.Store.FindRecord(ctx, domain, shortURL)
+	if err != nil {
+		return models.URLData{}, err
+	}
+	if record.WorkspaceID == 0 {
+		if record.UserID != userID {
+			return models.URLData{}, fmt.Errorf("data by key: %s, %w", shortURL, storage.ErrNotFound)
+		}
+		return record, nil
+	}
+	return record, authorizeWorkspace(ctx, record.WorkspaceID, userID, need)
+}
+
+// PostWorkspaceWebhook функция обработчик POST HTTP-запроса для создания рабочего пространства,
+// создатель становится его владельцем
+func PostWorkspaceWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if !strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		writeUnsupportedMedia(w, "application/json")
+		return
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	var req models.WorkspaceRequest
+	if err := dec.Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || utf8.RuneCountInString(name) > maxWorkspaceNameLength {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, fmt.Sprintf("name must be 1 to %d characters", maxWorkspaceNameLength))
+		return
+	}
+
+	userID := r.Context().Value(constants.UserIDKey).(int)
+	workspace, err := storage.Store.CreateWorkspace(r.Context(), name, userID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, workspace)
+}
+
+// GetWorkspacesWebhook функция обработчик GET HTTP-запроса для получения рабочих пространств пользователя
+func GetWorkspacesWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+
+	userID := r.Context().Value(constants.UserIDKey).(int)
+	workspaces, err := storage.Store.ListWorkspaces(r.Context(), userID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if len(workspaces) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, workspaces)
+}
+
+// GetMembersWebhook функция обработчик GET HTTP-запроса для получения участников рабочего пространства
+func GetMembersWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeMethodNotAllowed(w)
+		return
+	}
+	workspaceID, _, err := parseWorkspacePath(r.URL.Path, "members", false)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	userID := r.Context().Value(constants.UserIDKey).(int)
+	if err := authorizeWorkspace(r.Context(), workspaceID, userID, storage.RoleViewer); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	members, err := storage.Store.Members(r.Context(), workspaceID)
+	if err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, members)
+}
+
+// PutMemberWebhook функция обработчик PUT HTTP-запроса для назначения роли участнику рабочего
+// пространства, доступен владельцам
+func PutMemberWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		writeMethodNotAllowed(w)
+		return
+	}
+	if !strings.Contains(r.Header.Get("Content-Type"), "application/json") {
+		writeUnsupportedMedia(w, "application/json")
+		return
+	}
+	workspaceID, memberID, err := parseWorkspacePath(r.URL.Path, "members", true)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	var req models.MemberRequest
+	if err := dec.Decode(&req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if _, ok := roleRank[req.Role]; !ok {
+		writeError(w, http.StatusBadRequest, codeInvalidBody, "role must be owner, editor or viewer")
+		return
+	}
+
+	userID := r.Context().Value(constants.UserIDKey).(int)
+	if err := authorizeWorkspace(r.Context(), workspaceID, userID, storage.RoleOwner); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if err := setMemberRole(r.Context(), workspaceID, memberID, req.Role); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, models.Member{UserID: memberID, Role: req.Role})
+}
+
+// DeleteMemberWebhook функция обработчик DELETE HTTP-запроса для исключения участника рабочего
+// пространства. Владелец исключает любого участника, остальные могут только выйти сами
+func DeleteMemberWebhook(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeMethodNotAllowed(w)
+		return
+	}
+	workspaceID, memberID, err := parseWorkspacePath(r.URL.Path, "members", true)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeBadRequest, err.Error())
+		return
+	}
+
+	userID := r.Context().Value(constants.UserIDKey).(int)
+	need := storage.RoleOwner
+	if memberID == userID {
+		need = storage.RoleViewer
+	}
+	if err := authorizeWorkspace(r.Context(), workspaceID, userID, need); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	if err := setMemberRole(r.Context(), workspaceID, memberID, ""); err != nil {
+		writeStorageError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// setMemberRole меняет роль участника, не позволяя убрать последнего владельца
+func setMemberRole(ctx context.Context, workspaceID int, memberID int, role string) error {
+	if role != storage.RoleOwner {
+		members, err := storage.Store.Members(ctx, workspaceID)
+		if err != nil {
+			return err
+		}
+		owners, demoted := 0, false
+		for _, member := range members {
+			if member.Role == storage.RoleOwner {
+				owners++
+				demoted = demoted || member.UserID == memberID
+			}
+		}
+		if demoted && owners == 1 {
+			return errLastOwner
+		}
+	}
+	return storage.Store.SetMemberRole(ctx, workspaceID, memberID, role)
+}
+
+// parseWorkspacePath разбирает путь /api/user/workspaces/{id}/{section}[/{user_id}]
+func parseWorkspacePath(path string, section string, withMember bool) (workspaceID int, memberID int, err error) {
+	parts := strings.Split(strings.TrimSuffix(strings.TrimPrefix(path, workspacesPathPrefix), "/"), "/")
+	if withMember && len(parts) != 3 || !withMember && len(parts) != 2 || parts[1] != section {
+		return 0, 0, fmt.Errorf("unexpected path: %s", path)
+	}
+	if workspaceID, err = strconv.Atoi(parts[0]); err != nil || workspaceID < 1 {
+		return 0, 0, fmt.Errorf("workspace id must be a positive number")
+	}
+	if withMember {
+		if memberID, err = strconv.Atoi(parts[2]); err != nil {
+			return 0, 0, fmt.Errorf("user id must be a number")
+		}
+	}
+	return workspaceID, memberID, nil
+}
+
+// writeJSON отвечает телом v в формате JSON
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	buf := bytes.Buffer{}
+	if err := json.NewEncoder(&buf).Encode(v); err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternalError, err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, _ = w.Write(buf.Bytes())
+}
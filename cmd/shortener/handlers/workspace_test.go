@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWorkspaceRoles(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	owner := context.WithValue(context.Background(), constants.UserIDKey, 1)
+
+	request := httptest.NewRequest(http.MethodPost, "/api/user/workspaces", strings.NewReader(`{"name":"team"}`))
+	request.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	PostWorkspaceWebhook(w, request.WithContext(owner))
+	require.Equal(t, http.StatusCreated, w.Code)
+	var workspace models.Workspace
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&workspace))
+	require.Equal(t, storage.RoleOwner, workspace.Role)
+	membersPath := "/api/user/workspaces/" + strconv.Itoa(workspace.ID) + "/members/"
+
+	for userID, role := range map[int]string{2: storage.RoleEditor, 3: storage.RoleViewer} {
+		request := httptest.NewRequest(http.MethodPut, membersPath+strconv.Itoa(userID), strings.NewReader(`{"role":"`+role+`"}`))
+		request.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		PutMemberWebhook(w, request.WithContext(owner))
+		require.Equal(t, http.StatusOK, w.Code)
+	}
+	require.NoError(t, storage.Store.SaveRecord(owner, models.URLData{ShortURL: "team", OriginalURL: "https://ya.ru/team", UserID: 1, WorkspaceID: workspace.ID}))
+	require.NoError(t, storage.Store.SaveRecord(owner, models.URLData{ShortURL: "own", OriginalURL: "https://ya.ru/own", UserID: 1}))
+
+	tests := []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		userID     int
+		handler    http.HandlerFunc
+		statusCode int
+	}{
+		{"viewer lists workspace links", http.MethodGet, "/api/user/urls?workspace=" + strconv.Itoa(workspace.ID), "", 3, GetUrlsWebhook, http.StatusOK},
+		{"stranger lists workspace links", http.MethodGet, "/api/user/urls?workspace=" + strconv.Itoa(workspace.ID), "", 4, GetUrlsWebhook, http.StatusNotFound},
+		{"viewer reads history", http.MethodGet, "/api/user/urls/team/history", "", 3, GetUrlHistoryWebhook, http.StatusNoContent},
+		{"viewer edits link", http.MethodPatch, "/api/user/urls/team", `{"folder":"docs"}`, 3, PatchUrlWebhook, http.StatusForbidden},
+		{"editor edits link of colleague", http.MethodPatch, "/api/user/urls/team", `{"folder":"docs"}`, 2, PatchUrlWebhook, http.StatusOK},
+		{"stranger edits link", http.MethodPatch, "/api/user/urls/team", `{"folder":"docs"}`, 4, PatchUrlWebhook, http.StatusNotFound},
+		{"editor edits personal link of colleague", http.MethodPatch, "/api/user/urls/own", `{"folder":"docs"}`, 2, PatchUrlWebhook, http.StatusNotFound},
+		{"viewer shortens into workspace", http.MethodPost, "/api/shorten", `{"url":"https://ya.ru/viewer","workspace":` + strconv.Itoa(workspace.ID) + `}`, 3, PostShortenWebhook, http.StatusForbidden},
+		{"editor shortens into workspace", http.MethodPost, "/api/shorten", `{"url":"https://ya.ru/editor","workspace":` + strconv.Itoa(workspace.ID) + `}`, 2, PostShortenWebhook, http.StatusCreated},
+		{"viewer lists members", http.MethodGet, strings.TrimSuffix(membersPath, "/"), "", 3, GetMembersWebhook, http.StatusOK},
+		{"editor changes role", http.MethodPut, membersPath + "3", `{"role":"owner"}`, 2, PutMemberWebhook, http.StatusForbidden},
+		{"invalid role", http.MethodPut, membersPath + "3", `{"role":"admin"}`, 1, PutMemberWebhook, http.StatusBadRequest},
+		{"last owner leaves", http.MethodDelete, membersPath + "1", "", 1, DeleteMemberWebhook, http.StatusConflict},
+		{"viewer leaves", http.MethodDelete, membersPath + "3", "", 3, DeleteMemberWebhook, http.StatusNoContent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", "application/json")
+			request.AddCookie(&http.Cookie{Name: CookieName, Value: "test"})
+			request = request.WithContext(context.WithValue(request.Context(), constants.UserIDKey, tt.userID))
+			w := httptest.NewRecorder()
+
+			tt.handler(w, request)
+			require.Equal(t, tt.statusCode, w.Code)
+		})
+	}
+
+	record, err := storage.Store.GetRecord(owner, "", "team")
+	require.NoError(t, err)
+	require.Equal(t, "docs", record.Folder)
+	role, err := storage.Store.MemberRole(owner, workspace.ID, 3)
+	require.NoError(t, err)
+	require.Empty(t, role)
+}
@@ -1,34 +1,173 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"github.com/fngoc/url-shortener/cmd/shortener/domains"
+	"github.com/fngoc/url-shortener/cmd/shortener/handlers"
+	"github.com/fngoc/url-shortener/cmd/shortener/outbox"
+	"github.com/fngoc/url-shortener/cmd/shortener/policy"
+	"github.com/fngoc/url-shortener/cmd/shortener/preview"
+	"github.com/fngoc/url-shortener/cmd/shortener/qr"
+	"github.com/fngoc/url-shortener/cmd/shortener/ratelimit"
 	"github.com/fngoc/url-shortener/cmd/shortener/server"
 	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/cmd/shortener/targeting"
+	"github.com/fngoc/url-shortener/cmd/shortener/transfer"
+	"github.com/fngoc/url-shortener/cmd/shortener/utm"
+	"github.com/fngoc/url-shortener/cmd/shortener/webhooks"
 	"github.com/fngoc/url-shortener/internal/logger"
+	"os"
+	"strings"
+	"sync"
 )
 
-// main функция вызывается автоматически при запуске приложения
+// main функция вызывается автоматически при запуске приложения.
+// Без подкоманды запускается сервер, подкоманды export и import переносят данные между хранилищами
 func main() {
 	if err := logger.Initialize(); err != nil {
 		panic(err)
 	}
 
+	command := ""
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		command = os.Args[1]
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	config.ParseArgs()
 
+	if err := initializeStorage(); err != nil {
+		logger.Log.Fatal(err.Error())
+	}
+
+	var err error
+	switch command {
+	case "":
+		err = runServer()
+	case "export":
+		err = runExport()
+	case "import":
+		err = runImport()
+	default:
+		logger.Log.Fatal("unknown command: " + command)
+	}
+	if err != nil {
+		logger.Log.Fatal(err.Error())
+	}
+}
+
+// initializeStorage выбирает хранилище по флагам и переменным окружения
+func initializeStorage() error {
 	if config.HasFlagOrEnvPostgresVariable() {
-		if err := storage.InitializeDB(config.Flags.DBConf); err != nil {
-			logger.Log.Fatal(err.Error())
-		}
+		return storage.InitializeDB(config.Flags.DBConf)
 	} else if config.HasFlagOrEnvFileVariable() {
-		if err := storage.InitializeFileLocalStore(config.Flags.FilePath); err != nil {
-			logger.Log.Fatal(err.Error())
+		return storage.InitializeFileLocalStore(config.Flags.FilePath)
+	}
+	return storage.InitializeInMemoryLocalStore()
+}
+
+// runServer настраивает домены коротких ссылок, политику адресов назначения, базу GeoIP, лимиты запросов, кэш QR-кодов,
+// загрузку заголовков страниц, запись переходов, доставку уведомлений подписчикам, публикацию событий ссылок
+// и очистку удалённых ссылок и запускает сервер
+func runServer() error {
+	if !handlers.IsRedirectType(config.Flags.RedirectType) {
+		return fmt.Errorf("redirect type %d is not supported, use 301, 302, 307 or 308", config.Flags.RedirectType)
+	}
+	if !utm.IsPassthroughMode(config.Flags.QueryPassthrough) {
+		return fmt.Errorf("query passthrough %q is not supported, use none, utm or all", config.Flags.QueryPassthrough)
+	}
+	if config.Flags.PurgeAfter > 0 && config.Flags.PurgeAfter < config.Flags.RestoreWindow {
+		return fmt.Errorf("purge-after %s is shorter than restore-window %s", config.Flags.PurgeAfter, config.Flags.RestoreWindow)
+	}
+	if err := domains.Initialize(config.Flags.Domains); err != nil {
+		return err
+	}
+	if err := policy.Initialize(context.Background(), config.Flags.PolicyFile, config.Flags.ReputationFile,
+		config.Flags.BlockPrivateIPs, config.Flags.PolicyReload); err != nil {
+		return err
+	}
+	if err := targeting.Initialize(context.Background(), config.Flags.GeoIPFile, config.Flags.PolicyReload); err != nil {
+		return err
+	}
+	if err := ratelimit.Initialize(map[string]string{
+		ratelimit.GroupCreate:   config.Flags.RateLimitCreate,
+		ratelimit.GroupRedirect: config.Flags.RateLimitRedirect,
+		ratelimit.GroupDelete:   config.Flags.RateLimitDelete,
+		ratelimit.GroupPassword: config.Flags.PasswordAttempts,
+	}); err != nil {
+		return err
+	}
+	qr.Initialize(config.Flags.QRCacheSize)
+	handlers.InitializeClicks()
+	webhooks.Initialize(config.Flags.WebhookAttempts, config.Flags.WebhookBackoff, config.Flags.WebhookTimeout,
+		config.Flags.BlockPrivateIPs)
+	if err := outbox.Initialize(config.Flags.OutboxSink); err != nil {
+		return err
+	}
+
+	// фоновые обработчики останавливаются после остановки сервера, ожидание даёт приёмнику событий закрыться
+	ctx, cancel := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+	defer func() {
+		cancel()
+		background.Wait()
+	}()
+	start := func(run func(ctx context.Context)) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			run(ctx)
+		}()
+	}
+
+	preview.Initialize(ctx, config.Flags.TitleFetchWorkers, config.Flags.TitleFetchTimeout, config.Flags.BlockPrivateIPs)
+	start(handlers.RunClicks)
+	start(webhooks.Run)
+	start(func(ctx context.Context) {
+		outbox.Run(ctx, config.Flags.OutboxInterval)
+	})
+	if config.Flags.PurgeAfter > 0 {
+		start(func(ctx context.Context) {
+			storage.RunPurge(ctx, storage.Store, config.Flags.PurgeAfter, config.Flags.PurgeInterval)
+		})
+	}
+	return server.Run()
+}
+
+// runExport выгружает хранилище в файл -dump или в stdout
+func runExport() error {
+	out := os.Stdout
+	if config.Flags.DumpPath != "" {
+		file, err := os.Create(config.Flags.DumpPath)
+		if err != nil {
+			return err
 		}
-	} else {
-		if err := storage.InitializeInMemoryLocalStore(); err != nil {
-			logger.Log.Fatal(err.Error())
+		defer file.Close()
+		out = file
+	}
+	_, err := transfer.Export(context.Background(), storage.Store, out, config.Flags.DumpFormat)
+	return err
+}
+
+// runImport загружает записи из файла -dump или из stdin
+func runImport() error {
+	in := os.Stdin
+	if config.Flags.DumpPath != "" {
+		file, err := os.Open(config.Flags.DumpPath)
+		if err != nil {
+			return err
 		}
+		defer file.Close()
+		in = file
 	}
-	if err := server.Run(); err != nil {
-		logger.Log.Fatal(err.Error())
+	_, failed, err := transfer.Import(context.Background(), storage.Store, in, config.Flags.DumpFormat)
+	if err != nil {
+		return err
+	}
+	if failed > 0 {
+		logger.Log.Warn("Some records are not imported, see warnings above")
 	}
+	return nil
 }
@@ -0,0 +1,103 @@
+// Package outbox публикует события ссылок из outbox хранилища во внешние системы. Хранилище пишет событие
+// вместе с изменением ссылки, ретранслятор публикует события в порядке записи и удаляет их только после
+// публикации, поэтому каждое событие доставляется хотя бы один раз, а события одной ссылки — по порядку.
+// Хранилище в памяти теряет неопубликованные события при перезапуске вместе со всеми данными
+package outbox
+
+import (
+	"context"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"go.uber.org/zap"
+	"time"
+)
+
+// batchSize число событий, которые ретранслятор публикует за раз
+const batchSize = 100
+
+// Relay переносит события из outbox хранилища в приёмник
+type Relay struct {
+	store storage.Repository
+	sink  Sink
+	batch int
+}
+
+// Current ретранслятор событий, nil если публикация выключена
+var Current *Relay
+
+// NewRelay создаёт ретранслятор, публикующий события из store в sink пачками до batch событий
+func NewRelay(store storage.Repository, sink Sink, batch int) *Relay {
+	return &Relay{store: store, sink: sink, batch: batch}
+}
+
+// Run публикует события каждые interval, пока не отменён ctx, затем закрывает приёмник
+func (r *Relay) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	defer func() {
+		if err := r.sink.Close(); err != nil {
+			logger.Log.Warn("Outbox sink is not closed", zap.Error(err))
+		}
+	}()
+	for {
+		if err := r.relay(ctx); err != nil {
+			logger.Log.Warn("Outbox events are not published", zap.Error(err))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// relay публикует накопленные события. При ошибке публикации следующие события не публикуются,
+// чтобы не нарушить порядок, и пачка повторяется при следующем вызове
+func (r *Relay) relay(ctx context.Context) error {
+	for {
+		events, err := r.store.OutboxEvents(ctx, r.batch)
+		if err != nil || len(events) == 0 {
+			return err
+		}
+		if err := r.sink.Publish(ctx, events); err != nil {
+			return err
+		}
+		ids := make([]int64, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		if err := r.store.DeleteOutbox(ctx, ids); err != nil {
+			return err
+		}
+		if len(events) < r.batch {
+			return nil
+		}
+	}
+}
+
+// Initialize настраивает Current с приёмником spec (см. NewSink) и включает запись событий в outbox хранилища.
+// Пустой spec выключает публикацию. Ретранслятор запускает Run
+func Initialize(spec string) error {
+	if spec == "" {
+		storage.Outbox = false
+		Current = nil
+		return nil
+	}
+	sink, err := NewSink(spec)
+	if err != nil {
+		return err
+	}
+	storage.Outbox = true
+	Current = NewRelay(storage.Store, sink, batchSize)
+	logger.Log.Info("Outbox relay is configured", zap.String("sink", spec))
+	return nil
+}
+
+// Run публикует события Current каждые interval, пока не отменён ctx, и закрывает приёмник.
+// Если публикация выключена, сразу возвращается
+func Run(ctx context.Context, interval time.Duration) {
+	if Current != nil {
+		Current.Run(ctx, interval)
+	}
+}
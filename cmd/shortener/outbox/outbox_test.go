@@ -0,0 +1,177 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// flakySink отклоняет первые failures пачек, остальные передаёт в MemorySink
+type flakySink struct {
+	MemorySink
+	failures int
+}
+
+func (s *flakySink) Publish(ctx context.Context, events []models.OutboxEvent) error {
+	if s.failures > 0 {
+		s.failures--
+		return errors.New("broker is unavailable")
+	}
+	return s.MemorySink.Publish(ctx, events)
+}
+
+// closeSink запоминает закрытие приёмника
+type closeSink struct {
+	MemorySink
+	closed bool
+}
+
+func (s *closeSink) Close() error {
+	s.closed = true
+	return nil
+}
+
+// initializeStore создаёт пустое хранилище с включённым outbox
+func initializeStore(t *testing.T) context.Context {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	storage.Outbox = true
+	t.Cleanup(func() { storage.Outbox = false })
+	return context.WithValue(context.Background(), constants.UserIDKey, 1)
+}
+
+func TestRelay(t *testing.T) {
+	ctx := initializeStore(t)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, storage.Store.SaveData(ctx, "id"+strconv.Itoa(i), "https://ya.ru/"+strconv.Itoa(i)))
+	}
+	_, err := storage.Store.RecordClick(ctx, "", "id0", storage.NoVariant)
+	require.NoError(t, err)
+	require.NoError(t, storage.Store.DeleteData("", "id0"))
+
+	sink := &MemorySink{}
+	require.NoError(t, NewRelay(storage.Store, sink, 2).relay(ctx))
+
+	events := sink.Events()
+	require.Len(t, events, 7, "all batches are published")
+	for i := 1; i < len(events); i++ {
+		require.Greater(t, events[i].ID, events[i-1].ID)
+	}
+	require.Equal(t, storage.OutboxLinkCreated, events[0].Event)
+	require.Equal(t, storage.OutboxLinkClicked, events[5].Event)
+	require.Equal(t, storage.OutboxLinkDeleted, events[6].Event)
+	require.Equal(t, "id0", events[6].ShortURL)
+
+	rest, err := storage.Store.OutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, rest, "published events are removed")
+}
+
+func TestRelay_Retry(t *testing.T) {
+	ctx := initializeStore(t)
+	require.NoError(t, storage.Store.SaveData(ctx, "first", "https://ya.ru/first"))
+	require.NoError(t, storage.Store.DeleteData("", "first"))
+
+	sink := &flakySink{failures: 1}
+	relay := NewRelay(storage.Store, sink, 10)
+	require.Error(t, relay.relay(ctx))
+	require.Empty(t, sink.Events())
+	pending, err := storage.Store.OutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Len(t, pending, 2, "events stay in the outbox until published")
+
+	require.NoError(t, storage.Store.SaveData(ctx, "second", "https://ya.ru/second"))
+	require.NoError(t, relay.relay(ctx))
+	events := sink.Events()
+	require.Len(t, events, 3)
+	require.Equal(t, []string{storage.OutboxLinkCreated, storage.OutboxLinkDeleted, storage.OutboxLinkCreated},
+		[]string{events[0].Event, events[1].Event, events[2].Event}, "retried events keep their order")
+}
+
+func TestRelay_Run(t *testing.T) {
+	ctx := initializeStore(t)
+	runCtx, cancel := context.WithCancel(ctx)
+	sink := &closeSink{}
+	done := make(chan struct{})
+	go func() {
+		NewRelay(storage.Store, sink, 10).Run(runCtx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	require.NoError(t, storage.Store.SaveData(ctx, "first", "https://ya.ru/first"))
+	require.Eventually(t, func() bool {
+		return len(sink.Events()) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	cancel()
+	<-done
+	require.True(t, sink.closed, "sink is closed when the relay stops")
+}
+
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	variant := 1
+	for _, event := range []models.OutboxEvent{
+		{ID: 1, Event: storage.OutboxLinkCreated, ShortURL: "first", OriginalURL: "https://ya.ru", UserID: 1},
+		{ID: 2, Event: storage.OutboxLinkClicked, ShortURL: "first", UserID: 1, Variant: &variant},
+	} {
+		// каждый раз файл открывается заново: события дописываются к уже опубликованным
+		sink, err := NewFileSink(path)
+		require.NoError(t, err)
+		require.NoError(t, sink.Publish(context.Background(), []models.OutboxEvent{event}))
+		require.NoError(t, sink.Close())
+	}
+
+	file, err := os.Open(path)
+	require.NoError(t, err)
+	defer file.Close()
+	var events []models.OutboxEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event models.OutboxEvent
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &event))
+		events = append(events, event)
+	}
+	require.NoError(t, scanner.Err())
+	require.Len(t, events, 2)
+	require.Equal(t, "https://ya.ru", events[0].OriginalURL)
+	require.Nil(t, events[0].Variant)
+	require.Equal(t, 1, *events[1].Variant)
+}
+
+func TestNewSink(t *testing.T) {
+	tests := []struct {
+		spec    string
+		wantErr bool
+	}{
+		{"memory", false},
+		{"file:" + filepath.Join(t.TempDir(), "events.jsonl"), false},
+		{"file:" + filepath.Join(t.TempDir(), "missing", "events.jsonl"), true},
+		{"kafka://localhost:9092", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			sink, err := NewSink(tt.spec)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.NoError(t, sink.Close())
+		})
+	}
+}
+
+func TestKey(t *testing.T) {
+	require.Equal(t, "/abc", Key(models.OutboxEvent{ShortURL: "abc"}))
+	require.Equal(t, "go.example.com/abc", Key(models.OutboxEvent{Domain: "go.example.com", ShortURL: "abc"}))
+}
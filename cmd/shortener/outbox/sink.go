@@ -0,0 +1,104 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/fngoc/url-shortener/internal/models"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// Sink публикует события во внешнюю систему. Адаптер брокера, например NATS или Kafka, публикует событие
+// в subject или с ключом партиции Key(event), чтобы события одной ссылки читались в порядке записи
+type Sink interface {
+	// Publish публикует события в переданном порядке. При ошибке ретранслятор повторит всю пачку,
+	// поэтому часть событий может быть опубликована дважды
+	Publish(ctx context.Context, events []models.OutboxEvent) error
+	Close() error
+}
+
+// Key ключ упорядочивания события: события с одним ключом относятся к одной ссылке
+func Key(event models.OutboxEvent) string {
+	return event.Domain + "/" + event.ShortURL
+}
+
+// NewSink создаёт приёмник по описанию: memory или file:путь
+func NewSink(spec string) (Sink, error) {
+	switch {
+	case spec == "memory":
+		return &MemorySink{}, nil
+	case strings.HasPrefix(spec, "file:"):
+		return NewFileSink(strings.TrimPrefix(spec, "file:"))
+	}
+	return nil, fmt.Errorf("outbox sink %q is not supported, use memory or file:path", spec)
+}
+
+// MemorySink хранит опубликованные события в памяти, для локальной проверки и тестов
+type MemorySink struct {
+	mu     sync.Mutex
+	events []models.OutboxEvent
+}
+
+func (s *MemorySink) Publish(_ context.Context, events []models.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, events...)
+	return nil
+}
+
+// Events возвращает опубликованные события в порядке публикации
+func (s *MemorySink) Events() []models.OutboxEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return slices.Clone(s.events)
+}
+
+func (s *MemorySink) Close() error {
+	return nil
+}
+
+// FileSink дописывает события в файл по одному JSON на строку. Пачка считается опубликованной
+// после сброса файла на диск
+type FileSink struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewFileSink открывает файл path для дописывания, создавая его при необходимости
+func NewFileSink(path string) (*FileSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSink{file: file}, nil
+}
+
+func (s *FileSink) Publish(_ context.Context, events []models.OutboxEvent) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	writer := bufio.NewWriter(s.file)
+	encoder := json.NewEncoder(writer)
+	for _, event := range events {
+		if err := encoder.Encode(event); err != nil {
+			return err
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		return err
+	}
+	return s.file.Sync()
+}
+
+func (s *FileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.file.Close()
+}
@@ -0,0 +1,114 @@
+package policy
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"go.uber.org/zap"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// DomainLists списки разрешённых и запрещённых доменов. Домен в списке покрывает и свои поддомены.
+// Если список разрешённых не пуст, пропускаются только домены из него
+type DomainLists struct {
+	Allow []string
+	Deny  []string
+}
+
+// Denied возвращает домен из списка запрещённых, которому соответствует host
+func (l DomainLists) Denied(host string) (string, bool) {
+	return matchDomain(host, l.Deny)
+}
+
+// Allowed сообщает, пропускает ли список разрешённых host
+func (l DomainLists) Allowed(host string) bool {
+	if len(l.Allow) == 0 {
+		return true
+	}
+	_, ok := matchDomain(host, l.Allow)
+	return ok
+}
+
+func matchDomain(host string, domains []string) (string, bool) {
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return domain, true
+		}
+	}
+	return "", false
+}
+
+// ParseDomainLists читает файл со строками вида "allow example.com" и "deny evil.com".
+// Пустые строки и строки, начинающиеся с #, пропускаются
+func ParseDomainLists(r io.Reader) (DomainLists, error) {
+	var lists DomainLists
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return DomainLists{}, fmt.Errorf("line %d: expected \"allow|deny domain\"", line)
+		}
+		domain := strings.TrimPrefix(strings.ToLower(fields[1]), "*.")
+		switch strings.ToLower(fields[0]) {
+		case "allow":
+			lists.Allow = append(lists.Allow, domain)
+		case "deny":
+			lists.Deny = append(lists.Deny, domain)
+		default:
+			return DomainLists{}, fmt.Errorf("line %d: unknown action %s", line, fields[0])
+		}
+	}
+	return lists, scanner.Err()
+}
+
+// LoadDomainLists загружает списки доменов из файла path в e
+func (e *Engine) LoadDomainLists(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	lists, err := ParseDomainLists(file)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	e.SetLists(lists)
+	logger.Log.Info("Domain lists loaded", zap.Int("allow", len(lists.Allow)), zap.Int("deny", len(lists.Deny)))
+	return nil
+}
+
+// WatchFile вызывает load каждый раз, когда меняется время изменения файла path, пока не отменён ctx.
+// Ошибки перезагрузки логируются, прежние данные при этом остаются в силе
+func WatchFile(ctx context.Context, path string, interval time.Duration, load func(path string) error) {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(modTime) {
+				continue
+			}
+			modTime = info.ModTime()
+			if err := load(path); err != nil {
+				logger.Log.Warn("File is not reloaded", zap.String("path", path), zap.Error(err))
+			}
+		}
+	}
+}
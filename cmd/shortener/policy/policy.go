@@ -0,0 +1,199 @@
+package policy
+
+import (
+	"context"
+	"fmt"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"github.com/fngoc/url-shortener/internal/normalizer"
+	"go.uber.org/zap"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Коды нарушений политики, попадают в ответ 422
+const (
+	CodeDomainDenied     = "domain_denied"
+	CodeDomainNotAllowed = "domain_not_allowed"
+	CodePrivateAddress   = "private_address"
+	CodeUnresolvedHost   = "unresolved_host"
+	CodeBadReputation    = "bad_reputation"
+)
+
+// Violation ошибка: ссылка ведёт на запрещённый адрес
+type Violation struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason"`
+}
+
+func (v *Violation) Error() string {
+	return v.Code + ": " + v.Reason
+}
+
+// Resolver разрешает имя хоста в IP-адреса, ему удовлетворяет net.DefaultResolver
+type Resolver interface {
+	LookupIPAddr(ctx context.Context, host string) ([]net.IPAddr, error)
+}
+
+// ReputationChecker проверяет репутацию адреса. Пустая причина означает, что адрес не известен как вредоносный
+type ReputationChecker interface {
+	Check(ctx context.Context, u *url.URL) (reason string, err error)
+}
+
+// Engine политика адресов назначения: списки доменов, запрет внутренних адресов и проверка репутации
+type Engine struct {
+	mu    sync.RWMutex
+	lists DomainLists
+
+	// Resolver используется для запрета внутренних адресов, nil отключает проверку
+	Resolver Resolver
+	// Reputation необязательная проверка репутации
+	Reputation ReputationChecker
+}
+
+// Current политика, с которой работают обработчики. nil отключает проверки
+var Current *Engine
+
+// resolveTimeout ограничивает время разрешения имени хоста
+const resolveTimeout = 2 * time.Second
+
+// SetLists подменяет списки доменов, безопасно вызывать во время работы
+func (e *Engine) SetLists(lists DomainLists) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.lists = lists
+}
+
+// Check проверяет нормализованный абсолютный URL и возвращает *Violation, если он запрещён
+func (e *Engine) Check(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return err
+	}
+	host := strings.ToLower(u.Hostname())
+
+	e.mu.RLock()
+	lists := e.lists
+	e.mu.RUnlock()
+
+	if domain, ok := lists.Denied(host); ok {
+		return &Violation{Code: CodeDomainDenied, Reason: fmt.Sprintf("domain %s is denied", domain)}
+	}
+	if !lists.Allowed(host) {
+		return &Violation{Code: CodeDomainNotAllowed, Reason: fmt.Sprintf("domain %s is not in allow list", host)}
+	}
+
+	if e.Resolver != nil {
+		if err := e.checkAddresses(ctx, host); err != nil {
+			return err
+		}
+	}
+
+	if e.Reputation != nil {
+		reason, err := e.Reputation.Check(ctx, u)
+		if err != nil {
+			return err
+		}
+		if reason != "" {
+			return &Violation{Code: CodeBadReputation, Reason: reason}
+		}
+	}
+	return nil
+}
+
+// checkAddresses запрещает хосты, которые указывают на внутренние адреса. IPv4 в любой записи, которую понимает
+// браузер, например 2852039166 или 0xA9FEA9FE, проверяется как адрес. Имя, которое не разрешается, тоже запрещается:
+// по ссылке нельзя перейти сейчас, а позже имя может указать на внутренний адрес
+func (e *Engine) checkAddresses(ctx context.Context, host string) error {
+	var ips []net.IP
+	ip := net.ParseIP(host)
+	if ip == nil {
+		var err error
+		if ip, err = normalizer.ParseIPv4(host); err != nil {
+			return &Violation{Code: CodePrivateAddress, Reason: fmt.Sprintf("host %s is not a valid address", host)}
+		}
+	}
+	if ip != nil {
+		ips = append(ips, ip)
+	} else {
+		resolveCtx, cancel := context.WithTimeout(ctx, resolveTimeout)
+		defer cancel()
+
+		addrs, err := e.Resolver.LookupIPAddr(resolveCtx, host)
+		if err != nil {
+			logger.Log.Info("Host is not resolved", zap.String("host", host), zap.Error(err))
+			return &Violation{Code: CodeUnresolvedHost, Reason: fmt.Sprintf("host %s is not resolved", host)}
+		}
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+
+	for _, ip := range ips {
+		if IsInternal(ip) {
+			return &Violation{Code: CodePrivateAddress, Reason: fmt.Sprintf("host %s resolves to internal address %s", host, ip)}
+		}
+	}
+	return nil
+}
+
+// reservedNetworks сети IPv4 для особых целей, которые не покрывают методы net.IP
+var reservedNetworks = []*net.IPNet{
+	mustParseCIDR("0.0.0.0/8"),     // «эта» сеть
+	mustParseCIDR("100.64.0.0/10"), // CGNAT, в том числе метаданные облаков, например 100.100.100.200
+	mustParseCIDR("192.0.0.0/24"),  // протокольные назначения IETF
+	mustParseCIDR("198.18.0.0/15"), // тестирование производительности
+	mustParseCIDR("240.0.0.0/4"),   // зарезервировано, включая широковещательный адрес
+}
+
+func mustParseCIDR(cidr string) *net.IPNet {
+	_, network, err := net.ParseCIDR(cidr)
+	if err != nil {
+		panic(err)
+	}
+	return network
+}
+
+// IsInternal сообщает, что адрес частный, локальный, неопределённый или зарезервированный
+func IsInternal(ip net.IP) bool {
+	if ip.IsPrivate() || ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+	for _, network := range reservedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// Initialize настраивает Current. Файлы listsPath и reputationPath необязательны и перечитываются
+// при изменении с периодом reload, пока не отменён ctx
+func Initialize(ctx context.Context, listsPath, reputationPath string, blockPrivate bool, reload time.Duration) error {
+	engine := &Engine{}
+	if blockPrivate {
+		engine.Resolver = net.DefaultResolver
+	}
+
+	if listsPath != "" {
+		if err := engine.LoadDomainLists(listsPath); err != nil {
+			return err
+		}
+		go WatchFile(ctx, listsPath, reload, engine.LoadDomainLists)
+	}
+	if reputationPath != "" {
+		reputation, err := NewFileReputation(reputationPath)
+		if err != nil {
+			return err
+		}
+		engine.Reputation = reputation
+		go WatchFile(ctx, reputationPath, reload, reputation.Load)
+	}
+
+	Current = engine
+	logger.Log.Info("Destination policy initialized")
+	return nil
+}
@@ -0,0 +1,129 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeResolver разрешает имена по таблице, неизвестные имена не разрешаются
+type fakeResolver map[string][]string
+
+func (f fakeResolver) LookupIPAddr(_ context.Context, host string) ([]net.IPAddr, error) {
+	ips, ok := f[host]
+	if !ok {
+		return nil, errors.New("no such host")
+	}
+	addrs := make([]net.IPAddr, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, net.IPAddr{IP: net.ParseIP(ip)})
+	}
+	return addrs, nil
+}
+
+func TestEngine_Check(t *testing.T) {
+	dir := t.TempDir()
+	reputationPath := filepath.Join(dir, "reputation.txt")
+	require.NoError(t, os.WriteFile(reputationPath, []byte("# known phishing\nphish.example\tphishing\nhttps://cdn.example/malware/\n"), 0666))
+	reputation, err := NewFileReputation(reputationPath)
+	require.NoError(t, err)
+
+	lists, err := ParseDomainLists(strings.NewReader("# lists\ndeny evil.com\ndeny *.bad.org\n"))
+	require.NoError(t, err)
+
+	engine := &Engine{
+		Resolver: fakeResolver{
+			"ya.ru":          {"77.88.55.242"},
+			"internal.corp":  {"10.0.0.5"},
+			"rebind.example": {"8.8.8.8", "127.0.0.1"},
+			"phish.example":  {"1.2.3.4"},
+			"cdn.example":    {"1.2.3.5"},
+		},
+		Reputation: reputation,
+	}
+	engine.SetLists(lists)
+
+	tests := []struct {
+		url  string
+		code string
+	}{
+		{"https://ya.ru/", ""},
+		{"https://unresolved.example/", CodeUnresolvedHost},
+		{"https://evil.com/", CodeDomainDenied},
+		{"https://www.evil.com/", CodeDomainDenied},
+		{"https://a.bad.org/", CodeDomainDenied},
+		{"http://169.254.169.254/latest/meta-data/", CodePrivateAddress},
+		{"http://[::1]/", CodePrivateAddress},
+		{"http://2852039166/", CodePrivateAddress},
+		{"http://0xA9FEA9FE/", CodePrivateAddress},
+		{"http://0251.0376.0251.0376/", CodePrivateAddress},
+		{"http://0x7f.1/", CodePrivateAddress},
+		{"http://1.2.3.4.5/", CodePrivateAddress},
+		{"http://0.0.0.1/", CodePrivateAddress},
+		{"http://100.100.100.200/", CodePrivateAddress},
+		{"http://192.0.0.170/", CodePrivateAddress},
+		{"http://198.18.0.1/", CodePrivateAddress},
+		{"http://255.255.255.255/", CodePrivateAddress},
+		{"http://8.8.8.8/", ""},
+		{"http://internal.corp/", CodePrivateAddress},
+		{"http://rebind.example/", CodePrivateAddress},
+		{"https://phish.example/login", CodeBadReputation},
+		{"https://cdn.example/malware/x.exe", CodeBadReputation},
+		{"https://cdn.example/images/x.png", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := engine.Check(context.Background(), tt.url)
+			if tt.code == "" {
+				require.NoError(t, err)
+				return
+			}
+			var violation *Violation
+			require.True(t, errors.As(err, &violation), "%v", err)
+			require.Equal(t, tt.code, violation.Code)
+		})
+	}
+
+	engine.SetLists(DomainLists{Allow: []string{"ya.ru"}})
+	require.NoError(t, engine.Check(context.Background(), "https://ya.ru/"))
+	var violation *Violation
+	require.True(t, errors.As(engine.Check(context.Background(), "https://google.com/"), &violation))
+	require.Equal(t, CodeDomainNotAllowed, violation.Code)
+}
+
+func TestParseDomainLists_Error(t *testing.T) {
+	_, err := ParseDomainLists(strings.NewReader("block evil.com\n"))
+	require.Error(t, err)
+	_, err = ParseDomainLists(strings.NewReader("deny\n"))
+	require.Error(t, err)
+}
+
+func TestWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "lists.txt")
+	require.NoError(t, os.WriteFile(path, []byte("deny evil.com\n"), 0666))
+
+	engine := &Engine{}
+	require.NoError(t, engine.LoadDomainLists(path))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go WatchFile(ctx, path, 10*time.Millisecond, engine.LoadDomainLists)
+
+	require.Error(t, engine.Check(context.Background(), "https://evil.com/"))
+
+	require.NoError(t, os.WriteFile(path, []byte("deny other.com\n"), 0666))
+	// время изменения сдвигается на каждой итерации, чтобы изменение заметил и только что запущенный WatchFile
+	step := 0
+	require.Eventually(t, func() bool {
+		step++
+		modTime := time.Now().Add(time.Duration(step) * time.Second)
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+		return engine.Check(context.Background(), "https://evil.com/") == nil
+	}, time.Second, 20*time.Millisecond)
+	require.Error(t, engine.Check(context.Background(), "https://other.com/"))
+}
@@ -0,0 +1,82 @@
+package policy
+
+import (
+	"bufio"
+	"context"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FileReputation проверка репутации по локальному файлу. Каждая строка — хост или префикс URL,
+// после табуляции можно указать причину. Пустые строки и строки, начинающиеся с #, пропускаются
+type FileReputation struct {
+	mu       sync.RWMutex
+	hosts    map[string]string
+	prefixes map[string]string
+}
+
+// NewFileReputation загружает репутацию из файла path
+func NewFileReputation(path string) (*FileReputation, error) {
+	r := &FileReputation{}
+	if err := r.Load(path); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Load перечитывает файл path
+func (r *FileReputation) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hosts := make(map[string]string)
+	prefixes := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		entry, reason, _ := strings.Cut(text, "\t")
+		entry = strings.TrimSpace(entry)
+		reason = strings.TrimSpace(reason)
+		if reason == "" {
+			reason = "listed in reputation file"
+		}
+		if strings.Contains(entry, "://") {
+			prefixes[entry] = reason
+		} else {
+			hosts[strings.ToLower(entry)] = reason
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hosts = hosts
+	r.prefixes = prefixes
+	return nil
+}
+
+func (r *FileReputation) Check(_ context.Context, u *url.URL) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if reason, ok := r.hosts[strings.ToLower(u.Hostname())]; ok {
+		return reason, nil
+	}
+	s := u.String()
+	for prefix, reason := range r.prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return reason, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,221 @@
+package preview
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/policy"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"go.uber.org/zap"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/charset"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+)
+
+// maxTitleLength максимальная длина сохраняемого заголовка в символах
+const maxTitleLength = 300
+
+// Fetcher загружает заголовок страницы по адресу. Тесты подменяют его или направляют на локальный сервер
+type Fetcher interface {
+	FetchTitle(ctx context.Context, rawURL string) (string, error)
+}
+
+// ErrInternalAddress возвращается HTTPFetcher, если адрес назначения оказался внутренним
+var ErrInternalAddress = errors.New("destination resolves to internal address")
+
+// HTTPFetcher читает заголовок из <title> HTML-страницы, загружая не больше MaxBytes байт
+type HTTPFetcher struct {
+	Client   *http.Client
+	MaxBytes int64
+}
+
+// NewHTTPFetcher создаёт HTTPFetcher с таймаутом запроса timeout. При blockInternal соединения
+// с внутренними адресами запрещаются, как в NewHTTPClient
+func NewHTTPFetcher(timeout time.Duration, blockInternal bool) *HTTPFetcher {
+	return &HTTPFetcher{
+		Client:   NewHTTPClient(timeout, blockInternal),
+		MaxBytes: 512 << 10,
+	}
+}
+
+// NewHTTPClient создаёт клиент с таймаутом запроса timeout для запросов по адресам пользователей.
+// При blockInternal соединения с внутренними адресами запрещаются на уровне dialer, в том числе после редиректов
+func NewHTTPClient(timeout time.Duration, blockInternal bool) *http.Client {
+	dialer := &net.Dialer{Timeout: timeout}
+	if blockInternal {
+		dialer.Control = func(_, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || policy.IsInternal(ip) {
+				return fmt.Errorf("%s: %w", host, ErrInternalAddress)
+			}
+			return nil
+		}
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	transport.Proxy = nil
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+func (f *HTTPFetcher) FetchTitle(ctx context.Context, rawURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "text/html")
+	req.Header.Set("User-Agent", "url-shortener-preview/1.0")
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.Contains(contentType, "html") {
+		return "", nil
+	}
+	body, err := charset.NewReader(io.LimitReader(resp.Body, f.MaxBytes), contentType)
+	if err != nil {
+		return "", err
+	}
+	return parseTitle(body), nil
+}
+
+// parseTitle возвращает текст первого <title> документа с нормализованными пробелами
+func parseTitle(r io.Reader) string {
+	tokenizer := html.NewTokenizer(r)
+	inTitle := false
+	var title strings.Builder
+	for {
+		switch tokenizer.Next() {
+		case html.ErrorToken:
+			return cleanTitle(title.String())
+		case html.StartTagToken:
+			name, _ := tokenizer.TagName()
+			switch string(name) {
+			case "title":
+				inTitle = true
+			case "body":
+				return cleanTitle(title.String())
+			}
+		case html.EndTagToken:
+			name, _ := tokenizer.TagName()
+			if string(name) == "title" && inTitle {
+				return cleanTitle(title.String())
+			}
+		case html.TextToken:
+			if inTitle {
+				title.Write(tokenizer.Text())
+			}
+		}
+	}
+}
+
+func cleanTitle(title string) string {
+	title = strings.Join(strings.Fields(title), " ")
+	if utf8.RuneCountInString(title) > maxTitleLength {
+		title = string([]rune(title)[:maxTitleLength-1]) + "…"
+	}
+	return title
+}
+
+type job struct {
+	domain      string
+	shortURL    string
+	originalURL string
+}
+
+// Worker загружает заголовки новых ссылок в фоне и сохраняет их в storage.Store
+type Worker struct {
+	fetcher Fetcher
+	timeout time.Duration
+	jobs    chan job
+}
+
+// Current очередь загрузки заголовков, nil отключает загрузку
+var Current *Worker
+
+// NewWorker создаёт очередь на queueSize ссылок. Загрузка начинается после Run
+func NewWorker(fetcher Fetcher, timeout time.Duration, queueSize int) *Worker {
+	return &Worker{
+		fetcher: fetcher,
+		timeout: timeout,
+		jobs:    make(chan job, queueSize),
+	}
+}
+
+// Run запускает workers обработчиков очереди, которые работают до отмены ctx
+func (w *Worker) Run(ctx context.Context, workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case j := <-w.jobs:
+					w.process(ctx, j)
+				}
+			}
+		}()
+	}
+}
+
+// Enqueue ставит ссылку shortURL домена domain в очередь. Если очередь заполнена, ссылка остаётся без заголовка
+func (w *Worker) Enqueue(domain, shortURL, originalURL string) bool {
+	select {
+	case w.jobs <- job{domain: domain, shortURL: shortURL, originalURL: originalURL}:
+		return true
+	default:
+		logger.Log.Warn("Title fetch queue is full", zap.String("short_url", shortURL))
+		return false
+	}
+}
+
+func (w *Worker) process(ctx context.Context, j job) {
+	fetchCtx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	title, err := w.fetcher.FetchTitle(fetchCtx, j.originalURL)
+	if err != nil {
+		logger.Log.Info("Title is not fetched", zap.String("url", j.originalURL), zap.Error(err))
+		return
+	}
+	if title == "" {
+		return
+	}
+	if err := storage.Store.UpdateTitle(ctx, j.domain, j.shortURL, title); err != nil {
+		logger.Log.Warn("Title is not saved", zap.String("short_url", j.shortURL), zap.Error(err))
+	}
+}
+
+// Enqueue ставит ссылку в очередь Current, если загрузка заголовков включена
+func Enqueue(domain, shortURL, originalURL string) {
+	if Current != nil {
+		Current.Enqueue(domain, shortURL, originalURL)
+	}
+}
+
+// Initialize настраивает Current: workers обработчиков с таймаутом загрузки timeout, пока не отменён ctx.
+// При workers < 1 загрузка заголовков отключена
+func Initialize(ctx context.Context, workers int, timeout time.Duration, blockInternal bool) {
+	if workers < 1 {
+		Current = nil
+		return
+	}
+	Current = NewWorker(NewHTTPFetcher(timeout, blockInternal), timeout, 1000)
+	Current.Run(ctx, workers)
+}
@@ -0,0 +1,97 @@
+package preview
+
+import (
+	"context"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHTTPFetcher_FetchTitle(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/page":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			_, _ = w.Write([]byte("<html><head><title>\n  Главная   страница &amp; новости\n</title></head><body><title>other</title></body></html>"))
+		case "/cp1251":
+			w.Header().Set("Content-Type", "text/html; charset=windows-1251")
+			_, _ = w.Write([]byte("<title>\xcf\xf0\xe8\xe2\xe5\xf2</title>"))
+		case "/no-title":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<html><body><h1>Title</h1></body></html>"))
+		case "/long":
+			w.Header().Set("Content-Type", "text/html")
+			_, _ = w.Write([]byte("<title>" + strings.Repeat("а", 1000) + "</title>"))
+		case "/json":
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"title":"json"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path    string
+		want    string
+		wantErr bool
+	}{
+		{"/page", "Главная страница & новости", false},
+		{"/cp1251", "Привет", false},
+		{"/no-title", "", false},
+		{"/json", "", false},
+		{"/missing", "", true},
+	}
+	fetcher := NewHTTPFetcher(time.Second, false)
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			title, err := fetcher.FetchTitle(context.Background(), server.URL+tt.path)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, title)
+		})
+	}
+
+	t.Run("long title is truncated", func(t *testing.T) {
+		title, err := fetcher.FetchTitle(context.Background(), server.URL+"/long")
+		require.NoError(t, err)
+		assert.Equal(t, maxTitleLength, len([]rune(title)))
+	})
+
+	t.Run("internal address is blocked", func(t *testing.T) {
+		_, err := NewHTTPFetcher(time.Second, true).FetchTitle(context.Background(), server.URL+"/page")
+		require.ErrorIs(t, err, ErrInternalAddress)
+	})
+}
+
+type fetcherFunc func(ctx context.Context, rawURL string) (string, error)
+
+func (f fetcherFunc) FetchTitle(ctx context.Context, rawURL string) (string, error) {
+	return f(ctx, rawURL)
+}
+
+func TestWorker(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	require.NoError(t, storage.Store.SaveData(context.Background(), "key", "https://ya.ru/"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	worker := NewWorker(fetcherFunc(func(_ context.Context, rawURL string) (string, error) {
+		return "Title of " + rawURL, nil
+	}), time.Second, 1)
+	worker.Run(ctx, 1)
+
+	require.True(t, worker.Enqueue("", "key", "https://ya.ru/"))
+	require.Eventually(t, func() bool {
+		record, err := storage.Store.GetRecord(context.Background(), "", "key")
+		return err == nil && record.Title == "Title of https://ya.ru/"
+	}, time.Second, 10*time.Millisecond)
+}
@@ -0,0 +1,197 @@
+package qr
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/golang-lru/v2"
+	"github.com/skip2/go-qrcode"
+	"image"
+	"image/color"
+	"image/png"
+	"strings"
+)
+
+// Форматы изображения QR-кода
+const (
+	FormatPNG = "png"
+	FormatSVG = "svg"
+)
+
+// Ограничения и значения по умолчанию для Options
+const (
+	DefaultSize   = 256
+	MinSize       = 64
+	MaxSize       = 2048
+	DefaultMargin = 4
+	MaxMargin     = 16
+	DefaultLevel  = "M"
+)
+
+// ErrSizeTooSmall размер изображения меньше числа модулей кода: содержимое длинное, уровень коррекции
+// или рамка велики для запрошенного size
+var ErrSizeTooSmall = errors.New("size is too small")
+
+var levels = map[string]qrcode.RecoveryLevel{
+	"L": qrcode.Low,
+	"M": qrcode.Medium,
+	"Q": qrcode.High,
+	"H": qrcode.Highest,
+}
+
+// Options параметры изображения: формат, размер стороны в пикселях, уровень коррекции ошибок
+// L, M, Q или H и ширина белой рамки в модулях
+type Options struct {
+	Format string
+	Size   int
+	Level  string
+	Margin int
+}
+
+// DefaultOptions возвращает параметры PNG-изображения по умолчанию
+func DefaultOptions() Options {
+	return Options{Format: FormatPNG, Size: DefaultSize, Level: DefaultLevel, Margin: DefaultMargin}
+}
+
+// Validate проверяет, что параметры в допустимых пределах
+func (o Options) Validate() error {
+	if o.Format != FormatPNG && o.Format != FormatSVG {
+		return fmt.Errorf("format must be %s or %s", FormatPNG, FormatSVG)
+	}
+	if o.Size < MinSize || o.Size > MaxSize {
+		return fmt.Errorf("size must be between %d and %d", MinSize, MaxSize)
+	}
+	if _, ok := levels[o.Level]; !ok {
+		return fmt.Errorf("level must be one of L, M, Q, H")
+	}
+	if o.Margin < 0 || o.Margin > MaxMargin {
+		return fmt.Errorf("margin must be between 0 and %d", MaxMargin)
+	}
+	return nil
+}
+
+// ContentType возвращает MIME-тип изображения
+func (o Options) ContentType() string {
+	if o.Format == FormatSVG {
+		return "image/svg+xml"
+	}
+	return "image/png"
+}
+
+type cacheKey struct {
+	content string
+	opts    Options
+}
+
+// Renderer рисует QR-коды и хранит последние отрисованные изображения в LRU-кэше
+type Renderer struct {
+	cache *lru.Cache[cacheKey, []byte]
+}
+
+// Current рендерер, которым пользуются обработчики
+var Current = NewRenderer(1024)
+
+// Initialize пересоздаёт Current с кэшем на cacheSize изображений
+func Initialize(cacheSize int) {
+	Current = NewRenderer(cacheSize)
+}
+
+// NewRenderer создаёт рендерер с кэшем на cacheSize изображений, при cacheSize < 1 без кэша
+func NewRenderer(cacheSize int) *Renderer {
+	r := &Renderer{}
+	if cacheSize > 0 {
+		r.cache, _ = lru.New[cacheKey, []byte](cacheSize)
+	}
+	return r
+}
+
+// Render возвращает изображение QR-кода с содержимым content
+func (r *Renderer) Render(content string, opts Options) ([]byte, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+	key := cacheKey{content: content, opts: opts}
+	if r.cache != nil {
+		if data, ok := r.cache.Get(key); ok {
+			return data, nil
+		}
+	}
+
+	code, err := qrcode.New(content, levels[opts.Level])
+	if err != nil {
+		return nil, err
+	}
+	code.DisableBorder = true
+	bitmap := code.Bitmap()
+
+	var data []byte
+	if opts.Format == FormatSVG {
+		data = renderSVG(bitmap, opts)
+	} else if data, err = renderPNG(bitmap, opts); err != nil {
+		return nil, err
+	}
+
+	if r.cache != nil {
+		r.cache.Add(key, data)
+	}
+	return data, nil
+}
+
+// renderPNG рисует bitmap с рамкой opts.Margin модулей в квадрат opts.Size пикселей.
+// Модуль занимает целое число пикселей, остаток поровну уходит в рамку
+func renderPNG(bitmap [][]bool, opts Options) ([]byte, error) {
+	modules := len(bitmap) + 2*opts.Margin
+	scale := opts.Size / modules
+	if scale < 1 {
+		return nil, fmt.Errorf("%w: %d pixels for %d modules", ErrSizeTooSmall, opts.Size, modules)
+	}
+	offset := (opts.Size - scale*len(bitmap)) / 2
+
+	img := image.NewPaletted(image.Rect(0, 0, opts.Size, opts.Size), color.Palette{color.White, color.Black})
+	for y, row := range bitmap {
+		for x, dark := range row {
+			if !dark {
+				continue
+			}
+			for dy := 0; dy < scale; dy++ {
+				start := img.PixOffset(offset+x*scale, offset+y*scale+dy)
+				for dx := 0; dx < scale; dx++ {
+					img.Pix[start+dx] = 1
+				}
+			}
+		}
+	}
+
+	buf := bytes.Buffer{}
+	encoder := png.Encoder{CompressionLevel: png.BestCompression}
+	if err := encoder.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// renderSVG рисует bitmap одним path в координатах модулей, масштабирование остаётся клиенту
+func renderSVG(bitmap [][]bool, opts Options) []byte {
+	modules := len(bitmap) + 2*opts.Margin
+
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" shape-rendering="crispEdges">`,
+		opts.Size, opts.Size, modules, modules)
+	fmt.Fprintf(&b, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, modules, modules)
+	for y, row := range bitmap {
+		// соседние тёмные модули строки рисуются одним прямоугольником
+		for x := 0; x < len(row); x++ {
+			if !row[x] {
+				continue
+			}
+			run := 1
+			for x+run < len(row) && row[x+run] {
+				run++
+			}
+			fmt.Fprintf(&b, "M%d %dh%dv1h-%dz", x+opts.Margin, y+opts.Margin, run, run)
+			x += run
+		}
+	}
+	b.WriteString(`"/></svg>`)
+	return []byte(b.String())
+}
@@ -0,0 +1,85 @@
+package qr
+
+import (
+	"bytes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+func TestRenderer_RenderPNG(t *testing.T) {
+	renderer := NewRenderer(10)
+	opts := DefaultOptions()
+	opts.Size = 300
+
+	// короткое содержимое помещается в QR-код версии 1 размером 21 модуль
+	data, err := renderer.Render("abc", opts)
+	require.NoError(t, err)
+
+	img, err := png.Decode(bytes.NewReader(data))
+	require.NoError(t, err)
+	require.Equal(t, 300, img.Bounds().Dx())
+	require.Equal(t, 300, img.Bounds().Dy())
+
+	// угол белый из-за рамки, левый верхний модуль поискового узора чёрный
+	r, _, _, _ := img.At(0, 0).RGBA()
+	assert.Equal(t, uint32(0xffff), r)
+	scale := 300 / (21 + 2*DefaultMargin)
+	offset := (300 - 21*scale) / 2
+	r, _, _, _ = img.At(offset, offset).RGBA()
+	assert.Equal(t, uint32(0), r)
+
+	cached, err := renderer.Render("abc", opts)
+	require.NoError(t, err)
+	assert.Same(t, &data[0], &cached[0])
+}
+
+func TestRenderer_RenderTooSmall(t *testing.T) {
+	opts := Options{Format: FormatPNG, Size: MinSize, Level: "H", Margin: MaxMargin}
+	_, err := NewRenderer(0).Render("https://example.com/"+strings.Repeat("a", 100), opts)
+	require.ErrorIs(t, err, ErrSizeTooSmall)
+}
+
+func TestRenderer_RenderSVG(t *testing.T) {
+	opts := DefaultOptions()
+	opts.Format = FormatSVG
+	opts.Margin = 2
+
+	// короткое содержимое помещается в QR-код версии 1 размером 21 модуль
+	data, err := NewRenderer(0).Render("abc", opts)
+	require.NoError(t, err)
+	svg := string(data)
+	assert.True(t, strings.HasPrefix(svg, "<svg "))
+	assert.Contains(t, svg, `viewBox="0 0 25 25"`)
+	assert.Contains(t, svg, "M2 2h7v1h-7z")
+	assert.Equal(t, "image/svg+xml", opts.ContentType())
+}
+
+func TestOptions_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(*Options)
+		wantErr bool
+	}{
+		{"default", func(o *Options) {}, false},
+		{"unknown format", func(o *Options) { o.Format = "gif" }, true},
+		{"too small", func(o *Options) { o.Size = MinSize - 1 }, true},
+		{"too large", func(o *Options) { o.Size = MaxSize + 1 }, true},
+		{"unknown level", func(o *Options) { o.Level = "X" }, true},
+		{"highest level", func(o *Options) { o.Level = "H" }, false},
+		{"negative margin", func(o *Options) { o.Margin = -1 }, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := DefaultOptions()
+			tt.modify(&opts)
+			if tt.wantErr {
+				require.Error(t, opts.Validate())
+				return
+			}
+			require.NoError(t, opts.Validate())
+		})
+	}
+}
@@ -0,0 +1,187 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Группы запросов с раздельными лимитами
+const (
+	GroupCreate   = "create"
+	GroupRedirect = "redirect"
+	GroupDelete   = "delete"
+	// GroupPassword неудачные попытки ввода пароля защищённой ссылки, считаются по ссылке и IP клиента
+	GroupPassword = "password"
+)
+
+// Limit параметры token bucket: Rate токенов в секунду и ёмкость Burst. Нулевой Limit отключает ограничение
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Result результат попытки взять токен
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter через сколько появится токен, если запрос не пропущен
+	RetryAfter time.Duration
+	// Reset через сколько ведро наполнится полностью
+	Reset time.Duration
+}
+
+// Store хранит вёдра по ключам. Общее для нескольких экземпляров сервиса хранилище реализует тот же интерфейс
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+	// Peek сообщает, есть ли в ведре key токен, не забирая его
+	Peek(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// Current хранилище, с которым работает middleware
+var Current Store = NewMemoryStore()
+
+// Limits лимиты по группам запросов, группа без лимита не ограничивается
+var Limits = map[string]Limit{}
+
+// Initialize разбирает лимиты групп в формате "rate:burst", например "10:50"
+func Initialize(limits map[string]string) error {
+	parsed := make(map[string]Limit, len(limits))
+	for group, value := range limits {
+		limit, err := ParseLimit(value)
+		if err != nil {
+			return fmt.Errorf("rate limit %s: %w", group, err)
+		}
+		parsed[group] = limit
+	}
+	Limits = parsed
+	return nil
+}
+
+// ParseLimit разбирает лимит "rate:burst". Пустая строка и "0" отключают ограничение
+func ParseLimit(value string) (Limit, error) {
+	if value == "" || value == "0" {
+		return Limit{}, nil
+	}
+	rate, burst, found := strings.Cut(value, ":")
+	if !found {
+		return Limit{}, fmt.Errorf("expected rate:burst, got %q", value)
+	}
+	r, err := strconv.ParseFloat(rate, 64)
+	if err != nil || r <= 0 {
+		return Limit{}, fmt.Errorf("rate must be positive number, got %q", rate)
+	}
+	b, err := strconv.Atoi(burst)
+	if err != nil || b < 1 {
+		return Limit{}, fmt.Errorf("burst must be positive integer, got %q", burst)
+	}
+	return Limit{Rate: r, Burst: b}, nil
+}
+
+// Disabled сообщает, что лимит не ограничивает запросы
+func (l Limit) Disabled() bool {
+	return l.Rate <= 0 || l.Burst <= 0
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	limit  Limit
+}
+
+// MemoryStore хранилище вёдер в памяти процесса
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	// now подменяется в тестах
+	now func() time.Time
+}
+
+// sweepInterval как часто из памяти удаляются полные вёдра
+const sweepInterval = time.Minute
+
+// NewMemoryStore создаёт пустое хранилище вёдер в памяти
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+// Take берёт токен из ведра key, создавая полное ведро при первом обращении
+func (m *MemoryStore) Take(_ context.Context, key string, limit Limit) (Result, error) {
+	if limit.Disabled() {
+		return Result{Allowed: true}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		m.buckets[key] = b
+	}
+	b.limit = limit
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return newResult(allowed, b.tokens, limit), nil
+}
+
+// Peek сообщает, есть ли токен в ведре key, не забирая его и не создавая ведро
+func (m *MemoryStore) Peek(_ context.Context, key string, limit Limit) (Result, error) {
+	if limit.Disabled() {
+		return Result{Allowed: true}, nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tokens := float64(limit.Burst)
+	if b, ok := m.buckets[key]; ok {
+		tokens = math.Min(tokens, b.tokens+m.now().Sub(b.last).Seconds()*limit.Rate)
+	}
+	return newResult(tokens >= 1, tokens, limit), nil
+}
+
+// newResult описывает ведро с tokens токенами после попытки, allowed — пропущен ли запрос
+func newResult(allowed bool, tokens float64, limit Limit) Result {
+	result := Result{Allowed: allowed, Limit: limit.Burst}
+	if !allowed {
+		result.RetryAfter = seconds((1 - tokens) / limit.Rate)
+	}
+	result.Remaining = int(tokens)
+	result.Reset = seconds((float64(limit.Burst) - tokens) / limit.Rate)
+	return result
+}
+
+// sweep удаляет вёдра, которые успели наполниться, вызывается под блокировкой
+func (m *MemoryStore) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepInterval {
+		return
+	}
+	m.lastSweep = now
+	for key, b := range m.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(m.buckets, key)
+		}
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
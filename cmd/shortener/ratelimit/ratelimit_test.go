@@ -0,0 +1,106 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		want    Limit
+		wantErr bool
+	}{
+		{"empty disables", "", Limit{}, false},
+		{"zero disables", "0", Limit{}, false},
+		{"rate and burst", "0.5:20", Limit{Rate: 0.5, Burst: 20}, false},
+		{"no burst", "10", Limit{}, true},
+		{"negative rate", "-1:5", Limit{}, true},
+		{"zero burst", "1:0", Limit{}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseLimit(tt.value)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestMemoryStore_Take(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Rate: 2, Burst: 3}
+	ctx := context.Background()
+
+	for i := 2; i >= 0; i-- {
+		result, err := store.Take(ctx, "user:1", limit)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, 3, result.Limit)
+		assert.Equal(t, i, result.Remaining)
+	}
+
+	result, err := store.Take(ctx, "user:1", limit)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 500*time.Millisecond, result.RetryAfter)
+	assert.Equal(t, 1500*time.Millisecond, result.Reset)
+
+	// другой ключ расходует своё ведро
+	result, err = store.Take(ctx, "ip:127.0.0.1", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	now = now.Add(500 * time.Millisecond)
+	result, err = store.Take(ctx, "user:1", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+
+	now = now.Add(2 * sweepInterval)
+	_, err = store.Take(ctx, "other", limit)
+	require.NoError(t, err)
+	assert.Len(t, store.buckets, 1)
+}
+
+func TestMemoryStore_TakeDisabled(t *testing.T) {
+	result, err := NewMemoryStore().Take(context.Background(), "key", Limit{})
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
+
+func TestMemoryStore_Peek(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	limit := Limit{Rate: 1, Burst: 1}
+	ctx := context.Background()
+
+	result, err := store.Peek(ctx, "key", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+	assert.Empty(t, store.buckets, "peek does not create a bucket")
+
+	_, err = store.Take(ctx, "key", limit)
+	require.NoError(t, err)
+	for i := 0; i < 2; i++ {
+		result, err = store.Peek(ctx, "key", limit)
+		require.NoError(t, err)
+		assert.False(t, result.Allowed, "peek does not take a token")
+		assert.Equal(t, time.Second, result.RetryAfter)
+	}
+
+	now = now.Add(time.Second)
+	result, err = store.Peek(ctx, "key", limit)
+	require.NoError(t, err)
+	assert.True(t, result.Allowed)
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"github.com/fngoc/url-shortener/cmd/shortener/config"
 	"github.com/fngoc/url-shortener/cmd/shortener/handlers"
+	"github.com/fngoc/url-shortener/cmd/shortener/ratelimit"
 	"github.com/fngoc/url-shortener/internal/logger"
 	"github.com/go-chi/chi/v5"
 	"net/http"
@@ -15,19 +16,44 @@ func Run() error {
 	r := chi.NewRouter()
 
 	r.Route("/", func(r chi.Router) {
-		r.Post("/", logger.RequestLogger(handlers.AuthMiddleware(handlers.GzipMiddleware(handlers.PostSaveWebhook))))
-		r.Get("/{id}", logger.RequestLogger(handlers.AuthMiddleware(handlers.GzipMiddleware(handlers.GetRedirectWebhook))))
-		r.Get("/ping", logger.RequestLogger(handlers.AuthMiddleware(handlers.GzipMiddleware(handlers.CheckConnection))))
+		r.Post("/", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupCreate, handlers.CompressMiddleware(handlers.PostSaveWebhook)))))
+		r.Get("/{id}", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupRedirect, handlers.CompressMiddleware(handlers.GetRedirectWebhook)))))
+		r.Post("/{id}", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupRedirect, handlers.CompressMiddleware(handlers.PostRedirectWebhook)))))
+		r.Get("/{id}/*", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupRedirect, handlers.CompressMiddleware(handlers.GetRedirectWebhook)))))
+		r.Post("/{id}/*", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupRedirect, handlers.CompressMiddleware(handlers.PostRedirectWebhook)))))
+		r.Get("/ping", logger.RequestLogger(handlers.AuthMiddleware(handlers.CompressMiddleware(handlers.CheckConnection))))
 
 		r.Route("/api", func(r chi.Router) {
 			r.Route("/shorten", func(r chi.Router) {
-				r.Post("/", logger.RequestLogger(handlers.AuthMiddleware(handlers.GzipMiddleware(handlers.PostShortenWebhook))))
-				r.Post("/batch", logger.RequestLogger(handlers.AuthMiddleware(handlers.GzipMiddleware(handlers.PostShortenBatchWebhook))))
+				r.Post("/", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupCreate, handlers.CompressMiddleware(handlers.PostShortenWebhook)))))
+				r.Post("/batch", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupCreate, handlers.CompressMiddleware(handlers.PostShortenBatchWebhook)))))
+				r.Post("/csv", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupCreate, handlers.CompressMiddleware(handlers.PostShortenCSVWebhook)))))
 			})
+			r.Get("/domains", logger.RequestLogger(handlers.AuthMiddleware(handlers.CompressMiddleware(handlers.GetDomainsWebhook))))
+			r.Get("/qr/{id}", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupRedirect, handlers.CompressMiddleware(handlers.GetQRWebhook)))))
 			r.Route("/user", func(r chi.Router) {
 				r.Route("/urls", func(r chi.Router) {
-					r.Get("/", logger.RequestLogger(handlers.AuthMiddleware(handlers.GzipMiddleware(handlers.GetUrlsWebhook))))
-					r.Delete("/", logger.RequestLogger(handlers.AuthMiddleware(handlers.GzipMiddleware(handlers.DeleteUrlsWebhook))))
+					r.Get("/", logger.RequestLogger(handlers.AuthMiddleware(handlers.CompressMiddleware(handlers.GetUrlsWebhook))))
+					r.Delete("/", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupDelete, handlers.CompressMiddleware(handlers.DeleteUrlsWebhook)))))
+					r.Post("/restore", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupDelete, handlers.CompressMiddleware(handlers.RestoreUrlsWebhook)))))
+					r.Patch("/{id}", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupCreate, handlers.CompressMiddleware(handlers.PatchUrlWebhook)))))
+					r.Get("/{id}/history", logger.RequestLogger(handlers.AuthMiddleware(handlers.CompressMiddleware(handlers.GetUrlHistoryWebhook))))
+					r.Get("/{id}/stats", logger.RequestLogger(handlers.AuthMiddleware(handlers.CompressMiddleware(handlers.GetUrlStatsWebhook))))
+				})
+				r.Route("/webhooks", func(r chi.Router) {
+					r.Post("/", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupCreate, handlers.CompressMiddleware(handlers.PostWebhookWebhook)))))
+					r.Get("/", logger.RequestLogger(handlers.AuthMiddleware(handlers.CompressMiddleware(handlers.GetWebhooksWebhook))))
+					r.Delete("/{id}", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupDelete, handlers.CompressMiddleware(handlers.DeleteWebhookWebhook)))))
+					r.Get("/{id}/deliveries", logger.RequestLogger(handlers.AuthMiddleware(handlers.CompressMiddleware(handlers.GetDeliveriesWebhook))))
+					r.Post("/{id}/test", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupCreate, handlers.CompressMiddleware(handlers.PostWebhookTestWebhook)))))
+				})
+				r.Route("/workspaces", func(r chi.Router) {
+					r.Get("/", logger.RequestLogger(handlers.AuthMiddleware(handlers.CompressMiddleware(handlers.GetWorkspacesWebhook))))
+					r.Post("/", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupCreate, handlers.CompressMiddleware(handlers.PostWorkspaceWebhook)))))
+					r.Get("/{id}/members", logger.RequestLogger(handlers.AuthMiddleware(handlers.CompressMiddleware(handlers.GetMembersWebhook))))
+					r.Put("/{id}/members/{user}", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupCreate, handlers.CompressMiddleware(handlers.PutMemberWebhook)))))
+					r.Put("/{id}/utm", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupCreate, handlers.CompressMiddleware(handlers.PutWorkspaceUTMWebhook)))))
+					r.Delete("/{id}/members/{user}", logger.RequestLogger(handlers.AuthMiddleware(handlers.RateLimitMiddleware(ratelimit.GroupDelete, handlers.CompressMiddleware(handlers.DeleteMemberWebhook)))))
 				})
 			})
 		})
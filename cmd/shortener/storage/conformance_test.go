@@ -0,0 +1,1001 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/jackc/pgerrcode"
+	"github.com/stretchr/testify/require"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// repositoryFactory создаёт пустое хранилище для одного теста. Возвращаемая функция reopen
+// открывает то же хранилище заново; для хранилищ без персистентности она равна nil
+type repositoryFactory func(t *testing.T) (store Repository, reopen func() Repository)
+
+func userContext(userID int) context.Context {
+	return context.WithValue(context.Background(), constants.UserIDKey, userID)
+}
+
+func TestLocalStore_Conformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) (Repository, func() Repository) {
+		return newLocalStore(), nil
+	})
+}
+
+func TestFileStore_Conformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) (Repository, func() Repository) {
+		path := filepath.Join(t.TempDir(), "data.json")
+		reopen := func() Repository {
+			fs, err := newFileStore(path)
+			require.NoError(t, err)
+			return fs
+		}
+		return reopen(), reopen
+	})
+}
+
+func TestSQLiteStore_Conformance(t *testing.T) {
+	runConformance(t, func(t *testing.T) (Repository, func() Repository) {
+		path := filepath.Join(t.TempDir(), "test.db")
+		reopen := func() Repository {
+			dbs, err := newSQLiteStore(path)
+			require.NoError(t, err)
+			t.Cleanup(func() { _ = dbs.db.Close() })
+			return dbs
+		}
+		return reopen(), reopen
+	})
+}
+
+// TestDBStore_Conformance запускается на Postgres из TEST_DATABASE_DSN, таблицы в этой базе очищаются.
+// Без TEST_DATABASE_DSN тест поднимает временный кластер через initdb и pg_ctl и пропускается,
+// только если запустить его не удалось
+func TestDBStore_Conformance(t *testing.T) {
+	dsn, ok := os.LookupEnv("TEST_DATABASE_DSN")
+	if !ok {
+		var err error
+		if dsn, err = startPostgres(t); err != nil {
+			t.Skipf("TEST_DATABASE_DSN is not set and local postgres is not started: %v", err)
+		}
+	}
+
+	runConformance(t, func(t *testing.T) (Repository, func() Repository) {
+		reopen := func() Repository {
+			dbs, err := newPostgresStore(dsn)
+			if err != nil {
+				t.Skipf("postgres is not available: %v", err)
+			}
+			t.Cleanup(func() { _ = dbs.db.Close() })
+			return dbs
+		}
+		store := reopen()
+		_, err := store.(DBStore).db.Exec("TRUNCATE url_shortener, url_history, url_tags, workspaces, workspace_members, link_clicks, webhooks, webhook_deliveries, link_outbox")
+		require.NoError(t, err)
+		return store, reopen
+	})
+}
+
+// startPostgres создаёт кластер Postgres во временном каталоге, запускает его на свободном порту
+// и возвращает DSN. Кластер останавливается по завершении теста
+func startPostgres(t *testing.T) (string, error) {
+	initdb, err := postgresBinary("initdb")
+	if err != nil {
+		return "", err
+	}
+	pgCtl, err := postgresBinary("pg_ctl")
+	if err != nil {
+		return "", err
+	}
+
+	dir := t.TempDir()
+	data := filepath.Join(dir, "data")
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "--no-sync").CombinedOutput(); err != nil {
+		return "", fmt.Errorf("initdb: %w: %s", err, out)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	_ = listener.Close()
+
+	// сокет не создаётся: путь временного каталога может быть длиннее допустимого для unix-сокета
+	options := fmt.Sprintf("-p %d -c listen_addresses=127.0.0.1 -c unix_socket_directories='' -F", port)
+	if out, err := exec.Command(pgCtl, "-D", data, "-l", filepath.Join(dir, "postgres.log"), "-o", options, "-w", "start").CombinedOutput(); err != nil {
+		return "", fmt.Errorf("pg_ctl start: %w: %s", err, out)
+	}
+	t.Cleanup(func() {
+		_ = exec.Command(pgCtl, "-D", data, "-m", "immediate", "-w", "stop").Run()
+	})
+	return fmt.Sprintf("postgres://postgres@127.0.0.1:%d/postgres?sslmode=disable", port), nil
+}
+
+// postgresBinary ищет программу Postgres в PATH, а затем в каталогах пакетов Debian и Ubuntu,
+// которые не добавляют её в PATH
+func postgresBinary(name string) (string, error) {
+	if path, err := exec.LookPath(name); err == nil {
+		return path, nil
+	}
+	matches, _ := filepath.Glob(filepath.Join("/usr/lib/postgresql", "*", "bin", name))
+	if len(matches) == 0 {
+		return "", fmt.Errorf("%s is not found", name)
+	}
+	return matches[len(matches)-1], nil
+}
+
+func runConformance(t *testing.T, factory repositoryFactory) {
+	t.Run("save and get", func(t *testing.T) {
+		store, _ := factory(t)
+
+		require.NoError(t, store.SaveData(userContext(1), "key", "https://ya.ru"))
+		value, err := store.GetData(userContext(2), "", "key")
+		require.NoError(t, err)
+		require.Equal(t, "https://ya.ru", value)
+	})
+
+	t.Run("empty values", func(t *testing.T) {
+		store, _ := factory(t)
+
+		require.Error(t, store.SaveData(userContext(1), "", "https://ya.ru"))
+		require.Error(t, store.SaveData(userContext(1), "key", ""))
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		store, _ := factory(t)
+
+		_, err := store.GetData(userContext(1), "", "unknown")
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("duplicate short url", func(t *testing.T) {
+		store, _ := factory(t)
+
+		require.NoError(t, store.SaveData(userContext(1), "key", "https://ya.ru"))
+		require.ErrorIs(t, store.SaveData(userContext(1), "key", "https://google.com"), ErrAlreadyExists)
+
+		value, err := store.GetData(userContext(1), "", "key")
+		require.NoError(t, err)
+		require.Equal(t, "https://ya.ru", value)
+	})
+
+	t.Run("conflict on original url", func(t *testing.T) {
+		store, _ := factory(t)
+
+		require.NoError(t, store.SaveData(userContext(1), "key", "https://ya.ru"))
+		err := store.SaveData(userContext(2), "other", "https://ya.ru")
+
+		var dbErr *DBError
+		require.True(t, errors.As(err, &dbErr))
+		require.True(t, pgerrcode.IsIntegrityConstraintViolation(dbErr.Err.Code))
+		require.Equal(t, "key", dbErr.ShortURL)
+	})
+
+	t.Run("list by user", func(t *testing.T) {
+		store, _ := factory(t)
+
+		require.NoError(t, store.SaveData(userContext(1), "first", "https://ya.ru"))
+		require.NoError(t, store.SaveData(userContext(1), "second", "https://google.com"))
+		require.NoError(t, store.SaveData(userContext(2), "third", "https://go.dev"))
+
+		urls, _, err := store.ListData(context.Background(), 1, ListOptions{})
+		require.NoError(t, err)
+		originals := make([]string, 0, len(urls))
+		for _, u := range urls {
+			originals = append(originals, u.OriginalURL)
+		}
+		require.ElementsMatch(t, []string{"https://ya.ru", "https://google.com"}, originals)
+
+		urls, _, err = store.ListData(context.Background(), 3, ListOptions{})
+		require.NoError(t, err)
+		require.Empty(t, urls)
+	})
+
+	t.Run("paginated list", func(t *testing.T) {
+		store, _ := factory(t)
+
+		base := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+		for i, u := range []string{"https://c.ru/Go", "https://a.ru", "https://b.ru/go", "https://d.ru", "https://e.ru/GO_"} {
+			require.NoError(t, store.ImportData(context.Background(), models.URLData{
+				ShortURL:    fmt.Sprintf("key%d", i),
+				OriginalURL: u,
+				UserID:      1,
+				IsDeleted:   i == 3,
+				CreatedAt:   base.Add(time.Duration(i%3) * time.Minute),
+			}))
+		}
+		require.NoError(t, store.SaveData(userContext(2), "foreign", "https://f.ru/go"))
+
+		collect := func(opts ListOptions) []string {
+			var keys []string
+			for {
+				urls, next, err := store.ListData(context.Background(), 1, opts)
+				require.NoError(t, err)
+				if opts.Limit > 0 {
+					require.LessOrEqual(t, len(urls), opts.Limit)
+				}
+				for _, u := range urls {
+					keys = append(keys, u.ShortURL)
+				}
+				if next == "" {
+					return keys
+				}
+				opts.Cursor = next
+			}
+		}
+
+		require.Equal(t, []string{"key0", "key3", "key1", "key4", "key2"}, collect(ListOptions{Limit: 2}))
+		require.Equal(t, []string{"key2", "key4", "key1", "key3", "key0"}, collect(ListOptions{Limit: 2, Sort: SortCreatedDesc}))
+		require.Equal(t, []string{"key1", "key2", "key0", "key3", "key4"}, collect(ListOptions{Limit: 3, Sort: SortOriginalAsc}))
+		require.Equal(t, []string{"key4", "key3", "key0", "key2", "key1"}, collect(ListOptions{Limit: 1, Sort: SortOriginalDesc}))
+		require.Equal(t, []string{"key0", "key4", "key2"}, collect(ListOptions{Limit: 1, Query: "go"}))
+		require.Equal(t, []string{"key4"}, collect(ListOptions{Query: "go_"}))
+
+		deleted, active := true, false
+		require.Equal(t, []string{"key3"}, collect(ListOptions{Deleted: &deleted}))
+		require.Equal(t, []string{"key0", "key1", "key4", "key2"}, collect(ListOptions{Limit: 10, Deleted: &active}))
+
+		from, to := base.Add(time.Minute), base.Add(2*time.Minute)
+		require.Equal(t, []string{"key1", "key4"}, collect(ListOptions{CreatedFrom: &from, CreatedTo: &to}))
+
+		_, _, err := store.ListData(context.Background(), 1, ListOptions{Sort: "unknown"})
+		require.ErrorIs(t, err, ErrInvalidListOptions)
+		_, _, err = store.ListData(context.Background(), 1, ListOptions{Cursor: "broken"})
+		require.ErrorIs(t, err, ErrInvalidListOptions)
+		_, next, err := store.ListData(context.Background(), 1, ListOptions{Limit: 1})
+		require.NoError(t, err)
+		_, _, err = store.ListData(context.Background(), 1, ListOptions{Limit: 1, Cursor: next, Sort: SortOriginalAsc})
+		require.ErrorIs(t, err, ErrInvalidListOptions)
+	})
+
+	t.Run("delete and gone", func(t *testing.T) {
+		store, _ := factory(t)
+
+		require.NoError(t, store.SaveData(userContext(1), "key", "https://ya.ru"))
+
+		require.NoError(t, store.DeleteData("", "key"))
+		_, err := store.GetData(userContext(1), "", "key")
+		var deleteErr *DBDeleteError
+		require.True(t, errors.As(err, &deleteErr))
+
+		record, err := store.FindRecord(userContext(1), "", "key")
+		require.NoError(t, err, "deleted link is found for access checks")
+		require.True(t, record.IsDeleted)
+		require.NotNil(t, record.DeletedAt)
+
+		require.NoError(t, store.DeleteData("", "unknown"))
+		_, err = store.FindRecord(userContext(1), "", "unknown")
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		store, _ := factory(t)
+
+		const n = 50
+		var wg sync.WaitGroup
+		errs := make(chan error, 2*n)
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := fmt.Sprintf("key%d", i)
+				errs <- store.SaveData(userContext(1), key, "https://ya.ru/"+key)
+				if i%2 == 0 {
+					errs <- store.DeleteData("", key)
+				}
+			}(i)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			require.NoError(t, err)
+		}
+
+		for i := 0; i < n; i++ {
+			key := fmt.Sprintf("key%d", i)
+			value, err := store.GetData(userContext(1), "", key)
+			if i%2 == 0 {
+				var deleteErr *DBDeleteError
+				require.True(t, errors.As(err, &deleteErr))
+				continue
+			}
+			require.NoError(t, err)
+			require.Equal(t, "https://ya.ru/"+key, value)
+		}
+	})
+
+	t.Run("export and import", func(t *testing.T) {
+		store, _ := factory(t)
+
+		require.NoError(t, store.SaveData(userContext(1), "key", "https://ya.ru"))
+		require.NoError(t, store.DeleteData("", "key"))
+
+		expired := time.Now().Add(-time.Hour).UTC().Truncate(time.Second)
+		imported := models.URLData{
+			ShortURL:    "imported",
+			OriginalURL: "https://google.com",
+			UserID:      2,
+			CreatedAt:   expired.Add(-time.Hour),
+			ExpiresAt:   &expired,
+			History:     []models.HistoryEntry{{OriginalURL: "https://google.com/old", ChangedAt: expired.Add(-time.Minute)}},
+		}
+		require.NoError(t, store.ImportData(userContext(0), imported))
+		require.NoError(t, store.ImportData(userContext(0), imported), "import is idempotent")
+
+		var dbErr *DBError
+		require.True(t, errors.As(store.ImportData(userContext(0), models.URLData{
+			ShortURL:    "other",
+			OriginalURL: "https://ya.ru",
+		}), &dbErr))
+
+		_, err := store.GetData(userContext(1), "", "imported")
+		var deleteErr *DBDeleteError
+		require.True(t, errors.As(err, &deleteErr), "expired link is gone")
+
+		var records []models.URLData
+		require.NoError(t, store.ExportData(context.Background(), func(record models.URLData) error {
+			records = append(records, record)
+			return nil
+		}))
+		require.Len(t, records, 2)
+		require.Equal(t, "key", records[0].ShortURL)
+		require.True(t, records[0].IsDeleted)
+		require.Equal(t, 1, records[0].UserID)
+		require.False(t, records[0].CreatedAt.IsZero())
+
+		require.Equal(t, "imported", records[1].ShortURL)
+		require.Equal(t, imported.OriginalURL, records[1].OriginalURL)
+		require.Equal(t, imported.UserID, records[1].UserID)
+		require.True(t, imported.CreatedAt.Equal(records[1].CreatedAt))
+		require.NotNil(t, records[1].ExpiresAt)
+		require.True(t, expired.Equal(*records[1].ExpiresAt))
+		require.Equal(t, imported.History, records[1].History, "history is exported and re-import does not duplicate it")
+	})
+
+	t.Run("interstitial and title", func(t *testing.T) {
+		store, reopen := factory(t)
+		ctx := userContext(1)
+
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{
+			ShortURL: "key", OriginalURL: "https://ya.ru", UserID: 1, Interstitial: true,
+		}))
+		require.NoError(t, store.UpdateTitle(ctx, "", "key", "Яндекс"))
+		require.ErrorIs(t, store.UpdateTitle(ctx, "", "missing", "title"), ErrNotFound)
+
+		if reopen != nil {
+			store = reopen()
+		}
+		record, err := store.GetRecord(ctx, "", "key")
+		require.NoError(t, err)
+		require.Equal(t, "https://ya.ru", record.OriginalURL)
+		require.True(t, record.Interstitial)
+		require.Equal(t, "Яндекс", record.Title)
+
+		_, err = store.GetRecord(ctx, "", "missing")
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("update and history", func(t *testing.T) {
+		store, reopen := factory(t)
+		ctx := userContext(1)
+
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{ShortURL: "key", OriginalURL: "https://ya.ru/typo", UserID: 1}))
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{ShortURL: "other", OriginalURL: "https://ya.ru/other", UserID: 1}))
+		require.NoError(t, store.UpdateTitle(ctx, "", "key", "Typo"))
+
+		fixed, redirectType, expiresAt := "https://ya.ru/fixed", 301, time.Now().Add(time.Hour)
+		record, err := store.UpdateRecord(ctx, "", "key", LinkChanges{OriginalURL: &fixed, ExpiresAt: &expiresAt, RedirectType: &redirectType})
+		require.NoError(t, err)
+		require.Equal(t, fixed, record.OriginalURL)
+		require.Empty(t, record.Title)
+		require.Equal(t, 301, record.RedirectType)
+
+		_, err = store.UpdateRecord(ctx, "", "key", LinkChanges{ClearExpiresAt: true})
+		require.NoError(t, err)
+		final := "https://ya.ru/final"
+		_, err = store.UpdateRecord(ctx, "", "key", LinkChanges{OriginalURL: &final})
+		require.NoError(t, err)
+
+		taken := "https://ya.ru/other"
+		_, err = store.UpdateRecord(ctx, "", "key", LinkChanges{OriginalURL: &taken})
+		var dbErr *DBError
+		require.ErrorAs(t, err, &dbErr)
+		require.Equal(t, "other", dbErr.ShortURL)
+		_, err = store.UpdateRecord(ctx, "", "missing", LinkChanges{OriginalURL: &taken})
+		require.ErrorIs(t, err, ErrNotFound)
+		require.NoError(t, store.DeleteData("", "other"))
+		_, err = store.UpdateRecord(ctx, "", "other", LinkChanges{OriginalURL: &final})
+		var deleteErr *DBDeleteError
+		require.ErrorAs(t, err, &deleteErr)
+
+		if reopen != nil {
+			store = reopen()
+		}
+		record, err = store.GetRecord(ctx, "", "key")
+		require.NoError(t, err)
+		require.Equal(t, final, record.OriginalURL)
+		require.Nil(t, record.ExpiresAt)
+		require.Equal(t, 301, record.RedirectType)
+
+		history, err := store.History(ctx, "", "key")
+		require.NoError(t, err)
+		require.Len(t, history, 2)
+		require.Equal(t, fixed, history[0].OriginalURL)
+		require.Equal(t, "https://ya.ru/typo", history[1].OriginalURL)
+		require.False(t, history[0].ChangedAt.Before(history[1].ChangedAt))
+
+		_, err = store.History(ctx, "", "missing")
+		require.ErrorIs(t, err, ErrNotFound)
+	})
+
+	t.Run("restore and purge", func(t *testing.T) {
+		store, reopen := factory(t)
+		ctx := userContext(1)
+
+		for _, key := range []string{"kept", "restored", "late", "purged"} {
+			require.NoError(t, store.SaveRecord(ctx, models.URLData{ShortURL: key, OriginalURL: "https://ya.ru/" + key, UserID: 1}))
+		}
+		before := time.Now().Add(-time.Second)
+		for _, key := range []string{"restored", "late", "purged"} {
+			require.NoError(t, store.DeleteData("", key))
+		}
+
+		record, err := store.RestoreData(ctx, "", "restored", before)
+		require.NoError(t, err)
+		require.False(t, record.IsDeleted)
+		require.Nil(t, record.DeletedAt)
+		_, err = store.RestoreData(ctx, "", "late", time.Now().Add(time.Minute))
+		require.ErrorIs(t, err, ErrNotRestorable)
+		_, err = store.RestoreData(ctx, "", "kept", before)
+		require.ErrorIs(t, err, ErrNotRestorable)
+		_, err = store.RestoreData(ctx, "", "missing", before)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		_, err = store.RestoreData(ctx, "", "late", before)
+		require.NoError(t, err)
+		require.NoError(t, store.DeleteData("", "late"))
+
+		purged, err := store.PurgeDeleted(ctx, time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		require.Equal(t, 2, purged)
+		purged, err = store.PurgeDeleted(ctx, time.Now().Add(time.Minute))
+		require.NoError(t, err)
+		require.Zero(t, purged)
+
+		if reopen != nil {
+			store = reopen()
+		}
+		for _, key := range []string{"late", "purged"} {
+			_, err = store.GetRecord(ctx, "", key)
+			require.ErrorIs(t, err, ErrNotFound)
+		}
+		_, err = store.GetRecord(ctx, "", "restored")
+		require.NoError(t, err)
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{ShortURL: "again", OriginalURL: "https://ya.ru/purged", UserID: 1}),
+			"original url of purged link is free")
+	})
+
+	t.Run("tags and folders", func(t *testing.T) {
+		store, reopen := factory(t)
+		ctx := userContext(1)
+
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{
+			ShortURL: "docs", OriginalURL: "https://ya.ru/docs", UserID: 1, Tags: []string{"docs", "team"}, Folder: "Work",
+		}))
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{
+			ShortURL: "news", OriginalURL: "https://ya.ru/news", UserID: 1, Tags: []string{"team"},
+		}))
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{
+			ShortURL: "alien", OriginalURL: "https://ya.ru/alien", UserID: 2, Tags: []string{"team"},
+		}))
+
+		shortURLs := func(opts ListOptions) []string {
+			records, _, err := store.ListData(ctx, 1, opts)
+			require.NoError(t, err)
+			result := make([]string, 0, len(records))
+			for _, record := range records {
+				result = append(result, record.ShortURL)
+			}
+			return result
+		}
+		require.Equal(t, []string{"docs", "news"}, shortURLs(ListOptions{Tag: "team"}))
+		require.Equal(t, []string{"docs"}, shortURLs(ListOptions{Tag: "docs"}))
+		require.Equal(t, []string{"docs"}, shortURLs(ListOptions{Folder: "Work"}))
+		require.Empty(t, shortURLs(ListOptions{Tag: "missing"}))
+
+		tags, folder := []string{"archive"}, "Old"
+		record, err := store.UpdateRecord(ctx, "", "news", LinkChanges{Tags: &tags, Folder: &folder})
+		require.NoError(t, err)
+		require.Equal(t, tags, record.Tags)
+		require.Equal(t, folder, record.Folder)
+
+		if reopen != nil {
+			store = reopen()
+		}
+		record, err = store.GetRecord(ctx, "", "docs")
+		require.NoError(t, err)
+		require.Equal(t, []string{"docs", "team"}, record.Tags)
+		require.Equal(t, "Work", record.Folder)
+		require.Equal(t, []string{"news"}, shortURLs(ListOptions{Tag: "archive", Folder: "Old"}))
+		require.Equal(t, []string{"docs"}, shortURLs(ListOptions{Tag: "team"}))
+
+		exported := map[string][]string{}
+		require.NoError(t, store.ExportData(ctx, func(record models.URLData) error {
+			exported[record.ShortURL] = record.Tags
+			return nil
+		}))
+		require.Equal(t, map[string][]string{"docs": {"docs", "team"}, "news": {"archive"}, "alien": {"team"}}, exported)
+	})
+
+	t.Run("workspaces", func(t *testing.T) {
+		store, reopen := factory(t)
+		ctx := userContext(1)
+
+		team, err := store.CreateWorkspace(ctx, "Team", 1)
+		require.NoError(t, err)
+		require.Equal(t, RoleOwner, team.Role)
+		other, err := store.CreateWorkspace(ctx, "Other", 3)
+		require.NoError(t, err)
+		require.NotEqual(t, team.ID, other.ID)
+
+		require.NoError(t, store.SetMemberRole(ctx, team.ID, 2, RoleViewer))
+		require.NoError(t, store.SetMemberRole(ctx, team.ID, 2, RoleEditor))
+		require.NoError(t, store.SetMemberRole(ctx, team.ID, 4, RoleViewer))
+		require.NoError(t, store.SetMemberRole(ctx, team.ID, 4, ""))
+		require.ErrorIs(t, store.SetMemberRole(ctx, other.ID+100, 2, RoleViewer), ErrNotFound)
+
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{ShortURL: "shared", OriginalURL: "https://ya.ru/shared", UserID: 2, WorkspaceID: team.ID}))
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{ShortURL: "personal", OriginalURL: "https://ya.ru/personal", UserID: 2}))
+
+		if reopen != nil {
+			store = reopen()
+		}
+		role, err := store.MemberRole(ctx, team.ID, 2)
+		require.NoError(t, err)
+		require.Equal(t, RoleEditor, role)
+		role, err = store.MemberRole(ctx, team.ID, 4)
+		require.NoError(t, err)
+		require.Empty(t, role)
+
+		members, err := store.Members(ctx, team.ID)
+		require.NoError(t, err)
+		require.Equal(t, []models.Member{{UserID: 1, Role: RoleOwner}, {UserID: 2, Role: RoleEditor}}, members)
+		_, err = store.Members(ctx, other.ID+100)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		workspaces, err := store.ListWorkspaces(ctx, 2)
+		require.NoError(t, err)
+		require.Len(t, workspaces, 1)
+		require.Equal(t, "Team", workspaces[0].Name)
+		require.Equal(t, RoleEditor, workspaces[0].Role)
+
+		records, _, err := store.ListData(ctx, 1, ListOptions{WorkspaceID: team.ID})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.Equal(t, "shared", records[0].ShortURL)
+		records, _, err = store.ListData(ctx, 2, ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, records, 1)
+		require.Equal(t, "personal", records[0].ShortURL, "workspace links are not personal")
+
+		record, err := store.FindRecord(ctx, "", "shared")
+		require.NoError(t, err)
+		require.Equal(t, team.ID, record.WorkspaceID)
+	})
+
+	t.Run("domains", func(t *testing.T) {
+		store, reopen := factory(t)
+		ctx := userContext(1)
+
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{ShortURL: "sale", OriginalURL: "https://ya.ru/sale", UserID: 1, Tags: []string{"main"}}))
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{
+			ShortURL: "sale", OriginalURL: "https://ya.ru/brand-sale", UserID: 1, Domain: "go.brand.com", Tags: []string{"brand"},
+		}))
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{ShortURL: "same", OriginalURL: "https://ya.ru/sale", UserID: 1, Domain: "go.brand.com"}),
+			"original url is unique only within a domain")
+
+		err := store.SaveRecord(ctx, models.URLData{ShortURL: "sale", OriginalURL: "https://ya.ru/other", UserID: 1, Domain: "go.brand.com"})
+		require.ErrorIs(t, err, ErrAlreadyExists)
+		err = store.SaveRecord(ctx, models.URLData{ShortURL: "again", OriginalURL: "https://ya.ru/brand-sale", UserID: 1, Domain: "go.brand.com"})
+		var dbErr *DBError
+		require.ErrorAs(t, err, &dbErr)
+		require.Equal(t, "sale", dbErr.ShortURL)
+
+		url := "https://ya.ru/brand-new"
+		_, err = store.UpdateRecord(ctx, "go.brand.com", "sale", LinkChanges{OriginalURL: &url})
+		require.NoError(t, err)
+		require.NoError(t, store.DeleteData("", "sale"))
+
+		if reopen != nil {
+			store = reopen()
+		}
+		_, err = store.GetData(ctx, "", "sale")
+		var deleteErr *DBDeleteError
+		require.ErrorAs(t, err, &deleteErr)
+		record, err := store.GetRecord(ctx, "go.brand.com", "sale")
+		require.NoError(t, err)
+		require.Equal(t, "https://ya.ru/brand-new", record.OriginalURL)
+		require.Equal(t, "go.brand.com", record.Domain)
+		require.Equal(t, []string{"brand"}, record.Tags)
+		_, err = store.GetRecord(ctx, "other.brand.com", "sale")
+		require.ErrorIs(t, err, ErrNotFound)
+
+		history, err := store.History(ctx, "", "sale")
+		require.NoError(t, err)
+		require.Empty(t, history)
+		history, err = store.History(ctx, "go.brand.com", "sale")
+		require.NoError(t, err)
+		require.Len(t, history, 1)
+
+		purged, err := store.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		require.Equal(t, 1, purged)
+		record, err = store.FindRecord(ctx, "go.brand.com", "sale")
+		require.NoError(t, err)
+		require.Equal(t, []string{"brand"}, record.Tags)
+	})
+
+	t.Run("rules", func(t *testing.T) {
+		store, reopen := factory(t)
+		ctx := userContext(1)
+
+		rules := []models.TargetingRule{
+			{URL: "https://ya.ru/ios", OS: []string{"ios"}},
+			{URL: "https://ya.ru/de", Countries: []string{"DE"}, Languages: []string{"de"}},
+		}
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{ShortURL: "app", OriginalURL: "https://ya.ru/app", UserID: 1, Rules: rules}))
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{ShortURL: "plain", OriginalURL: "https://ya.ru/plain", UserID: 1}))
+
+		record, err := store.GetRecord(ctx, "", "app")
+		require.NoError(t, err)
+		require.Equal(t, rules, record.Rules)
+
+		replaced := rules[:1]
+		record, err = store.UpdateRecord(ctx, "", "app", LinkChanges{Rules: &replaced})
+		require.NoError(t, err)
+		require.Equal(t, replaced, record.Rules)
+
+		if reopen != nil {
+			store = reopen()
+		}
+		record, err = store.GetRecord(ctx, "", "app")
+		require.NoError(t, err)
+		require.Equal(t, replaced, record.Rules)
+		record, err = store.GetRecord(ctx, "", "plain")
+		require.NoError(t, err)
+		require.Empty(t, record.Rules)
+
+		cleared := []models.TargetingRule{}
+		record, err = store.UpdateRecord(ctx, "", "app", LinkChanges{Rules: &cleared})
+		require.NoError(t, err)
+		require.Empty(t, record.Rules)
+	})
+
+	t.Run("variants and clicks", func(t *testing.T) {
+		store, _ := factory(t)
+		ctx := userContext(1)
+
+		variants := []models.Variant{{URL: "https://ya.ru/a", Weight: 70}, {URL: "https://ya.ru/b", Weight: 30}}
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{ShortURL: "split", OriginalURL: "https://ya.ru/split", UserID: 1, Variants: variants}))
+		record, err := store.GetRecord(ctx, "", "split")
+		require.NoError(t, err)
+		require.Equal(t, variants, record.Variants)
+
+		stopped := []models.Variant{}
+		record, err = store.UpdateRecord(ctx, "", "split", LinkChanges{Variants: &stopped})
+		require.NoError(t, err)
+		require.Empty(t, record.Variants)
+
+		for i, variant := range []int{0, 1, 0, NoVariant} {
+			total, err := store.RecordClick(ctx, "", "split", variant)
+			require.NoError(t, err)
+			require.Equal(t, i+1, total)
+		}
+		clicks, err := store.Clicks(ctx, "", "split")
+		require.NoError(t, err)
+		require.Equal(t, map[int]int{0: 2, 1: 1, NoVariant: 1}, clicks)
+		clicks, err = store.Clicks(ctx, "go.brand.com", "split")
+		require.NoError(t, err)
+		require.Empty(t, clicks)
+
+		// одновременные переходы на разные варианты получают каждый своё число переходов
+		totals := make([]int, 20)
+		errs := make([]error, len(totals))
+		var wg sync.WaitGroup
+		for i := range totals {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				totals[i], errs[i] = store.RecordClick(ctx, "", "split", i%2)
+			}()
+		}
+		wg.Wait()
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+		slices.Sort(totals)
+		for i, total := range totals {
+			require.Equal(t, 5+i, total)
+		}
+
+		require.NoError(t, store.DeleteData("", "split"))
+		_, err = store.PurgeDeleted(ctx, time.Now().Add(time.Hour))
+		require.NoError(t, err)
+		clicks, err = store.Clicks(ctx, "", "split")
+		require.NoError(t, err)
+		require.Empty(t, clicks, "clicks are purged with the link")
+	})
+
+	t.Run("utm templates", func(t *testing.T) {
+		store, reopen := factory(t)
+		ctx := userContext(1)
+
+		workspace, err := store.CreateWorkspace(ctx, "team", 1)
+		require.NoError(t, err)
+		require.NoError(t, store.SetWorkspaceUTM(ctx, workspace.ID, map[string]string{"utm_source": "team"}))
+		require.ErrorIs(t, store.SetWorkspaceUTM(ctx, workspace.ID+1, map[string]string{"utm_source": "none"}), ErrNotFound)
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{
+			ShortURL: "mail", OriginalURL: "https://ya.ru/mail", UserID: 1, UTM: map[string]string{"utm_medium": "email"},
+		}))
+		utm := map[string]string{"utm_medium": "social"}
+		_, err = store.UpdateRecord(ctx, "", "mail", LinkChanges{UTM: &utm})
+		require.NoError(t, err)
+
+		if reopen != nil {
+			store = reopen()
+		}
+		workspace, err = store.Workspace(ctx, workspace.ID)
+		require.NoError(t, err)
+		require.Equal(t, "team", workspace.Name)
+		require.Equal(t, map[string]string{"utm_source": "team"}, workspace.UTM)
+		workspaces, err := store.ListWorkspaces(ctx, 1)
+		require.NoError(t, err)
+		require.Len(t, workspaces, 1)
+		require.Equal(t, workspace.UTM, workspaces[0].UTM)
+		_, err = store.Workspace(ctx, workspace.ID+1)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		record, err := store.GetRecord(ctx, "", "mail")
+		require.NoError(t, err)
+		require.Equal(t, utm, record.UTM)
+
+		require.NoError(t, store.SetWorkspaceUTM(ctx, workspace.ID, nil))
+		workspace, err = store.Workspace(ctx, workspace.ID)
+		require.NoError(t, err)
+		require.Empty(t, workspace.UTM)
+	})
+
+	t.Run("prefix mode", func(t *testing.T) {
+		store, reopen := factory(t)
+		ctx := userContext(1)
+
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{ShortURL: "docs", OriginalURL: "https://go.dev/doc", UserID: 1, PrefixMode: true}))
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{ShortURL: "plain", OriginalURL: "https://ya.ru", UserID: 1}))
+		prefixMode := true
+		_, err := store.UpdateRecord(ctx, "", "plain", LinkChanges{PrefixMode: &prefixMode})
+		require.NoError(t, err)
+		prefixMode = false
+		_, err = store.UpdateRecord(ctx, "", "docs", LinkChanges{PrefixMode: &prefixMode})
+		require.NoError(t, err)
+
+		if reopen != nil {
+			store = reopen()
+		}
+		record, err := store.GetRecord(ctx, "", "docs")
+		require.NoError(t, err)
+		require.False(t, record.PrefixMode)
+		record, err = store.GetRecord(ctx, "", "plain")
+		require.NoError(t, err)
+		require.True(t, record.PrefixMode)
+	})
+
+	t.Run("webhooks and deliveries", func(t *testing.T) {
+		store, reopen := factory(t)
+		ctx := userContext(1)
+
+		webhook, err := store.CreateWebhook(ctx, models.Webhook{
+			UserID: 1, URL: "https://crm.example.com/hook", Secret: "0123456789abcdef",
+			Events: []string{"link.created", "link.click_threshold"}, ClickThreshold: 100,
+		})
+		require.NoError(t, err)
+		require.NotZero(t, webhook.ID)
+		other, err := store.CreateWebhook(ctx, models.Webhook{UserID: 2, URL: "https://other.example.com", Secret: "fedcba9876543210",
+			Events: []string{"link.deleted"}})
+		require.NoError(t, err)
+		for _, threshold := range []int{100, 10} {
+			_, err = store.CreateWebhook(ctx, models.Webhook{UserID: 3, URL: "https://third.example.com", Secret: "fedcba9876543210",
+				Events: []string{"link.click_threshold"}, ClickThreshold: threshold})
+			require.NoError(t, err)
+		}
+
+		now := time.Now()
+		later := now.Add(time.Hour)
+		first, err := store.AddDelivery(ctx, models.WebhookDelivery{WebhookID: webhook.ID, Event: "link.created", Payload: `{"n":1}`, Status: DeliveryPending})
+		require.NoError(t, err)
+		_, err = store.AddDelivery(ctx, models.WebhookDelivery{WebhookID: webhook.ID, Event: "link.created", Payload: `{"n":2}`,
+			Status: DeliveryPending, NextAttemptAt: &later})
+		require.NoError(t, err)
+		_, err = store.AddDelivery(ctx, models.WebhookDelivery{WebhookID: other.ID, Event: "link.deleted", Payload: `{}`, Status: DeliveryPending})
+		require.NoError(t, err)
+
+		due, err := store.DueDeliveries(ctx, now, 1)
+		require.NoError(t, err)
+		require.Len(t, due, 1)
+		require.Equal(t, first.ID, due[0].ID)
+		require.Equal(t, `{"n":1}`, due[0].Payload)
+
+		first.Status, first.Attempts, first.ResponseCode, first.Error = DeliveryPending, 1, 500, "unexpected status"
+		first.NextAttemptAt = &later
+		require.NoError(t, store.UpdateDelivery(ctx, first))
+		due, err = store.DueDeliveries(ctx, now, 10)
+		require.NoError(t, err)
+		require.Len(t, due, 1, "only the delivery of the other webhook is due")
+		require.ErrorIs(t, store.UpdateDelivery(ctx, models.WebhookDelivery{ID: 1000, Status: DeliveryFailed}), ErrNotFound)
+
+		if reopen != nil {
+			store = reopen()
+		}
+		webhooks, err := store.Webhooks(ctx, 1)
+		require.NoError(t, err)
+		require.Len(t, webhooks, 1)
+		require.Equal(t, webhook, webhooks[0])
+		thresholds, err := store.ClickThresholds(ctx)
+		require.NoError(t, err)
+		require.Equal(t, []int{10, 100}, thresholds)
+		found, err := store.Webhook(ctx, other.ID)
+		require.NoError(t, err)
+		require.Equal(t, 2, found.UserID)
+		_, err = store.Webhook(ctx, other.ID+100)
+		require.ErrorIs(t, err, ErrNotFound)
+
+		deliveries, err := store.Deliveries(ctx, webhook.ID)
+		require.NoError(t, err)
+		require.Len(t, deliveries, 2)
+		require.Equal(t, first.ID, deliveries[1].ID, "latest deliveries first")
+		require.Equal(t, 1, deliveries[1].Attempts)
+		require.Equal(t, 500, deliveries[1].ResponseCode)
+		require.Equal(t, "unexpected status", deliveries[1].Error)
+		require.WithinDuration(t, later, *deliveries[1].NextAttemptAt, time.Millisecond)
+
+		require.NoError(t, store.DeleteWebhook(ctx, webhook.ID))
+		require.ErrorIs(t, store.DeleteWebhook(ctx, webhook.ID), ErrNotFound)
+		deliveries, err = store.Deliveries(ctx, webhook.ID)
+		require.NoError(t, err)
+		require.Empty(t, deliveries)
+	})
+
+	t.Run("delivery log size", func(t *testing.T) {
+		store, _ := factory(t)
+		ctx := userContext(1)
+
+		webhook, err := store.CreateWebhook(ctx, models.Webhook{UserID: 1, URL: "https://crm.example.com/hook", Secret: "0123456789abcdef",
+			Events: []string{"link.created"}})
+		require.NoError(t, err)
+		pending, err := store.AddDelivery(ctx, models.WebhookDelivery{WebhookID: webhook.ID, Event: "link.created", Payload: `{}`, Status: DeliveryPending})
+		require.NoError(t, err)
+		for i := 0; i < DeliveryLogSize+5; i++ {
+			_, err := store.AddDelivery(ctx, models.WebhookDelivery{WebhookID: webhook.ID, Event: "link.created", Payload: `{}`, Status: DeliveryDelivered})
+			require.NoError(t, err)
+		}
+
+		deliveries, err := store.Deliveries(ctx, webhook.ID)
+		require.NoError(t, err)
+		require.Len(t, deliveries, DeliveryLogSize+1, "pending deliveries are kept")
+		require.Equal(t, pending.ID, deliveries[len(deliveries)-1].ID)
+	})
+
+	t.Run("outbox", func(t *testing.T) {
+		store, reopen := factory(t)
+		ctx := userContext(1)
+
+		require.NoError(t, store.SaveData(ctx, "before", "https://ya.ru/before"))
+		Outbox = true
+		defer func() { Outbox = false }()
+
+		require.NoError(t, store.SaveData(ctx, "first", "https://ya.ru/first"))
+		require.NoError(t, store.SaveRecord(ctx, models.URLData{ShortURL: "second", OriginalURL: "https://ya.ru/second", UserID: 2, Domain: "go.example.com"}))
+		_, err := store.RecordClick(ctx, "", "first", 1)
+		require.NoError(t, err)
+		require.NoError(t, store.DeleteData("", "first"))
+		require.NoError(t, store.DeleteData("", "first"), "repeated delete writes no event")
+		require.Error(t, store.SaveData(ctx, "first", "https://ya.ru/other"), "failed save writes no event")
+
+		events, err := store.OutboxEvents(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, events, 4)
+		for i, want := range []struct {
+			event    string
+			domain   string
+			shortURL string
+			userID   int
+		}{
+			{OutboxLinkCreated, "", "first", 1},
+			{OutboxLinkCreated, "go.example.com", "second", 2},
+			{OutboxLinkClicked, "", "first", 1},
+			{OutboxLinkDeleted, "", "first", 1},
+		} {
+			require.Equal(t, want.event, events[i].Event)
+			require.Equal(t, want.domain, events[i].Domain)
+			require.Equal(t, want.shortURL, events[i].ShortURL)
+			require.Equal(t, want.userID, events[i].UserID)
+			require.False(t, events[i].CreatedAt.IsZero())
+			if i > 0 {
+				require.Greater(t, events[i].ID, events[i-1].ID)
+			}
+		}
+		require.Equal(t, "https://ya.ru/first", events[0].OriginalURL)
+		require.Nil(t, events[0].Variant)
+		require.Equal(t, 1, *events[2].Variant)
+		require.Equal(t, "https://ya.ru/first", events[3].OriginalURL)
+
+		if reopen != nil {
+			store = reopen()
+			reopened, err := store.OutboxEvents(ctx, 10)
+			require.NoError(t, err)
+			require.Equal(t, events, reopened, "unpublished events survive a restart")
+		}
+
+		page, err := store.OutboxEvents(ctx, 2)
+		require.NoError(t, err)
+		require.Equal(t, events[:2], page)
+		require.NoError(t, store.DeleteOutbox(ctx, []int64{events[0].ID, events[1].ID}))
+		rest, err := store.OutboxEvents(ctx, 10)
+		require.NoError(t, err)
+		require.Equal(t, events[2:], rest)
+		require.NoError(t, store.DeleteOutbox(ctx, []int64{events[3].ID}))
+		rest, err = store.OutboxEvents(ctx, 10)
+		require.NoError(t, err)
+		require.Equal(t, events[2:3], rest, "only the given ids are deleted")
+		require.NoError(t, store.DeleteOutbox(ctx, []int64{events[2].ID}))
+		rest, err = store.OutboxEvents(ctx, 10)
+		require.NoError(t, err)
+		require.Empty(t, rest)
+
+		if reopen != nil {
+			store = reopen()
+		}
+		require.NoError(t, store.SaveData(ctx, "third", "https://ya.ru/third"))
+		rest, err = store.OutboxEvents(ctx, 10)
+		require.NoError(t, err)
+		require.Len(t, rest, 1)
+		require.Greater(t, rest[0].ID, events[3].ID, "event ids are not reused")
+	})
+
+	t.Run("persistence across reopen", func(t *testing.T) {
+		store, reopen := factory(t)
+		if reopen == nil {
+			t.Skip("store is not persistent")
+		}
+
+		require.NoError(t, store.SaveData(userContext(1), "key", "https://ya.ru"))
+		require.NoError(t, store.SaveData(userContext(1), "deleted", "https://google.com"))
+		require.NoError(t, store.DeleteData("", "deleted"))
+
+		reopened := reopen()
+		value, err := reopened.GetData(userContext(1), "", "key")
+		require.NoError(t, err)
+		require.Equal(t, "https://ya.ru", value)
+
+		_, err = reopened.GetData(userContext(1), "", "deleted")
+		var deleteErr *DBDeleteError
+		require.True(t, errors.As(err, &deleteErr))
+
+		var dbErr *DBError
+		require.True(t, errors.As(reopened.SaveData(userContext(2), "other", "https://ya.ru"), &dbErr))
+		require.NoError(t, reopened.SaveData(userContext(2), "new", "https://go.dev"))
+
+		urls, _, err := reopened.ListData(context.Background(), 2, ListOptions{})
+		require.NoError(t, err)
+		require.Len(t, urls, 1)
+	})
+}
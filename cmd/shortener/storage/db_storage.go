@@ -3,20 +3,33 @@ package storage
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/fngoc/url-shortener/cmd/shortener/config"
-	"github.com/fngoc/url-shortener/cmd/shortener/constants"
 	"github.com/fngoc/url-shortener/internal/logger"
 	"github.com/fngoc/url-shortener/internal/models"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5/pgconn"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"strings"
 	"time"
 )
 
 type DBStore struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect dialect
+}
+
+// dialect описывает различия между SQL-бэкендами, использующими общую схему url_shortener
+type dialect struct {
+	// migrations миграции схемы, номер версии миграции равен её индексу плюс один.
+	// Применённые версии хранятся в таблице schema_migrations
+	migrations []string
+	// conflictError возвращает ошибку нарушения уникальности в виде *pgconn.PgError,
+	// либо nil, если err не является конфликтом
+	conflictError func(err error) *pgconn.PgError
+	// lockRow суффикс SELECT, блокирующий выбранную строку до конца транзакции
+	lockRow string
 }
 
 type DBError struct {
@@ -36,155 +49,1357 @@ func (d *DBDeleteError) Error() string {
 	return d.Message
 }
 
-var postgresInstant DBStore
+var postgresDialect = dialect{
+	migrations: []string{
+		`CREATE TABLE IF NOT EXISTS url_shortener (
+			uuid SERIAL PRIMARY KEY,
+			short_url VARCHAR NOT NULL UNIQUE,
+			original_url VARCHAR NOT NULL UNIQUE,
+			user_id BIGSERIAL NOT NULL,
+			is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS short_url_idx ON url_shortener (short_url)`,
+		`ALTER TABLE url_shortener ADD COLUMN IF NOT EXISTS expires_at TIMESTAMP NULL`,
+		`ALTER TABLE url_shortener
+			ADD COLUMN IF NOT EXISTS interstitial BOOLEAN NOT NULL DEFAULT FALSE,
+			ADD COLUMN IF NOT EXISTS title VARCHAR NOT NULL DEFAULT ''`,
+		`ALTER TABLE url_shortener ADD COLUMN IF NOT EXISTS password_hash VARCHAR NOT NULL DEFAULT ''`,
+		`ALTER TABLE url_shortener ADD COLUMN IF NOT EXISTS redirect_type INTEGER NOT NULL DEFAULT 0`,
+		`CREATE TABLE IF NOT EXISTS url_history (
+			id SERIAL PRIMARY KEY,
+			short_url VARCHAR NOT NULL,
+			original_url VARCHAR NOT NULL,
+			changed_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS url_history_short_url_idx ON url_history (short_url)`,
+		`ALTER TABLE url_shortener ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP NULL`,
+		`ALTER TABLE url_shortener ADD COLUMN IF NOT EXISTS folder VARCHAR NOT NULL DEFAULT ''`,
+		`CREATE TABLE IF NOT EXISTS url_tags (
+			short_url VARCHAR NOT NULL,
+			tag VARCHAR NOT NULL,
+			PRIMARY KEY (short_url, tag)
+		)`,
+		`CREATE INDEX IF NOT EXISTS url_tags_tag_idx ON url_tags (tag)`,
+		`CREATE TABLE IF NOT EXISTS workspaces (
+			id SERIAL PRIMARY KEY,
+			name VARCHAR NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS workspace_members (
+			workspace_id INTEGER NOT NULL,
+			user_id BIGINT NOT NULL,
+			role VARCHAR NOT NULL,
+			PRIMARY KEY (workspace_id, user_id)
+		)`,
+		`ALTER TABLE url_shortener ADD COLUMN IF NOT EXISTS workspace_id INTEGER NOT NULL DEFAULT 0`,
+		`CREATE INDEX IF NOT EXISTS workspace_id_idx ON url_shortener (workspace_id)`,
+		`ALTER TABLE url_shortener ADD COLUMN IF NOT EXISTS domain VARCHAR NOT NULL DEFAULT ''`,
+		`ALTER TABLE url_shortener
+			DROP CONSTRAINT IF EXISTS url_shortener_short_url_key,
+			DROP CONSTRAINT IF EXISTS url_shortener_original_url_key`,
+		`DROP INDEX IF EXISTS short_url_idx`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS domain_short_url_idx ON url_shortener (domain, short_url)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS domain_original_url_idx ON url_shortener (domain, original_url)`,
+		`ALTER TABLE url_history ADD COLUMN IF NOT EXISTS domain VARCHAR NOT NULL DEFAULT ''`,
+		`DROP INDEX IF EXISTS url_history_short_url_idx`,
+		`CREATE INDEX IF NOT EXISTS url_history_link_idx ON url_history (domain, short_url)`,
+		`ALTER TABLE url_tags ADD COLUMN IF NOT EXISTS domain VARCHAR NOT NULL DEFAULT ''`,
+		`ALTER TABLE url_tags DROP CONSTRAINT IF EXISTS url_tags_pkey, ADD PRIMARY KEY (domain, short_url, tag)`,
+		`ALTER TABLE url_shortener ADD COLUMN IF NOT EXISTS rules VARCHAR NOT NULL DEFAULT ''`,
+		`ALTER TABLE url_shortener ADD COLUMN IF NOT EXISTS variants VARCHAR NOT NULL DEFAULT ''`,
+		`CREATE TABLE IF NOT EXISTS link_clicks (
+			domain VARCHAR NOT NULL,
+			short_url VARCHAR NOT NULL,
+			variant INTEGER NOT NULL,
+			clicks BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (domain, short_url, variant)
+		)`,
+		`ALTER TABLE url_shortener ADD COLUMN IF NOT EXISTS utm VARCHAR NOT NULL DEFAULT ''`,
+		`ALTER TABLE workspaces ADD COLUMN IF NOT EXISTS utm VARCHAR NOT NULL DEFAULT ''`,
+		`ALTER TABLE url_shortener ADD COLUMN IF NOT EXISTS prefix_mode BOOLEAN NOT NULL DEFAULT FALSE`,
+		`CREATE TABLE IF NOT EXISTS webhooks (
+			id SERIAL PRIMARY KEY,
+			user_id BIGINT NOT NULL,
+			url VARCHAR NOT NULL,
+			secret VARCHAR NOT NULL,
+			events VARCHAR NOT NULL DEFAULT '',
+			click_threshold INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id)`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id SERIAL PRIMARY KEY,
+			webhook_id INTEGER NOT NULL,
+			event VARCHAR NOT NULL,
+			payload VARCHAR NOT NULL,
+			status VARCHAR NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			response_code INTEGER NOT NULL DEFAULT 0,
+			error VARCHAR NOT NULL DEFAULT '',
+			test BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP NOT NULL,
+			next_attempt_at TIMESTAMP NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id)`,
+		`CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (status, next_attempt_at)`,
+		`CREATE TABLE IF NOT EXISTS link_outbox (
+			id BIGSERIAL PRIMARY KEY,
+			event VARCHAR NOT NULL,
+			domain VARCHAR NOT NULL DEFAULT '',
+			short_url VARCHAR NOT NULL,
+			original_url VARCHAR NOT NULL DEFAULT '',
+			user_id BIGINT NOT NULL DEFAULT 0,
+			variant INTEGER NULL,
+			created_at TIMESTAMP NOT NULL
+		)`,
+	},
+	lockRow: " FOR UPDATE",
+	conflictError: func(err error) *pgconn.PgError {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
+			return pgErr
+		}
+		return nil
+	},
+}
+
+var dbInstant DBStore
 
+// InitializeDB инициализирует хранилище в БД. По умолчанию используется Postgres,
+// DSN вида sqlite:///path/to.db выбирает SQLite
 func InitializeDB(dbConf string) error {
+	if strings.HasPrefix(dbConf, sqliteScheme) {
+		return InitializeSQLite(strings.TrimPrefix(dbConf, sqliteScheme))
+	}
+
+	dbs, err := newPostgresStore(dbConf)
+	if err != nil {
+		return err
+	}
+	dbInstant = dbs
+	Store = dbInstant
+	return nil
+}
+
+func newPostgresStore(dbConf string) (DBStore, error) {
 	pqx, err := sql.Open("pgx", dbConf)
+	if err != nil {
+		return DBStore{}, err
+	}
+
+	dbs := DBStore{db: pqx, dialect: postgresDialect}
+	if err := dbs.createTables(); err != nil {
+		return DBStore{}, err
+	}
+	return dbs, nil
+}
+
+func (dbs DBStore) GetData(ctx context.Context, domain string, key string) (string, error) {
+	record, err := dbs.GetRecord(ctx, domain, key)
+	if err != nil {
+		return "", err
+	}
+	return record.OriginalURL, nil
+}
+
+func (dbs DBStore) GetRecord(ctx context.Context, domain string, key string) (models.URLData, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	row := dbs.db.QueryRowContext(dbCtx, "SELECT "+recordColumns+" FROM url_shortener WHERE domain = $1 AND short_url = $2", domain, key)
+	record, err := scanRecord(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.URLData{}, fmt.Errorf("data by key: %s, %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return models.URLData{}, err
+	}
+	if err := checkActive(record); err != nil {
+		return models.URLData{}, err
+	}
+	records := []models.URLData{record}
+	if err := loadTags(dbCtx, dbs.db, records); err != nil {
+		return models.URLData{}, err
+	}
+	return records[0], nil
+}
+
+func (dbs DBStore) FindRecord(ctx context.Context, domain string, key string) (models.URLData, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	row := dbs.db.QueryRowContext(dbCtx, "SELECT "+recordColumns+" FROM url_shortener WHERE domain = $1 AND short_url = $2", domain, key)
+	record, err := scanRecord(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.URLData{}, fmt.Errorf("data by key: %s, %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return models.URLData{}, err
+	}
+	records := []models.URLData{record}
+	if err := loadTags(dbCtx, dbs.db, records); err != nil {
+		return models.URLData{}, err
+	}
+	return records[0], nil
+}
+
+func (dbs DBStore) ListData(ctx context.Context, userID int, opts ListOptions) ([]models.URLData, string, error) {
+	column, desc, err := opts.sortColumn()
+	if err != nil {
+		return nil, "", err
+	}
+	cursor, err := opts.decodeCursor()
+	if err != nil {
+		return nil, "", err
+	}
+
+	conditions := []string{"user_id = $1 AND workspace_id = 0"}
+	args := []any{userID}
+	if opts.WorkspaceID != 0 {
+		conditions = []string{"workspace_id = $1"}
+		args = []any{opts.WorkspaceID}
+	}
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	if opts.CreatedFrom != nil {
+		addCondition("created_at >= $%d", storedTime(*opts.CreatedFrom))
+	}
+	if opts.CreatedTo != nil {
+		addCondition("created_at < $%d", storedTime(*opts.CreatedTo))
+	}
+	if opts.Deleted != nil {
+		addCondition("is_deleted = $%d", *opts.Deleted)
+	}
+	if opts.Query != "" {
+		addCondition(`LOWER(original_url) LIKE $%d ESCAPE '\'`, "%"+escapeLike(strings.ToLower(opts.Query))+"%")
+	}
+	if opts.Tag != "" {
+		addCondition("(domain, short_url) IN (SELECT domain, short_url FROM url_tags WHERE tag = $%d)", opts.Tag)
+	}
+	if opts.Folder != "" {
+		addCondition("folder = $%d", opts.Folder)
+	}
+
+	op, direction := ">", "ASC"
+	if desc {
+		op, direction = "<", "DESC"
+	}
+	if cursor != nil {
+		var value any = cursor.Value
+		if column == "created_at" {
+			t, err := time.Parse(time.RFC3339Nano, cursor.Value)
+			if err != nil {
+				return nil, "", ErrInvalidListOptions
+			}
+			value = t
+		}
+		args = append(args, value, cursor.UUID)
+		conditions = append(conditions, fmt.Sprintf("(%[1]s %[2]s $%[3]d OR (%[1]s = $%[3]d AND uuid %[2]s $%[4]d))",
+			column, op, len(args)-1, len(args)))
+	}
+
+	query := "SELECT " + recordColumns + " FROM url_shortener WHERE " + strings.Join(conditions, " AND ") +
+		fmt.Sprintf(" ORDER BY %s %s, uuid %s", column, direction, direction)
+	if opts.Limit > 0 {
+		args = append(args, opts.Limit+1)
+		query += fmt.Sprintf(" LIMIT $%d", len(args))
+	}
+
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := dbs.db.QueryContext(dbCtx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	result := make([]models.URLData, 0)
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		result = append(result, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	if err := loadTags(dbCtx, dbs.db, result); err != nil {
+		return nil, "", err
+	}
+	return pageOf(result, opts.Limit, column, desc)
+}
+
+// escapeLike экранирует спецсимволы шаблона LIKE
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
+
+func (dbs DBStore) SaveData(ctx context.Context, id string, value string) error {
+	return dbs.SaveRecord(ctx, models.URLData{
+		ShortURL:    id,
+		OriginalURL: value,
+		UserID:      userIDFromContext(ctx),
+	})
+}
+
+func (dbs DBStore) SaveRecord(ctx context.Context, record models.URLData) error {
+	if record.ShortURL == "" || record.OriginalURL == "" {
+		return fmt.Errorf("key or value is empty")
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := dbs.db.BeginTx(dbCtx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	rules, err := encodeList(record.Rules)
+	if err != nil {
+		return err
+	}
+	variants, err := encodeList(record.Variants)
 	if err != nil {
 		return err
 	}
+	utm, err := encodeMap(record.UTM)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(dbCtx, `INSERT INTO url_shortener(short_url, original_url, user_id, created_at, expires_at, interstitial, password_hash, redirect_type, folder, workspace_id, domain, rules, variants, utm, prefix_mode)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15)`,
+		record.ShortURL, record.OriginalURL, record.UserID, storedTime(time.Now()), nullTime(record.ExpiresAt), record.Interstitial, record.PasswordHash,
+		record.RedirectType, record.Folder, record.WorkspaceID, record.Domain, rules, variants, utm, record.PrefixMode)
+	if err != nil {
+		// saveError читает таблицу вне транзакции, а соединение SQLite единственное
+		_ = tx.Rollback()
+		return dbs.saveError(ctx, err, record)
+	}
+	if err := saveTags(dbCtx, tx, record.Domain, record.ShortURL, record.Tags); err != nil {
+		return err
+	}
+	if err := addOutbox(dbCtx, tx, models.OutboxEvent{Event: OutboxLinkCreated, Domain: record.Domain, ShortURL: record.ShortURL,
+		OriginalURL: record.OriginalURL, UserID: record.UserID}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
 
-	postgresInstant.db = pqx
+func (dbs DBStore) UpdateTitle(ctx context.Context, domain string, shortURL string, title string) error {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
 
-	if err := createTables(pqx); err != nil {
+	result, err := dbs.db.ExecContext(dbCtx, "UPDATE url_shortener SET title = $1 WHERE domain = $2 AND short_url = $3", title, domain, shortURL)
+	if err != nil {
 		return err
 	}
-	Store = postgresInstant
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("data by key: %s, %w", shortURL, ErrNotFound)
+	}
 	return nil
 }
 
-func (dbs DBStore) GetData(ctx context.Context, key string) (string, error) {
+func (dbs DBStore) UpdateRecord(ctx context.Context, domain string, shortURL string, changes LinkChanges) (models.URLData, error) {
 	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	row := dbs.db.QueryRowContext(dbCtx, "SELECT original_url, is_deleted FROM url_shortener WHERE short_url = $1", key)
-	var originalURL string
-	var deleteFlag bool
-
-	err := row.Scan(&originalURL, &deleteFlag)
+	tx, err := dbs.db.BeginTx(dbCtx, nil)
 	if err != nil {
-		return "", err
+		return models.URLData{}, err
 	}
+	defer tx.Rollback()
 
-	if deleteFlag {
-		return "", &DBDeleteError{
+	row := tx.QueryRowContext(dbCtx, "SELECT "+recordColumns+" FROM url_shortener WHERE domain = $1 AND short_url = $2"+dbs.dialect.lockRow, domain, shortURL)
+	record, err := scanRecord(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.URLData{}, fmt.Errorf("data by key: %s, %w", shortURL, ErrNotFound)
+	}
+	if err != nil {
+		return models.URLData{}, err
+	}
+	if record.IsDeleted {
+		return models.URLData{}, &DBDeleteError{
 			Message: "shortener is already deleted",
 		}
 	}
+	records := []models.URLData{record}
+	if err := loadTags(dbCtx, tx, records); err != nil {
+		return models.URLData{}, err
+	}
+	record = records[0]
 
-	return originalURL, nil
+	previous := record.OriginalURL
+	if changes.apply(&record) {
+		if _, err := tx.ExecContext(dbCtx, "INSERT INTO url_history(domain, short_url, original_url, changed_at) VALUES ($1, $2, $3, $4)",
+			domain, shortURL, previous, storedTime(time.Now())); err != nil {
+			return models.URLData{}, err
+		}
+	}
+	rules, err := encodeList(record.Rules)
+	if err != nil {
+		return models.URLData{}, err
+	}
+	variants, err := encodeList(record.Variants)
+	if err != nil {
+		return models.URLData{}, err
+	}
+	utm, err := encodeMap(record.UTM)
+	if err != nil {
+		return models.URLData{}, err
+	}
+	_, err = tx.ExecContext(dbCtx, `UPDATE url_shortener SET original_url = $1, expires_at = $2, redirect_type = $3, title = $4, folder = $5, rules = $6, variants = $7, utm = $8,
+		prefix_mode = $9 WHERE uuid = $10`, record.OriginalURL, nullTime(record.ExpiresAt), record.RedirectType, record.Title, record.Folder, rules, variants, utm,
+		record.PrefixMode, record.UUID)
+	if err != nil {
+		// транзакция держит соединение, а у SQLite оно единственное: откатываем до поиска конфликтующей ссылки
+		_ = tx.Rollback()
+		return models.URLData{}, dbs.saveError(ctx, err, record)
+	}
+	if changes.Tags != nil {
+		if err := saveTags(dbCtx, tx, domain, shortURL, record.Tags); err != nil {
+			return models.URLData{}, err
+		}
+	}
+	return record, tx.Commit()
 }
 
-func (dbs DBStore) GetAllData(ctx context.Context) ([]models.ResponseDto, error) {
+func (dbs DBStore) History(ctx context.Context, domain string, shortURL string) ([]models.HistoryEntry, error) {
 	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	userID := ctx.Value(constants.UserIDKey).(int)
-	rows, err := dbs.db.QueryContext(dbCtx, "SELECT short_url, original_url FROM url_shortener WHERE user_id = $1", userID)
+	var exists int
+	err := dbs.db.QueryRowContext(dbCtx, "SELECT 1 FROM url_shortener WHERE domain = $1 AND short_url = $2", domain, shortURL).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, fmt.Errorf("data by key: %s, %w", shortURL, ErrNotFound)
+	}
 	if err != nil {
 		return nil, err
 	}
-	if rows.Err() != nil {
-		return nil, rows.Err()
+
+	rows, err := dbs.db.QueryContext(dbCtx, "SELECT original_url, changed_at FROM url_history WHERE domain = $1 AND short_url = $2 ORDER BY id DESC",
+		domain, shortURL)
+	if err != nil {
+		return nil, err
 	}
+	defer rows.Close()
 
-	var result []models.ResponseDto
+	history := make([]models.HistoryEntry, 0)
 	for rows.Next() {
-		var shortURL string
-		var originalURL string
-
-		if err := rows.Scan(&shortURL, &originalURL); err != nil {
+		var entry models.HistoryEntry
+		if err := rows.Scan(&entry.OriginalURL, &entry.ChangedAt); err != nil {
 			return nil, err
 		}
-
-		result = append(result, models.ResponseDto{
-			ShortURL:    config.Flags.BaseResultAddress + "/" + shortURL,
-			OriginalURL: originalURL,
-		})
+		entry.ChangedAt = entry.ChangedAt.UTC()
+		history = append(history, entry)
 	}
-	return result, nil
+	return history, rows.Err()
 }
 
-func (dbs DBStore) SaveData(ctx context.Context, id string, value string) error {
-	if id == "" || value == "" {
-		return fmt.Errorf("key or value is empty")
+func (dbs DBStore) RecordClick(ctx context.Context, domain string, shortURL string, variant int) (int, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := dbs.db.BeginTx(dbCtx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// строка ссылки блокируется до подсчёта: переходы на разные варианты иначе видят одну сумму
+	// и порог переходов срабатывает дважды или пропускается, а события ссылки получают ID не в порядке фиксации
+	var userID int
+	if err := tx.QueryRowContext(dbCtx, "SELECT user_id FROM url_shortener WHERE domain = $1 AND short_url = $2"+dbs.dialect.lockRow,
+		domain, shortURL).Scan(&userID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(dbCtx, `INSERT INTO link_clicks(domain, short_url, variant, clicks) VALUES ($1, $2, $3, 1)
+		ON CONFLICT (domain, short_url, variant) DO UPDATE SET clicks = link_clicks.clicks + 1`, domain, shortURL, variant); err != nil {
+		return 0, err
 	}
+	var total int
+	if err := tx.QueryRowContext(dbCtx, "SELECT COALESCE(SUM(clicks), 0) FROM link_clicks WHERE domain = $1 AND short_url = $2",
+		domain, shortURL).Scan(&total); err != nil {
+		return 0, err
+	}
+	if err := addOutbox(dbCtx, tx, models.OutboxEvent{Event: OutboxLinkClicked, Domain: domain, ShortURL: shortURL,
+		UserID: userID, Variant: &variant}); err != nil {
+		return 0, err
+	}
+	return total, tx.Commit()
+}
+
+func (dbs DBStore) Clicks(ctx context.Context, domain string, shortURL string) (map[int]int, error) {
 	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	userID := ctx.Value(constants.UserIDKey).(int)
-	_, err := dbs.db.ExecContext(dbCtx, "INSERT INTO url_shortener(short_url, original_url, user_id) VALUES ($1, $2, $3)", id, value, userID)
+	rows, err := dbs.db.QueryContext(dbCtx, "SELECT variant, clicks FROM link_clicks WHERE domain = $1 AND short_url = $2", domain, shortURL)
 	if err != nil {
-		var pgErr *pgconn.PgError
+		return nil, err
+	}
+	defer rows.Close()
 
-		if errors.As(err, &pgErr) && pgerrcode.IsIntegrityConstraintViolation(pgErr.Code) {
-			id, repeatingError := postgresInstant.getShortURLByOriginalURL(ctx, value)
-			if repeatingError != nil {
-				return repeatingError
-			}
-			return &DBError{
-				ShortURL: id,
-				Err:      pgErr,
-			}
-		} else {
-			return err
+	clicks := make(map[int]int)
+	for rows.Next() {
+		var variant, n int
+		if err := rows.Scan(&variant, &n); err != nil {
+			return nil, err
 		}
+		clicks[variant] = n
 	}
-	return nil
+	return clicks, rows.Err()
 }
 
-func CustomPing() bool {
-	if postgresInstant.db == nil {
-		return false
+// saveError превращает нарушение уникальности в DBError со ссылкой на уже сохранённый original_url,
+// либо в ErrAlreadyExists, если занят сам short_url
+func (dbs DBStore) saveError(ctx context.Context, err error, record models.URLData) error {
+	pgErr := dbs.dialect.conflictError(err)
+	if pgErr == nil {
+		return err
+	}
+	id, repeatingError := dbs.getShortURLByOriginalURL(ctx, record.Domain, record.OriginalURL)
+	if errors.Is(repeatingError, sql.ErrNoRows) {
+		return fmt.Errorf("data by key: %s, %w", record.ShortURL, ErrAlreadyExists)
+	}
+	if repeatingError != nil {
+		return repeatingError
+	}
+	return &DBError{
+		ShortURL: id,
+		Err:      pgErr,
 	}
-	err := postgresInstant.db.Ping()
-	return err == nil
 }
 
-func (dbs DBStore) DeleteData(userID int, url string) error {
-	query := "UPDATE url_shortener SET is_deleted = true WHERE short_url = ($1) AND user_id = ($2);"
-	_, err := dbs.db.Exec(query, url, userID)
-	if err != nil {
-		return err
+// recordColumns колонки url_shortener в порядке, который ожидает scanRecord
+const recordColumns = "uuid, short_url, original_url, user_id, is_deleted, created_at, expires_at, interstitial, title, password_hash, redirect_type, deleted_at, folder, workspace_id, domain, rules, variants, utm, prefix_mode"
+
+// scanRecord читает строку, выбранную по recordColumns
+func scanRecord(row interface{ Scan(...any) error }) (models.URLData, error) {
+	var record models.URLData
+	var createdAt, expiresAt, deletedAt sql.NullTime
+	var rules, variants, utm string
+	if err := row.Scan(&record.UUID, &record.ShortURL, &record.OriginalURL, &record.UserID, &record.IsDeleted, &createdAt, &expiresAt,
+		&record.Interstitial, &record.Title, &record.PasswordHash, &record.RedirectType, &deletedAt, &record.Folder, &record.WorkspaceID,
+		&record.Domain, &rules, &variants, &utm, &record.PrefixMode); err != nil {
+		return models.URLData{}, err
 	}
-	return nil
+	if err := decodeJSON(rules, &record.Rules); err != nil {
+		return models.URLData{}, fmt.Errorf("rules of %s: %w", record.ShortURL, err)
+	}
+	if err := decodeJSON(variants, &record.Variants); err != nil {
+		return models.URLData{}, fmt.Errorf("variants of %s: %w", record.ShortURL, err)
+	}
+	if err := decodeJSON(utm, &record.UTM); err != nil {
+		return models.URLData{}, fmt.Errorf("utm of %s: %w", record.ShortURL, err)
+	}
+	record.CreatedAt = createdAt.Time.UTC()
+	if expiresAt.Valid {
+		expires := expiresAt.Time.UTC()
+		record.ExpiresAt = &expires
+	}
+	if deletedAt.Valid {
+		deleted := deletedAt.Time.UTC()
+		record.DeletedAt = &deleted
+	}
+	return record, nil
+}
+
+// encodeList сериализует список в JSON для текстовой колонки, пустой список хранится пустой строкой
+func encodeList[T any](items []T) (string, error) {
+	if len(items) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(items)
+	return string(data), err
 }
 
-func createTables(db *sql.DB) error {
-	createTableQuery := `
-	CREATE TABLE IF NOT EXISTS url_shortener (
-		uuid SERIAL PRIMARY KEY,
-		short_url VARCHAR NOT NULL UNIQUE,
-		original_url VARCHAR NOT NULL UNIQUE,
-		user_id BIGSERIAL NOT NULL,
-		is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
-		created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-	);`
-	createIndexQuery := `CREATE UNIQUE INDEX IF NOT EXISTS short_url_idx ON url_shortener (short_url)`
+// encodeMap сериализует словарь в JSON для текстовой колонки, пустой словарь хранится пустой строкой
+func encodeMap[V any](m map[string]V) (string, error) {
+	if len(m) == 0 {
+		return "", nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+// decodeJSON читает значение, сохранённое encodeList или encodeMap
+func decodeJSON(data string, v any) error {
+	if data == "" {
+		return nil
+	}
+	return json.Unmarshal([]byte(data), v)
+}
 
-	_, err := db.ExecContext(ctx, createTableQuery)
+func (dbs DBStore) ExportData(ctx context.Context, fn func(models.URLData) error) error {
+	tags, err := dbs.allTags(ctx)
+	if err != nil {
+		return err
+	}
+	history, err := dbs.allHistory(ctx)
 	if err != nil {
 		return err
 	}
-	_, errIdx := db.ExecContext(ctx, createIndexQuery)
-	if errIdx != nil {
+	rows, err := dbs.db.QueryContext(ctx, "SELECT "+recordColumns+" FROM url_shortener ORDER BY uuid")
+	if err != nil {
 		return err
 	}
-	logger.Log.Info("Database table created")
-	return nil
+	defer rows.Close()
+
+	for rows.Next() {
+		record, err := scanRecord(rows)
+		if err != nil {
+			return err
+		}
+		record.Tags = tags[linkKey(record.Domain, record.ShortURL)]
+		record.History = history[linkKey(record.Domain, record.ShortURL)]
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
 }
 
-func (dbs DBStore) getShortURLByOriginalURL(ctx context.Context, originalURL string) (string, error) {
+func (dbs DBStore) ImportData(ctx context.Context, record models.URLData) error {
+	if record.ShortURL == "" || record.OriginalURL == "" {
+		return fmt.Errorf("key or value is empty")
+	}
 	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
 
-	row := dbs.db.QueryRowContext(dbCtx, "SELECT short_url FROM url_shortener WHERE original_url = $1", originalURL)
-	var original string
-	if err := row.Scan(&original); err != nil {
-		return "", err
+	createdAt := record.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
 	}
-	return original, nil
+	query := `INSERT INTO url_shortener(short_url, original_url, user_id, is_deleted, created_at, expires_at, interstitial, title, password_hash, redirect_type, deleted_at, folder, workspace_id, domain, rules, variants, utm, prefix_mode)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18)
+		ON CONFLICT (domain, short_url) DO UPDATE SET
+			original_url = excluded.original_url,
+			user_id = excluded.user_id,
+			is_deleted = excluded.is_deleted,
+			created_at = excluded.created_at,
+			expires_at = excluded.expires_at,
+			interstitial = excluded.interstitial,
+			title = excluded.title,
+			password_hash = excluded.password_hash,
+			redirect_type = excluded.redirect_type,
+			deleted_at = excluded.deleted_at,
+			folder = excluded.folder,
+			workspace_id = excluded.workspace_id,
+			rules = excluded.rules,
+			variants = excluded.variants,
+			utm = excluded.utm,
+			prefix_mode = excluded.prefix_mode`
+
+	rules, err := encodeList(record.Rules)
+	if err != nil {
+		return err
+	}
+	variants, err := encodeList(record.Variants)
+	if err != nil {
+		return err
+	}
+	utm, err := encodeMap(record.UTM)
+	if err != nil {
+		return err
+	}
+	tx, err := dbs.db.BeginTx(dbCtx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(dbCtx, query, record.ShortURL, record.OriginalURL, record.UserID,
+		record.IsDeleted, storedTime(createdAt), nullTime(record.ExpiresAt), record.Interstitial, record.Title, record.PasswordHash,
+		record.RedirectType, nullTime(record.DeletedAt), record.Folder, record.WorkspaceID, record.Domain, rules, variants, utm, record.PrefixMode)
+	if err != nil {
+		_ = tx.Rollback()
+		return dbs.saveError(ctx, err, record)
+	}
+	if err := saveTags(dbCtx, tx, record.Domain, record.ShortURL, record.Tags); err != nil {
+		return err
+	}
+	if err := saveHistory(dbCtx, tx, record.Domain, record.ShortURL, record.History); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// querier общая часть *sql.DB и *sql.Tx
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// loadTags заполняет Tags записей из url_tags
+func loadTags(ctx context.Context, q querier, records []models.URLData) error {
+	if len(records) == 0 {
+		return nil
+	}
+	index := make(map[string]int, len(records))
+	placeholders := make([]string, 0, len(records))
+	args := make([]any, 0, len(records))
+	for i, record := range records {
+		index[linkKey(record.Domain, record.ShortURL)] = i
+		args = append(args, record.ShortURL)
+		placeholders = append(placeholders, fmt.Sprintf("$%d", len(args)))
+	}
+
+	// выборка по short_url захватывает одноимённые ссылки других доменов, они отбрасываются по index
+	rows, err := q.QueryContext(ctx, "SELECT domain, short_url, tag FROM url_tags WHERE short_url IN ("+strings.Join(placeholders, ", ")+") ORDER BY tag", args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var domain, shortURL, tag string
+		if err := rows.Scan(&domain, &shortURL, &tag); err != nil {
+			return err
+		}
+		if i, ok := index[linkKey(domain, shortURL)]; ok {
+			records[i].Tags = append(records[i].Tags, tag)
+		}
+	}
+	return rows.Err()
+}
+
+// allTags возвращает метки всех ссылок по linkKey
+func (dbs DBStore) allTags(ctx context.Context) (map[string][]string, error) {
+	rows, err := dbs.db.QueryContext(ctx, "SELECT domain, short_url, tag FROM url_tags ORDER BY domain, short_url, tag")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := make(map[string][]string)
+	for rows.Next() {
+		var domain, shortURL, tag string
+		if err := rows.Scan(&domain, &shortURL, &tag); err != nil {
+			return nil, err
+		}
+		key := linkKey(domain, shortURL)
+		tags[key] = append(tags[key], tag)
+	}
+	return tags, rows.Err()
+}
+
+// allHistory возвращает прежние адреса назначения всех ссылок по linkKey в порядке замены
+func (dbs DBStore) allHistory(ctx context.Context) (map[string][]models.HistoryEntry, error) {
+	rows, err := dbs.db.QueryContext(ctx, "SELECT domain, short_url, original_url, changed_at FROM url_history ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := make(map[string][]models.HistoryEntry)
+	for rows.Next() {
+		var domain, shortURL string
+		var entry models.HistoryEntry
+		if err := rows.Scan(&domain, &shortURL, &entry.OriginalURL, &entry.ChangedAt); err != nil {
+			return nil, err
+		}
+		entry.ChangedAt = entry.ChangedAt.UTC()
+		key := linkKey(domain, shortURL)
+		history[key] = append(history[key], entry)
+	}
+	return history, rows.Err()
+}
+
+// saveHistory заменяет историю ссылки shortURL домена domain, записи сохраняются в порядке замены
+func saveHistory(ctx context.Context, q querier, domain string, shortURL string, history []models.HistoryEntry) error {
+	if _, err := q.ExecContext(ctx, "DELETE FROM url_history WHERE domain = $1 AND short_url = $2", domain, shortURL); err != nil {
+		return err
+	}
+	for _, entry := range history {
+		if _, err := q.ExecContext(ctx, "INSERT INTO url_history(domain, short_url, original_url, changed_at) VALUES ($1, $2, $3, $4)",
+			domain, shortURL, entry.OriginalURL, storedTime(entry.ChangedAt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveTags заменяет метки ссылки shortURL домена domain
+func saveTags(ctx context.Context, q querier, domain string, shortURL string, tags []string) error {
+	if _, err := q.ExecContext(ctx, "DELETE FROM url_tags WHERE domain = $1 AND short_url = $2", domain, shortURL); err != nil {
+		return err
+	}
+	for _, tag := range tags {
+		if _, err := q.ExecContext(ctx, "INSERT INTO url_tags(domain, short_url, tag) VALUES ($1, $2, $3)", domain, shortURL, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// nullTime переводит необязательное время в значение для запроса
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: storedTime(*t), Valid: true}
+}
+
+func CustomPing() bool {
+	if dbInstant.db == nil {
+		return false
+	}
+	err := dbInstant.db.Ping()
+	return err == nil
+}
+
+func (dbs DBStore) DeleteData(domain string, url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID int
+	var originalURL string
+	err = tx.QueryRowContext(ctx, `UPDATE url_shortener SET is_deleted = true, deleted_at = $3
+		WHERE domain = $1 AND short_url = $2 AND NOT is_deleted RETURNING user_id, original_url`,
+		domain, url, storedTime(time.Now())).Scan(&userID, &originalURL)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := addOutbox(ctx, tx, models.OutboxEvent{Event: OutboxLinkDeleted, Domain: domain, ShortURL: url,
+		OriginalURL: originalURL, UserID: userID}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (dbs DBStore) RestoreData(ctx context.Context, domain string, shortURL string, deletedAfter time.Time) (models.URLData, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	row := dbs.db.QueryRowContext(dbCtx, `UPDATE url_shortener SET is_deleted = false, deleted_at = NULL
+		WHERE domain = $1 AND short_url = $2 AND is_deleted AND deleted_at >= $3
+		RETURNING `+recordColumns, domain, shortURL, storedTime(deletedAfter))
+	record, err := scanRecord(row)
+	if err == nil {
+		records := []models.URLData{record}
+		err = loadTags(dbCtx, dbs.db, records)
+		return records[0], err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return models.URLData{}, err
+	}
+
+	var exists int
+	err = dbs.db.QueryRowContext(dbCtx, "SELECT 1 FROM url_shortener WHERE domain = $1 AND short_url = $2", domain, shortURL).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.URLData{}, fmt.Errorf("data by key: %s, %w", shortURL, ErrNotFound)
+	}
+	if err != nil {
+		return models.URLData{}, err
+	}
+	return models.URLData{}, fmt.Errorf("data by key: %s, %w", shortURL, ErrNotRestorable)
+}
+
+func (dbs DBStore) PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error) {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	const purgeable = "is_deleted AND (deleted_at IS NULL OR deleted_at < $1)"
+	for _, table := range []string{"url_history", "url_tags", "link_clicks"} {
+		if _, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE (domain, short_url) IN (SELECT domain, short_url FROM url_shortener WHERE "+purgeable+")",
+			storedTime(deletedBefore)); err != nil {
+			return 0, err
+		}
+	}
+	result, err := tx.ExecContext(ctx, "DELETE FROM url_shortener WHERE "+purgeable, storedTime(deletedBefore))
+	if err != nil {
+		return 0, err
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return int(purged), tx.Commit()
+}
+
+func (dbs DBStore) createTables() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	if _, err := dbs.db.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)"); err != nil {
+		return err
+	}
+	var version int
+	if err := dbs.db.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version); err != nil {
+		return err
+	}
+
+	for i := version; i < len(dbs.dialect.migrations); i++ {
+		if err := dbs.migrate(ctx, i+1, dbs.dialect.migrations[i]); err != nil {
+			return fmt.Errorf("migration %d: %w", i+1, err)
+		}
+	}
+	logger.Log.Info("Database table created")
+	return nil
+}
+
+// migrate применяет одну миграцию и запоминает её версию в одной транзакции
+func (dbs DBStore) migrate(ctx context.Context, version int, query string) error {
+	tx, err := dbs.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations(version) VALUES ($1)", version); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (dbs DBStore) getShortURLByOriginalURL(ctx context.Context, domain string, originalURL string) (string, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	row := dbs.db.QueryRowContext(dbCtx, "SELECT short_url FROM url_shortener WHERE domain = $1 AND original_url = $2", domain, originalURL)
+	var original string
+	if err := row.Scan(&original); err != nil {
+		return "", err
+	}
+	return original, nil
+}
+
+func (dbs DBStore) CreateWorkspace(ctx context.Context, name string, ownerID int) (models.Workspace, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := dbs.db.BeginTx(dbCtx, nil)
+	if err != nil {
+		return models.Workspace{}, err
+	}
+	defer tx.Rollback()
+
+	workspace := models.Workspace{Name: name, CreatedAt: storedTime(time.Now()), Role: RoleOwner}
+	if err := tx.QueryRowContext(dbCtx, "INSERT INTO workspaces(name, created_at) VALUES ($1, $2) RETURNING id",
+		workspace.Name, workspace.CreatedAt).Scan(&workspace.ID); err != nil {
+		return models.Workspace{}, err
+	}
+	if _, err := tx.ExecContext(dbCtx, "INSERT INTO workspace_members(workspace_id, user_id, role) VALUES ($1, $2, $3)",
+		workspace.ID, ownerID, RoleOwner); err != nil {
+		return models.Workspace{}, err
+	}
+	return workspace, tx.Commit()
+}
+
+func (dbs DBStore) Workspace(ctx context.Context, workspaceID int) (models.Workspace, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	workspace := models.Workspace{ID: workspaceID}
+	var utm string
+	err := dbs.db.QueryRowContext(dbCtx, "SELECT name, created_at, utm FROM workspaces WHERE id = $1", workspaceID).
+		Scan(&workspace.Name, &workspace.CreatedAt, &utm)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Workspace{}, fmt.Errorf("workspace: %d, %w", workspaceID, ErrNotFound)
+	}
+	if err != nil {
+		return models.Workspace{}, err
+	}
+	if err := decodeJSON(utm, &workspace.UTM); err != nil {
+		return models.Workspace{}, fmt.Errorf("utm of workspace %d: %w", workspaceID, err)
+	}
+	workspace.CreatedAt = workspace.CreatedAt.UTC()
+	return workspace, nil
+}
+
+func (dbs DBStore) SetWorkspaceUTM(ctx context.Context, workspaceID int, utm map[string]string) error {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	encoded, err := encodeMap(utm)
+	if err != nil {
+		return err
+	}
+	result, err := dbs.db.ExecContext(dbCtx, "UPDATE workspaces SET utm = $1 WHERE id = $2", encoded, workspaceID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("workspace: %d, %w", workspaceID, ErrNotFound)
+	}
+	return nil
+}
+
+func (dbs DBStore) ListWorkspaces(ctx context.Context, userID int) ([]models.Workspace, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := dbs.db.QueryContext(dbCtx, `SELECT w.id, w.name, w.created_at, w.utm, m.role FROM workspaces w
+		JOIN workspace_members m ON m.workspace_id = w.id WHERE m.user_id = $1 ORDER BY w.id`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.Workspace, 0)
+	for rows.Next() {
+		var workspace models.Workspace
+		var utm string
+		if err := rows.Scan(&workspace.ID, &workspace.Name, &workspace.CreatedAt, &utm, &workspace.Role); err != nil {
+			return nil, err
+		}
+		if err := decodeJSON(utm, &workspace.UTM); err != nil {
+			return nil, fmt.Errorf("utm of workspace %d: %w", workspace.ID, err)
+		}
+		workspace.CreatedAt = workspace.CreatedAt.UTC()
+		result = append(result, workspace)
+	}
+	return result, rows.Err()
+}
+
+func (dbs DBStore) MemberRole(ctx context.Context, workspaceID int, userID int) (string, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var role string
+	err := dbs.db.QueryRowContext(dbCtx, "SELECT role FROM workspace_members WHERE workspace_id = $1 AND user_id = $2",
+		workspaceID, userID).Scan(&role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return role, err
+}
+
+func (dbs DBStore) Members(ctx context.Context, workspaceID int) ([]models.Member, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := dbs.workspaceExists(dbCtx, workspaceID); err != nil {
+		return nil, err
+	}
+	rows, err := dbs.db.QueryContext(dbCtx, "SELECT user_id, role FROM workspace_members WHERE workspace_id = $1 ORDER BY user_id", workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.Member, 0)
+	for rows.Next() {
+		var member models.Member
+		if err := rows.Scan(&member.UserID, &member.Role); err != nil {
+			return nil, err
+		}
+		result = append(result, member)
+	}
+	return result, rows.Err()
+}
+
+func (dbs DBStore) SetMemberRole(ctx context.Context, workspaceID int, userID int, role string) error {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	if err := dbs.workspaceExists(dbCtx, workspaceID); err != nil {
+		return err
+	}
+	if role == "" {
+		_, err := dbs.db.ExecContext(dbCtx, "DELETE FROM workspace_members WHERE workspace_id = $1 AND user_id = $2", workspaceID, userID)
+		return err
+	}
+	_, err := dbs.db.ExecContext(dbCtx, `INSERT INTO workspace_members(workspace_id, user_id, role) VALUES ($1, $2, $3)
+		ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = excluded.role`, workspaceID, userID, role)
+	return err
+}
+
+// workspaceExists возвращает ErrNotFound, если рабочего пространства нет
+func (dbs DBStore) workspaceExists(ctx context.Context, workspaceID int) error {
+	var exists int
+	err := dbs.db.QueryRowContext(ctx, "SELECT 1 FROM workspaces WHERE id = $1", workspaceID).Scan(&exists)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("workspace: %d, %w", workspaceID, ErrNotFound)
+	}
+	return err
+}
+
+func (dbs DBStore) CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	events, err := encodeList(webhook.Events)
+	if err != nil {
+		return models.Webhook{}, err
+	}
+	webhook.CreatedAt = storedTime(time.Now())
+	if err := dbs.db.QueryRowContext(dbCtx, `INSERT INTO webhooks(user_id, url, secret, events, click_threshold, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`, webhook.UserID, webhook.URL, webhook.Secret, events,
+		webhook.ClickThreshold, webhook.CreatedAt).Scan(&webhook.ID); err != nil {
+		return models.Webhook{}, err
+	}
+	return webhook, nil
+}
+
+// webhookColumns колонки webhooks в порядке, который ожидает scanWebhook
+const webhookColumns = "id, user_id, url, secret, events, click_threshold, created_at"
+
+// scanWebhook читает строку, выбранную по webhookColumns
+func scanWebhook(row interface{ Scan(...any) error }) (models.Webhook, error) {
+	var webhook models.Webhook
+	var events string
+	if err := row.Scan(&webhook.ID, &webhook.UserID, &webhook.URL, &webhook.Secret, &events, &webhook.ClickThreshold,
+		&webhook.CreatedAt); err != nil {
+		return models.Webhook{}, err
+	}
+	if err := decodeJSON(events, &webhook.Events); err != nil {
+		return models.Webhook{}, fmt.Errorf("events of webhook %d: %w", webhook.ID, err)
+	}
+	webhook.CreatedAt = webhook.CreatedAt.UTC()
+	return webhook, nil
+}
+
+func (dbs DBStore) Webhook(ctx context.Context, webhookID int) (models.Webhook, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	webhook, err := scanWebhook(dbs.db.QueryRowContext(dbCtx, "SELECT "+webhookColumns+" FROM webhooks WHERE id = $1", webhookID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Webhook{}, fmt.Errorf("webhook: %d, %w", webhookID, ErrNotFound)
+	}
+	return webhook, err
+}
+
+func (dbs DBStore) Webhooks(ctx context.Context, userID int) ([]models.Webhook, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := dbs.db.QueryContext(dbCtx, "SELECT "+webhookColumns+" FROM webhooks WHERE user_id = $1 ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.Webhook, 0)
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, webhook)
+	}
+	return result, rows.Err()
+}
+
+func (dbs DBStore) ClickThresholds(ctx context.Context) ([]int, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := dbs.db.QueryContext(dbCtx, "SELECT DISTINCT click_threshold FROM webhooks WHERE click_threshold > 0 ORDER BY click_threshold")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]int, 0)
+	for rows.Next() {
+		var threshold int
+		if err := rows.Scan(&threshold); err != nil {
+			return nil, err
+		}
+		result = append(result, threshold)
+	}
+	return result, rows.Err()
+}
+
+func (dbs DBStore) DeleteWebhook(ctx context.Context, webhookID int) error {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := dbs.db.BeginTx(dbCtx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(dbCtx, "DELETE FROM webhooks WHERE id = $1", webhookID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("webhook: %d, %w", webhookID, ErrNotFound)
+	}
+	if _, err := tx.ExecContext(dbCtx, "DELETE FROM webhook_deliveries WHERE webhook_id = $1", webhookID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (dbs DBStore) AddDelivery(ctx context.Context, delivery models.WebhookDelivery) (models.WebhookDelivery, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	tx, err := dbs.db.BeginTx(dbCtx, nil)
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	defer tx.Rollback()
+
+	delivery.CreatedAt = storedTime(time.Now())
+	delivery.NextAttemptAt = storedTimePtr(delivery.NextAttemptAt)
+	if err := tx.QueryRowContext(dbCtx, `INSERT INTO webhook_deliveries(webhook_id, event, payload, status, attempts, response_code, error, test, created_at, next_attempt_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) RETURNING id`, delivery.WebhookID, delivery.Event, delivery.Payload, delivery.Status,
+		delivery.Attempts, delivery.ResponseCode, delivery.Error, delivery.Test, delivery.CreatedAt, nullTime(delivery.NextAttemptAt)).
+		Scan(&delivery.ID); err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	if _, err := tx.ExecContext(dbCtx, `DELETE FROM webhook_deliveries WHERE webhook_id = $1 AND status <> $2 AND id NOT IN (
+		SELECT id FROM webhook_deliveries WHERE webhook_id = $1 AND status <> $2 ORDER BY id DESC LIMIT $3)`,
+		delivery.WebhookID, DeliveryPending, DeliveryLogSize); err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	return delivery, tx.Commit()
+}
+
+// deliveryColumns колонки webhook_deliveries в порядке, который ожидает scanDeliveries
+const deliveryColumns = "id, webhook_id, event, payload, status, attempts, response_code, error, test, created_at, next_attempt_at"
+
+// scanDeliveries читает строки, выбранные по deliveryColumns
+func scanDeliveries(rows *sql.Rows) ([]models.WebhookDelivery, error) {
+	defer rows.Close()
+
+	result := make([]models.WebhookDelivery, 0)
+	for rows.Next() {
+		var delivery models.WebhookDelivery
+		var nextAttemptAt sql.NullTime
+		if err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.Event, &delivery.Payload, &delivery.Status, &delivery.Attempts,
+			&delivery.ResponseCode, &delivery.Error, &delivery.Test, &delivery.CreatedAt, &nextAttemptAt); err != nil {
+			return nil, err
+		}
+		delivery.CreatedAt = delivery.CreatedAt.UTC()
+		if nextAttemptAt.Valid {
+			next := nextAttemptAt.Time.UTC()
+			delivery.NextAttemptAt = &next
+		}
+		result = append(result, delivery)
+	}
+	return result, rows.Err()
+}
+
+func (dbs DBStore) DueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := dbs.db.QueryContext(dbCtx, "SELECT "+deliveryColumns+` FROM webhook_deliveries
+		WHERE status = $1 AND (next_attempt_at IS NULL OR next_attempt_at <= $2) ORDER BY id LIMIT $3`,
+		DeliveryPending, storedTime(now), limit)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+func (dbs DBStore) UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	result, err := dbs.db.ExecContext(dbCtx, `UPDATE webhook_deliveries SET status = $1, attempts = $2, response_code = $3, error = $4,
+		next_attempt_at = $5 WHERE id = $6`, delivery.Status, delivery.Attempts, delivery.ResponseCode, delivery.Error,
+		nullTime(delivery.NextAttemptAt), delivery.ID)
+	if err != nil {
+		return err
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("delivery: %d, %w", delivery.ID, ErrNotFound)
+	}
+	return nil
+}
+
+func (dbs DBStore) Deliveries(ctx context.Context, webhookID int) ([]models.WebhookDelivery, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := dbs.db.QueryContext(dbCtx, "SELECT "+deliveryColumns+" FROM webhook_deliveries WHERE webhook_id = $1 ORDER BY id DESC", webhookID)
+	if err != nil {
+		return nil, err
+	}
+	return scanDeliveries(rows)
+}
+
+// addOutbox записывает событие ссылки в outbox в транзакции изменения, если запись включена. Транзакция
+// уже держит блокировку строки ссылки: новая строка заблокирована вставкой, изменённая — UPDATE или
+// SELECT с lockRow. Поэтому следующее событие ссылки получает больший ID только после фиксации предыдущего
+func addOutbox(ctx context.Context, q querier, event models.OutboxEvent) error {
+	if !Outbox {
+		return nil
+	}
+	var variant sql.NullInt64
+	if event.Variant != nil {
+		variant = sql.NullInt64{Int64: int64(*event.Variant), Valid: true}
+	}
+	_, err := q.ExecContext(ctx, `INSERT INTO link_outbox(event, domain, short_url, original_url, user_id, variant, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`, event.Event, event.Domain, event.ShortURL, event.OriginalURL, event.UserID,
+		variant, storedTime(time.Now()))
+	return err
+}
+
+func (dbs DBStore) OutboxEvents(ctx context.Context, limit int) ([]models.OutboxEvent, error) {
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	rows, err := dbs.db.QueryContext(dbCtx, `SELECT id, event, domain, short_url, original_url, user_id, variant, created_at
+		FROM link_outbox ORDER BY id LIMIT $1`, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := make([]models.OutboxEvent, 0)
+	for rows.Next() {
+		var event models.OutboxEvent
+		var variant sql.NullInt64
+		if err := rows.Scan(&event.ID, &event.Event, &event.Domain, &event.ShortURL, &event.OriginalURL, &event.UserID,
+			&variant, &event.CreatedAt); err != nil {
+			return nil, err
+		}
+		event.CreatedAt = event.CreatedAt.UTC()
+		if variant.Valid {
+			v := int(variant.Int64)
+			event.Variant = &v
+		}
+		result = append(result, event)
+	}
+	return result, rows.Err()
+}
+
+func (dbs DBStore) DeleteOutbox(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	dbCtx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	placeholders := make([]string, 0, len(ids))
+	args := make([]any, 0, len(ids))
+	for i, id := range ids {
+		placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
+		args = append(args, id)
+	}
+	_, err := dbs.db.ExecContext(dbCtx, "DELETE FROM link_outbox WHERE id IN ("+strings.Join(placeholders, ", ")+")", args...)
+	return err
 }
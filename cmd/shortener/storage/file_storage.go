@@ -2,119 +2,298 @@ package storage
 
 import (
 	"bufio"
-	"context"
 	"encoding/json"
-	"fmt"
-	"github.com/fngoc/url-shortener/cmd/shortener/config"
+	"errors"
 	"github.com/fngoc/url-shortener/internal/logger"
 	"github.com/fngoc/url-shortener/internal/models"
+	"io"
 	"os"
+	"slices"
 )
 
-type FileStore map[string]string
+// FileStore хранилище в памяти, которое дописывает каждое изменение записи в файл.
+// При чтении файла последняя версия записи побеждает. Рабочие пространства, подписки на события,
+// журнал их доставок и неопубликованные события ссылок хранятся в соседних файлах
+type FileStore struct {
+	*LocalStore
+	filePath string
+}
 
-var (
-	fileStorage     FileStore
-	currentUUID     int
-	currentFilePath string
-)
+var fileStorage *FileStore
 
 func InitializeFileLocalStore(filename string) error {
 	logger.Log.Info("Initializing file store")
-	currentFilePath = filename
 
-	if ok, _ := isCreate(currentFilePath); !ok {
-		_, err := os.Create(currentFilePath)
-		if err != nil {
-			return err
+	fs, err := newFileStore(filename)
+	if err != nil {
+		return err
+	}
+	fileStorage = fs
+	Store = fileStorage
+	return nil
+}
+
+func newFileStore(filename string) (*FileStore, error) {
+	fs := &FileStore{
+		LocalStore: newLocalStore(),
+		filePath:   filename,
+	}
+
+	file, err := os.OpenFile(filename, os.O_RDONLY|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// строки читаются декодером, а не bufio.Scanner, чтобы длина записи не была ограничена
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var line fileRecord
+		if err := decoder.Decode(&line); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
 		}
-		fileStorage = make(FileStore)
-		Store = fileStorage
-		return nil
+		if line.HistoryAppend {
+			previous := fs.records[linkKey(line.Domain, line.ShortURL)].History
+			line.History = append(previous[:len(previous):len(previous)], line.History...)
+		}
+		fs.load(line.URLData)
+	}
+
+	if err := fs.loadWorkspaces(); err != nil {
+		return nil, err
+	}
+	if err := fs.loadWebhooks(); err != nil {
+		return nil, err
+	}
+	if err := fs.loadOutbox(); err != nil {
+		return nil, err
+	}
+
+	fs.persist = fs.saveToFile
+	fs.rewrite = fs.rewriteFile
+	fs.persistWorkspaces = fs.saveWorkspaces
+	fs.persistWebhooks = fs.saveWebhooks
+	fs.persistOutbox = fs.saveOutbox
+	fs.rewriteOutbox = fs.rewriteOutboxFile
+	return fs, nil
+}
+
+// fileRecord строка файла ссылок. При HistoryAppend в History только записи, добавленные этим изменением,
+// они дописываются к истории предыдущей версии. Иначе History полная: так пишутся новые ссылки,
+// перезапись файла и импорт, заменивший историю
+type fileRecord struct {
+	models.URLData
+	HistoryAppend bool `json:"history_append,omitempty"`
+}
+
+// saveToFile дописывает версию записи в файл. Вызывается под блокировкой до замены записи в памяти,
+// поэтому история сравнивается с сохранённой версией и в строку попадают только новые записи истории,
+// иначе файл рос бы квадратично числу правок ссылки
+func (fs *FileStore) saveToFile(record models.URLData) error {
+	line := fileRecord{URLData: record}
+	if previous, ok := fs.records[linkKey(record.Domain, record.ShortURL)]; ok &&
+		len(record.History) >= len(previous.History) && slices.Equal(record.History[:len(previous.History)], previous.History) {
+		line.History = record.History[len(previous.History):]
+		line.HistoryAppend = true
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return err
 	}
 
-	file, err := os.OpenFile(currentFilePath, os.O_RDONLY, 0666)
+	file, err := os.OpenFile(fs.filePath, os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
 
-	fileStorage = make(FileStore)
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		saveData := models.URLData{}
-		data := scanner.Bytes()
-		err := json.Unmarshal(data, &saveData)
-		if err != nil {
-			return err
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
+
+// rewriteFile заменяет файл записями records, чтобы в нём не оставалось окончательно удалённых ссылок
+func (fs *FileStore) rewriteFile(records []models.URLData) error {
+	return writeFileAtomic(fs.filePath, func(w *bufio.Writer) error {
+		for _, record := range records {
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if _, err := w.Write(append(data, '\n')); err != nil {
+				return err
+			}
 		}
-		currentUUID = saveData.UUID
-		fileStorage[saveData.ShortURL] = saveData.OriginalURL
+		return nil
+	})
+}
+
+// saveWorkspaces перезаписывает файл рабочих пространств, их немного, поэтому он пишется целиком
+func (fs *FileStore) saveWorkspaces(workspaces []workspaceData) error {
+	return writeFileAtomic(fs.workspacesPath(), func(w *bufio.Writer) error {
+		return json.NewEncoder(w).Encode(workspaces)
+	})
+}
+
+// loadWorkspaces читает файл рабочих пространств, если он есть
+func (fs *FileStore) loadWorkspaces() error {
+	data, err := os.ReadFile(fs.workspacesPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var workspaces []workspaceData
+	if err := json.Unmarshal(data, &workspaces); err != nil {
+		return err
+	}
+	for _, workspace := range workspaces {
+		fs.workspaces[workspace.ID] = workspace
+		fs.workspaceID = max(fs.workspaceID, workspace.ID)
 	}
-	Store = fileStorage
 	return nil
 }
 
-func (fs FileStore) GetData(_ context.Context, key string) (string, error) {
-	if val, ok := fs[key]; ok {
-		return val, nil
-	}
-	return "", fmt.Errorf("data by key: %s, not found", key)
+// workspacesPath файл рабочих пространств рядом с файлом ссылок
+func (fs *FileStore) workspacesPath() string {
+	return fs.filePath + ".workspaces"
 }
 
-func (fs FileStore) GetAllData(_ context.Context) ([]models.ResponseDto, error) {
-	result := make([]models.ResponseDto, 0, len(fs))
-	for key, val := range fs {
-		result = append(result, models.ResponseDto{
-			ShortURL:    config.Flags.BaseResultAddress + "/" + key,
-			OriginalURL: val,
-		})
-	}
-	return result, nil
+// saveWebhooks перезаписывает файл подписок и доставок, журнал каждой подписки ограничен DeliveryLogSize
+func (fs *FileStore) saveWebhooks(data webhookData) error {
+	return writeFileAtomic(fs.webhooksPath(), func(w *bufio.Writer) error {
+		return json.NewEncoder(w).Encode(data)
+	})
 }
 
-func (fs FileStore) SaveData(_ context.Context, key string, value string) error {
-	if key == "" || value == "" {
-		return fmt.Errorf("key or value is empty")
+// loadWebhooks читает файл подписок и доставок, если он есть
+func (fs *FileStore) loadWebhooks() error {
+	raw, err := os.ReadFile(fs.webhooksPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var data webhookData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return err
+	}
+	for _, webhook := range data.Webhooks {
+		fs.webhooks[webhook.ID] = webhook
+		fs.webhookID = max(fs.webhookID, webhook.ID)
 	}
-	if _, ok := fs[key]; ok {
-		return fmt.Errorf("data by key: %s, already exists", key)
+	for _, delivery := range data.Deliveries {
+		fs.deliveries[delivery.ID] = delivery
+		fs.deliveryID = max(fs.deliveryID, delivery.ID)
 	}
-	fs[key] = value
+	return nil
+}
+
+// webhooksPath файл подписок рядом с файлом ссылок
+func (fs *FileStore) webhooksPath() string {
+	return fs.filePath + ".webhooks"
+}
 
-	err := saveToFile(key, value)
+// outboxRecord строка файла неопубликованных событий: событие или последний выданный ID,
+// которым начинается файл после перезаписи
+type outboxRecord struct {
+	LastID int64               `json:"last_id,omitempty"`
+	Event  *models.OutboxEvent `json:"event,omitempty"`
+}
+
+// saveOutbox дописывает событие в файл неопубликованных событий, поэтому переход по ссылке
+// не переписывает весь файл
+func (fs *FileStore) saveOutbox(event models.OutboxEvent) error {
+	data, err := json.Marshal(outboxRecord{Event: &event})
 	if err != nil {
 		return err
 	}
 
-	return nil
+	file, err := os.OpenFile(fs.outboxPath(), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	return err
 }
 
-func (fs FileStore) DeleteData(userID int, url string) error {
-	return fmt.Errorf("not implemented yet")
+// rewriteOutboxFile заменяет файл неопубликованных событий оставшимися событиями, чтобы в нём
+// не копились опубликованные. Ретранслятор удаляет события пачками, поэтому файл остаётся небольшим,
+// пока публикация работает
+func (fs *FileStore) rewriteOutboxFile(data outboxData) error {
+	return writeFileAtomic(fs.outboxPath(), func(w *bufio.Writer) error {
+		encoder := json.NewEncoder(w)
+		if err := encoder.Encode(outboxRecord{LastID: data.LastID}); err != nil {
+			return err
+		}
+		for i := range data.Events {
+			if err := encoder.Encode(outboxRecord{Event: &data.Events[i]}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
-func isCreate(filename string) (bool, error) {
-	file, err := os.Open(filename)
+// loadOutbox читает файл неопубликованных событий, если он есть
+func (fs *FileStore) loadOutbox() error {
+	file, err := os.Open(fs.outboxPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
 	if err != nil {
-		return false, err
+		return err
 	}
 	defer file.Close()
-	return true, nil
+
+	decoder := json.NewDecoder(bufio.NewReader(file))
+	for {
+		var line outboxRecord
+		if err := decoder.Decode(&line); errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return err
+		}
+		fs.outboxID = max(fs.outboxID, line.LastID)
+		if line.Event != nil {
+			fs.outbox = append(fs.outbox, *line.Event)
+			fs.outboxID = max(fs.outboxID, line.Event.ID)
+		}
+	}
+	return nil
 }
 
-func saveToFile(shortURL, OriginalURL string) error {
-	currentUUID += 1
-	saveData := models.URLData{UUID: currentUUID, ShortURL: shortURL, OriginalURL: OriginalURL}
-	data, err := json.Marshal(saveData)
+// outboxPath файл неопубликованных событий рядом с файлом ссылок
+func (fs *FileStore) outboxPath() string {
+	return fs.filePath + ".outbox"
+}
+
+// writeFileAtomic пишет файл path через временный файл рядом и переименование,
+// поэтому при сбое остаётся прежняя версия
+func writeFileAtomic(path string, write func(w *bufio.Writer) error) error {
+	tmpPath := path + ".tmp"
+	file, err := os.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
 		return err
 	}
+	defer os.Remove(tmpPath)
 
-	file, err := os.OpenFile(currentFilePath, os.O_WRONLY|os.O_APPEND, 0666)
-	if err != nil {
+	w := bufio.NewWriter(file)
+	if err := write(w); err != nil {
+		file.Close()
 		return err
 	}
-	_, err = file.WriteString(string(data) + "\n")
-	return err
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
 }
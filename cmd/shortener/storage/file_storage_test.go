@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFileStore_HistoryReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	fs, err := newFileStore(path)
+	require.NoError(t, err)
+	ctx := userContext(1)
+
+	const edits = 300
+	padding := strings.Repeat("a", 1000)
+	require.NoError(t, fs.SaveData(ctx, "key", "https://ya.ru/"+padding+"/0"))
+	for i := 1; i <= edits; i++ {
+		originalURL := "https://ya.ru/" + padding + "/" + strconv.Itoa(i)
+		_, err := fs.UpdateRecord(ctx, "", "key", LinkChanges{OriginalURL: &originalURL})
+		require.NoError(t, err)
+	}
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	// каждая правка дописывает новый адрес и одну запись истории, а не всю историю
+	require.Less(t, info.Size(), int64(edits*4*len(padding)))
+
+	fs, err = newFileStore(path)
+	require.NoError(t, err)
+	history, err := fs.History(ctx, "", "key")
+	require.NoError(t, err)
+	require.Len(t, history, edits)
+	require.True(t, strings.HasSuffix(history[0].OriginalURL, "/"+strconv.Itoa(edits-1)))
+	require.True(t, strings.HasSuffix(history[edits-1].OriginalURL, "/0"))
+	record, err := fs.GetRecord(ctx, "", "key")
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(record.OriginalURL, "/"+strconv.Itoa(edits)))
+}
+
+func TestFileStore_HistoryReplace(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	changedAt := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	// строка в прежнем формате хранит полную историю без history_append
+	old, err := json.Marshal(models.URLData{UUID: 1, ShortURL: "key", OriginalURL: "https://ya.ru/2", UserID: 1,
+		History: []models.HistoryEntry{{OriginalURL: "https://ya.ru/1", ChangedAt: changedAt}}})
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(path, append(old, '\n'), 0666))
+
+	fs, err := newFileStore(path)
+	require.NoError(t, err)
+	history, err := fs.History(context.Background(), "", "key")
+	require.NoError(t, err)
+	require.Equal(t, []models.HistoryEntry{{OriginalURL: "https://ya.ru/1", ChangedAt: changedAt}}, history)
+
+	// импорт заменяет историю целиком
+	replaced := []models.HistoryEntry{{OriginalURL: "https://ya.ru/0", ChangedAt: changedAt}}
+	require.NoError(t, fs.ImportData(context.Background(), models.URLData{ShortURL: "key", OriginalURL: "https://ya.ru/2",
+		UserID: 1, History: replaced}))
+	fs, err = newFileStore(path)
+	require.NoError(t, err)
+	history, err = fs.History(context.Background(), "", "key")
+	require.NoError(t, err)
+	require.Equal(t, replaced, history)
+}
+
+func TestFileStore_OutboxLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.json")
+	fs, err := newFileStore(path)
+	require.NoError(t, err)
+	ctx := userContext(1)
+	Outbox = true
+	defer func() { Outbox = false }()
+
+	const clicks = 500
+	require.NoError(t, fs.SaveData(ctx, "key", "https://ya.ru/"))
+	for i := 0; i < clicks; i++ {
+		_, err := fs.RecordClick(ctx, "", "key", NoVariant)
+		require.NoError(t, err)
+	}
+	raw, err := os.ReadFile(fs.outboxPath())
+	require.NoError(t, err)
+	// каждое событие дописывается одной строкой, а не перезаписью файла
+	require.Equal(t, clicks+1, strings.Count(string(raw), "\n"))
+
+	fs, err = newFileStore(path)
+	require.NoError(t, err)
+	events, err := fs.OutboxEvents(ctx, clicks+1)
+	require.NoError(t, err)
+	require.Len(t, events, clicks+1)
+
+	ids := make([]int64, 0, clicks)
+	for _, event := range events[:clicks] {
+		ids = append(ids, event.ID)
+	}
+	require.NoError(t, fs.DeleteOutbox(ctx, ids))
+	raw, err = os.ReadFile(fs.outboxPath())
+	require.NoError(t, err)
+	require.Equal(t, 2, strings.Count(string(raw), "\n"), "published events are compacted away")
+
+	require.NoError(t, fs.DeleteOutbox(ctx, []int64{events[clicks].ID}))
+	fs, err = newFileStore(path)
+	require.NoError(t, err)
+	rest, err := fs.OutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Empty(t, rest)
+	require.NoError(t, fs.SaveData(ctx, "other", "https://ya.ru/other"))
+	rest, err = fs.OutboxEvents(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, events[clicks].ID+1, rest[0].ID, "last id survives compaction of all events")
+}
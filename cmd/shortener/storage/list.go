@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/fngoc/url-shortener/internal/models"
+	"strings"
+	"time"
+)
+
+// Варианты сортировки ListOptions.Sort, минус означает сортировку по убыванию
+const (
+	SortCreatedAsc   = "created_at"
+	SortCreatedDesc  = "-created_at"
+	SortOriginalAsc  = "original_url"
+	SortOriginalDesc = "-original_url"
+)
+
+// ErrInvalidListOptions возвращается при неизвестной сортировке или испорченном курсоре
+var ErrInvalidListOptions = errors.New("invalid list options")
+
+// ListOptions параметры постраничной выборки ссылок пользователя
+type ListOptions struct {
+	// WorkspaceID выбирает ссылки рабочего пространства вместо личных ссылок пользователя.
+	// Право пользователя на просмотр проверяет вызывающий
+	WorkspaceID int
+	// Limit размер страницы, 0 — без ограничения
+	Limit int
+	// Cursor курсор следующей страницы, полученный из предыдущего вызова ListData
+	Cursor string
+	// CreatedFrom и CreatedTo ограничивают created_at полуинтервалом [CreatedFrom, CreatedTo)
+	CreatedFrom *time.Time
+	CreatedTo   *time.Time
+	// Deleted отбирает только удалённые или только активные ссылки, nil — все
+	Deleted *bool
+	// Query подстрока original_url без учёта регистра
+	Query string
+	// Tag отбирает ссылки с меткой, Folder — ссылки из папки. Пустые значения не фильтруют
+	Tag    string
+	Folder string
+	// Sort одна из констант Sort*, по умолчанию SortCreatedAsc
+	Sort string
+}
+
+// owns сообщает, что запись попадает в выборку: принадлежит рабочему пространству WorkspaceID
+// или является личной ссылкой пользователя userID
+func (o ListOptions) owns(record models.URLData, userID int) bool {
+	if o.WorkspaceID != 0 {
+		return record.WorkspaceID == o.WorkspaceID
+	}
+	return record.WorkspaceID == 0 && record.UserID == userID
+}
+
+// listCursor позиция последней записи страницы: значение колонки сортировки и uuid
+type listCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	UUID  int    `json:"id"`
+}
+
+// sortColumn возвращает колонку сортировки и её направление
+func (o ListOptions) sortColumn() (column string, desc bool, err error) {
+	sort := o.Sort
+	if sort == "" {
+		sort = SortCreatedAsc
+	}
+	switch sort {
+	case SortCreatedAsc, SortCreatedDesc, SortOriginalAsc, SortOriginalDesc:
+		return strings.TrimPrefix(sort, "-"), strings.HasPrefix(sort, "-"), nil
+	}
+	return "", false, ErrInvalidListOptions
+}
+
+// decodeCursor разбирает курсор и проверяет, что он выдан для той же сортировки
+func (o ListOptions) decodeCursor() (*listCursor, error) {
+	if o.Cursor == "" {
+		return nil, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(o.Cursor)
+	if err != nil {
+		return nil, ErrInvalidListOptions
+	}
+	var cursor listCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidListOptions
+	}
+	if column, desc, _ := o.sortColumn(); cursor.Sort != sortName(column, desc) {
+		return nil, ErrInvalidListOptions
+	}
+	return &cursor, nil
+}
+
+func encodeCursor(column string, desc bool, value string, uuid int) string {
+	data, _ := json.Marshal(listCursor{Sort: sortName(column, desc), Value: value, UUID: uuid})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func sortName(column string, desc bool) string {
+	if desc {
+		return "-" + column
+	}
+	return column
+}
+
+// cursorTime переводит время в значение курсора
+func cursorTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+// storedTime приводит время к точности, которую сохраняют все бэкенды
+func storedTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Microsecond)
+}
+
+// storedTimePtr применяет storedTime к необязательному времени
+func storedTimePtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	stored := storedTime(*t)
+	return &stored
+}
+
+// pageOf обрезает выборку, полученную с запасом в одну запись, до limit
+// и возвращает курсор следующей страницы, если она есть
+func pageOf(records []models.URLData, limit int, column string, desc bool) ([]models.URLData, string, error) {
+	if limit <= 0 || len(records) <= limit {
+		return records, "", nil
+	}
+	records = records[:limit]
+	last := records[len(records)-1]
+	value := last.OriginalURL
+	if column == "created_at" {
+		value = cursorTime(last.CreatedAt)
+	}
+	return records, encodeCursor(column, desc, value, last.UUID), nil
+}
@@ -3,51 +3,831 @@ package storage
 import (
 	"context"
 	"fmt"
-	"github.com/fngoc/url-shortener/cmd/shortener/config"
 	"github.com/fngoc/url-shortener/internal/logger"
 	"github.com/fngoc/url-shortener/internal/models"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
-type LocalStore map[string]string
+// LocalStore хранилище в памяти, повторяющее семантику DBStore:
+// уникальность short_url и original_url в пределах домена, владелец ссылки и мягкое удаление
+type LocalStore struct {
+	mu sync.RWMutex
+	// records ссылки по linkKey, byOriginal short_url ссылок по linkKey домена и original_url
+	records    map[string]models.URLData
+	byOriginal map[string]string
+	currentID  int
+	// persist вызывается под блокировкой после каждого изменения записи
+	persist func(models.URLData) error
+	// rewrite вызывается под блокировкой с оставшимися записями после окончательного удаления
+	rewrite func([]models.URLData) error
 
-var localStorage LocalStore
+	workspaces  map[int]workspaceData
+	workspaceID int
+	// persistWorkspaces вызывается под блокировкой после каждого изменения рабочих пространств
+	persistWorkspaces func([]workspaceData) error
+
+	// clicks переходы по ссылкам по linkKey и индексу варианта. Счётчики хранятся только в памяти,
+	// чтобы не дописывать запись в файл FileStore на каждый переход
+	clicks map[string]map[int]int
+
+	webhooks   map[int]models.Webhook
+	webhookID  int
+	deliveries map[int]models.WebhookDelivery
+	deliveryID int
+	// persistWebhooks вызывается под блокировкой после каждого изменения подписок и доставок
+	persistWebhooks func(webhookData) error
+
+	// outbox неопубликованные события ссылок, outboxID последний выданный ID события
+	outbox   []models.OutboxEvent
+	outboxID int64
+	// persistOutbox вызывается под блокировкой для каждого нового события, rewriteOutbox — после удаления
+	// опубликованных событий
+	persistOutbox func(models.OutboxEvent) error
+	rewriteOutbox func(outboxData) error
+}
+
+// workspaceData рабочее пространство вместе с участниками в том виде, в каком его сохраняет FileStore
+type workspaceData struct {
+	models.Workspace
+	Members []models.Member `json:"members"`
+}
+
+// webhookData подписки и журнал доставок в том виде, в каком их сохраняет FileStore
+type webhookData struct {
+	Webhooks   []models.Webhook         `json:"webhooks"`
+	Deliveries []models.WebhookDelivery `json:"deliveries"`
+}
+
+// outboxData неопубликованные события и последний выданный ID, которыми FileStore перезаписывает файл outbox.
+// LastID хранится отдельно, чтобы ID событий не повторялись после публикации всех событий и перезапуска
+type outboxData struct {
+	LastID int64
+	Events []models.OutboxEvent
+}
+
+var localStorage *LocalStore
+
+func newLocalStore() *LocalStore {
+	return &LocalStore{
+		records:    make(map[string]models.URLData),
+		byOriginal: make(map[string]string),
+		workspaces: make(map[int]workspaceData),
+		clicks:     make(map[string]map[int]int),
+		webhooks:   make(map[int]models.Webhook),
+		deliveries: make(map[int]models.WebhookDelivery),
+	}
+}
 
 func InitializeInMemoryLocalStore() error {
-	localStorage = make(map[string]string)
+	localStorage = newLocalStore()
 	Store = localStorage
 	logger.Log.Info("Initializing local storage")
 	return nil
 }
 
-func (lc LocalStore) GetData(_ context.Context, key string) (string, error) {
-	if val, ok := lc[key]; ok {
-		return val, nil
+func (lc *LocalStore) GetData(ctx context.Context, domain string, key string) (string, error) {
+	record, err := lc.GetRecord(ctx, domain, key)
+	if err != nil {
+		return "", err
 	}
-	return "", fmt.Errorf("data by key: %s, not found", key)
+	return record.OriginalURL, nil
 }
 
-func (lc LocalStore) GetAllData(_ context.Context) ([]models.ResponseDto, error) {
-	result := make([]models.ResponseDto, 0, len(lc))
-	for key, val := range lc {
-		result = append(result, models.ResponseDto{
-			ShortURL:    config.Flags.BaseResultAddress + "/" + key,
-			OriginalURL: val,
+func (lc *LocalStore) GetRecord(_ context.Context, domain string, key string) (models.URLData, error) {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	record, ok := lc.records[linkKey(domain, key)]
+	if !ok {
+		return models.URLData{}, fmt.Errorf("data by key: %s, %w", key, ErrNotFound)
+	}
+	if err := checkActive(record); err != nil {
+		return models.URLData{}, err
+	}
+	return record, nil
+}
+
+func (lc *LocalStore) FindRecord(_ context.Context, domain string, key string) (models.URLData, error) {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	record, ok := lc.records[linkKey(domain, key)]
+	if !ok {
+		return models.URLData{}, fmt.Errorf("data by key: %s, %w", key, ErrNotFound)
+	}
+	return record, nil
+}
+
+func (lc *LocalStore) ListData(_ context.Context, userID int, opts ListOptions) ([]models.URLData, string, error) {
+	column, desc, err := opts.sortColumn()
+	if err != nil {
+		return nil, "", err
+	}
+	cursor, err := opts.decodeCursor()
+	if err != nil {
+		return nil, "", err
+	}
+	query := strings.ToLower(opts.Query)
+
+	lc.mu.RLock()
+	result := make([]models.URLData, 0)
+	for _, record := range lc.records {
+		if !opts.owns(record, userID) ||
+			opts.CreatedFrom != nil && record.CreatedAt.Before(storedTime(*opts.CreatedFrom)) ||
+			opts.CreatedTo != nil && !record.CreatedAt.Before(storedTime(*opts.CreatedTo)) ||
+			opts.Deleted != nil && record.IsDeleted != *opts.Deleted ||
+			query != "" && !strings.Contains(strings.ToLower(record.OriginalURL), query) ||
+			opts.Tag != "" && !slices.Contains(record.Tags, opts.Tag) ||
+			opts.Folder != "" && record.Folder != opts.Folder {
+			continue
+		}
+		result = append(result, record)
+	}
+	lc.mu.RUnlock()
+
+	// compare сравнивает записи в порядке сортировки
+	compare := func(a, b models.URLData) int {
+		c := strings.Compare(a.OriginalURL, b.OriginalURL)
+		if column == "created_at" {
+			c = a.CreatedAt.Compare(b.CreatedAt)
+		}
+		if c == 0 {
+			c = a.UUID - b.UUID
+		}
+		if desc {
+			return -c
+		}
+		return c
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return compare(result[i], result[j]) < 0
+	})
+
+	if cursor != nil {
+		last := models.URLData{UUID: cursor.UUID, OriginalURL: cursor.Value}
+		if column == "created_at" {
+			if last.CreatedAt, err = time.Parse(time.RFC3339Nano, cursor.Value); err != nil {
+				return nil, "", ErrInvalidListOptions
+			}
+		}
+		start := sort.Search(len(result), func(i int) bool {
+			return compare(result[i], last) > 0
 		})
+		result = result[start:]
 	}
-	return result, nil
+	if opts.Limit > 0 && len(result) > opts.Limit+1 {
+		result = result[:opts.Limit+1]
+	}
+	return pageOf(result, opts.Limit, column, desc)
+}
+
+func (lc *LocalStore) DeleteData(domain string, url string) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	record, ok := lc.records[linkKey(domain, url)]
+	if !ok || record.IsDeleted {
+		return nil
+	}
+	deletedAt := storedTime(time.Now())
+	record.IsDeleted = true
+	record.DeletedAt = &deletedAt
+	if err := lc.put(record); err != nil {
+		return err
+	}
+	return lc.appendOutbox(models.OutboxEvent{Event: OutboxLinkDeleted, Domain: domain, ShortURL: url,
+		OriginalURL: record.OriginalURL, UserID: record.UserID})
 }
 
-func (lc LocalStore) DeleteData(userID int, url string) error {
-	return fmt.Errorf("not implemented yet")
+func (lc *LocalStore) RestoreData(_ context.Context, domain string, shortURL string, deletedAfter time.Time) (models.URLData, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	record, ok := lc.records[linkKey(domain, shortURL)]
+	if !ok {
+		return models.URLData{}, fmt.Errorf("data by key: %s, %w", shortURL, ErrNotFound)
+	}
+	if !record.IsDeleted || record.DeletedAt == nil || record.DeletedAt.Before(deletedAfter) {
+		return models.URLData{}, fmt.Errorf("data by key: %s, %w", shortURL, ErrNotRestorable)
+	}
+	record.IsDeleted = false
+	record.DeletedAt = nil
+	return record, lc.put(record)
 }
 
-func (lc LocalStore) SaveData(_ context.Context, key string, value string) error {
-	if key == "" || value == "" {
+func (lc *LocalStore) PurgeDeleted(_ context.Context, deletedBefore time.Time) (int, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	purged := 0
+	for key, record := range lc.records {
+		if !isPurgeable(record, deletedBefore) {
+			continue
+		}
+		delete(lc.records, key)
+		delete(lc.clicks, key)
+		if original := linkKey(record.Domain, record.OriginalURL); lc.byOriginal[original] == record.ShortURL {
+			delete(lc.byOriginal, original)
+		}
+		purged++
+	}
+	if purged == 0 || lc.rewrite == nil {
+		return purged, nil
+	}
+
+	records := make([]models.URLData, 0, len(lc.records))
+	for _, record := range lc.records {
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].UUID < records[j].UUID
+	})
+	return purged, lc.rewrite(records)
+}
+
+func (lc *LocalStore) SaveData(ctx context.Context, key string, value string) error {
+	return lc.SaveRecord(ctx, models.URLData{
+		ShortURL:    key,
+		OriginalURL: value,
+		UserID:      userIDFromContext(ctx),
+	})
+}
+
+func (lc *LocalStore) SaveRecord(_ context.Context, record models.URLData) error {
+	if record.ShortURL == "" || record.OriginalURL == "" {
 		return fmt.Errorf("key or value is empty")
 	}
-	if _, ok := lc[key]; ok {
-		return fmt.Errorf("data by key: %s, already exists", key)
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if shortURL, ok := lc.byOriginal[linkKey(record.Domain, record.OriginalURL)]; ok {
+		return newConflictError(shortURL)
+	}
+	if _, ok := lc.records[linkKey(record.Domain, record.ShortURL)]; ok {
+		return fmt.Errorf("data by key: %s, %w", record.ShortURL, ErrAlreadyExists)
+	}
+
+	lc.currentID++
+	err := lc.put(models.URLData{
+		UUID:         lc.currentID,
+		ShortURL:     record.ShortURL,
+		OriginalURL:  record.OriginalURL,
+		UserID:       record.UserID,
+		CreatedAt:    storedTime(time.Now()),
+		ExpiresAt:    record.ExpiresAt,
+		Interstitial: record.Interstitial,
+		PasswordHash: record.PasswordHash,
+		RedirectType: record.RedirectType,
+		Tags:         slices.Clone(record.Tags),
+		Folder:       record.Folder,
+		WorkspaceID:  record.WorkspaceID,
+		Domain:       record.Domain,
+		Rules:        slices.Clone(record.Rules),
+		Variants:     slices.Clone(record.Variants),
+		UTM:          maps.Clone(record.UTM),
+		PrefixMode:   record.PrefixMode,
+	})
+	if err != nil {
+		return err
+	}
+	return lc.appendOutbox(models.OutboxEvent{Event: OutboxLinkCreated, Domain: record.Domain, ShortURL: record.ShortURL,
+		OriginalURL: record.OriginalURL, UserID: record.UserID})
+}
+
+func (lc *LocalStore) UpdateTitle(_ context.Context, domain string, shortURL string, title string) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	record, ok := lc.records[linkKey(domain, shortURL)]
+	if !ok {
+		return fmt.Errorf("data by key: %s, %w", shortURL, ErrNotFound)
+	}
+	record.Title = title
+	return lc.put(record)
+}
+
+func (lc *LocalStore) UpdateRecord(_ context.Context, domain string, shortURL string, changes LinkChanges) (models.URLData, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	record, ok := lc.records[linkKey(domain, shortURL)]
+	if !ok {
+		return models.URLData{}, fmt.Errorf("data by key: %s, %w", shortURL, ErrNotFound)
+	}
+	if record.IsDeleted {
+		return models.URLData{}, &DBDeleteError{
+			Message: "shortener is already deleted",
+		}
+	}
+	previous := record.OriginalURL
+	if changes.apply(&record) {
+		if other, ok := lc.byOriginal[linkKey(domain, record.OriginalURL)]; ok {
+			return models.URLData{}, newConflictError(other)
+		}
+		record.History = append(record.History[:len(record.History):len(record.History)], models.HistoryEntry{
+			OriginalURL: previous,
+			ChangedAt:   storedTime(time.Now()),
+		})
+	}
+	return record, lc.put(record)
+}
+
+func (lc *LocalStore) History(_ context.Context, domain string, shortURL string) ([]models.HistoryEntry, error) {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	record, ok := lc.records[linkKey(domain, shortURL)]
+	if !ok {
+		return nil, fmt.Errorf("data by key: %s, %w", shortURL, ErrNotFound)
+	}
+	history := make([]models.HistoryEntry, 0, len(record.History))
+	for i := len(record.History) - 1; i >= 0; i-- {
+		history = append(history, record.History[i])
+	}
+	return history, nil
+}
+
+func (lc *LocalStore) RecordClick(_ context.Context, domain string, shortURL string, variant int) (int, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	key := linkKey(domain, shortURL)
+	if lc.clicks[key] == nil {
+		lc.clicks[key] = make(map[int]int)
+	}
+	lc.clicks[key][variant]++
+
+	total := 0
+	for _, n := range lc.clicks[key] {
+		total += n
+	}
+	if err := lc.appendOutbox(models.OutboxEvent{Event: OutboxLinkClicked, Domain: domain, ShortURL: shortURL,
+		UserID: lc.records[key].UserID, Variant: &variant}); err != nil {
+		lc.clicks[key][variant]--
+		return 0, err
+	}
+	return total, nil
+}
+
+func (lc *LocalStore) Clicks(_ context.Context, domain string, shortURL string) (map[int]int, error) {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	return maps.Clone(lc.clicks[linkKey(domain, shortURL)]), nil
+}
+
+func (lc *LocalStore) ExportData(_ context.Context, fn func(models.URLData) error) error {
+	lc.mu.RLock()
+	records := make([]models.URLData, 0, len(lc.records))
+	for _, record := range lc.records {
+		records = append(records, record)
+	}
+	lc.mu.RUnlock()
+
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].UUID < records[j].UUID
+	})
+	for _, record := range records {
+		if err := fn(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (lc *LocalStore) ImportData(_ context.Context, record models.URLData) error {
+	if record.ShortURL == "" || record.OriginalURL == "" {
+		return fmt.Errorf("key or value is empty")
+	}
+
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	if shortURL, ok := lc.byOriginal[linkKey(record.Domain, record.OriginalURL)]; ok && shortURL != record.ShortURL {
+		return newConflictError(shortURL)
+	}
+	if old, ok := lc.records[linkKey(record.Domain, record.ShortURL)]; ok {
+		record.UUID = old.UUID
+	} else {
+		lc.currentID++
+		record.UUID = lc.currentID
+	}
+	if record.CreatedAt.IsZero() {
+		record.CreatedAt = time.Now()
+	}
+	record.CreatedAt = storedTime(record.CreatedAt)
+	if record.ExpiresAt != nil {
+		expiresAt := storedTime(*record.ExpiresAt)
+		record.ExpiresAt = &expiresAt
+	}
+	if record.DeletedAt != nil {
+		deletedAt := storedTime(*record.DeletedAt)
+		record.DeletedAt = &deletedAt
+	}
+	return lc.put(record)
+}
+
+// put сохраняет запись и обновляет индексы, вызывается под блокировкой
+func (lc *LocalStore) put(record models.URLData) error {
+	if lc.persist != nil {
+		if err := lc.persist(record); err != nil {
+			return err
+		}
+	}
+	lc.load(record)
+	return nil
+}
+
+// load кладёт запись в память без сохранения, вызывается под блокировкой
+func (lc *LocalStore) load(record models.URLData) {
+	key := linkKey(record.Domain, record.ShortURL)
+	if old, ok := lc.records[key]; ok && old.OriginalURL != record.OriginalURL {
+		delete(lc.byOriginal, linkKey(old.Domain, old.OriginalURL))
+	}
+	lc.records[key] = record
+	lc.byOriginal[linkKey(record.Domain, record.OriginalURL)] = record.ShortURL
+	if record.UUID > lc.currentID {
+		lc.currentID = record.UUID
+	}
+}
+
+func (lc *LocalStore) CreateWorkspace(_ context.Context, name string, ownerID int) (models.Workspace, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	lc.workspaceID++
+	workspace := workspaceData{
+		Workspace: models.Workspace{ID: lc.workspaceID, Name: name, CreatedAt: storedTime(time.Now())},
+		Members:   []models.Member{{UserID: ownerID, Role: RoleOwner}},
+	}
+	lc.workspaces[workspace.ID] = workspace
+	if err := lc.putWorkspaces(); err != nil {
+		delete(lc.workspaces, workspace.ID)
+		return models.Workspace{}, err
+	}
+	workspace.Role = RoleOwner
+	return workspace.Workspace, nil
+}
+
+func (lc *LocalStore) Workspace(_ context.Context, workspaceID int) (models.Workspace, error) {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	workspace, ok := lc.workspaces[workspaceID]
+	if !ok {
+		return models.Workspace{}, fmt.Errorf("workspace: %d, %w", workspaceID, ErrNotFound)
+	}
+	workspace.UTM = maps.Clone(workspace.UTM)
+	return workspace.Workspace, nil
+}
+
+func (lc *LocalStore) SetWorkspaceUTM(_ context.Context, workspaceID int, utm map[string]string) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	workspace, ok := lc.workspaces[workspaceID]
+	if !ok {
+		return fmt.Errorf("workspace: %d, %w", workspaceID, ErrNotFound)
+	}
+	previous := workspace
+	workspace.UTM = maps.Clone(utm)
+	lc.workspaces[workspaceID] = workspace
+	if err := lc.putWorkspaces(); err != nil {
+		lc.workspaces[workspaceID] = previous
+		return err
+	}
+	return nil
+}
+
+func (lc *LocalStore) ListWorkspaces(_ context.Context, userID int) ([]models.Workspace, error) {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	result := make([]models.Workspace, 0)
+	for _, workspace := range lc.workspaces {
+		for _, member := range workspace.Members {
+			if member.UserID == userID {
+				workspace.Role = member.Role
+				workspace.UTM = maps.Clone(workspace.UTM)
+				result = append(result, workspace.Workspace)
+			}
+		}
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+func (lc *LocalStore) MemberRole(_ context.Context, workspaceID int, userID int) (string, error) {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	for _, member := range lc.workspaces[workspaceID].Members {
+		if member.UserID == userID {
+			return member.Role, nil
+		}
+	}
+	return "", nil
+}
+
+func (lc *LocalStore) Members(_ context.Context, workspaceID int) ([]models.Member, error) {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	workspace, ok := lc.workspaces[workspaceID]
+	if !ok {
+		return nil, fmt.Errorf("workspace: %d, %w", workspaceID, ErrNotFound)
+	}
+	return slices.Clone(workspace.Members), nil
+}
+
+func (lc *LocalStore) SetMemberRole(_ context.Context, workspaceID int, userID int, role string) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	workspace, ok := lc.workspaces[workspaceID]
+	if !ok {
+		return fmt.Errorf("workspace: %d, %w", workspaceID, ErrNotFound)
+	}
+	previous := workspace
+	workspace.Members = slices.DeleteFunc(slices.Clone(workspace.Members), func(member models.Member) bool {
+		return member.UserID == userID
+	})
+	if role != "" {
+		workspace.Members = append(workspace.Members, models.Member{UserID: userID, Role: role})
+		sort.Slice(workspace.Members, func(i, j int) bool {
+			return workspace.Members[i].UserID < workspace.Members[j].UserID
+		})
+	}
+	lc.workspaces[workspaceID] = workspace
+	if err := lc.putWorkspaces(); err != nil {
+		lc.workspaces[workspaceID] = previous
+		return err
+	}
+	return nil
+}
+
+// putWorkspaces сохраняет рабочие пространства, вызывается под блокировкой
+func (lc *LocalStore) putWorkspaces() error {
+	if lc.persistWorkspaces == nil {
+		return nil
+	}
+	workspaces := make([]workspaceData, 0, len(lc.workspaces))
+	for _, workspace := range lc.workspaces {
+		workspaces = append(workspaces, workspace)
+	}
+	sort.Slice(workspaces, func(i, j int) bool {
+		return workspaces[i].ID < workspaces[j].ID
+	})
+	return lc.persistWorkspaces(workspaces)
+}
+
+func (lc *LocalStore) CreateWebhook(_ context.Context, webhook models.Webhook) (models.Webhook, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	lc.webhookID++
+	webhook.ID = lc.webhookID
+	webhook.Events = slices.Clone(webhook.Events)
+	webhook.CreatedAt = storedTime(time.Now())
+	lc.webhooks[webhook.ID] = webhook
+	if err := lc.putWebhooks(); err != nil {
+		delete(lc.webhooks, webhook.ID)
+		return models.Webhook{}, err
+	}
+	return webhook, nil
+}
+
+func (lc *LocalStore) Webhook(_ context.Context, webhookID int) (models.Webhook, error) {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	webhook, ok := lc.webhooks[webhookID]
+	if !ok {
+		return models.Webhook{}, fmt.Errorf("webhook: %d, %w", webhookID, ErrNotFound)
+	}
+	webhook.Events = slices.Clone(webhook.Events)
+	return webhook, nil
+}
+
+func (lc *LocalStore) Webhooks(_ context.Context, userID int) ([]models.Webhook, error) {
+	lc.mu.RLock()
+	result := make([]models.Webhook, 0)
+	for _, webhook := range lc.webhooks {
+		if webhook.UserID == userID {
+			webhook.Events = slices.Clone(webhook.Events)
+			result = append(result, webhook)
+		}
+	}
+	lc.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result, nil
+}
+
+func (lc *LocalStore) ClickThresholds(_ context.Context) ([]int, error) {
+	lc.mu.RLock()
+	result := make([]int, 0)
+	for _, webhook := range lc.webhooks {
+		if webhook.ClickThreshold > 0 {
+			result = append(result, webhook.ClickThreshold)
+		}
+	}
+	lc.mu.RUnlock()
+
+	slices.Sort(result)
+	return slices.Compact(result), nil
+}
+
+func (lc *LocalStore) DeleteWebhook(_ context.Context, webhookID int) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	webhook, ok := lc.webhooks[webhookID]
+	if !ok {
+		return fmt.Errorf("webhook: %d, %w", webhookID, ErrNotFound)
+	}
+	removed := make([]models.WebhookDelivery, 0)
+	for id, delivery := range lc.deliveries {
+		if delivery.WebhookID == webhookID {
+			removed = append(removed, delivery)
+			delete(lc.deliveries, id)
+		}
+	}
+	delete(lc.webhooks, webhookID)
+	if err := lc.putWebhooks(); err != nil {
+		lc.webhooks[webhookID] = webhook
+		for _, delivery := range removed {
+			lc.deliveries[delivery.ID] = delivery
+		}
+		return err
+	}
+	return nil
+}
+
+func (lc *LocalStore) AddDelivery(_ context.Context, delivery models.WebhookDelivery) (models.WebhookDelivery, error) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	lc.deliveryID++
+	delivery.ID = lc.deliveryID
+	delivery.CreatedAt = storedTime(time.Now())
+	delivery.NextAttemptAt = storedTimePtr(delivery.NextAttemptAt)
+	lc.deliveries[delivery.ID] = delivery
+
+	finished := make([]int, 0)
+	for id, stored := range lc.deliveries {
+		if stored.WebhookID == delivery.WebhookID && stored.Status != DeliveryPending {
+			finished = append(finished, id)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(finished)))
+	pruned := make([]models.WebhookDelivery, 0)
+	for _, id := range finished[min(len(finished), DeliveryLogSize):] {
+		pruned = append(pruned, lc.deliveries[id])
+		delete(lc.deliveries, id)
+	}
+
+	if err := lc.putWebhooks(); err != nil {
+		delete(lc.deliveries, delivery.ID)
+		for _, stored := range pruned {
+			lc.deliveries[stored.ID] = stored
+		}
+		return models.WebhookDelivery{}, err
+	}
+	return delivery, nil
+}
+
+func (lc *LocalStore) DueDeliveries(_ context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error) {
+	lc.mu.RLock()
+	result := make([]models.WebhookDelivery, 0)
+	for _, delivery := range lc.deliveries {
+		if delivery.Status == DeliveryPending && (delivery.NextAttemptAt == nil || !delivery.NextAttemptAt.After(now)) {
+			result = append(result, delivery)
+		}
+	}
+	lc.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	return result, nil
+}
+
+func (lc *LocalStore) UpdateDelivery(_ context.Context, delivery models.WebhookDelivery) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	stored, ok := lc.deliveries[delivery.ID]
+	if !ok {
+		return fmt.Errorf("delivery: %d, %w", delivery.ID, ErrNotFound)
+	}
+	updated := stored
+	updated.Status = delivery.Status
+	updated.Attempts = delivery.Attempts
+	updated.ResponseCode = delivery.ResponseCode
+	updated.Error = delivery.Error
+	updated.NextAttemptAt = storedTimePtr(delivery.NextAttemptAt)
+	lc.deliveries[delivery.ID] = updated
+	if err := lc.putWebhooks(); err != nil {
+		lc.deliveries[delivery.ID] = stored
+		return err
+	}
+	return nil
+}
+
+func (lc *LocalStore) Deliveries(_ context.Context, webhookID int) ([]models.WebhookDelivery, error) {
+	lc.mu.RLock()
+	result := make([]models.WebhookDelivery, 0)
+	for _, delivery := range lc.deliveries {
+		if delivery.WebhookID == webhookID {
+			result = append(result, delivery)
+		}
+	}
+	lc.mu.RUnlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID > result[j].ID
+	})
+	return result, nil
+}
+
+// putWebhooks сохраняет подписки и доставки, вызывается под блокировкой
+func (lc *LocalStore) putWebhooks() error {
+	if lc.persistWebhooks == nil {
+		return nil
+	}
+	data := webhookData{
+		Webhooks:   make([]models.Webhook, 0, len(lc.webhooks)),
+		Deliveries: make([]models.WebhookDelivery, 0, len(lc.deliveries)),
+	}
+	for _, webhook := range lc.webhooks {
+		data.Webhooks = append(data.Webhooks, webhook)
+	}
+	for _, delivery := range lc.deliveries {
+		data.Deliveries = append(data.Deliveries, delivery)
+	}
+	sort.Slice(data.Webhooks, func(i, j int) bool {
+		return data.Webhooks[i].ID < data.Webhooks[j].ID
+	})
+	sort.Slice(data.Deliveries, func(i, j int) bool {
+		return data.Deliveries[i].ID < data.Deliveries[j].ID
+	})
+	return lc.persistWebhooks(data)
+}
+
+// appendOutbox добавляет событие ссылки в outbox, если запись включена. Вызывается под блокировкой
+// после сохранения изменения: если событие не сохранилось, вызывающий получает ошибку
+func (lc *LocalStore) appendOutbox(event models.OutboxEvent) error {
+	if !Outbox {
+		return nil
+	}
+	event.ID = lc.outboxID + 1
+	event.CreatedAt = storedTime(time.Now())
+	if lc.persistOutbox != nil {
+		if err := lc.persistOutbox(event); err != nil {
+			return err
+		}
+	}
+	lc.outbox = append(lc.outbox, event)
+	lc.outboxID = event.ID
+	return nil
+}
+
+func (lc *LocalStore) OutboxEvents(_ context.Context, limit int) ([]models.OutboxEvent, error) {
+	lc.mu.RLock()
+	defer lc.mu.RUnlock()
+
+	return slices.Clone(lc.outbox[:min(limit, len(lc.outbox))]), nil
+}
+
+func (lc *LocalStore) DeleteOutbox(_ context.Context, ids []int64) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+
+	outbox := slices.DeleteFunc(slices.Clone(lc.outbox), func(event models.OutboxEvent) bool {
+		return slices.Contains(ids, event.ID)
+	})
+	if len(outbox) == len(lc.outbox) {
+		return nil
+	}
+	if lc.rewriteOutbox != nil {
+		if err := lc.rewriteOutbox(outboxData{LastID: lc.outboxID, Events: outbox}); err != nil {
+			return err
+		}
 	}
-	lc[key] = value
+	lc.outbox = outbox
 	return nil
 }
@@ -0,0 +1,29 @@
+package storage
+
+import (
+	"context"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"go.uber.org/zap"
+	"time"
+)
+
+// RunPurge раз в interval окончательно удаляет из store ссылки, удалённые больше after назад,
+// пока не отменён ctx. Первая очистка выполняется сразу
+func RunPurge(ctx context.Context, store Repository, after, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		purged, err := store.PurgeDeleted(ctx, time.Now().Add(-after))
+		if err != nil {
+			logger.Log.Warn("Deleted urls are not purged", zap.Error(err))
+		} else if purged > 0 {
+			logger.Log.Info("Deleted urls are purged", zap.Int("count", purged))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// sqliteScheme префикс DSN, по которому выбирается SQLite
+const sqliteScheme = "sqlite://"
+
+// sqliteDialect повторяет схему Postgres: уникальность short_url и original_url в пределах домена
+// задаётся индексами, чтобы их можно было менять миграциями
+var sqliteDialect = dialect{
+	migrations: []string{
+		`CREATE TABLE IF NOT EXISTS url_shortener (
+			uuid INTEGER PRIMARY KEY AUTOINCREMENT,
+			short_url VARCHAR NOT NULL,
+			original_url VARCHAR NOT NULL,
+			user_id BIGINT NOT NULL,
+			is_deleted BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		);`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS short_url_idx ON url_shortener (short_url)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS original_url_idx ON url_shortener (original_url)`,
+		`ALTER TABLE url_shortener ADD COLUMN expires_at TIMESTAMP NULL`,
+		`ALTER TABLE url_shortener ADD COLUMN interstitial BOOLEAN NOT NULL DEFAULT FALSE`,
+		`ALTER TABLE url_shortener ADD COLUMN title VARCHAR NOT NULL DEFAULT ''`,
+		`ALTER TABLE url_shortener ADD COLUMN password_hash VARCHAR NOT NULL DEFAULT ''`,
+		`ALTER TABLE url_shortener ADD COLUMN redirect_type INTEGER NOT NULL DEFAULT 0`,
+		`CREATE TABLE IF NOT EXISTS url_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			short_url VARCHAR NOT NULL,
+			original_url VARCHAR NOT NULL,
+			changed_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS url_history_short_url_idx ON url_history (short_url)`,
+		`ALTER TABLE url_shortener ADD COLUMN deleted_at TIMESTAMP NULL`,
+		`ALTER TABLE url_shortener ADD COLUMN folder VARCHAR NOT NULL DEFAULT ''`,
+		`CREATE TABLE IF NOT EXISTS url_tags (
+			short_url VARCHAR NOT NULL,
+			tag VARCHAR NOT NULL,
+			PRIMARY KEY (short_url, tag)
+		)`,
+		`CREATE INDEX IF NOT EXISTS url_tags_tag_idx ON url_tags (tag)`,
+		`CREATE TABLE IF NOT EXISTS workspaces (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			name VARCHAR NOT NULL,
+			created_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE TABLE IF NOT EXISTS workspace_members (
+			workspace_id INTEGER NOT NULL,
+			user_id BIGINT NOT NULL,
+			role VARCHAR NOT NULL,
+			PRIMARY KEY (workspace_id, user_id)
+		)`,
+		`ALTER TABLE url_shortener ADD COLUMN workspace_id INTEGER NOT NULL DEFAULT 0`,
+		`CREATE INDEX IF NOT EXISTS workspace_id_idx ON url_shortener (workspace_id)`,
+		`ALTER TABLE url_shortener ADD COLUMN domain VARCHAR NOT NULL DEFAULT ''`,
+		`DROP INDEX IF EXISTS short_url_idx`,
+		`DROP INDEX IF EXISTS original_url_idx`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS domain_short_url_idx ON url_shortener (domain, short_url)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS domain_original_url_idx ON url_shortener (domain, original_url)`,
+		`ALTER TABLE url_history ADD COLUMN domain VARCHAR NOT NULL DEFAULT ''`,
+		`DROP INDEX IF EXISTS url_history_short_url_idx`,
+		`CREATE INDEX IF NOT EXISTS url_history_link_idx ON url_history (domain, short_url)`,
+		// первичный ключ в SQLite не меняется через ALTER TABLE, поэтому url_tags пересоздаётся
+		`CREATE TABLE url_tags_domain (
+			domain VARCHAR NOT NULL DEFAULT '',
+			short_url VARCHAR NOT NULL,
+			tag VARCHAR NOT NULL,
+			PRIMARY KEY (domain, short_url, tag)
+		)`,
+		`INSERT INTO url_tags_domain (short_url, tag) SELECT short_url, tag FROM url_tags`,
+		`DROP TABLE url_tags`,
+		`ALTER TABLE url_tags_domain RENAME TO url_tags`,
+		`CREATE INDEX IF NOT EXISTS url_tags_tag_idx ON url_tags (tag)`,
+		`ALTER TABLE url_shortener ADD COLUMN rules VARCHAR NOT NULL DEFAULT ''`,
+		`ALTER TABLE url_shortener ADD COLUMN variants VARCHAR NOT NULL DEFAULT ''`,
+		`CREATE TABLE IF NOT EXISTS link_clicks (
+			domain VARCHAR NOT NULL,
+			short_url VARCHAR NOT NULL,
+			variant INTEGER NOT NULL,
+			clicks BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (domain, short_url, variant)
+		)`,
+		`ALTER TABLE url_shortener ADD COLUMN utm VARCHAR NOT NULL DEFAULT ''`,
+		`ALTER TABLE workspaces ADD COLUMN utm VARCHAR NOT NULL DEFAULT ''`,
+		`ALTER TABLE url_shortener ADD COLUMN prefix_mode BOOLEAN NOT NULL DEFAULT FALSE`,
+		`CREATE TABLE IF NOT EXISTS webhooks (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			user_id BIGINT NOT NULL,
+			url VARCHAR NOT NULL,
+			secret VARCHAR NOT NULL,
+			events VARCHAR NOT NULL DEFAULT '',
+			click_threshold INTEGER NOT NULL DEFAULT 0,
+			created_at TIMESTAMP NOT NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS webhooks_user_id_idx ON webhooks (user_id)`,
+		`CREATE TABLE IF NOT EXISTS webhook_deliveries (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			webhook_id INTEGER NOT NULL,
+			event VARCHAR NOT NULL,
+			payload VARCHAR NOT NULL,
+			status VARCHAR NOT NULL,
+			attempts INTEGER NOT NULL DEFAULT 0,
+			response_code INTEGER NOT NULL DEFAULT 0,
+			error VARCHAR NOT NULL DEFAULT '',
+			test BOOLEAN NOT NULL DEFAULT FALSE,
+			created_at TIMESTAMP NOT NULL,
+			next_attempt_at TIMESTAMP NULL
+		)`,
+		`CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id)`,
+		`CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (status, next_attempt_at)`,
+		`CREATE TABLE IF NOT EXISTS link_outbox (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			event VARCHAR NOT NULL,
+			domain VARCHAR NOT NULL DEFAULT '',
+			short_url VARCHAR NOT NULL,
+			original_url VARCHAR NOT NULL DEFAULT '',
+			user_id BIGINT NOT NULL DEFAULT 0,
+			variant INTEGER NULL,
+			created_at TIMESTAMP NOT NULL
+		)`,
+	},
+	conflictError: func(err error) *pgconn.PgError {
+		var sqliteErr *sqlite.Error
+		if !errors.As(err, &sqliteErr) {
+			return nil
+		}
+		switch sqliteErr.Code() {
+		case sqlite3.SQLITE_CONSTRAINT_UNIQUE, sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			// приводим к ошибке Postgres, чтобы обработчики не зависели от бэкенда
+			return &pgconn.PgError{
+				Code:    pgerrcode.UniqueViolation,
+				Message: sqliteErr.Error(),
+			}
+		}
+		return nil
+	},
+}
+
+// InitializeSQLite инициализирует хранилище в файле SQLite по пути path
+func InitializeSQLite(path string) error {
+	dbs, err := newSQLiteStore(path)
+	if err != nil {
+		return err
+	}
+	dbInstant = dbs
+	Store = dbInstant
+	return nil
+}
+
+func newSQLiteStore(path string) (DBStore, error) {
+	dsn, err := sqliteDSN(path)
+	if err != nil {
+		return DBStore{}, err
+	}
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return DBStore{}, err
+	}
+	// SQLite не поддерживает конкурентную запись, поэтому все запросы идут через одно соединение
+	db.SetMaxOpenConns(1)
+
+	dbs := DBStore{db: db, dialect: sqliteDialect}
+	if err := dbs.createTables(); err != nil {
+		return DBStore{}, err
+	}
+	return dbs, nil
+}
+
+// sqliteDSN добавляет к пути базы параметры драйвера, сохраняя параметры, уже заданные в пути.
+// Формат времени sqlite сохраняет порядок при сравнении строк, на этом держится постраничная выборка,
+// поэтому он задаётся всегда. Ожидание блокировки задаётся, только если в пути его нет
+func sqliteDSN(path string) (string, error) {
+	file, rawQuery, _ := strings.Cut(path, "?")
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return "", fmt.Errorf("sqlite dsn query: %w", err)
+	}
+	if !slices.ContainsFunc(query["_pragma"], func(pragma string) bool {
+		return strings.HasPrefix(strings.ToLower(pragma), "busy_timeout")
+	}) {
+		query.Add("_pragma", "busy_timeout(5000)")
+	}
+	query.Set("_time_format", "sqlite")
+	return file + "?" + query.Encode(), nil
+}
@@ -0,0 +1,87 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/jackc/pgerrcode"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"path/filepath"
+	"testing"
+)
+
+func TestSQLiteStore(t *testing.T) {
+	dsn := sqliteScheme + filepath.Join(t.TempDir(), "test.db")
+	require.NoError(t, InitializeDB(dsn))
+
+	ctx := context.WithValue(context.Background(), constants.UserIDKey, 1)
+	otherCtx := context.WithValue(context.Background(), constants.UserIDKey, 2)
+
+	require.NoError(t, Store.SaveData(ctx, "key", "https://ya.ru"))
+	require.NoError(t, Store.SaveData(otherCtx, "other", "https://google.com"))
+	require.True(t, CustomPing())
+
+	value, err := Store.GetData(ctx, "", "key")
+	require.NoError(t, err)
+	require.Equal(t, "https://ya.ru", value)
+
+	_, err = Store.GetData(ctx, "", "unknown")
+	require.Error(t, err)
+
+	err = Store.SaveData(ctx, "newKey", "https://ya.ru")
+	var dbErr *DBError
+	require.True(t, errors.As(err, &dbErr))
+	require.True(t, pgerrcode.IsIntegrityConstraintViolation(dbErr.Err.Code))
+	require.Equal(t, "key", dbErr.ShortURL)
+
+	urls, _, err := Store.ListData(ctx, 1, ListOptions{})
+	require.NoError(t, err)
+	require.Len(t, urls, 1)
+	require.Equal(t, "https://ya.ru", urls[0].OriginalURL)
+
+	require.NoError(t, Store.DeleteData("", "key"))
+	_, err = Store.GetData(ctx, "", "key")
+	var deleteErr *DBDeleteError
+	require.True(t, errors.As(err, &deleteErr))
+}
+
+func TestSQLiteDSN(t *testing.T) {
+	tests := []struct {
+		name      string
+		path      string
+		wantFile  string
+		wantQuery url.Values
+		wantErr   bool
+	}{
+		{"plain path", "/data/test.db", "/data/test.db",
+			url.Values{"_pragma": {"busy_timeout(5000)"}, "_time_format": {"sqlite"}}, false},
+		{"existing query", "/data/test.db?mode=ro", "/data/test.db",
+			url.Values{"mode": {"ro"}, "_pragma": {"busy_timeout(5000)"}, "_time_format": {"sqlite"}}, false},
+		{"own pragmas", "/data/test.db?_pragma=foreign_keys(1)&_pragma=busy_timeout(100)", "/data/test.db",
+			url.Values{"_pragma": {"foreign_keys(1)", "busy_timeout(100)"}, "_time_format": {"sqlite"}}, false},
+		{"time format is forced", "/data/test.db?_time_format=other", "/data/test.db",
+			url.Values{"_pragma": {"busy_timeout(5000)"}, "_time_format": {"sqlite"}}, false},
+		{"bad query", "/data/test.db?mode=%zz", "", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dsn, err := sqliteDSN(tt.path)
+			if tt.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			u, err := url.Parse(dsn)
+			require.NoError(t, err)
+			require.Equal(t, tt.wantFile, u.Path)
+			require.Equal(t, tt.wantQuery, u.Query())
+		})
+	}
+}
+
+func TestSQLiteStore_DSNQuery(t *testing.T) {
+	dsn := sqliteScheme + filepath.Join(t.TempDir(), "test.db") + "?mode=rwc"
+	require.NoError(t, InitializeDB(dsn))
+	require.NoError(t, Store.SaveData(context.WithValue(context.Background(), constants.UserIDKey, 1), "key", "https://ya.ru"))
+}
@@ -2,14 +2,241 @@ package storage
 
 import (
 	"context"
+	"errors"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
 	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"maps"
+	"slices"
+	"time"
 )
 
 type Repository interface {
-	GetData(context.Context, string) (string, error)
-	GetAllData(context.Context) ([]models.ResponseDto, error)
-	DeleteData(userID int, url string) error
+	// GetData возвращает адрес назначения действующей ссылки. Ссылки ищутся по домену и short_url,
+	// пустой домен означает домен по умолчанию
+	GetData(ctx context.Context, domain string, shortURL string) (string, error)
+	// GetRecord возвращает действующую ссылку целиком, ошибки те же, что у GetData
+	GetRecord(ctx context.Context, domain string, shortURL string) (models.URLData, error)
+	// FindRecord возвращает ссылку в любом состоянии, включая удалённые и истёкшие, например для проверки прав
+	FindRecord(ctx context.Context, domain string, shortURL string) (models.URLData, error)
+	// ListData возвращает страницу личных ссылок пользователя или ссылок рабочего пространства
+	// opts.WorkspaceID и курсор следующей страницы, пустой на последней
+	ListData(ctx context.Context, userID int, opts ListOptions) ([]models.URLData, string, error)
+	// DeleteData мягко удаляет ссылку, права пользователя проверяет вызывающий
+	DeleteData(domain string, url string) error
+	// RestoreData отменяет удаление ссылки, если она удалена не раньше deletedAfter.
+	// Для неудалённой ссылки и ссылки вне окна восстановления возвращается ErrNotRestorable
+	RestoreData(ctx context.Context, domain string, shortURL string, deletedAfter time.Time) (models.URLData, error)
+	// PurgeDeleted окончательно удаляет ссылки, удалённые раньше deletedBefore, вместе с их историей
+	// и освобождает их original_url. Ссылки, удалённые до появления deleted_at, удаляются всегда
+	PurgeDeleted(ctx context.Context, deletedBefore time.Time) (int, error)
+	// SaveData сохраняет ссылку на домене по умолчанию
 	SaveData(context.Context, string, string) error
+	// SaveRecord сохраняет новую ссылку с Domain, ShortURL, OriginalURL, UserID, ExpiresAt, Interstitial, PasswordHash,
+	// RedirectType, Tags, Folder, WorkspaceID, Rules, Variants, UTM и PrefixMode из record. short_url и original_url уникальны в пределах домена
+	SaveRecord(ctx context.Context, record models.URLData) error
+	// UpdateTitle сохраняет заголовок страницы назначения ссылки
+	UpdateTitle(ctx context.Context, domain string, shortURL string, title string) error
+	// UpdateRecord применяет changes к ссылке и возвращает её новую версию.
+	// Прежний адрес назначения сохраняется в истории ссылки
+	UpdateRecord(ctx context.Context, domain string, shortURL string, changes LinkChanges) (models.URLData, error)
+	// History возвращает прежние адреса назначения ссылки, последние первыми
+	History(ctx context.Context, domain string, shortURL string) ([]models.HistoryEntry, error)
+	// RecordClick учитывает переход по ссылке на вариант A/B-теста с индексом variant или NoVariant
+	// и возвращает число переходов по ссылке с учётом этого
+	RecordClick(ctx context.Context, domain string, shortURL string, variant int) (int, error)
+	// Clicks возвращает число переходов по ссылке по индексам вариантов, переходы без варианта — под NoVariant
+	Clicks(ctx context.Context, domain string, shortURL string) (map[int]int, error)
+	// CreateWorkspace создаёт рабочее пространство с владельцем ownerID
+	CreateWorkspace(ctx context.Context, name string, ownerID int) (models.Workspace, error)
+	// Workspace возвращает рабочее пространство без роли пользователя
+	Workspace(ctx context.Context, workspaceID int) (models.Workspace, error)
+	// SetWorkspaceUTM заменяет шаблон UTM-меток рабочего пространства, пустой шаблон снимает его
+	SetWorkspaceUTM(ctx context.Context, workspaceID int, utm map[string]string) error
+	// ListWorkspaces возвращает рабочие пространства пользователя с его ролью в каждом
+	ListWorkspaces(ctx context.Context, userID int) ([]models.Workspace, error)
+	// MemberRole возвращает роль пользователя в рабочем пространстве, пустую, если он не участник
+	MemberRole(ctx context.Context, workspaceID int, userID int) (string, error)
+	// Members возвращает участников рабочего пространства
+	Members(ctx context.Context, workspaceID int) ([]models.Member, error)
+	// SetMemberRole назначает роль участнику рабочего пространства, пустая роль исключает его
+	SetMemberRole(ctx context.Context, workspaceID int, userID int, role string) error
+	// CreateWebhook сохраняет подписку на события и возвращает её с ID и CreatedAt
+	CreateWebhook(ctx context.Context, webhook models.Webhook) (models.Webhook, error)
+	// Webhook возвращает подписку по ID
+	Webhook(ctx context.Context, webhookID int) (models.Webhook, error)
+	// Webhooks возвращает подписки пользователя в порядке создания
+	Webhooks(ctx context.Context, userID int) ([]models.Webhook, error)
+	// ClickThresholds возвращает различные пороги переходов всех подписок по возрастанию
+	ClickThresholds(ctx context.Context) ([]int, error)
+	// DeleteWebhook удаляет подписку вместе с её доставками
+	DeleteWebhook(ctx context.Context, webhookID int) error
+	// AddDelivery сохраняет доставку события и возвращает её с ID и CreatedAt. Из журнала подписки
+	// удаляются завершённые доставки сверх DeliveryLogSize
+	AddDelivery(ctx context.Context, delivery models.WebhookDelivery) (models.WebhookDelivery, error)
+	// DueDeliveries возвращает до limit доставок в статусе DeliveryPending, попытка которых наступила к now,
+	// в порядке создания
+	DueDeliveries(ctx context.Context, now time.Time, limit int) ([]models.WebhookDelivery, error)
+	// UpdateDelivery сохраняет Status, Attempts, ResponseCode, Error и NextAttemptAt доставки
+	UpdateDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+	// Deliveries возвращает журнал доставок подписки, последние первыми
+	Deliveries(ctx context.Context, webhookID int) ([]models.WebhookDelivery, error)
+	// OutboxEvents возвращает до limit неопубликованных событий ссылок в порядке записи
+	OutboxEvents(ctx context.Context, limit int) ([]models.OutboxEvent, error)
+	// DeleteOutbox удаляет опубликованные события с ID из ids. Удаляются только переданные ID:
+	// событие с меньшим ID могло зафиксироваться уже после чтения
+	DeleteOutbox(ctx context.Context, ids []int64) error
+	// ExportData вызывает fn для каждой записи хранилища, включая удалённые, с метками и историей
+	ExportData(ctx context.Context, fn func(models.URLData) error) error
+	// ImportData сохраняет запись как есть вместе с метками и историей, перезаписывая запись с тем же Domain и ShortURL
+	ImportData(ctx context.Context, record models.URLData) error
 }
 
 var Store Repository
+
+// ErrNotFound возвращается, если сокращённой ссылки нет в хранилище
+var ErrNotFound = errors.New("not found")
+
+// ErrAlreadyExists возвращается, если сокращённая ссылка уже занята
+var ErrAlreadyExists = errors.New("already exists")
+
+// Роли участников рабочего пространства: владелец управляет участниками, редактор меняет ссылки,
+// наблюдатель только просматривает их
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// NoVariant индекс варианта перехода по ссылке без A/B-теста
+const NoVariant = -1
+
+// Статусы доставки события подписчику: ожидает попытки, доставлена или попытки исчерпаны
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// DeliveryLogSize число завершённых доставок, которые хранятся в журнале каждой подписки
+const DeliveryLogSize = 100
+
+// События ссылок в outbox
+const (
+	OutboxLinkCreated = "link.created"
+	OutboxLinkDeleted = "link.deleted"
+	OutboxLinkClicked = "link.clicked"
+)
+
+// Outbox включает запись событий ссылок в outbox вместе с изменениями, которые их вызвали: в DBStore
+// в той же транзакции. Без ретранслятора события копятся, поэтому по умолчанию запись выключена
+var Outbox bool
+
+// ErrNotRestorable возвращается, если удаление ссылки нельзя отменить
+var ErrNotRestorable = errors.New("not restorable")
+
+// LinkChanges изменения ссылки для UpdateRecord, поля nil не меняются
+type LinkChanges struct {
+	OriginalURL *string
+	ExpiresAt   *time.Time
+	// ClearExpiresAt снимает срок жизни ссылки
+	ClearExpiresAt bool
+	RedirectType   *int
+	// Tags заменяет метки ссылки целиком
+	Tags   *[]string
+	Folder *string
+	// Rules заменяет правила перенаправления целиком
+	Rules *[]models.TargetingRule
+	// Variants заменяет варианты A/B-теста целиком
+	Variants *[]models.Variant
+	// UTM заменяет шаблон UTM-меток целиком
+	UTM *map[string]string
+	// PrefixMode включает или выключает дописывание пути к адресу назначения
+	PrefixMode *bool
+}
+
+// apply применяет изменения к record и сообщает, сменился ли адрес назначения.
+// При смене адреса сбрасывается заголовок прежней страницы
+func (c LinkChanges) apply(record *models.URLData) bool {
+	changed := c.OriginalURL != nil && *c.OriginalURL != record.OriginalURL
+	if changed {
+		record.OriginalURL = *c.OriginalURL
+		record.Title = ""
+	}
+	if c.ClearExpiresAt {
+		record.ExpiresAt = nil
+	} else if c.ExpiresAt != nil {
+		expiresAt := storedTime(*c.ExpiresAt)
+		record.ExpiresAt = &expiresAt
+	}
+	if c.RedirectType != nil {
+		record.RedirectType = *c.RedirectType
+	}
+	if c.Tags != nil {
+		record.Tags = slices.Clone(*c.Tags)
+	}
+	if c.Folder != nil {
+		record.Folder = *c.Folder
+	}
+	if c.Rules != nil {
+		record.Rules = slices.Clone(*c.Rules)
+	}
+	if c.Variants != nil {
+		record.Variants = slices.Clone(*c.Variants)
+	}
+	if c.UTM != nil {
+		record.UTM = maps.Clone(*c.UTM)
+	}
+	if c.PrefixMode != nil {
+		record.PrefixMode = *c.PrefixMode
+	}
+	return changed
+}
+
+// linkKey ключ ссылки в пределах хранилища: short_url уникален только внутри домена
+func linkKey(domain string, shortURL string) string {
+	return domain + "/" + shortURL
+}
+
+// userIDFromContext возвращает идентификатор пользователя из контекста или 0, если его нет
+func userIDFromContext(ctx context.Context) int {
+	userID, _ := ctx.Value(constants.UserIDKey).(int)
+	return userID
+}
+
+// newConflictError создаёт ошибку конфликта в том же виде, что и у DBStore
+func newConflictError(shortURL string) *DBError {
+	return &DBError{
+		ShortURL: shortURL,
+		Err: &pgconn.PgError{
+			Code:    pgerrcode.UniqueViolation,
+			Message: "original url already exists",
+		},
+	}
+}
+
+// checkActive возвращает DBDeleteError для удалённой или истёкшей ссылки
+func checkActive(record models.URLData) error {
+	if record.IsDeleted {
+		return &DBDeleteError{
+			Message: "shortener is already deleted",
+		}
+	}
+	if isExpired(record.ExpiresAt) {
+		return &DBDeleteError{
+			Message: "shortener is expired",
+		}
+	}
+	return nil
+}
+
+// isPurgeable сообщает, что удалённую ссылку пора удалить окончательно
+func isPurgeable(record models.URLData, deletedBefore time.Time) bool {
+	return record.IsDeleted && (record.DeletedAt == nil || record.DeletedAt.Before(deletedBefore))
+}
+
+// isExpired сообщает, истёк ли срок жизни ссылки
+func isExpired(expiresAt *time.Time) bool {
+	return expiresAt != nil && !time.Now().Before(*expiresAt)
+}
@@ -42,16 +42,13 @@ func TestLocalStore_GetData(t *testing.T) {
 		},
 	}
 
-	if err := InitializeFileLocalStore("data.json"); err != nil {
-		t.Fatal(err)
-	}
-	mockLocalStore := make(LocalStore)
+	mockLocalStore := newLocalStore()
 	require.NoError(t, mockLocalStore.SaveData(context.TODO(), "key", "value"))
 	require.NoError(t, mockLocalStore.SaveData(context.TODO(), "vdsdhhmggdsadcxvvfsdsaf", "fdsbhgkjmdfsaew341gfds"))
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			value, err := mockLocalStore.GetData(context.TODO(), tt.input)
+			value, err := mockLocalStore.GetData(context.TODO(), "", tt.input)
 			if tt.want.isError {
 				require.Error(t, err)
 			}
@@ -99,10 +96,7 @@ func TestLocalStore_SaveData(t *testing.T) {
 		},
 	}
 
-	if err := InitializeFileLocalStore("data.json"); err != nil {
-		t.Fatal(err)
-	}
-	mockLocalStore := make(LocalStore)
+	mockLocalStore := newLocalStore()
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -111,7 +105,7 @@ func TestLocalStore_SaveData(t *testing.T) {
 				require.Error(t, err)
 				return
 			}
-			value, err := mockLocalStore.GetData(context.TODO(), tt.inputKey)
+			value, err := mockLocalStore.GetData(context.TODO(), "", tt.inputKey)
 			if err != nil {
 				t.Fatal(err)
 			}
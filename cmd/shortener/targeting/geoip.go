@@ -0,0 +1,130 @@
+package targeting
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/policy"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GeoIP база стран из CSV-файла со строками "сеть,страна", например "5.8.0.0/16,DE".
+// Сети не должны пересекаться. Строка заголовка, пустые строки и строки, начинающиеся с #, пропускаются
+type GeoIP struct {
+	mu     sync.RWMutex
+	ranges []geoRange
+}
+
+// geoRange диапазон адресов одной сети
+type geoRange struct {
+	first   netip.Addr
+	last    netip.Addr
+	country string
+}
+
+// Geo база, по которой определяется страна клиента, nil отключает условия по стране
+var Geo *GeoIP
+
+// NewGeoIP загружает базу из файла path
+func NewGeoIP(path string) (*GeoIP, error) {
+	g := &GeoIP{}
+	if err := g.Load(path); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// Load перечитывает файл path
+func (g *GeoIP) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	ranges := make([]geoRange, 0)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") || line == 1 && strings.HasPrefix(strings.ToLower(text), "network") {
+			continue
+		}
+		network, country, ok := strings.Cut(text, ",")
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(network))
+		country = strings.ToUpper(strings.TrimSpace(country))
+		if !ok || err != nil || !countryPattern.MatchString(country) {
+			return fmt.Errorf("%s:%d: expected network,country", path, line)
+		}
+		prefix = prefix.Masked()
+		ranges = append(ranges, geoRange{first: prefix.Addr(), last: lastAddr(prefix), country: country})
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[i].first.Less(ranges[j].first)
+	})
+	for i := 1; i < len(ranges); i++ {
+		if ranges[i].first.Is4() == ranges[i-1].last.Is4() && !ranges[i-1].last.Less(ranges[i].first) {
+			return fmt.Errorf("%s: network %s overlaps %s", path, ranges[i].first, ranges[i-1].first)
+		}
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.ranges = ranges
+	return nil
+}
+
+// Country возвращает код страны адреса ip или пустую строку, если адреса нет в базе
+func (g *GeoIP) Country(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ""
+	}
+	addr = addr.Unmap()
+
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	i := sort.Search(len(g.ranges), func(i int) bool {
+		return addr.Less(g.ranges[i].first)
+	}) - 1
+	if i < 0 || g.ranges[i].last.Less(addr) || g.ranges[i].first.Is4() != addr.Is4() {
+		return ""
+	}
+	return g.ranges[i].country
+}
+
+// lastAddr возвращает последний адрес сети prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 0x80 >> (bit % 8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// Initialize загружает Geo из файла path и перечитывает его при изменении с периодом reload,
+// пока не отменён ctx. Пустой path отключает условия по стране
+func Initialize(ctx context.Context, path string, reload time.Duration) error {
+	if path == "" {
+		Geo = nil
+		return nil
+	}
+	geo, err := NewGeoIP(path)
+	if err != nil {
+		return err
+	}
+	Geo = geo
+	go policy.WatchFile(ctx, path, reload, geo.Load)
+	logger.Log.Info("GeoIP database loaded")
+	return nil
+}
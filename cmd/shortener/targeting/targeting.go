@@ -0,0 +1,209 @@
+// Package targeting выбирает адрес назначения ссылки по правилам: классу устройства и ОС из User-Agent,
+// предпочтительному языку из Accept-Language, стране клиента по локальной базе GeoIP и окну времени
+package targeting
+
+import (
+	"fmt"
+	"github.com/fngoc/url-shortener/internal/models"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Классы устройств
+const (
+	DeviceMobile  = "mobile"
+	DeviceTablet  = "tablet"
+	DeviceDesktop = "desktop"
+	DeviceBot     = "bot"
+)
+
+// Операционные системы
+const (
+	OSAndroid  = "android"
+	OSIOS      = "ios"
+	OSWindows  = "windows"
+	OSMacOS    = "macos"
+	OSLinux    = "linux"
+	OSChromeOS = "chromeos"
+)
+
+// CountryEU условие на все страны Евросоюза
+const CountryEU = "EU"
+
+// MaxValues ограничение на число значений в одном условии
+const MaxValues = 50
+
+var (
+	devices  = []string{DeviceMobile, DeviceTablet, DeviceDesktop, DeviceBot}
+	systems  = []string{OSAndroid, OSIOS, OSWindows, OSMacOS, OSLinux, OSChromeOS}
+	euStates = []string{"AT", "BE", "BG", "CY", "CZ", "DE", "DK", "EE", "ES", "FI", "FR", "GR", "HR", "HU",
+		"IE", "IT", "LT", "LU", "LV", "MT", "NL", "PL", "PT", "RO", "SE", "SI", "SK"}
+
+	languagePattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})*$`)
+	countryPattern  = regexp.MustCompile(`^[A-Z]{2}$`)
+)
+
+// Client свойства запроса, по которым проверяются условия правил. Пустые значения не совпадают
+// ни с одним условием
+type Client struct {
+	Device   string
+	OS       string
+	Language string
+	// Country код страны ISO 3166-1 в верхнем регистре
+	Country string
+	Time    time.Time
+}
+
+// Match возвращает адрес первого правила, все условия которого выполнены для client
+func Match(rules []models.TargetingRule, client Client) (string, bool) {
+	for _, rule := range rules {
+		if matches(rule, client) {
+			return rule.URL, true
+		}
+	}
+	return "", false
+}
+
+func matches(rule models.TargetingRule, client Client) bool {
+	if len(rule.Devices) > 0 && !slices.Contains(rule.Devices, client.Device) {
+		return false
+	}
+	if len(rule.OS) > 0 && !slices.Contains(rule.OS, client.OS) {
+		return false
+	}
+	if len(rule.Languages) > 0 && !slices.ContainsFunc(rule.Languages, func(language string) bool {
+		return client.Language == language || strings.HasPrefix(client.Language, language+"-")
+	}) {
+		return false
+	}
+	if len(rule.Countries) > 0 && (client.Country == "" || !slices.ContainsFunc(rule.Countries, func(country string) bool {
+		return country == client.Country || country == CountryEU && slices.Contains(euStates, client.Country)
+	})) {
+		return false
+	}
+	if rule.StartsAt != nil && client.Time.Before(*rule.StartsAt) {
+		return false
+	}
+	if rule.EndsAt != nil && !client.Time.Before(*rule.EndsAt) {
+		return false
+	}
+	return true
+}
+
+// Normalize приводит условия правила к каноническому виду и проверяет их. Адрес назначения
+// правила не проверяется
+func Normalize(rule models.TargetingRule) (models.TargetingRule, error) {
+	var err error
+	if rule.Devices, err = normalizeValues("devices", rule.Devices, strings.ToLower, func(v string) bool {
+		return slices.Contains(devices, v)
+	}); err != nil {
+		return rule, err
+	}
+	if rule.OS, err = normalizeValues("os", rule.OS, strings.ToLower, func(v string) bool {
+		return slices.Contains(systems, v)
+	}); err != nil {
+		return rule, err
+	}
+	if rule.Languages, err = normalizeValues("languages", rule.Languages, strings.ToLower, languagePattern.MatchString); err != nil {
+		return rule, err
+	}
+	if rule.Countries, err = normalizeValues("countries", rule.Countries, strings.ToUpper, countryPattern.MatchString); err != nil {
+		return rule, err
+	}
+	if rule.StartsAt != nil && rule.EndsAt != nil && !rule.StartsAt.Before(*rule.EndsAt) {
+		return rule, fmt.Errorf("starts_at must be before ends_at")
+	}
+	if len(rule.Devices)+len(rule.OS)+len(rule.Languages)+len(rule.Countries) == 0 && rule.StartsAt == nil && rule.EndsAt == nil {
+		return rule, fmt.Errorf("rule must have at least one condition")
+	}
+	return rule, nil
+}
+
+// normalizeValues приводит значения условия name к виду normalize и проверяет их функцией valid
+func normalizeValues(name string, values []string, normalize func(string) string, valid func(string) bool) ([]string, error) {
+	if len(values) > MaxValues {
+		return nil, fmt.Errorf("%s: no more than %d values are allowed", name, MaxValues)
+	}
+	if len(values) == 0 {
+		return nil, nil
+	}
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		value = normalize(strings.TrimSpace(value))
+		if !valid(value) {
+			return nil, fmt.Errorf("%s: unknown value %q", name, value)
+		}
+		if !slices.Contains(result, value) {
+			result = append(result, value)
+		}
+	}
+	return result, nil
+}
+
+// ParseUserAgent определяет класс устройства и ОС по User-Agent. Нераспознанная ОС возвращается пустой
+func ParseUserAgent(userAgent string) (device string, os string) {
+	ua := strings.ToLower(userAgent)
+	switch {
+	case strings.Contains(ua, "iphone"), strings.Contains(ua, "ipad"), strings.Contains(ua, "ipod"):
+		os = OSIOS
+	case strings.Contains(ua, "android"):
+		os = OSAndroid
+	case strings.Contains(ua, "windows"):
+		os = OSWindows
+	case strings.Contains(ua, "cros"):
+		os = OSChromeOS
+	case strings.Contains(ua, "macintosh"), strings.Contains(ua, "mac os x"):
+		os = OSMacOS
+	case strings.Contains(ua, "linux"):
+		os = OSLinux
+	}
+
+	switch {
+	case ua == "", containsAny(ua, "bot", "crawler", "spider", "slurp", "curl/", "wget/", "python-", "go-http-client", "facebookexternalhit"):
+		device = DeviceBot
+	case containsAny(ua, "ipad", "tablet", "kindle", "silk/"), os == OSAndroid && !strings.Contains(ua, "mobile"):
+		device = DeviceTablet
+	case containsAny(ua, "mobi", "iphone", "ipod", "windows phone"):
+		device = DeviceMobile
+	default:
+		device = DeviceDesktop
+	}
+	return device, os
+}
+
+func containsAny(s string, substrings ...string) bool {
+	for _, substring := range substrings {
+		if strings.Contains(s, substring) {
+			return true
+		}
+	}
+	return false
+}
+
+// PreferredLanguage возвращает язык с наибольшим весом из Accept-Language в нижнем регистре,
+// при равных весах — первый из них. Для пустого заголовка и "*" возвращается пустая строка
+func PreferredLanguage(acceptLanguage string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptLanguage, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
@@ -0,0 +1,131 @@
+package targeting
+
+import (
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseUserAgent(t *testing.T) {
+	tests := []struct {
+		name      string
+		userAgent string
+		device    string
+		os        string
+	}{
+		{"iphone", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) AppleWebKit/605.1.15 Mobile/15E148", DeviceMobile, OSIOS},
+		{"ipad", "Mozilla/5.0 (iPad; CPU OS 17_0 like Mac OS X) AppleWebKit/605.1.15", DeviceTablet, OSIOS},
+		{"android phone", "Mozilla/5.0 (Linux; Android 14; Pixel 8) Chrome/120.0 Mobile Safari/537.36", DeviceMobile, OSAndroid},
+		{"android tablet", "Mozilla/5.0 (Linux; Android 13; SM-X700) Chrome/120.0 Safari/537.36", DeviceTablet, OSAndroid},
+		{"windows", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) Chrome/120.0 Safari/537.36", DeviceDesktop, OSWindows},
+		{"mac", "Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) Safari/605.1.15", DeviceDesktop, OSMacOS},
+		{"chromebook", "Mozilla/5.0 (X11; CrOS x86_64 15633.69.0) Chrome/119.0 Safari/537.36", DeviceDesktop, OSChromeOS},
+		{"linux", "Mozilla/5.0 (X11; Linux x86_64; rv:121.0) Gecko/20100101 Firefox/121.0", DeviceDesktop, OSLinux},
+		{"crawler", "Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)", DeviceBot, ""},
+		{"curl", "curl/8.4.0", DeviceBot, ""},
+		{"empty", "", DeviceBot, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device, os := ParseUserAgent(tt.userAgent)
+			assert.Equal(t, tt.device, device)
+			assert.Equal(t, tt.os, os)
+		})
+	}
+}
+
+func TestPreferredLanguage(t *testing.T) {
+	assert.Equal(t, "de-de", PreferredLanguage("en;q=0.5, de-DE, fr;q=0.8"))
+	assert.Equal(t, "fr", PreferredLanguage("*, fr;q=0.9, en;q=0.9"))
+	assert.Equal(t, "", PreferredLanguage(""))
+	assert.Equal(t, "", PreferredLanguage("en;q=0"))
+}
+
+func TestNormalize(t *testing.T) {
+	rule, err := Normalize(models.TargetingRule{
+		URL:       "https://ya.ru",
+		Devices:   []string{"Mobile", "mobile"},
+		Languages: []string{"EN-us"},
+		Countries: []string{"de", "eu"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{DeviceMobile}, rule.Devices)
+	assert.Equal(t, []string{"en-us"}, rule.Languages)
+	assert.Equal(t, []string{"DE", CountryEU}, rule.Countries)
+
+	now := time.Now()
+	later := now.Add(time.Hour)
+	for name, rule := range map[string]models.TargetingRule{
+		"no conditions":    {URL: "https://ya.ru"},
+		"unknown device":   {URL: "https://ya.ru", Devices: []string{"watch"}},
+		"unknown os":       {URL: "https://ya.ru", OS: []string{"symbian"}},
+		"bad language":     {URL: "https://ya.ru", Languages: []string{"english"}},
+		"bad country":      {URL: "https://ya.ru", Countries: []string{"DEU"}},
+		"inverted window":  {URL: "https://ya.ru", StartsAt: &later, EndsAt: &now},
+		"too many options": {URL: "https://ya.ru", Countries: make([]string, MaxValues+1)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := Normalize(rule)
+			require.Error(t, err)
+		})
+	}
+}
+
+func TestMatch(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	rules := []models.TargetingRule{
+		{URL: "https://ya.ru/ios", OS: []string{OSIOS}, Devices: []string{DeviceMobile}},
+		{URL: "https://ya.ru/eu-de", Countries: []string{CountryEU}, Languages: []string{"de"}},
+		{URL: "https://ya.ru/promo", StartsAt: &past, EndsAt: &future, Devices: []string{DeviceDesktop}},
+		{URL: "https://ya.ru/expired", EndsAt: &past},
+	}
+
+	tests := []struct {
+		name   string
+		client Client
+		want   string
+	}{
+		{"device and os", Client{Device: DeviceMobile, OS: OSIOS, Time: now}, "https://ya.ru/ios"},
+		{"ipad does not match phone rule", Client{Device: DeviceTablet, OS: OSIOS, Time: now}, ""},
+		{"eu country with language prefix", Client{Country: "AT", Language: "de-at", Time: now}, "https://ya.ru/eu-de"},
+		{"country outside eu", Client{Country: "CH", Language: "de", Time: now}, ""},
+		{"unknown country", Client{Language: "de", Time: now}, ""},
+		{"language is not a prefix", Client{Country: "DE", Language: "den", Time: now}, ""},
+		{"inside time window", Client{Device: DeviceDesktop, Time: now}, "https://ya.ru/promo"},
+		{"after time window", Client{Device: DeviceDesktop, Time: future}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := Match(rules, tt.client)
+			assert.Equal(t, tt.want != "", ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestGeoIP(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geoip.csv")
+	require.NoError(t, os.WriteFile(path, []byte("network,country\n# test data\n5.8.0.0/16,de\n81.2.69.0/24,GB\n2a02:6b8::/32,RU\n"), 0o600))
+
+	geo, err := NewGeoIP(path)
+	require.NoError(t, err)
+	assert.Equal(t, "DE", geo.Country("5.8.255.255"))
+	assert.Equal(t, "GB", geo.Country("81.2.69.142"))
+	assert.Equal(t, "GB", geo.Country("::ffff:81.2.69.1"))
+	assert.Equal(t, "RU", geo.Country("2a02:6b8::1"))
+	assert.Equal(t, "", geo.Country("5.9.0.1"))
+	assert.Equal(t, "", geo.Country("1.1.1.1"))
+	assert.Equal(t, "", geo.Country("not an ip"))
+
+	require.NoError(t, os.WriteFile(path, []byte("5.8.0.0/16,DE\n5.8.1.0/24,FR\n"), 0o600))
+	require.Error(t, geo.Load(path), "overlapping networks")
+	assert.Equal(t, "DE", geo.Country("5.8.1.1"), "failed reload keeps previous data")
+
+	require.NoError(t, os.WriteFile(path, []byte("5.8.0.0/16\n"), 0o600))
+	require.Error(t, geo.Load(path))
+}
@@ -0,0 +1,290 @@
+package transfer
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"github.com/fngoc/url-shortener/internal/models"
+	"go.uber.org/zap"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// FormatJSONL одна запись models.URLData в формате JSON на строку
+	FormatJSONL = "jsonl"
+	// FormatCSV CSV с заголовком из csvHeader
+	FormatCSV = "csv"
+)
+
+// progressStep через сколько записей логируется прогресс
+const progressStep = 1000
+
+var csvHeader = []string{"short_url", "original_url", "user_id", "is_deleted", "created_at", "expires_at", "interstitial", "title", "password_hash", "redirect_type", "deleted_at", "folder", "tags", "workspace_id", "domain", "rules", "variants", "utm", "prefix_mode", "history"}
+
+// csvRequiredColumns сколько первых колонок csvHeader обязательно, остальные появились позже
+// и могут отсутствовать в старых выгрузках
+const csvRequiredColumns = 6
+
+// Export выгружает все записи хранилища в w в формате format и возвращает их количество
+func Export(ctx context.Context, repo storage.Repository, w io.Writer, format string) (int, error) {
+	bw := bufio.NewWriter(w)
+	write, flush, err := newRecordWriter(bw, format)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = repo.ExportData(ctx, func(record models.URLData) error {
+		if err := write(record); err != nil {
+			return err
+		}
+		count++
+		if count%progressStep == 0 {
+			logger.Log.Info("Export in progress", zap.Int("records", count))
+		}
+		return nil
+	})
+	if err != nil {
+		return count, err
+	}
+	if err := flush(); err != nil {
+		return count, err
+	}
+	logger.Log.Info("Export is done", zap.Int("records", count))
+	return count, bw.Flush()
+}
+
+// Import загружает записи из r в хранилище. Записи с уже существующим short_url перезаписываются,
+// поэтому повторный импорт того же файла не меняет состояние. Записи, которые не удалось
+// сохранить, пропускаются и учитываются в failed
+func Import(ctx context.Context, repo storage.Repository, r io.Reader, format string) (imported int, failed int, err error) {
+	read, err := newRecordReader(r, format)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	for {
+		record, err := read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return imported, failed, err
+		}
+
+		if err := repo.ImportData(ctx, record); err != nil {
+			failed++
+			logger.Log.Warn("Record is not imported", zap.String("short_url", record.ShortURL), zap.Error(err))
+			continue
+		}
+		imported++
+		if imported%progressStep == 0 {
+			logger.Log.Info("Import in progress", zap.Int("records", imported))
+		}
+	}
+	logger.Log.Info("Import is done", zap.Int("records", imported), zap.Int("failed", failed))
+	return imported, failed, nil
+}
+
+func newRecordWriter(w io.Writer, format string) (write func(models.URLData) error, flush func() error, err error) {
+	switch format {
+	case FormatJSONL:
+		enc := json.NewEncoder(w)
+		return func(record models.URLData) error {
+			return enc.Encode(record)
+		}, func() error { return nil }, nil
+	case FormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(csvHeader); err != nil {
+			return nil, nil, err
+		}
+		return func(record models.URLData) error {
+				return cw.Write(recordToCSV(record))
+			}, func() error {
+				cw.Flush()
+				return cw.Error()
+			}, nil
+	}
+	return nil, nil, fmt.Errorf("unknown format: %s", format)
+}
+
+func newRecordReader(r io.Reader, format string) (func() (models.URLData, error), error) {
+	switch format {
+	case FormatJSONL:
+		dec := json.NewDecoder(r)
+		return func() (models.URLData, error) {
+			var record models.URLData
+			err := dec.Decode(&record)
+			return record, err
+		}, nil
+	case FormatCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = -1
+		if _, err := cr.Read(); err != nil {
+			return nil, err
+		}
+		return func() (models.URLData, error) {
+			row, err := cr.Read()
+			if err != nil {
+				return models.URLData{}, err
+			}
+			return recordFromCSV(row)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown format: %s", format)
+}
+
+func recordToCSV(record models.URLData) []string {
+	expiresAt, deletedAt := "", ""
+	if record.ExpiresAt != nil {
+		expiresAt = record.ExpiresAt.Format(time.RFC3339Nano)
+	}
+	if record.DeletedAt != nil {
+		deletedAt = record.DeletedAt.Format(time.RFC3339Nano)
+	}
+	return []string{
+		record.ShortURL,
+		record.OriginalURL,
+		strconv.Itoa(record.UserID),
+		strconv.FormatBool(record.IsDeleted),
+		record.CreatedAt.Format(time.RFC3339Nano),
+		expiresAt,
+		strconv.FormatBool(record.Interstitial),
+		record.Title,
+		record.PasswordHash,
+		strconv.Itoa(record.RedirectType),
+		deletedAt,
+		record.Folder,
+		strings.Join(record.Tags, ","),
+		strconv.Itoa(record.WorkspaceID),
+		record.Domain,
+		jsonColumn(record.Rules),
+		jsonColumn(record.Variants),
+		utmColumn(record.UTM),
+		strconv.FormatBool(record.PrefixMode),
+		jsonColumn(record.History),
+	}
+}
+
+// utmColumn записывает шаблон UTM-меток в колонку CSV как JSON, пустой шаблон — пустой строкой
+func utmColumn(utm map[string]string) string {
+	if len(utm) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(utm)
+	return string(data)
+}
+
+// jsonColumn записывает список в колонку CSV как JSON, пустой список — пустой строкой
+func jsonColumn[T any](items []T) string {
+	if len(items) == 0 {
+		return ""
+	}
+	// списки из хранилища всегда сериализуются
+	data, _ := json.Marshal(items)
+	return string(data)
+}
+
+func recordFromCSV(row []string) (models.URLData, error) {
+	if len(row) < csvRequiredColumns || len(row) > len(csvHeader) {
+		return models.URLData{}, fmt.Errorf("expected %d to %d columns, got %d", csvRequiredColumns, len(csvHeader), len(row))
+	}
+	userID, err := strconv.Atoi(row[2])
+	if err != nil {
+		return models.URLData{}, fmt.Errorf("user_id: %w", err)
+	}
+	isDeleted, err := strconv.ParseBool(row[3])
+	if err != nil {
+		return models.URLData{}, fmt.Errorf("is_deleted: %w", err)
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, row[4])
+	if err != nil {
+		return models.URLData{}, fmt.Errorf("created_at: %w", err)
+	}
+
+	record := models.URLData{
+		ShortURL:    row[0],
+		OriginalURL: row[1],
+		UserID:      userID,
+		IsDeleted:   isDeleted,
+		CreatedAt:   createdAt,
+	}
+	if row[5] != "" {
+		expiresAt, err := time.Parse(time.RFC3339Nano, row[5])
+		if err != nil {
+			return models.URLData{}, fmt.Errorf("expires_at: %w", err)
+		}
+		record.ExpiresAt = &expiresAt
+	}
+	if len(row) > 6 && row[6] != "" {
+		if record.Interstitial, err = strconv.ParseBool(row[6]); err != nil {
+			return models.URLData{}, fmt.Errorf("interstitial: %w", err)
+		}
+	}
+	if len(row) > 7 {
+		record.Title = row[7]
+	}
+	if len(row) > 8 {
+		record.PasswordHash = row[8]
+	}
+	if len(row) > 9 && row[9] != "" {
+		if record.RedirectType, err = strconv.Atoi(row[9]); err != nil {
+			return models.URLData{}, fmt.Errorf("redirect_type: %w", err)
+		}
+	}
+	if len(row) > 10 && row[10] != "" {
+		deletedAt, err := time.Parse(time.RFC3339Nano, row[10])
+		if err != nil {
+			return models.URLData{}, fmt.Errorf("deleted_at: %w", err)
+		}
+		record.DeletedAt = &deletedAt
+	}
+	if len(row) > 11 {
+		record.Folder = row[11]
+	}
+	if len(row) > 12 && row[12] != "" {
+		record.Tags = strings.Split(row[12], ",")
+	}
+	if len(row) > 13 && row[13] != "" {
+		if record.WorkspaceID, err = strconv.Atoi(row[13]); err != nil {
+			return models.URLData{}, fmt.Errorf("workspace_id: %w", err)
+		}
+	}
+	if len(row) > 14 {
+		record.Domain = row[14]
+	}
+	if len(row) > 15 && row[15] != "" {
+		if err := json.Unmarshal([]byte(row[15]), &record.Rules); err != nil {
+			return models.URLData{}, fmt.Errorf("rules: %w", err)
+		}
+	}
+	if len(row) > 16 && row[16] != "" {
+		if err := json.Unmarshal([]byte(row[16]), &record.Variants); err != nil {
+			return models.URLData{}, fmt.Errorf("variants: %w", err)
+		}
+	}
+	if len(row) > 17 && row[17] != "" {
+		if err := json.Unmarshal([]byte(row[17]), &record.UTM); err != nil {
+			return models.URLData{}, fmt.Errorf("utm: %w", err)
+		}
+	}
+	if len(row) > 18 && row[18] != "" {
+		if record.PrefixMode, err = strconv.ParseBool(row[18]); err != nil {
+			return models.URLData{}, fmt.Errorf("prefix_mode: %w", err)
+		}
+	}
+	if len(row) > 19 && row[19] != "" {
+		if err := json.Unmarshal([]byte(row[19]), &record.History); err != nil {
+			return models.URLData{}, fmt.Errorf("history: %w", err)
+		}
+	}
+	return record, nil
+}
@@ -0,0 +1,90 @@
+package transfer
+
+import (
+	"bytes"
+	"context"
+	"github.com/fngoc/url-shortener/cmd/shortener/constants"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func exportAll(t *testing.T, repo storage.Repository) []models.URLData {
+	var records []models.URLData
+	require.NoError(t, repo.ExportData(context.Background(), func(record models.URLData) error {
+		record.UUID = 0
+		records = append(records, record)
+		return nil
+	}))
+	return records
+}
+
+func TestExportImport(t *testing.T) {
+	for _, format := range []string{FormatJSONL, FormatCSV} {
+		t.Run(format, func(t *testing.T) {
+			require.NoError(t, storage.InitializeFileLocalStore(filepath.Join(t.TempDir(), "data.json")))
+			source := storage.Store
+
+			ctx := context.WithValue(context.Background(), constants.UserIDKey, 7)
+			require.NoError(t, source.SaveData(ctx, "first", "https://ya.ru"))
+			require.NoError(t, source.SaveData(ctx, "second", "https://google.com"))
+			require.NoError(t, source.DeleteData("", "second"))
+			for _, originalURL := range []string{"https://ya.ru/1", "https://ya.ru/2"} {
+				_, err := source.UpdateRecord(ctx, "", "first", storage.LinkChanges{OriginalURL: &originalURL})
+				require.NoError(t, err)
+			}
+			expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+			require.NoError(t, source.ImportData(ctx, models.URLData{
+				ShortURL:    "third",
+				OriginalURL: "https://go.dev",
+				UserID:      8,
+				CreatedAt:   time.Now().UTC().Truncate(time.Second),
+				ExpiresAt:   &expiresAt,
+			}))
+
+			var buf bytes.Buffer
+			count, err := Export(context.Background(), source, &buf, format)
+			require.NoError(t, err)
+			require.Equal(t, 3, count)
+
+			require.NoError(t, storage.InitializeSQLite(filepath.Join(t.TempDir(), "test.db")))
+			target := storage.Store
+
+			for i := 0; i < 2; i++ {
+				imported, failed, err := Import(context.Background(), target, bytes.NewReader(buf.Bytes()), format)
+				require.NoError(t, err)
+				require.Equal(t, 3, imported)
+				require.Equal(t, 0, failed)
+			}
+
+			expected := exportAll(t, source)
+			actual := exportAll(t, target)
+			require.Len(t, actual, len(expected))
+			for i := range expected {
+				require.Equal(t, expected[i].ShortURL, actual[i].ShortURL)
+				require.Equal(t, expected[i].OriginalURL, actual[i].OriginalURL)
+				require.Equal(t, expected[i].UserID, actual[i].UserID)
+				require.Equal(t, expected[i].IsDeleted, actual[i].IsDeleted)
+				require.True(t, expected[i].CreatedAt.Equal(actual[i].CreatedAt))
+				require.Equal(t, expected[i].ExpiresAt == nil, actual[i].ExpiresAt == nil)
+				require.Equal(t, expected[i].History, actual[i].History)
+			}
+			history, err := target.History(context.Background(), "", "first")
+			require.NoError(t, err)
+			require.Len(t, history, 2, "edit history is transferred")
+			require.Equal(t, "https://ya.ru/1", history[0].OriginalURL)
+		})
+	}
+}
+
+func TestUnknownFormat(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+
+	_, err := Export(context.Background(), storage.Store, &bytes.Buffer{}, "xml")
+	require.Error(t, err)
+	_, _, err = Import(context.Background(), storage.Store, &bytes.Buffer{}, "xml")
+	require.Error(t, err)
+}
@@ -0,0 +1,125 @@
+// Package utm дополняет адрес назначения ссылки UTM-метками из шаблонов и параметрами
+// запроса перехода, не трогая параметры и фрагмент, которые уже есть в адресе
+package utm
+
+import (
+	"fmt"
+	"maps"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Режимы переноса параметров запроса перехода в адрес назначения
+const (
+	PassthroughNone = "none"
+	PassthroughUTM  = "utm"
+	PassthroughAll  = "all"
+)
+
+// maxValueLength ограничение на длину значения метки в шаблоне
+const maxValueLength = 200
+
+// Keys метки, которые можно задать в шаблоне
+var Keys = []string{"utm_source", "utm_medium", "utm_campaign", "utm_term", "utm_content", "utm_id"}
+
+// IsPassthroughMode сообщает, что mode — поддерживаемый режим переноса параметров
+func IsPassthroughMode(mode string) bool {
+	return mode == PassthroughNone || mode == PassthroughUTM || mode == PassthroughAll
+}
+
+// Validate приводит имена меток шаблона к нижнему регистру и проверяет их и значения.
+// Пустой шаблон возвращается как nil
+func Validate(template map[string]string) (map[string]string, error) {
+	if len(template) == 0 {
+		return nil, nil
+	}
+	result := make(map[string]string, len(template))
+	for key, value := range template {
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !slices.Contains(Keys, key) {
+			return nil, fmt.Errorf("unknown utm parameter %q, use one of %s", key, strings.Join(Keys, ", "))
+		}
+		value = strings.TrimSpace(value)
+		if value == "" || utf8.RuneCountInString(value) > maxValueLength {
+			return nil, fmt.Errorf("%s must be 1 to %d characters", key, maxValueLength)
+		}
+		result[key] = value
+	}
+	return result, nil
+}
+
+// Merge объединяет шаблон рабочего пространства и шаблон ссылки, метки ссылки важнее
+func Merge(workspace map[string]string, link map[string]string) map[string]string {
+	if len(workspace) == 0 {
+		return link
+	}
+	result := maps.Clone(workspace)
+	maps.Copy(result, link)
+	return result
+}
+
+// Expand подставляет в значения шаблона идентификатор ссылки {id} и хост её домена {domain}
+func Expand(template map[string]string, id string, domain string) url.Values {
+	replacer := strings.NewReplacer("{id}", id, "{domain}", domain)
+	values := make(url.Values, len(template))
+	for key, value := range template {
+		values.Set(key, replacer.Replace(value))
+	}
+	return values
+}
+
+// Passthrough выбирает из параметров запроса перехода те, что переносятся в адрес назначения в режиме mode
+func Passthrough(query url.Values, mode string) url.Values {
+	values := make(url.Values)
+	for key, value := range query {
+		if mode == PassthroughAll || mode == PassthroughUTM && strings.HasPrefix(key, "utm_") {
+			values[key] = value
+		}
+	}
+	return values
+}
+
+// Apply дополняет адрес destination параметрами defaults, которых в нём ещё нет, и параметрами overrides,
+// заменяя одноимённые параметры адреса. Остальные параметры адреса сохраняются как есть и в том же
+// порядке, новые параметры добавляются в конец по алфавиту, фрагмент остаётся в конце адреса
+func Apply(destination string, defaults url.Values, overrides url.Values) string {
+	if len(defaults) == 0 && len(overrides) == 0 {
+		return destination
+	}
+	u, err := url.Parse(destination)
+	if err != nil {
+		return destination
+	}
+
+	present := make(map[string]bool)
+	pairs := make([]string, 0)
+	for _, pair := range strings.Split(u.RawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		key, _, _ := strings.Cut(pair, "=")
+		if unescaped, err := url.QueryUnescape(key); err == nil {
+			key = unescaped
+		}
+		if overrides.Has(key) {
+			continue
+		}
+		present[key] = true
+		pairs = append(pairs, pair)
+	}
+
+	added := make(url.Values)
+	for key, value := range defaults {
+		if !present[key] {
+			added[key] = value
+		}
+	}
+	maps.Copy(added, overrides)
+	if encoded := added.Encode(); encoded != "" {
+		pairs = append(pairs, encoded)
+	}
+	u.RawQuery = strings.Join(pairs, "&")
+	return u.String()
+}
@@ -0,0 +1,55 @@
+package utm
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name        string
+		destination string
+		defaults    url.Values
+		overrides   url.Values
+		want        string
+	}{
+		{"nothing to add", "https://ya.ru/a?b=1", nil, nil, "https://ya.ru/a?b=1"},
+		{"template", "https://ya.ru/a", url.Values{"utm_source": {"mail"}}, nil, "https://ya.ru/a?utm_source=mail"},
+		{"keeps query and fragment", "https://ya.ru/a?b=1&c#top", url.Values{"utm_source": {"mail"}}, nil,
+			"https://ya.ru/a?b=1&c&utm_source=mail#top"},
+		{"destination wins over template", "https://ya.ru/a?utm_source=site", url.Values{"utm_source": {"mail"}, "utm_medium": {"email"}}, nil,
+			"https://ya.ru/a?utm_source=site&utm_medium=email"},
+		{"incoming wins over destination", "https://ya.ru/a?utm_source=site&x=%20y", url.Values{"utm_source": {"mail"}}, url.Values{"utm_source": {"ads"}},
+			"https://ya.ru/a?x=%20y&utm_source=ads"},
+		{"escaped values", "https://ya.ru/a#f", nil, url.Values{"utm_term": {"a&b c"}}, "https://ya.ru/a?utm_term=a%26b+c#f"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Apply(tt.destination, tt.defaults, tt.overrides))
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	template, err := Validate(map[string]string{" UTM_Source ": " newsletter "})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"utm_source": "newsletter"}, template)
+
+	_, err = Validate(map[string]string{"ref": "x"})
+	require.Error(t, err)
+	_, err = Validate(map[string]string{"utm_source": " "})
+	require.Error(t, err)
+}
+
+func TestTemplates(t *testing.T) {
+	template := Merge(map[string]string{"utm_source": "team", "utm_medium": "social"}, map[string]string{"utm_source": "{domain}", "utm_content": "{id}"})
+	assert.Equal(t, url.Values{"utm_source": {"go.brand.com"}, "utm_medium": {"social"}, "utm_content": {"abc"}},
+		Expand(template, "abc", "go.brand.com"))
+
+	query := url.Values{"utm_source": {"ads"}, "ref": {"x"}}
+	assert.Equal(t, url.Values{}, Passthrough(query, PassthroughNone))
+	assert.Equal(t, url.Values{"utm_source": {"ads"}}, Passthrough(query, PassthroughUTM))
+	assert.Equal(t, query, Passthrough(query, PassthroughAll))
+}
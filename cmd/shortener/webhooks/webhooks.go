@@ -0,0 +1,343 @@
+// Package webhooks доставляет события ссылок подписчикам. Событие сохраняется в хранилище доставкой
+// на каждую подходящую подписку до ответа на запрос, который его вызвал, фоновый обработчик отправляет
+// доставки и повторяет неудачные с экспоненциальной задержкой, поэтому очередь переживает перезапуск сервиса
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/fngoc/url-shortener/cmd/shortener/preview"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/logger"
+	"github.com/fngoc/url-shortener/internal/models"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// События ссылок, на которые подписываются пользователи
+const (
+	EventLinkCreated    = "link.created"
+	EventLinkDeleted    = "link.deleted"
+	EventClickThreshold = "link.click_threshold"
+	// EventPing тестовое событие, которое отправляет Test
+	EventPing = "ping"
+)
+
+// Заголовки запроса к подписчику. Подпись считается от значения TimestampHeader и тела запроса, см. Sign
+const (
+	EventHeader     = "X-Webhook-Event"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	TimestampHeader = "X-Webhook-Timestamp"
+	SignatureHeader = "X-Webhook-Signature"
+)
+
+const (
+	// maxBackoff ограничение задержки между попытками доставки
+	maxBackoff = 6 * time.Hour
+	// dueBatch число доставок, которые обработчик берёт из хранилища за раз
+	dueBatch = 100
+	// pollInterval период проверки доставок, попытка которых наступила
+	pollInterval = 5 * time.Second
+	// maxErrorLength ограничение длины ошибки в журнале доставок
+	maxErrorLength = 500
+)
+
+// Events события, на которые можно подписаться
+var Events = []string{EventLinkCreated, EventLinkDeleted, EventClickThreshold}
+
+// IsEvent сообщает, можно ли подписаться на событие
+func IsEvent(event string) bool {
+	return slices.Contains(Events, event)
+}
+
+// Sign возвращает подпись запроса: HMAC-SHA256 с ключом secret от "timestamp.body" в hex с префиксом sha256=
+func Sign(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher превращает события в доставки и отправляет их подписчикам
+type Dispatcher struct {
+	store  storage.Repository
+	client *http.Client
+	// attempts число попыток доставки, backoff задержка перед второй попыткой, каждая следующая вдвое больше
+	attempts int
+	backoff  time.Duration
+	wake     chan struct{}
+
+	mu sync.RWMutex
+	// thresholds пороги переходов всех подписок, обновляются каждые interval из Run и через RefreshThresholds
+	thresholds []int
+}
+
+// Current обработчик событий, nil отключает уведомления
+var Current *Dispatcher
+
+// NewDispatcher создаёт обработчик подписок и доставок из store. Доставка начинается после Run
+func NewDispatcher(store storage.Repository, client *http.Client, attempts int, backoff time.Duration) *Dispatcher {
+	return &Dispatcher{
+		store:    store,
+		client:   client,
+		attempts: attempts,
+		backoff:  backoff,
+		wake:     make(chan struct{}, 1),
+	}
+}
+
+// Run отправляет доставки, пока не отменён ctx. Доставки и пороги переходов подписок проверяются
+// каждые interval, доставки — ещё и сразу после новых событий
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := d.RefreshThresholds(ctx); err != nil {
+			logger.Log.Warn("Webhook click thresholds are not loaded", zap.Error(err))
+		}
+		d.deliverDue(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-d.wake:
+		}
+	}
+}
+
+// RefreshThresholds перечитывает пороги переходов подписок. Его вызывают после создания подписки,
+// чтобы её порог учитывался сразу, а подписки других экземпляров сервиса учитываются в Run
+func (d *Dispatcher) RefreshThresholds(ctx context.Context) error {
+	thresholds, err := d.store.ClickThresholds(ctx)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	d.thresholds = thresholds
+	d.mu.Unlock()
+	return nil
+}
+
+// Crossed сообщает, что число переходов по ссылке выросло с previous до clicks через порог хотя бы одной подписки
+func (d *Dispatcher) Crossed(previous, clicks int) bool {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	i, _ := slices.BinarySearch(d.thresholds, previous+1)
+	return i < len(d.thresholds) && d.thresholds[i] <= clicks
+}
+
+// Notify сохраняет доставку события для каждой подписки пользователя userID, которая его ждёт
+func (d *Dispatcher) Notify(ctx context.Context, userID int, event models.WebhookEvent) error {
+	return d.enqueue(ctx, userID, event, func(webhook models.Webhook) bool {
+		return slices.Contains(webhook.Events, event.Event)
+	})
+}
+
+// NotifyClicks сохраняет доставку события EventClickThreshold для каждой подписки пользователя userID,
+// порог которой число переходов по ссылке перешло, вырастая с previous до event.Clicks
+func (d *Dispatcher) NotifyClicks(ctx context.Context, userID int, previous int, event models.WebhookEvent) error {
+	event.Event = EventClickThreshold
+	return d.enqueue(ctx, userID, event, func(webhook models.Webhook) bool {
+		return slices.Contains(webhook.Events, EventClickThreshold) &&
+			previous < webhook.ClickThreshold && webhook.ClickThreshold <= event.Clicks
+	})
+}
+
+// Test сразу отправляет подписке событие EventPing одной попыткой и возвращает доставку с результатом.
+// Доставка сохраняется в журнале подписки с отметкой Test
+func (d *Dispatcher) Test(ctx context.Context, webhook models.Webhook) (models.WebhookDelivery, error) {
+	payload, err := json.Marshal(models.WebhookEvent{Event: EventPing, CreatedAt: time.Now().UTC()})
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	// попытка отложена, чтобы фоновый обработчик не отправил доставку одновременно с Test
+	parked := time.Now().Add(maxBackoff)
+	delivery, err := d.store.AddDelivery(ctx, models.WebhookDelivery{
+		WebhookID:     webhook.ID,
+		Event:         EventPing,
+		Payload:       string(payload),
+		Status:        storage.DeliveryPending,
+		Test:          true,
+		NextAttemptAt: &parked,
+	})
+	if err != nil {
+		return models.WebhookDelivery{}, err
+	}
+	return d.attempt(ctx, webhook, delivery)
+}
+
+// enqueue сохраняет доставку события для каждой подписки пользователя userID, для которой match истинно,
+// и будит обработчик доставок
+func (d *Dispatcher) enqueue(ctx context.Context, userID int, event models.WebhookEvent, match func(models.Webhook) bool) error {
+	webhooks, err := d.store.Webhooks(ctx, userID)
+	if err != nil {
+		return err
+	}
+	var payload []byte
+	added := false
+	for _, webhook := range webhooks {
+		if !match(webhook) {
+			continue
+		}
+		if payload == nil {
+			if payload, err = json.Marshal(event); err != nil {
+				return err
+			}
+		}
+		_, err := d.store.AddDelivery(ctx, models.WebhookDelivery{
+			WebhookID: webhook.ID,
+			Event:     event.Event,
+			Payload:   string(payload),
+			Status:    storage.DeliveryPending,
+		})
+		if err != nil {
+			return err
+		}
+		added = true
+	}
+	if added {
+		select {
+		case d.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// deliverDue отправляет доставки, попытка которых наступила
+func (d *Dispatcher) deliverDue(ctx context.Context) {
+	for {
+		due, err := d.store.DueDeliveries(ctx, time.Now(), dueBatch)
+		if err != nil {
+			logger.Log.Warn("Webhook deliveries are not loaded", zap.Error(err))
+			return
+		}
+		for _, delivery := range due {
+			if ctx.Err() != nil {
+				return
+			}
+			webhook, err := d.store.Webhook(ctx, delivery.WebhookID)
+			if errors.Is(err, storage.ErrNotFound) {
+				delivery.Status = storage.DeliveryFailed
+				delivery.Error = "webhook is deleted"
+				delivery.NextAttemptAt = nil
+				_ = d.store.UpdateDelivery(ctx, delivery)
+				continue
+			}
+			if err != nil {
+				logger.Log.Warn("Webhook is not loaded", zap.Int("webhook_id", delivery.WebhookID), zap.Error(err))
+				return
+			}
+			if _, err := d.attempt(ctx, webhook, delivery); err != nil {
+				logger.Log.Warn("Webhook delivery is not updated", zap.Int("delivery_id", delivery.ID), zap.Error(err))
+				return
+			}
+		}
+		if len(due) < dueBatch {
+			return
+		}
+	}
+}
+
+// attempt отправляет доставку и сохраняет результат: доставлена, следующая попытка через backoff
+// или failed, если попытки исчерпаны. Тестовые доставки не повторяются
+func (d *Dispatcher) attempt(ctx context.Context, webhook models.Webhook, delivery models.WebhookDelivery) (models.WebhookDelivery, error) {
+	code, err := d.send(ctx, webhook, delivery)
+	delivery.Attempts++
+	delivery.ResponseCode = code
+	delivery.Error = ""
+	delivery.NextAttemptAt = nil
+	switch {
+	case err == nil:
+		delivery.Status = storage.DeliveryDelivered
+	case delivery.Test || delivery.Attempts >= d.attempts:
+		delivery.Status = storage.DeliveryFailed
+		delivery.Error = errorText(err)
+	default:
+		next := time.Now().Add(d.delay(delivery.Attempts))
+		delivery.NextAttemptAt = &next
+		delivery.Error = errorText(err)
+	}
+	return delivery, d.store.UpdateDelivery(ctx, delivery)
+}
+
+// send отправляет доставку подписчику и возвращает код ответа. Успешным считается только ответ 2xx
+func (d *Dispatcher) send(ctx context.Context, webhook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	body := []byte(delivery.Payload)
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, strings.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "url-shortener-webhook/1.0")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// delay задержка перед попыткой после attempts неудачных: backoff, 2·backoff, 4·backoff и так далее до maxBackoff
+func (d *Dispatcher) delay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	return min(delay, maxBackoff)
+}
+
+// errorText обрезает текст ошибки до maxErrorLength байт
+func errorText(err error) string {
+	text := err.Error()
+	if len(text) > maxErrorLength {
+		text = strings.ToValidUTF8(text[:maxErrorLength], "")
+	}
+	return text
+}
+
+// Initialize настраивает Current: attempts попыток доставки с задержкой backoff перед второй и таймаутом
+// запроса timeout. При blockInternal запросы к внутренним адресам запрещены. При attempts < 1
+// уведомления отключены. Доставку запускает Run
+func Initialize(attempts int, backoff, timeout time.Duration, blockInternal bool) {
+	if attempts < 1 {
+		Current = nil
+		return
+	}
+	client := preview.NewHTTPClient(timeout, blockInternal)
+	// редирект превратил бы POST в GET, поэтому ответ 3xx считается неудачной попыткой
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	Current = NewDispatcher(storage.Store, client, attempts, backoff)
+}
+
+// Run отправляет доставки Current, пока не отменён ctx. Если уведомления отключены, сразу возвращается
+func Run(ctx context.Context) {
+	if Current != nil {
+		Current.Run(ctx, pollInterval)
+	}
+}
@@ -0,0 +1,254 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/fngoc/url-shortener/cmd/shortener/storage"
+	"github.com/fngoc/url-shortener/internal/models"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// receiver принимает запросы подписчика, проверяет подпись и отвечает кодами statuses по очереди,
+// после них — 204
+type receiver struct {
+	mu       sync.Mutex
+	secret   string
+	statuses []int
+	events   []models.WebhookEvent
+	headers  []http.Header
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	timestamp, err := strconv.ParseInt(r.Header.Get(TimestampHeader), 10, 64)
+	if err != nil || r.Header.Get(SignatureHeader) != Sign(rc.secret, timestamp, body) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	var event models.WebhookEvent
+	_ = json.Unmarshal(body, &event)
+	rc.events = append(rc.events, event)
+	rc.headers = append(rc.headers, r.Header.Clone())
+	status := http.StatusNoContent
+	if len(rc.statuses) > 0 {
+		status, rc.statuses = rc.statuses[0], rc.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func (rc *receiver) received() []models.WebhookEvent {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	return append([]models.WebhookEvent(nil), rc.events...)
+}
+
+func TestSign(t *testing.T) {
+	signature := Sign("secret", 1700000000, []byte(`{"event":"ping"}`))
+	require.Equal(t, signature, Sign("secret", 1700000000, []byte(`{"event":"ping"}`)))
+	require.Regexp(t, "^sha256=[0-9a-f]{64}$", signature)
+	require.NotEqual(t, signature, Sign("other", 1700000000, []byte(`{"event":"ping"}`)))
+	require.NotEqual(t, signature, Sign("secret", 1700000001, []byte(`{"event":"ping"}`)))
+	require.NotEqual(t, signature, Sign("secret", 1700000000, []byte(`{"event":"pong"}`)))
+}
+
+func TestDispatcher_Delay(t *testing.T) {
+	d := NewDispatcher(storage.Store, http.DefaultClient, 10, 30*time.Second)
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{3, 2 * time.Minute},
+		{20, maxBackoff},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.attempts), func(t *testing.T) {
+			require.Equal(t, tt.want, d.delay(tt.attempts))
+		})
+	}
+}
+
+func TestDispatcher_Deliver(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	ctx := context.Background()
+	rc := &receiver{secret: "0123456789abcdef"}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	webhook, err := storage.Store.CreateWebhook(ctx, models.Webhook{UserID: 1, URL: server.URL, Secret: rc.secret,
+		Events: []string{EventLinkCreated, EventClickThreshold}, ClickThreshold: 3})
+	require.NoError(t, err)
+	_, err = storage.Store.CreateWebhook(ctx, models.Webhook{UserID: 2, URL: server.URL, Secret: "other secret value",
+		Events: []string{EventLinkCreated}})
+	require.NoError(t, err)
+
+	d := NewDispatcher(storage.Store, server.Client(), 3, time.Minute)
+	link := &models.ResponseDto{ShortURL: "http://localhost:8080/abc", OriginalURL: "https://ya.ru"}
+	require.NoError(t, d.Notify(ctx, 1, models.WebhookEvent{Event: EventLinkCreated, Link: link}))
+	require.NoError(t, d.Notify(ctx, 1, models.WebhookEvent{Event: EventLinkDeleted, Link: link}))
+	require.NoError(t, d.NotifyClicks(ctx, 1, 1, models.WebhookEvent{Link: link, Clicks: 2}))
+	// число переходов перескочило порог 3
+	require.NoError(t, d.NotifyClicks(ctx, 1, 2, models.WebhookEvent{Link: link, Clicks: 4}))
+	require.NoError(t, d.NotifyClicks(ctx, 1, 4, models.WebhookEvent{Link: link, Clicks: 5}))
+	d.deliverDue(ctx)
+
+	events := rc.received()
+	require.Len(t, events, 2, "only subscribed events and the crossed threshold are delivered")
+	require.Equal(t, EventLinkCreated, events[0].Event)
+	require.Equal(t, link.ShortURL, events[0].Link.ShortURL)
+	require.Equal(t, EventClickThreshold, events[1].Event)
+	require.Equal(t, 4, events[1].Clicks)
+	require.Equal(t, EventLinkCreated, rc.headers[0].Get(EventHeader))
+	require.Equal(t, "application/json", rc.headers[0].Get("Content-Type"))
+
+	deliveries, err := storage.Store.Deliveries(ctx, webhook.ID)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	for i, delivery := range deliveries {
+		require.Equal(t, storage.DeliveryDelivered, delivery.Status)
+		require.Equal(t, 1, delivery.Attempts)
+		require.Equal(t, http.StatusNoContent, delivery.ResponseCode)
+		// журнал начинается с последней доставки, а подписчик получил их в порядке создания
+		require.Equal(t, strconv.Itoa(delivery.ID), rc.headers[len(deliveries)-1-i].Get(DeliveryHeader))
+	}
+}
+
+func TestDispatcher_Retry(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	ctx := context.Background()
+	rc := &receiver{secret: "0123456789abcdef", statuses: []int{http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	webhook, err := storage.Store.CreateWebhook(ctx, models.Webhook{UserID: 1, URL: server.URL, Secret: rc.secret,
+		Events: []string{EventLinkDeleted}})
+	require.NoError(t, err)
+	d := NewDispatcher(storage.Store, server.Client(), 3, time.Minute)
+	require.NoError(t, d.Notify(ctx, 1, models.WebhookEvent{Event: EventLinkDeleted}))
+
+	d.deliverDue(ctx)
+	deliveries, err := storage.Store.Deliveries(ctx, webhook.ID)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	delivery := deliveries[0]
+	require.Equal(t, storage.DeliveryPending, delivery.Status)
+	require.Equal(t, 1, delivery.Attempts)
+	require.Equal(t, http.StatusInternalServerError, delivery.ResponseCode)
+	require.NotEmpty(t, delivery.Error)
+	require.WithinDuration(t, time.Now().Add(time.Minute), *delivery.NextAttemptAt, 5*time.Second)
+
+	d.deliverDue(ctx)
+	require.Len(t, rc.received(), 1, "retry waits for backoff")
+
+	for attempt := 2; attempt <= 3; attempt++ {
+		delivery.NextAttemptAt = nil
+		require.NoError(t, storage.Store.UpdateDelivery(ctx, delivery))
+		d.deliverDue(ctx)
+		deliveries, err = storage.Store.Deliveries(ctx, webhook.ID)
+		require.NoError(t, err)
+		delivery = deliveries[0]
+		require.Equal(t, attempt, delivery.Attempts)
+	}
+	require.Equal(t, storage.DeliveryFailed, delivery.Status, "attempts are exhausted")
+	require.Equal(t, http.StatusServiceUnavailable, delivery.ResponseCode)
+	require.Nil(t, delivery.NextAttemptAt)
+	require.Len(t, rc.received(), 3)
+}
+
+func TestDispatcher_Test(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	ctx := context.Background()
+	rc := &receiver{secret: "0123456789abcdef", statuses: []int{http.StatusOK, http.StatusInternalServerError}}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	webhook, err := storage.Store.CreateWebhook(ctx, models.Webhook{UserID: 1, URL: server.URL, Secret: rc.secret,
+		Events: []string{EventLinkCreated}})
+	require.NoError(t, err)
+	d := NewDispatcher(storage.Store, server.Client(), 3, time.Minute)
+
+	delivery, err := d.Test(ctx, webhook)
+	require.NoError(t, err)
+	require.Equal(t, storage.DeliveryDelivered, delivery.Status)
+	require.True(t, delivery.Test)
+	require.Equal(t, EventPing, rc.received()[0].Event)
+
+	delivery, err = d.Test(ctx, webhook)
+	require.NoError(t, err)
+	require.Equal(t, storage.DeliveryFailed, delivery.Status, "test deliveries are not retried")
+	require.Equal(t, http.StatusInternalServerError, delivery.ResponseCode)
+
+	d.deliverDue(ctx)
+	require.Len(t, rc.received(), 2)
+}
+
+func TestDispatcher_Run(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	rc := &receiver{secret: "0123456789abcdef"}
+	server := httptest.NewServer(rc)
+	defer server.Close()
+
+	_, err := storage.Store.CreateWebhook(context.Background(), models.Webhook{UserID: 1, URL: server.URL, Secret: rc.secret,
+		Events: []string{EventLinkCreated}})
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	d := NewDispatcher(storage.Store, server.Client(), 3, time.Minute)
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx, time.Hour)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	require.NoError(t, d.Notify(context.Background(), 1, models.WebhookEvent{Event: EventLinkCreated}))
+	require.Eventually(t, func() bool {
+		return len(rc.received()) == 1
+	}, time.Second, 10*time.Millisecond, "new events are delivered without waiting for the poll interval")
+}
+
+func TestDispatcher_Crossed(t *testing.T) {
+	require.NoError(t, storage.InitializeInMemoryLocalStore())
+	ctx := context.Background()
+	d := NewDispatcher(storage.Store, http.DefaultClient, 3, time.Minute)
+	require.False(t, d.Crossed(9, 10), "thresholds are not loaded yet")
+
+	for _, threshold := range []int{10, 100} {
+		_, err := storage.Store.CreateWebhook(ctx, models.Webhook{UserID: 1, URL: "https://crm.example.com/hook",
+			Secret: "0123456789abcdef", Events: []string{EventClickThreshold}, ClickThreshold: threshold})
+		require.NoError(t, err)
+	}
+	require.NoError(t, d.RefreshThresholds(ctx))
+
+	tests := []struct {
+		previous int
+		clicks   int
+		want     bool
+	}{
+		{8, 9, false},
+		{9, 10, true},
+		{10, 11, false},
+		{8, 12, true},
+		{99, 100, true},
+		{100, 101, false},
+	}
+	for _, tt := range tests {
+		t.Run(strconv.Itoa(tt.previous)+"-"+strconv.Itoa(tt.clicks), func(t *testing.T) {
+			require.Equal(t, tt.want, d.Crossed(tt.previous, tt.clicks))
+		})
+	}
+}
@@ -30,6 +30,13 @@ func (r *loggingResponseWriter) WriteHeader(statusCode int) {
 	r.responseData.status = statusCode // захватываем код статуса
 }
 
+// Flush пробрасывает http.Flusher, чтобы потоковые ответы не буферизовались
+func (r *loggingResponseWriter) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
 // Log будет доступен всему коду как синглтон.
 // По умолчанию установлен no-op-логер, который не выводит никаких сообщений.
 var Log = zap.NewNop()
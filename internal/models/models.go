@@ -1,17 +1,200 @@
 package models
 
+import "time"
+
 type (
 	Request struct {
 		URL string `json:"url"`
+		// QR запрашивает в ответе адрес QR-кода сокращённой ссылки
+		QR bool `json:"qr,omitempty"`
+		// Interstitial показывает страницу предпросмотра вместо немедленного редиректа
+		Interstitial bool `json:"interstitial,omitempty"`
+		// Password защищает ссылку паролем
+		Password string `json:"password,omitempty"`
+		// RedirectType код редиректа 301, 302, 307 или 308, по умолчанию берётся из настроек сервиса
+		RedirectType int `json:"redirect_type,omitempty"`
+		// Tags метки ссылки, Folder папка, в которой она показывается
+		Tags   []string `json:"tags,omitempty"`
+		Folder string   `json:"folder,omitempty"`
+		// Workspace рабочее пространство, которому принадлежит ссылка, 0 — личная ссылка
+		Workspace int `json:"workspace,omitempty"`
+		// Domain хост домена короткой ссылки, по умолчанию домен из настроек сервиса
+		Domain string `json:"domain,omitempty"`
+		// Rules правила выбора адреса назначения, проверяются по порядку до первого совпадения
+		Rules []TargetingRule `json:"rules,omitempty"`
+		// Variants адреса назначения A/B-теста с весами, каждый посетитель закрепляется за одним из них
+		Variants []Variant `json:"variants,omitempty"`
+		// UTM шаблон UTM-меток, добавляемых к адресу назначения при переходе. Значения могут содержать
+		// {id} и {domain}
+		UTM map[string]string `json:"utm,omitempty"`
+		// PrefixMode дописывает путь после короткой ссылки к адресу назначения
+		PrefixMode bool `json:"prefix_mode,omitempty"`
+	}
+
+	// Variant адрес назначения A/B-теста. Доля переходов пропорциональна Weight, вариант
+	// с нулевым весом новым посетителям не выдаётся
+	Variant struct {
+		URL    string `json:"url"`
+		Weight int    `json:"weight"`
+	}
+
+	// LinkStats переходы по ссылке, Variants — по вариантам A/B-теста в порядке их объявления
+	LinkStats struct {
+		Clicks   int            `json:"clicks"`
+		Variants []VariantStats `json:"variants,omitempty"`
+	}
+
+	// VariantStats переходы на вариант A/B-теста
+	VariantStats struct {
+		Variant
+		Clicks int `json:"clicks"`
+	}
+
+	// TargetingRule правило выбора адреса назначения при переходе по ссылке. Правило срабатывает,
+	// когда выполнены все заданные условия, пустые условия не проверяются
+	TargetingRule struct {
+		URL string `json:"url"`
+		// Devices классы устройств: mobile, tablet, desktop или bot
+		Devices []string `json:"devices,omitempty"`
+		// OS операционные системы: android, ios, windows, macos, linux или chromeos
+		OS []string `json:"os,omitempty"`
+		// Languages языки из Accept-Language, "en" подходит и для "en-US"
+		Languages []string `json:"languages,omitempty"`
+		// Countries коды стран ISO 3166-1 или EU для стран Евросоюза
+		Countries []string `json:"countries,omitempty"`
+		// StartsAt и EndsAt окно времени, в котором правило действует
+		StartsAt *time.Time `json:"starts_at,omitempty"`
+		EndsAt   *time.Time `json:"ends_at,omitempty"`
+	}
+
+	// LinkUpdate тело PATCH /api/user/urls/{id}, отсутствующие поля не меняются
+	LinkUpdate struct {
+		URL *string `json:"url,omitempty"`
+		// ExpiresAt время в RFC 3339 или длительность от текущего момента, пустая строка снимает срок жизни
+		ExpiresAt *string `json:"expires_at,omitempty"`
+		// RedirectType код редиректа 301, 302, 307 или 308, 0 возвращает код по умолчанию
+		RedirectType *int `json:"redirect_type,omitempty"`
+		// Tags заменяет метки ссылки, пустой список снимает все метки
+		Tags *[]string `json:"tags,omitempty"`
+		// Folder переносит ссылку в папку, пустая строка убирает её из папки
+		Folder *string `json:"folder,omitempty"`
+		// Rules заменяет правила выбора адреса назначения, пустой список снимает их
+		Rules *[]TargetingRule `json:"rules,omitempty"`
+		// Variants заменяет варианты A/B-теста, пустой список завершает тест
+		Variants *[]Variant `json:"variants,omitempty"`
+		// UTM заменяет шаблон UTM-меток, пустой шаблон снимает его
+		UTM *map[string]string `json:"utm,omitempty"`
+		// PrefixMode включает или выключает дописывание пути к адресу назначения
+		PrefixMode *bool `json:"prefix_mode,omitempty"`
+	}
+
+	// HistoryEntry прежний адрес назначения ссылки и время его замены
+	HistoryEntry struct {
+		OriginalURL string    `json:"original_url"`
+		ChangedAt   time.Time `json:"changed_at"`
+	}
+
+	// Workspace рабочее пространство команды. Role роль пользователя, запросившего список
+	Workspace struct {
+		ID        int       `json:"id"`
+		Name      string    `json:"name"`
+		CreatedAt time.Time `json:"created_at"`
+		Role      string    `json:"role,omitempty"`
+		// UTM шаблон UTM-меток ссылок рабочего пространства, метки шаблона ссылки важнее
+		UTM map[string]string `json:"utm,omitempty"`
+	}
+
+	// Member участник рабочего пространства с ролью owner, editor или viewer
+	Member struct {
+		UserID int    `json:"user_id"`
+		Role   string `json:"role"`
+	}
+
+	// WorkspaceRequest тело создания рабочего пространства
+	WorkspaceRequest struct {
+		Name string `json:"name"`
+	}
+
+	// Domain домен, на котором сервис отдаёт короткие ссылки
+	Domain struct {
+		Name    string `json:"name"`
+		BaseURL string `json:"base_url"`
+		Default bool   `json:"default,omitempty"`
+	}
+
+	// MemberRequest тело назначения роли участнику рабочего пространства
+	MemberRequest struct {
+		Role string `json:"role"`
+	}
+
+	// Webhook подписка пользователя на события ссылок. Secret подписывает тело запроса HMAC-SHA256
+	// и отдаётся только при создании подписки
+	Webhook struct {
+		ID     int      `json:"id"`
+		UserID int      `json:"user_id"`
+		URL    string   `json:"url"`
+		Secret string   `json:"secret,omitempty"`
+		Events []string `json:"events"`
+		// ClickThreshold число переходов по ссылке, при котором отправляется событие link.click_threshold
+		ClickThreshold int       `json:"click_threshold,omitempty"`
+		CreatedAt      time.Time `json:"created_at"`
+	}
+
+	// WebhookRequest тело создания подписки, пустой Secret генерируется сервисом
+	WebhookRequest struct {
+		URL            string   `json:"url"`
+		Secret         string   `json:"secret,omitempty"`
+		Events         []string `json:"events"`
+		ClickThreshold int      `json:"click_threshold,omitempty"`
+	}
+
+	// WebhookEvent тело запроса, которое получает подписчик
+	WebhookEvent struct {
+		Event     string       `json:"event"`
+		CreatedAt time.Time    `json:"created_at"`
+		Link      *ResponseDto `json:"link,omitempty"`
+		// Clicks число переходов по ссылке для события link.click_threshold
+		Clicks int `json:"clicks,omitempty"`
+	}
+
+	// WebhookDelivery доставка события подписчику и её журнал. Status pending, delivered или failed,
+	// NextAttemptAt время следующей попытки доставки в статусе pending
+	WebhookDelivery struct {
+		ID            int        `json:"id"`
+		WebhookID     int        `json:"webhook_id"`
+		Event         string     `json:"event"`
+		Payload       string     `json:"payload"`
+		Status        string     `json:"status"`
+		Attempts      int        `json:"attempts"`
+		ResponseCode  int        `json:"response_code,omitempty"`
+		Error         string     `json:"error,omitempty"`
+		Test          bool       `json:"test,omitempty"`
+		CreatedAt     time.Time  `json:"created_at"`
+		NextAttemptAt *time.Time `json:"next_attempt_at,omitempty"`
+	}
+
+	// OutboxEvent событие ссылки для внешних систем. ID растёт в порядке записи событий, Variant задан
+	// только у перехода по ссылке: индекс варианта A/B-теста или -1
+	OutboxEvent struct {
+		ID          int64     `json:"id"`
+		Event       string    `json:"event"`
+		Domain      string    `json:"domain,omitempty"`
+		ShortURL    string    `json:"short_url"`
+		OriginalURL string    `json:"original_url,omitempty"`
+		UserID      int       `json:"user_id"`
+		Variant     *int      `json:"variant,omitempty"`
+		CreatedAt   time.Time `json:"created_at"`
 	}
 
 	Response struct {
 		Result string `json:"result"`
+		QR     string `json:"qr,omitempty"`
 	}
 
 	RequestBatch struct {
 		CorrelationID string `json:"correlation_id"`
 		OriginalURL   string `json:"original_url"`
+		Domain        string `json:"domain,omitempty"`
 	}
 
 	ResponseBatch struct {
@@ -20,13 +203,62 @@ type (
 	}
 
 	ResponseDto struct {
-		ShortURL    string `json:"short_url"`
-		OriginalURL string `json:"original_url"`
+		ShortURL     string            `json:"short_url"`
+		OriginalURL  string            `json:"original_url"`
+		CreatedAt    time.Time         `json:"created_at"`
+		ExpiresAt    *time.Time        `json:"expires_at,omitempty"`
+		IsDeleted    bool              `json:"is_deleted"`
+		DeletedAt    *time.Time        `json:"deleted_at,omitempty"`
+		Title        string            `json:"title,omitempty"`
+		Interstitial bool              `json:"interstitial,omitempty"`
+		Protected    bool              `json:"protected,omitempty"`
+		RedirectType int               `json:"redirect_type,omitempty"`
+		Tags         []string          `json:"tags,omitempty"`
+		Folder       string            `json:"folder,omitempty"`
+		Workspace    int               `json:"workspace,omitempty"`
+		Domain       string            `json:"domain,omitempty"`
+		Rules        []TargetingRule   `json:"rules,omitempty"`
+		Variants     []Variant         `json:"variants,omitempty"`
+		UTM          map[string]string `json:"utm,omitempty"`
+		PrefixMode   bool              `json:"prefix_mode,omitempty"`
 	}
 
 	URLData struct {
-		UUID        int    `json:"uuid"`
-		ShortURL    string `json:"short_url"`
-		OriginalURL string `json:"original_url"`
+		UUID        int        `json:"uuid"`
+		ShortURL    string     `json:"short_url"`
+		OriginalURL string     `json:"original_url"`
+		UserID      int        `json:"user_id"`
+		IsDeleted   bool       `json:"is_deleted"`
+		CreatedAt   time.Time  `json:"created_at"`
+		ExpiresAt   *time.Time `json:"expires_at,omitempty"`
+		// DeletedAt время мягкого удаления, от него отсчитываются окно восстановления и срок окончательного удаления
+		DeletedAt *time.Time `json:"deleted_at,omitempty"`
+		// Interstitial показывать страницу предпросмотра вместо редиректа
+		Interstitial bool `json:"interstitial,omitempty"`
+		// Title заголовок страницы назначения, загружается после создания ссылки
+		Title string `json:"title,omitempty"`
+		// PasswordHash bcrypt-хеш пароля ссылки, пустой у ссылок без пароля
+		PasswordHash string `json:"password_hash,omitempty"`
+		// RedirectType код редиректа ссылки, 0 означает код по умолчанию
+		RedirectType int `json:"redirect_type,omitempty"`
+		// History прежние адреса назначения в порядке замены. Хранится в записи у файлового
+		// и in-memory хранилищ, DBStore хранит историю в таблице url_history
+		History []HistoryEntry `json:"history,omitempty"`
+		// Tags метки ссылки без повторов в порядке сортировки. DBStore хранит их в таблице url_tags
+		Tags []string `json:"tags,omitempty"`
+		// Folder папка ссылки, пустая у ссылок вне папок
+		Folder string `json:"folder,omitempty"`
+		// WorkspaceID рабочее пространство ссылки, 0 у личных ссылок, которыми распоряжается только UserID
+		WorkspaceID int `json:"workspace_id,omitempty"`
+		// Domain домен ссылки, пустой у ссылок домена по умолчанию. ShortURL уникален в пределах домена
+		Domain string `json:"domain,omitempty"`
+		// Rules правила выбора адреса назначения, OriginalURL используется, если ни одно не сработало
+		Rules []TargetingRule `json:"rules,omitempty"`
+		// Variants варианты A/B-теста, используются, если не сработало ни одно из Rules
+		Variants []Variant `json:"variants,omitempty"`
+		// UTM шаблон UTM-меток ссылки
+		UTM map[string]string `json:"utm,omitempty"`
+		// PrefixMode дописывать путь после короткой ссылки к адресу назначения: /{id}/a/b ведёт на OriginalURL/a/b
+		PrefixMode bool `json:"prefix_mode,omitempty"`
 	}
 )
@@ -0,0 +1,180 @@
+package normalizer
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/idna"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidURL базовая ошибка для всех отказов нормализации
+var ErrInvalidURL = errors.New("invalid url")
+
+// Options настройки нормализации
+type Options struct {
+	// AllowedSchemes допустимые схемы в нижнем регистре
+	AllowedSchemes []string
+	// MaxLength максимальная длина URL до и после нормализации, 0 — без ограничения
+	MaxLength int
+	// StripFragment отбрасывать ли #fragment
+	StripFragment bool
+}
+
+// defaultPorts порты, которые не указываются в нормальной форме
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// Normalize проверяет, что raw — абсолютный URL с разрешённой схемой, и приводит его к нормальной форме:
+// схема и хост в нижнем регистре, IDN в punycode, без порта по умолчанию и с путём не короче "/".
+// Нормальная форма используется для поиска дубликатов
+func Normalize(raw string, opts Options) (string, error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" {
+		return "", fmt.Errorf("%w: url is empty", ErrInvalidURL)
+	}
+	if opts.MaxLength > 0 && len(raw) > opts.MaxLength {
+		return "", fmt.Errorf("%w: url is longer than %d", ErrInvalidURL, opts.MaxLength)
+	}
+
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidURL, err.Error())
+	}
+	if !u.IsAbs() || u.Opaque != "" {
+		return "", fmt.Errorf("%w: url must be absolute", ErrInvalidURL)
+	}
+
+	u.Scheme = strings.ToLower(u.Scheme)
+	if !isAllowedScheme(u.Scheme, opts.AllowedSchemes) {
+		return "", fmt.Errorf("%w: scheme %s is not allowed", ErrInvalidURL, u.Scheme)
+	}
+
+	host, err := normalizeHost(u.Hostname())
+	if err != nil {
+		return "", err
+	}
+	port := u.Port()
+	if port == defaultPorts[u.Scheme] {
+		port = ""
+	}
+	if strings.Contains(host, ":") {
+		host = "[" + host + "]"
+	}
+	if port != "" {
+		host += ":" + port
+	}
+	u.Host = host
+
+	if u.Path == "" {
+		u.Path = "/"
+		u.RawPath = ""
+	}
+	if opts.StripFragment {
+		u.Fragment = ""
+		u.RawFragment = ""
+	}
+
+	result := u.String()
+	if opts.MaxLength > 0 && len(result) > opts.MaxLength {
+		return "", fmt.Errorf("%w: url is longer than %d", ErrInvalidURL, opts.MaxLength)
+	}
+	return result, nil
+}
+
+// normalizeHost переводит хост в нижний регистр, IDN в punycode, а IPv4 в любой записи — в четыре десятичных октета
+func normalizeHost(host string) (string, error) {
+	if host == "" {
+		return "", fmt.Errorf("%w: host is empty", ErrInvalidURL)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return ip.String(), nil
+	}
+	ip, err := ParseIPv4(host)
+	if err != nil {
+		return "", err
+	}
+	if ip != nil {
+		return ip.String(), nil
+	}
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(host, "."))
+	if err != nil {
+		return "", fmt.Errorf("%w: host %s: %s", ErrInvalidURL, host, err.Error())
+	}
+	return strings.ToLower(ascii), nil
+}
+
+// ParseIPv4 разбирает хост как IPv4 по правилам WHATWG URL, как это делают браузеры: от одной до четырёх частей
+// в десятичной, восьмеричной (0177) или шестнадцатеричной (0x7f) записи, последняя часть занимает оставшиеся байты,
+// например 2130706433, 0x7f.1 и 0177.0.0.1 — это 127.0.0.1. Возвращает nil без ошибки, если хост — имя,
+// и ErrInvalidURL, если хост оканчивается числом, но не является адресом
+func ParseIPv4(host string) (net.IP, error) {
+	parts := strings.Split(host, ".")
+	if len(parts) > 1 && parts[len(parts)-1] == "" {
+		parts = parts[:len(parts)-1]
+	}
+	if !isIPv4Number(parts[len(parts)-1]) {
+		return nil, nil
+	}
+	if len(parts) > 4 {
+		return nil, fmt.Errorf("%w: host %s is not a valid ipv4 address", ErrInvalidURL, host)
+	}
+
+	var address uint64
+	for i, part := range parts {
+		n, err := parseIPv4Part(part)
+		last := i == len(parts)-1
+		if err != nil || (!last && n > 255) || (last && n >= 1<<(8*(5-len(parts)))) {
+			return nil, fmt.Errorf("%w: host %s is not a valid ipv4 address", ErrInvalidURL, host)
+		}
+		if last {
+			address = address<<(8*(5-len(parts))) | n
+		} else {
+			address = address<<8 | n
+		}
+	}
+	return net.IPv4(byte(address>>24), byte(address>>16), byte(address>>8), byte(address)), nil
+}
+
+// isIPv4Number сообщает, что часть хоста — число, и хост надо разбирать как IPv4
+func isIPv4Number(part string) bool {
+	if part == "" {
+		return false
+	}
+	if _, err := strconv.ParseUint(part, 10, 64); err == nil {
+		return true
+	}
+	_, err := parseIPv4Part(part)
+	return err == nil && (strings.HasPrefix(part, "0x") || strings.HasPrefix(part, "0X"))
+}
+
+// parseIPv4Part разбирает часть IPv4 в десятичной, восьмеричной или шестнадцатеричной записи
+func parseIPv4Part(part string) (uint64, error) {
+	base := 10
+	switch {
+	case strings.HasPrefix(part, "0x") || strings.HasPrefix(part, "0X"):
+		part, base = part[2:], 16
+		if part == "" {
+			return 0, nil
+		}
+	case len(part) > 1 && part[0] == '0':
+		part, base = part[1:], 8
+	}
+	if part == "" || part[0] == '+' || part[0] == '-' {
+		return 0, strconv.ErrSyntax
+	}
+	return strconv.ParseUint(part, base, 32)
+}
+
+func isAllowedScheme(scheme string, allowed []string) bool {
+	for _, s := range allowed {
+		if strings.EqualFold(s, scheme) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package normalizer
+
+import (
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	opts := Options{
+		AllowedSchemes: []string{"http", "https"},
+		MaxLength:      64,
+	}
+	tests := []struct {
+		name    string
+		input   string
+		opts    Options
+		want    string
+		isError bool
+	}{
+		{"already normal", "http://example.com/", opts, "http://example.com/", false},
+		{"upper case and default port", "HTTP://Example.com:80/", opts, "http://example.com/", false},
+		{"https default port", "https://example.com:443/path?q=1", opts, "https://example.com/path?q=1", false},
+		{"custom port is kept", "https://example.com:8443", opts, "https://example.com:8443/", false},
+		{"empty path", "http://example.com", opts, "http://example.com/", false},
+		{"idn", "https://Пример.рф/путь", opts, "https://xn--e1afmkfd.xn--p1ai/%D0%BF%D1%83%D1%82%D1%8C", false},
+		{"ipv6", "http://[::1]:80/", opts, "http://[::1]/", false},
+		{"decimal ipv4", "http://2130706433/", opts, "http://127.0.0.1/", false},
+		{"hex ipv4", "http://0xA9FEA9FE/", opts, "http://169.254.169.254/", false},
+		{"octal ipv4", "http://0251.0376.0251.0376/", opts, "http://169.254.169.254/", false},
+		{"short ipv4", "http://0x7f.1/", opts, "http://127.0.0.1/", false},
+		{"ipv4 with trailing dot", "http://127.0.0.1./", opts, "http://127.0.0.1/", false},
+		{"ipv4 with port", "http://0177.0.0.1:8080/", opts, "http://127.0.0.1:8080/", false},
+		{"numeric label is a name", "http://123.example/", opts, "http://123.example/", false},
+		{"ipv4 part is too large", "http://256.0.0.1/", opts, "", true},
+		{"ipv4 is too large", "http://4294967296/", opts, "", true},
+		{"too many ipv4 parts", "http://1.2.3.4.5/", opts, "", true},
+		{"bad octal ipv4", "http://09.0.0.1/", opts, "", true},
+		{"fragment is kept", "http://example.com/#top", opts, "http://example.com/#top", false},
+		{"fragment is stripped", "http://example.com/#top", Options{AllowedSchemes: []string{"http"}, StripFragment: true}, "http://example.com/", false},
+		{"spaces", "  https://ya.ru  ", opts, "https://ya.ru/", false},
+		{"form encoded", "url=https%3A%2F%2Fya.ru", opts, "", true},
+		{"relative", "/path", opts, "", true},
+		{"no host", "http:///path", opts, "", true},
+		{"scheme is not allowed", "ftp://example.com/", opts, "", true},
+		{"javascript", "javascript:alert(1)", opts, "", true},
+		{"too long", "https://example.com/" + strings.Repeat("a", 64), opts, "", true},
+		{"empty", "", opts, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Normalize(tt.input, tt.opts)
+			if tt.isError {
+				require.ErrorIs(t, err, ErrInvalidURL)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParseIPv4(t *testing.T) {
+	tests := []struct {
+		host    string
+		want    string
+		isError bool
+	}{
+		{"0.0.0.1", "0.0.0.1", false},
+		{"0x", "0.0.0.0", false},
+		{"1.0x10.3", "1.16.0.3", false},
+		{"10.65535", "10.0.255.255", false},
+		{"1.16777215", "1.255.255.255", false},
+		{"ya.ru", "", false},
+		{"0x1g", "", false},
+		{"1.2.65536", "", true},
+		{"1..2", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			ip, err := ParseIPv4(tt.host)
+			if tt.isError {
+				require.ErrorIs(t, err, ErrInvalidURL)
+				return
+			}
+			require.NoError(t, err)
+			if tt.want == "" {
+				require.Nil(t, ip)
+				return
+			}
+			require.Equal(t, tt.want, ip.String())
+		})
+	}
+}